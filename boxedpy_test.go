@@ -3,6 +3,7 @@ package boxedpy
 import (
 	"context"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -548,6 +549,9 @@ func TestNilPythonMethods(t *testing.T) {
 	assert.Empty(t, py.InterpreterPath())
 	assert.Empty(t, py.VirtualEnvPath())
 	assert.Empty(t, py.ProjectsDir())
+
+	_, err := py.Run(context.Background(), sandbox.DefaultPolicy(), ExecConfig{}, "-c", "pass")
+	assert.Error(t, err)
 }
 
 // TestPolicyConcurrentReuse tests that a Policy can be safely reused across concurrent calls.
@@ -712,3 +716,76 @@ func TestClose_NilPython(t *testing.T) {
 	err := py.Close()
 	require.NoError(t, err)
 }
+
+// TestConfigFile tests loading, validating and writing config files
+func TestConfigFile(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	venvDir := filepath.Join(tmpDir, "venv")
+	require.NoError(t, os.MkdirAll(filepath.Join(venvDir, "bin"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(venvDir, "bin", "python"), []byte("#!/bin/sh\n"), 0o755))
+
+	path := filepath.Join(tmpDir, "boxedpy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("version: 1\nvirtual_env: ${ROOT}/venv\n"), 0o644))
+	cfg, err := LoadConfig(path, map[string]string{"ROOT": tmpDir})
+	require.NoError(t, err)
+	assert.Equal(t, Config{VirtualEnv: venvDir}, cfg)
+	require.NoError(t, cfg.Validate())
+
+	// Round trip through both formats
+	cfg.ReferenceDir = tmpDir
+	for _, format := range []sandbox.Format{sandbox.FormatYAML, sandbox.FormatJSON} {
+		data, err := MarshalConfig(cfg, format)
+		require.NoError(t, err)
+		decoded, err := UnmarshalConfig(data, format, nil)
+		require.NoError(t, err)
+		assert.Equal(t, cfg, decoded)
+	}
+
+	// Unknown fields and missing versions are rejected
+	_, err = UnmarshalConfig([]byte("version: 1\nvirtualenv: /venv\n"), sandbox.FormatYAML, nil)
+	assert.Error(t, err)
+	_, err = UnmarshalConfig([]byte(`{"virtual_env": "/venv"}`), sandbox.FormatJSON, nil)
+	assert.ErrorContains(t, err, "missing version")
+
+	// Validate reports every problem
+	err = Config{VirtualEnv: "venv", ReferenceDir: filepath.Join(tmpDir, "missing"), ConfigDir: path}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"venv" is not an absolute path`)
+	assert.Contains(t, err.Error(), "projects directory")
+	assert.Contains(t, err.Error(), "is not a directory")
+}
+
+// TestRun_PythonError tests that a failing script is reported in the Result
+func TestRun_PythonError(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if _, err := exec.LookPath("bwrap"); runtime.GOOS == "linux" && err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	// A stand-in interpreter that fails the way Python does
+	tmpDir := t.TempDir()
+	binDir := filepath.Join(tmpDir, "venv", "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0o755))
+	script := "#!/bin/sh\necho partial\necho 'Traceback (most recent call last):' >&2\n" +
+		"echo '  File \"<string>\", line 2, in <module>' >&2\necho \"NameError: name 'x' is not defined\" >&2\nexit 1\n"
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "python"), []byte(script), 0o755))
+
+	py, err := New(Config{VirtualEnv: filepath.Join(tmpDir, "venv")})
+	require.NoError(t, err)
+	defer py.Close()
+
+	policy := sandbox.DefaultPolicy()
+	policy.WorkDir = tmpDir
+	res, err := py.Run(context.Background(), policy, ExecConfig{}, "-c", "print('partial')\nx")
+	require.NoError(t, err)
+	assert.Equal(t, "partial\n", string(res.Stdout))
+	assert.Equal(t, 1, res.ExitCode)
+	require.NotNil(t, res.PythonError)
+	assert.Equal(t, "NameError", res.PythonError.Type)
+	assert.Equal(t, 2, res.PythonError.Line)
+}
@@ -0,0 +1,143 @@
+package boxedpy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bpowers/boxedpy/sandbox"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileVersion is the version of the config file schema written by MarshalConfig.
+// UnmarshalConfig rejects files declaring any other version.
+const ConfigFileVersion = 1
+
+// configFile is the on-disk representation of a Config.
+type configFile struct {
+	Version      int    `json:"version" yaml:"version"`
+	VirtualEnv   string `json:"virtual_env" yaml:"virtual_env"`
+	ReferenceDir string `json:"reference_dir,omitempty" yaml:"reference_dir,omitempty"`
+	ConfigDir    string `json:"config_dir,omitempty" yaml:"config_dir,omitempty"`
+}
+
+// LoadConfig reads a config file, in the format given by its extension (see
+// sandbox.FormatOf). See UnmarshalConfig.
+func LoadConfig(path string, vars map[string]string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("config file: %w", err)
+	}
+	cfg, err := UnmarshalConfig(data, sandbox.FormatOf(path), vars)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// UnmarshalConfig decodes a config file. The file must declare "version: 1" (see
+// ConfigFileVersion) and unknown fields are rejected. ${NAME} references in paths are
+// replaced using vars, as described for sandbox.ExpandVars.
+//
+// Example file:
+//
+//	version: 1
+//	virtual_env: ${HOME}/.venvs/analysis
+//	reference_dir: /srv/datasets
+func UnmarshalConfig(data []byte, format sandbox.Format, vars map[string]string) (Config, error) {
+	var f configFile
+	if err := sandbox.DecodeStrict(data, format, &f); err != nil {
+		return Config{}, fmt.Errorf("config file: %w", err)
+	}
+	switch f.Version {
+	case ConfigFileVersion:
+	case 0:
+		return Config{}, fmt.Errorf("config file: missing version")
+	default:
+		return Config{}, fmt.Errorf("config file: unsupported version %d (want %d)", f.Version, ConfigFileVersion)
+	}
+
+	var errs []error
+	expand := func(s string) string {
+		out, err := sandbox.ExpandVars(s, vars)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("config file: %w", err))
+		}
+		return out
+	}
+	cfg := Config{
+		VirtualEnv:   expand(f.VirtualEnv),
+		ReferenceDir: expand(f.ReferenceDir),
+		ConfigDir:    expand(f.ConfigDir),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// MarshalConfig encodes cfg as a config file.
+func MarshalConfig(cfg Config, format sandbox.Format) ([]byte, error) {
+	f := configFile{
+		Version:      ConfigFileVersion,
+		VirtualEnv:   cfg.VirtualEnv,
+		ReferenceDir: cfg.ReferenceDir,
+		ConfigDir:    cfg.ConfigDir,
+	}
+	switch format {
+	case sandbox.FormatJSON:
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+		return append(data, '\n'), nil
+	case sandbox.FormatYAML:
+		data, err := yaml.Marshal(f)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown format %d", format)
+}
+
+// Validate checks cfg the way New does and reports every problem at once, joined with
+// errors.Join. Unlike New, it requires absolute paths, since a relative path in a config
+// file would depend on the working directory of whichever process loads it.
+func (cfg Config) Validate() error {
+	var errs []error
+	dir := func(what, path string) bool {
+		if !filepath.IsAbs(path) {
+			errs = append(errs, fmt.Errorf("%s %q is not an absolute path", what, path))
+			return false
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s at %s: %w", what, path, err))
+			return false
+		}
+		if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s at %s is not a directory", what, path))
+			return false
+		}
+		return true
+	}
+
+	if cfg.VirtualEnv == "" {
+		errs = append(errs, fmt.Errorf("VirtualEnv is required"))
+	} else if dir("virtualenv", cfg.VirtualEnv) {
+		pythonPath := filepath.Join(cfg.VirtualEnv, "bin", "python")
+		if _, err := os.Stat(pythonPath); err != nil {
+			errs = append(errs, fmt.Errorf("python interpreter not found at %s: %w", pythonPath, err))
+		}
+	}
+	if cfg.ReferenceDir != "" {
+		dir("projects directory", cfg.ReferenceDir)
+	}
+	if cfg.ConfigDir != "" {
+		dir("config directory", cfg.ConfigDir)
+	}
+	return errors.Join(errs...)
+}
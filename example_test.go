@@ -63,7 +63,7 @@ func ExampleJupyterEnv() {
 	jupyterEnv := boxedpy.JupyterEnv(notebookDir, configDir)
 
 	// Add to command environment
-	// cmd.Env = append(os.Environ(), jupyterEnv...)
+	// cmd.Env = append(cmd.Env, jupyterEnv...)
 
 	// Print the environment variables (for demonstration)
 	for _, envVar := range jupyterEnv {
@@ -19,7 +19,7 @@ import (
 // Example usage:
 //
 //	env := boxedpy.JupyterEnv("/path/to/notebook/dir", "/path/to/config")
-//	cmd.Env = append(os.Environ(), env...)
+//	cmd.Env = append(cmd.Env, env...)
 func JupyterEnv(notebookDir, configDir string) []string {
 	jupyterData := filepath.Join(notebookDir, ".jupyter")
 
@@ -82,3 +82,41 @@ func (p *Python) Command(ctx context.Context, policy *sandbox.Policy, cfg ExecCo
 	pythonPath := p.InterpreterPath()
 	return policy.Command(ctx, pythonPath, args...)
 }
+
+// Result is the outcome of a Python run started by Python.Run.
+type Result struct {
+	sandbox.Result
+
+	// PythonError is the Python error parsed from Stderr by ParsePythonError when the run did
+	// not succeed, or nil if there was none.
+	PythonError *PythonError
+}
+
+// Run runs Python in a sandbox, as Command does, and returns its output, exit status and
+// resource usage once it has exited. The error is only non-nil if Python could not be
+// started; a script that fails is reported in the Result, including the parsed error.
+//
+// Example:
+//
+//	res, err := py.Run(ctx, policy, ExecConfig{}, "-c", code)
+//	if err != nil {
+//	    return err
+//	}
+//	if res.PythonError != nil {
+//	    log.Printf("%s on line %d: %s", res.PythonError.Type, res.PythonError.Line, res.PythonError.Hint)
+//	}
+func (p *Python) Run(ctx context.Context, policy *sandbox.Policy, cfg ExecConfig, args ...string) (*Result, error) {
+	cmd, err := p.Command(ctx, policy, cfg, args...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := sandbox.RunCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Result: *res}
+	if !res.Success() {
+		result.PythonError = ParsePythonError(res.Stderr)
+	}
+	return result, nil
+}
@@ -0,0 +1,157 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Backend turns a Policy into a command that runs inside a sandbox. The sandbox package
+// provides one backend per platform, bubblewrap on Linux and Seatbelt on macOS, and
+// others (e.g., nsjail or gVisor's runsc) can be added with RegisterBackend and selected
+// with Policy.Backend.
+//
+// Policy.Command validates the policy and checks the features it uses against Supports
+// before calling the backend; resource limits, termination, output limits and the other
+// run-time facilities of the package are applied around the command the backend returns.
+// Every backend must enforce the policy's mounts, working directory and network settings
+// (AllowNetwork, AllowLocalhostOnly), which are not optional features.
+type Backend interface {
+	// Name identifies the backend in Policy.Backend and in error messages.
+	Name() string
+
+	// Supports reports whether the backend enforces the feature.
+	Supports(f Feature) bool
+
+	// Command returns an unstarted command that runs name with arg inside a sandbox
+	// configured by p, with Env set to the sandboxed process's environment (see
+	// Policy.Environ). The termination signal is delivered to the command's process,
+	// which must forward it to the sandboxed process. Files in the command's ExtraFiles
+	// are closed when the run's resources are released (see Release).
+	Command(ctx context.Context, p *Policy, name string, arg ...string) (*exec.Cmd, error)
+}
+
+// Feature is an optional capability of a Backend, used by a Policy field.
+type Feature string
+
+const (
+	FeatureMaskedPaths   Feature = "masked-paths"   // Policy.MaskedPaths
+	FeatureOverlay       Feature = "overlay"        // Policy.Overlay
+	FeatureFiles         Feature = "files"          // Policy.Files
+	FeatureIdentity      Feature = "identity"       // Policy.Identity
+	FeatureNetworkProxy  Feature = "network-proxy"  // Policy.NetworkProxy
+	FeatureLocalhostOnly Feature = "localhost-only" // Policy.AllowLocalhostOnly
+	FeatureSeccomp       Feature = "seccomp"        // Policy.Seccomp (checked on Linux only)
+	FeatureViolations    Feature = "violations"     // Policy.ReportViolations (checked on Linux only)
+	FeatureSessions      Feature = "sessions"       // Policy.NewSession
+)
+
+// policyFeatures lists the features that policy fields require. The Linux-specific fields
+// are ignored on macOS, so backends there are not asked about them.
+var policyFeatures = []struct {
+	feature   Feature
+	field     string
+	linuxOnly bool
+	used      func(p *Policy) bool
+}{
+	{FeatureMaskedPaths, "MaskedPaths", false, func(p *Policy) bool { return len(p.MaskedPaths) > 0 }},
+	{FeatureOverlay, "Overlay", false, func(p *Policy) bool { return p.Overlay != nil }},
+	{FeatureFiles, "Files", false, func(p *Policy) bool { return len(p.Files) > 0 }},
+	{FeatureIdentity, "Identity", false, func(p *Policy) bool { return p.Identity != nil }},
+	{FeatureNetworkProxy, "NetworkProxy", false, func(p *Policy) bool { return p.NetworkProxy != nil }},
+	{FeatureLocalhostOnly, "AllowLocalhostOnly", false, func(p *Policy) bool {
+		return p.AllowLocalhostOnly && p.NetworkProxy == nil
+	}},
+	{FeatureSeccomp, "Seccomp", true, func(p *Policy) bool { return p.Seccomp != nil }},
+	{FeatureViolations, "ReportViolations", true, func(p *Policy) bool { return p.ReportViolations }},
+}
+
+// helperBackend is implemented by built-in backends that enforce the policy in the helper
+// process, just before it execs the command. Policy.Command calls helperCommand instead of
+// Command so that the restrictions share one helper with the limits; helperCommand returns
+// the command unwrapped, records its settings in the helper configuration of st and
+// registers the resources it creates to be freed with the run's.
+type helperBackend interface {
+	helperCommand(ctx context.Context, p *Policy, st *cmdState, name string, arg ...string) (*exec.Cmd, error)
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Backend)
+)
+
+// RegisterBackend makes a backend available under its name. It is meant to be called from
+// an init function, and panics if the name is empty or already registered.
+func RegisterBackend(b Backend) {
+	if b == nil {
+		panic("sandbox: RegisterBackend backend is nil")
+	}
+	name := b.Name()
+	if name == "" {
+		panic("sandbox: RegisterBackend backend has no name")
+	}
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, dup := backends[name]; dup {
+		panic("sandbox: RegisterBackend called twice for backend " + name)
+	}
+	backends[name] = b
+}
+
+// Backends returns the names of the registered backends, sorted.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultBackend returns the name of the backend used when Policy.Backend is empty:
+// "bubblewrap" on Linux and "seatbelt" on macOS.
+func DefaultBackend() string {
+	return defaultBackend
+}
+
+// selectBackend returns the backend selected by the policy, after checking that it supports
+// the features the policy uses.
+func (p *Policy) selectBackend() (Backend, error) {
+	name := p.Backend
+	if name == "" {
+		name = defaultBackend
+	}
+	backendsMu.RLock()
+	b, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("sandbox: unknown backend %q (registered: %v)", name, Backends())
+	}
+
+	var errs []error
+	for _, pf := range policyFeatures {
+		if pf.linuxOnly && runtime.GOOS != "linux" {
+			continue
+		}
+		if pf.used(p) && !b.Supports(pf.feature) {
+			errs = append(errs, fmt.Errorf("sandbox: backend %q does not support %s (Policy.%s)", name, pf.feature, pf.field))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return b, nil
+}
+
+// Environ returns the environment of a sandboxed command as described by Environment, with
+// injected "KEY=VALUE" entries (TMPDIR, proxy variables and the like) applied in step 2.
+// Backends use it to build cmd.Env.
+func (p *Policy) Environ(injected ...string) []string {
+	return p.environ(injected)
+}
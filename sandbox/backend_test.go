@@ -0,0 +1,83 @@
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// directBackend runs commands without a sandbox and supports no optional features.
+type directBackend struct{}
+
+func (directBackend) Name() string { return "test-direct" }
+
+func (directBackend) Supports(f Feature) bool { return false }
+
+func (directBackend) Command(ctx context.Context, p *Policy, name string, arg ...string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Env = p.Environ()
+	cmd.Dir = p.WorkDir
+	return cmd, nil
+}
+
+func init() {
+	RegisterBackend(directBackend{})
+}
+
+func TestRegisterBackend(t *testing.T) {
+	t.Parallel()
+
+	assert.Contains(t, Backends(), DefaultBackend())
+	assert.Contains(t, Backends(), "test-direct")
+	assert.PanicsWithValue(t, "sandbox: RegisterBackend called twice for backend test-direct", func() {
+		RegisterBackend(directBackend{})
+	})
+	assert.Panics(t, func() { RegisterBackend(nil) })
+}
+
+func TestPolicyBackend(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("selected", func(t *testing.T) {
+		t.Parallel()
+		p := &Policy{Backend: "test-direct", Env: Environment{Set: map[string]string{"GREETING": "hello"}}}
+		res, err := p.Run(ctx, "sh", "-c", `echo "$GREETING"`)
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", string(res.Stdout))
+		assert.True(t, res.Success())
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		t.Parallel()
+		p := &Policy{Backend: "nonexistent"}
+		_, err := p.Command(ctx, "true")
+		assert.ErrorContains(t, err, `sandbox: unknown backend "nonexistent"`)
+		assert.ErrorContains(t, p.Validate(), `unknown backend "nonexistent"`)
+	})
+
+	t.Run("unsupported features", func(t *testing.T) {
+		t.Parallel()
+		p := &Policy{
+			Backend:  "test-direct",
+			Files:    map[string]File{"/etc/motd": {Data: []byte("hi")}},
+			Identity: &Identity{},
+		}
+		_, err := p.Command(ctx, "true")
+		assert.ErrorContains(t, err, `backend "test-direct" does not support files (Policy.Files)`)
+		assert.ErrorContains(t, err, `backend "test-direct" does not support identity (Policy.Identity)`)
+		assert.ErrorContains(t, p.Validate(), "does not support files")
+	})
+
+	t.Run("sessions and plans", func(t *testing.T) {
+		t.Parallel()
+		p := &Policy{Backend: "test-direct"}
+		_, err := p.NewSession(ctx)
+		assert.ErrorContains(t, err, `backend "test-direct" does not support sessions`)
+		_, err = p.Plan("true")
+		assert.ErrorContains(t, err, `Plan is not supported for backend "test-direct"`)
+	})
+}
@@ -0,0 +1,179 @@
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"sync"
+	"weak"
+)
+
+// cmdState holds host-side bookkeeping for an *exec.Cmd created by Policy.Command:
+// resources that must stay alive until the command has been started and waited for
+// (cgroup directories, file descriptors, timers), and the information needed afterwards
+// to explain how the run ended.
+//
+// The state is associated with its Cmd through a weak pointer and must never reference
+// the Cmd otherwise, or cmdStates would keep it alive; functions that act on the Cmd take
+// it as an argument. Cleanup functions run once the run is over, when CheckLimits or
+// Release is called after Wait, and the state is then replaced by a runRecord; garbage
+// collection of the Cmd only serves as a backstop, mirroring the best-effort finalizer
+// cleanup used for macOS temp directories.
+type cmdState struct {
+	limits    Limits
+	output    OutputLimits
+	key       weak.Pointer[exec.Cmd]    // the Cmd, set by attach
+	kill      func(cmd *exec.Cmd) error // kills every process of the run
+	wallCtx   context.Context           // non-nil when Limits.WallTime is set
+	cgroupDir string                    // per-run cgroup directory (Linux only); cleared on release
+	helper    *helperConfig             // non-nil when the command must start through the helper
+	bwrap     bool                      // the exit status is reported by bubblewrap
+
+	vmu        sync.Mutex
+	violations []Violation
+	watchers   []chan<- Violation
+	monitorErr error // why violations could no longer be observed
+
+	mu          sync.Mutex
+	cleanups    []func()
+	done        bool
+	termination TerminationStage
+	outputLimit bool      // OutputLimits.KillBytes was exceeded
+	cgroupLimit LimitKind // cgroup limit hit by the run, recorded when the cgroup is removed
+}
+
+// runRecord is what remains of a run once its resources have been released: the outcome
+// reported by Violations, TerminationOf and CheckLimits.
+type runRecord struct {
+	violations  []Violation
+	termination TerminationStage
+	limit       LimitKind // limit that ended the run, if any; only set once released
+	monitorErr  error
+}
+
+var (
+	// cmdStates maps weak.Pointer[exec.Cmd] to the *cmdState of a command that has not
+	// been released.
+	cmdStates sync.Map
+	// cmdRecords maps weak.Pointer[exec.Cmd] to the *runRecord of a released command,
+	// until the Cmd is garbage collected.
+	cmdRecords sync.Map
+)
+
+func newCmdState(p *Policy) *cmdState {
+	return &cmdState{limits: p.Limits, output: p.Output}
+}
+
+// helperConfig returns the configuration of the helper, requesting one if necessary.
+func (s *cmdState) helperConfig() *helperConfig {
+	if s.helper == nil {
+		s.helper = &helperConfig{}
+	}
+	return s.helper
+}
+
+// addCleanup registers f to run when the state is released.
+func (s *cmdState) addCleanup(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanups = append(s.cleanups, f)
+}
+
+// release runs the registered cleanup functions in reverse order. It is safe to call
+// more than once; only the first call has an effect.
+func (s *cmdState) release() {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	cleanups := s.cleanups
+	s.cleanups = nil
+	s.mu.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+}
+
+// finish releases the state of cmd, which has been waited for or will not be started, and
+// replaces it with the record of the run's outcome, which it returns.
+func (s *cmdState) finish(cmd *exec.Cmd) *runRecord {
+	s.release()
+	rec := s.outcome()
+	rec.limit = s.exceededLimit(cmd)
+	s.vmu.Lock()
+	s.watchers = nil
+	s.vmu.Unlock()
+	cmdRecords.Store(s.key, rec)
+	cmdStates.Delete(s.key)
+	return rec
+}
+
+// outcome returns the outcome of the run so far, without the limit that ended it.
+func (s *cmdState) outcome() *runRecord {
+	rec := &runRecord{}
+	s.vmu.Lock()
+	rec.violations, rec.monitorErr = s.violations, s.monitorErr
+	s.vmu.Unlock()
+	s.mu.Lock()
+	rec.termination = s.termination
+	s.mu.Unlock()
+	return rec
+}
+
+// attach associates the state with cmd and arranges for release to run once cmd
+// is garbage collected, in case it was not called after Wait.
+func (s *cmdState) attach(cmd *exec.Cmd) {
+	key := weak.Make(cmd)
+	s.key = key
+	cmdStates.Store(key, s)
+	runtime.AddCleanup(cmd, func(key weak.Pointer[exec.Cmd]) {
+		if v, ok := cmdStates.LoadAndDelete(key); ok {
+			v.(*cmdState).release()
+		}
+		cmdRecords.Delete(key)
+	}, key)
+}
+
+// stateOf returns the state attached to cmd, or nil if cmd was not created by Policy.Command
+// or has been released.
+func stateOf(cmd *exec.Cmd) *cmdState {
+	v, ok := cmdStates.Load(weak.Make(cmd))
+	if !ok {
+		return nil
+	}
+	return v.(*cmdState)
+}
+
+// recordOf returns the record of a released command, or nil.
+func recordOf(cmd *exec.Cmd) *runRecord {
+	v, ok := cmdRecords.Load(weak.Make(cmd))
+	if !ok {
+		return nil
+	}
+	return v.(*runRecord)
+}
+
+// outcomeOf returns the outcome of the run of cmd so far, whether or not it has been
+// released, or nil if cmd was not created by Policy.Command or Session.Command.
+func outcomeOf(cmd *exec.Cmd) *runRecord {
+	if st := stateOf(cmd); st != nil {
+		return st.outcome()
+	}
+	return recordOf(cmd)
+}
+
+// outputExceeded records that the run wrote more than OutputLimits.KillBytes and kills it.
+func (s *cmdState) outputExceeded() {
+	s.mu.Lock()
+	s.outputLimit = true
+	s.mu.Unlock()
+	if s.kill == nil {
+		return
+	}
+	if cmd := s.key.Value(); cmd != nil {
+		s.kill(cmd)
+	}
+}
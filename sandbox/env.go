@@ -0,0 +1,123 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultPath is injected as PATH when the environment does not otherwise provide one.
+const defaultPath = "/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin"
+
+// Environment describes the environment variables of sandboxed processes.
+// The zero value inherits nothing from the host: API keys, cloud credentials and
+// PYTHONPATH in the parent's environment are never visible inside the sandbox unless
+// explicitly passed through.
+//
+// The environment is assembled in this order, later steps overriding earlier ones:
+//  1. Host variables whose names match Inherit
+//  2. Injected variables:
+//     - PATH (a standard system search path, unless inherited)
+//     - TMPDIR, when ProvideTmp is set (/tmp on Linux, the private temp directory on macOS)
+//     - HTTP_PROXY, HTTPS_PROXY, ALL_PROXY and lowercase variants, when NetworkProxy is set
+//     - HOME, USER, LOGNAME and optionally TZ and LANG, when Identity is set
+//  3. Set
+//  4. Unset
+//
+// Callers that need per-command additions can still append to the returned cmd.Env,
+// e.g. cmd.Env = append(cmd.Env, boxedpy.JupyterEnv(dir, configDir)...).
+type Environment struct {
+	// Inherit lists host variables passed through to the sandbox when set on the host.
+	// Entries are exact names or path.Match patterns (e.g., "LC_*").
+	Inherit []string
+
+	// Set assigns variables inside the sandbox.
+	Set map[string]string
+
+	// Unset removes variables, including inherited and injected ones.
+	Unset []string
+}
+
+// validate checks that every name and value can be represented in an environment block.
+func (e *Environment) validate() error {
+	for _, pattern := range e.Inherit {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("sandbox: env: invalid inherit pattern %q: %w", pattern, err)
+		}
+	}
+	for name, value := range e.Set {
+		if err := validEnvName(name); err != nil {
+			return err
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("sandbox: env: value of %s contains NUL", name)
+		}
+	}
+	for _, name := range e.Unset {
+		if err := validEnvName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validEnvName(name string) error {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return fmt.Errorf("sandbox: env: invalid variable name %q", name)
+	}
+	return nil
+}
+
+// inherits reports whether the host variable name matches an Inherit entry.
+func (e *Environment) inherits(name string) bool {
+	for _, pattern := range e.Inherit {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// environ builds the environment of a sandboxed command from the policy. injected holds
+// the platform's "KEY=VALUE" entries for step 2 (TMPDIR, proxy variables). The result is
+// sorted by name so that identical policies produce identical environments.
+func (p *Policy) environ(injected []string) []string {
+	vars := make(map[string]string)
+
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && name != "" && p.Env.inherits(name) {
+			vars[name] = value
+		}
+	}
+
+	if _, ok := vars["PATH"]; !ok {
+		vars["PATH"] = defaultPath
+	}
+	for _, kv := range injected {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			vars[name] = value
+		}
+	}
+
+	for name, value := range p.Env.Set {
+		vars[name] = value
+	}
+	for _, name := range p.Env.Unset {
+		delete(vars, name)
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, name+"="+vars[name])
+	}
+	return env
+}
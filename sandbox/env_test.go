@@ -0,0 +1,74 @@
+package sandbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironNotInherited(t *testing.T) {
+	t.Setenv("BOXEDPY_TEST_SECRET", "hunter2")
+	t.Setenv("PATH", "/host/bin")
+
+	policy := &Policy{}
+	env := policy.environ(nil)
+	assert.Equal(t, []string{"PATH=" + defaultPath}, env)
+}
+
+func TestEnvironPrecedence(t *testing.T) {
+	t.Setenv("BOXEDPY_TEST_LANG", "en_US.UTF-8")
+	t.Setenv("BOXEDPY_TEST_LC_ALL", "C")
+	t.Setenv("BOXEDPY_TEST_SECRET", "hunter2")
+	t.Setenv("PATH", "/host/bin")
+
+	policy := &Policy{Env: Environment{
+		Inherit: []string{"BOXEDPY_TEST_LANG", "BOXEDPY_TEST_LC_*", "PATH"},
+		Set: map[string]string{
+			"BOXEDPY_TEST_LC_ALL": "POSIX",
+			"HTTP_PROXY":          "http://override",
+			"EMPTY":               "",
+		},
+		Unset: []string{"BOXEDPY_TEST_LANG", "TMPDIR"},
+	}}
+	env := policy.environ([]string{"TMPDIR=/tmp", "HTTP_PROXY=http://proxy", "NO_PROXY=localhost"})
+
+	assert.Equal(t, []string{
+		"BOXEDPY_TEST_LC_ALL=POSIX",
+		"EMPTY=",
+		"HTTP_PROXY=http://override",
+		"NO_PROXY=localhost",
+		"PATH=/host/bin",
+	}, env)
+}
+
+func TestEnvironmentValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		env  Environment
+		want string
+	}{
+		{"bad pattern", Environment{Inherit: []string{"LC_["}}, "invalid inherit pattern"},
+		{"empty name", Environment{Set: map[string]string{"": "x"}}, "invalid variable name"},
+		{"name with equals", Environment{Set: map[string]string{"A=B": "x"}}, "invalid variable name"},
+		{"value with NUL", Environment{Set: map[string]string{"A": "x\x00y"}}, "contains NUL"},
+		{"unset with equals", Environment{Unset: []string{"A=B"}}, "invalid variable name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.env.validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+
+	assert.NoError(t, (&Environment{Inherit: []string{"LC_*"}, Set: map[string]string{"A": ""}}).validate())
+
+	policy := DefaultPolicy()
+	policy.Env.Unset = []string{"A=B"}
+	_, err := policy.Command(context.Background(), "echo", "hi")
+	require.Error(t, err)
+}
@@ -6,12 +6,16 @@
 //   - Linux: Uses bubblewrap (bwrap) with namespace isolation
 //   - macOS: Uses Seatbelt (/usr/bin/sandbox-exec) with mandatory access control
 //
+// Other sandboxing tools can be plugged in as a Backend (see RegisterBackend) and
+// selected with Policy.Backend.
+//
 // Security model:
 //
 // By default (zero-value Policy), the sandbox provides maximum isolation:
 //   - No filesystem access (commands will fail to execute)
 //   - Network is blocked
 //   - No /tmp directory
+//   - No host environment variables (see Environment)
 //   - Linux: All namespaces are unshared
 //   - Linux: Child processes die when the parent exits
 //
@@ -99,6 +103,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 )
 
 // Command returns an *exec.Cmd configured to run the specified command
@@ -125,8 +130,107 @@ func (p *Policy) Command(ctx context.Context, name string, arg ...string) (*exec
 		return nil, fmt.Errorf("sandbox: command name must not be empty")
 	}
 
-	// Platform-specific implementations in exec_linux.go and exec_darwin.go
-	return p.commandContext(ctx, name, arg...)
+	if err := p.validateCommand(); err != nil {
+		return nil, err
+	}
+	backend, err := p.selectBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	st := newCmdState(p)
+	st.bwrap = backend.Name() == "bubblewrap"
+	if p.Limits.WallTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.Limits.WallTime, errWallTimeExceeded)
+		st.wallCtx = ctx
+		st.addCleanup(cancel)
+	}
+
+	// Built-in backends in exec_linux.go, exec_darwin.go and landlock_linux.go
+	var cmd *exec.Cmd
+	if hb, ok := backend.(helperBackend); ok {
+		cmd, err = hb.helperCommand(ctx, p, st, name, arg...)
+	} else {
+		cmd, err = backend.Command(ctx, p, name, arg...)
+	}
+	if err != nil {
+		st.release()
+		return nil, err
+	}
+	// The parent's copies of the descriptors handed to the sandbox (e.g., the read ends of
+	// data pipes) are closed with the rest of the run's resources, which also unblocks
+	// pipe writers when the command is never started
+	for _, f := range cmd.ExtraFiles {
+		st.addCleanup(func() { f.Close() })
+	}
+	if err := p.Limits.apply(cmd, st); err != nil {
+		st.release()
+		return nil, err
+	}
+	if p.ReportViolations {
+		if err := p.watchViolations(cmd, st); err != nil {
+			st.release()
+			return nil, err
+		}
+	}
+	if st.helper != nil {
+		if err := wrapWithHelper(cmd, helperModeExec, st.helper); err != nil {
+			st.release()
+			return nil, err
+		}
+	}
+	st.kill = func(cmd *exec.Cmd) error { return killSandbox(cmd, st) }
+	signal := func(sig syscall.Signal) error { return signalSandbox(cmd, sig) }
+	if backend.Name() != defaultBackend {
+		// Other backends forward signals to the sandboxed process themselves
+		signal = func(sig syscall.Signal) error { return cmd.Process.Signal(sig) }
+	}
+	p.Termination.apply(cmd, st, signal)
+	st.attach(cmd)
+	return cmd, nil
+}
+
+// Release frees the host resources held for a command created by Policy.Command: its
+// cgroup, the timer enforcing Limits.WallTime and the descriptors set up to pass data and
+// report violations. CheckLimits and RunCommand call it once the command has been waited
+// for. Otherwise call it after Wait, or when the Cmd is discarded without being started;
+// if it is never called, the resources are only freed when the Cmd is garbage collected.
+//
+// Release must not be called while the command is running. Violations, TerminationOf
+// and CheckLimits still work afterwards: they report the outcome recorded at release,
+// which is kept until the Cmd is garbage collected. It does nothing for other commands
+// and is safe to call more than once.
+func Release(cmd *exec.Cmd) {
+	if st := stateOf(cmd); st != nil {
+		st.finish(cmd)
+	}
+}
+
+// validateCommand checks the parts of the policy that Command relies on being well-formed.
+// Mount sources and the working directory are checked when the arguments are built.
+func (p *Policy) validateCommand() error {
+	if err := p.Limits.validate(); err != nil {
+		return err
+	}
+	if err := p.Termination.validate(); err != nil {
+		return err
+	}
+	if err := p.Output.validate(); err != nil {
+		return err
+	}
+	if err := p.Env.validate(); err != nil {
+		return err
+	}
+	if err := validateFiles(p.Files); err != nil {
+		return err
+	}
+	if p.Identity != nil {
+		if err := p.Identity.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Exec executes the command inside a sandbox and waits for completion.
@@ -20,21 +20,56 @@ var seatbeltBasePolicy string
 
 const seatbeltPath = "/usr/bin/sandbox-exec"
 
-// commandContext implements macOS sandboxing using Seatbelt.
-func (p *Policy) commandContext(ctx context.Context, name string, arg ...string) (*exec.Cmd, error) {
+// defaultBackend is the name of the backend used when Policy.Backend is empty.
+const defaultBackend = "seatbelt"
+
+func init() {
+	RegisterBackend(seatbelt{})
+}
+
+// seatbelt is the macOS Backend, which sandboxes commands with sandbox-exec. Overlays,
+// injected files, synthetic identities and sessions need Linux namespaces and are not
+// supported.
+type seatbelt struct{}
+
+func (seatbelt) Name() string { return "seatbelt" }
+
+func (seatbelt) Supports(f Feature) bool {
+	switch f {
+	case FeatureOverlay, FeatureFiles, FeatureIdentity, FeatureSessions:
+		return false
+	}
+	return true
+}
+
+// Command implements macOS sandboxing using Seatbelt.
+func (seatbelt) Command(ctx context.Context, p *Policy, name string, arg ...string) (*exec.Cmd, error) {
 	// Build full argv
 	argv := append([]string{name}, arg...)
 
-	// Get current environment (caller can override cmd.Env later)
-	envv := os.Environ()
+	// Create the temporary directory, if requested
+	var tmpDir string
+	if p.ProvideTmp {
+		var err error
+		tmpDir, err = makeTmpDir()
+		if err != nil {
+			return nil, fmt.Errorf("seatbelt: %w", err)
+		}
+	}
 
-	// Generate seatbelt arguments
-	// Returns (args, tmpDir, workDir, error) where tmpDir is non-empty if a temp directory was created
-	seatbeltArgs, tmpDir, workDir, err := seatbeltArgs(p, name, argv, envv)
+	// Generate seatbelt arguments, with a unique log tag for violation tracking
+	logTag := fmt.Sprintf("boxedpy-%d-%s", time.Now().Unix(), randomString(8))
+	seatbeltArgs, workDir, err := seatbeltArgs(p, argv, tmpDir, logTag)
 	if err != nil {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
 		return nil, fmt.Errorf("seatbelt: build args: %w", err)
 	}
 
+	// Build the sandbox environment from the policy (caller can extend cmd.Env later)
+	envv := p.commandEnv(tmpDir)
+
 	// Create command: /usr/bin/sandbox-exec -p <policy> -D... -- <command> <args>
 	// seatbeltArgs[0] is seatbeltPath itself, skip it for exec.CommandContext
 	cmd := exec.CommandContext(ctx, seatbeltPath, seatbeltArgs[1:]...)
@@ -43,11 +78,7 @@ func (p *Policy) commandContext(ctx context.Context, name string, arg ...string)
 	// This allows code to use relative paths inside the sandbox
 	cmd.Dir = workDir
 
-	// If a temp directory was created, set TMPDIR to point to it
-	// This provides isolation similar to Linux's tmpfs
 	if tmpDir != "" {
-		cmd.Env = append(cmd.Env, "TMPDIR="+tmpDir)
-
 		// Set up finalizer to clean up temp directory when Cmd is garbage collected.
 		// This is best-effort cleanup - finalizers are not guaranteed to run, but
 		// acceptable for temp directories that the OS will eventually clean up.
@@ -58,28 +89,53 @@ func (p *Policy) commandContext(ctx context.Context, name string, arg ...string)
 		})
 	}
 
-	// If network proxy is configured, add proxy environment variables
+	return cmd, nil
+}
+
+// commandEnv returns the environment of the sandboxed process, given the temporary directory
+// made available to it (if any).
+func (p *Policy) commandEnv(tmpDir string) []string {
+	var injected []string
+	if tmpDir != "" {
+		// Provides isolation similar to Linux's tmpfs
+		injected = append(injected, "TMPDIR="+tmpDir)
+	}
 	if p.NetworkProxy != nil {
-		cmd.Env = append(cmd.Env, p.NetworkProxy.Env()...)
+		injected = append(injected, p.NetworkProxy.Env()...)
 	}
+	return p.environ(injected)
+}
 
-	return cmd, nil
+// makeTmpDir creates a temporary directory for ProvideTmp and returns its canonical path.
+func makeTmpDir() (string, error) {
+	tmpDir, err := os.MkdirTemp("", "boxedpy-sandbox-*")
+	if err != nil {
+		return "", fmt.Errorf("create temp directory: %w", err)
+	}
+	// Canonicalize tmpDir to handle macOS symlinks (/var -> /private/var)
+	canonTmpDir, err := canonicalPath(tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("canonicalize temp directory %s: %w", tmpDir, err)
+	}
+	return canonTmpDir, nil
 }
 
 // seatbeltArgs builds the argument list for sandbox-exec.
-// Returns (args, tmpDir, workDir, error) where:
+// tmpDir is the canonical temporary directory to allow (empty if none) and logTag tags the
+// policy's violation messages.
+// Returns (args, workDir, error) where:
 // - args: full argv including seatbeltPath at [0]
-// - tmpDir: path to created temp directory (empty string if none)
 // - workDir: canonicalized working directory path
 // - error: any error that occurred
-func seatbeltArgs(policy *Policy, name string, argv, envv []string) ([]string, string, string, error) {
+func seatbeltArgs(policy *Policy, argv []string, tmpDir, logTag string) ([]string, string, error) {
 	// Use Policy.WorkDir if specified, otherwise current directory
 	wd := policy.WorkDir
 	if wd == "" {
 		var err error
 		wd, err = os.Getwd()
 		if err != nil {
-			return nil, "", "", fmt.Errorf("getwd: %w", err)
+			return nil, "", fmt.Errorf("getwd: %w", err)
 		}
 	}
 
@@ -91,7 +147,10 @@ func seatbeltArgs(policy *Policy, name string, argv, envv []string) ([]string, s
 	for _, m := range policy.ReadOnlyMounts {
 		canonSrc, err := canonicalPath(m.Source)
 		if err != nil {
-			return nil, "", "", fmt.Errorf("canonicalize readonly mount %s: %w", m.Source, err)
+			return nil, "", fmt.Errorf("canonicalize readonly mount %s: %w", m.Source, err)
+		}
+		if err := checkNotRemapped(m, canonSrc); err != nil {
+			return nil, "", err
 		}
 		if !readableSet.has("", canonSrc) {
 			readableSet.add("", canonSrc)
@@ -108,7 +167,10 @@ func seatbeltArgs(policy *Policy, name string, argv, envv []string) ([]string, s
 	for _, m := range policy.ReadWriteMounts {
 		canonSrc, err := canonicalPath(m.Source)
 		if err != nil {
-			return nil, "", "", fmt.Errorf("canonicalize readwrite mount %s: %w", m.Source, err)
+			return nil, "", fmt.Errorf("canonicalize readwrite mount %s: %w", m.Source, err)
+		}
+		if err := checkNotRemapped(m, canonSrc); err != nil {
+			return nil, "", err
 		}
 		if !writableSet.has("", canonSrc) {
 			writableSet.add("", canonSrc)
@@ -123,7 +185,7 @@ func seatbeltArgs(policy *Policy, name string, argv, envv []string) ([]string, s
 	// Add working directory to writable (and readable)
 	workdir, err := canonicalPath(wd)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("canonicalize working directory: %w", err)
+		return nil, "", fmt.Errorf("canonicalize working directory: %w", err)
 	}
 	if !writableSet.has("", workdir) {
 		writableSet.add("", workdir)
@@ -134,35 +196,29 @@ func seatbeltArgs(policy *Policy, name string, argv, envv []string) ([]string, s
 		readablePaths = append(readablePaths, workdir)
 	}
 
-	// Create and add temporary directory if requested
-	var tmpDir string
-	if policy.ProvideTmp {
-		tmpDir, err = os.MkdirTemp("", "boxedpy-sandbox-*")
-		if err != nil {
-			return nil, "", "", fmt.Errorf("create temp directory: %w", err)
-		}
-		// Canonicalize tmpDir to handle macOS symlinks (/var -> /private/var)
-		canonTmpDir, err := canonicalPath(tmpDir)
-		if err != nil {
-			return nil, "", "", fmt.Errorf("canonicalize temp directory %s: %w", tmpDir, err)
-		}
-		// Allow read-write access to the temp directory
-		// The sandboxed process will access it via TMPDIR env var
-		if !writableSet.has("", canonTmpDir) {
-			writableSet.add("", canonTmpDir)
-			writablePaths = append(writablePaths, canonTmpDir)
+	// Resolve masked paths against everything readable (Seatbelt has no mount remapping)
+	visible := make([]Mount, 0, len(readablePaths))
+	for _, path := range readablePaths {
+		visible = append(visible, Mount{Source: path, Target: path})
+	}
+	masks, err := expandMasks(policy.MaskedPaths, workdir, visible)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Allow read-write access to the temp directory
+	// The sandboxed process will access it via TMPDIR env var
+	if tmpDir != "" {
+		if !writableSet.has("", tmpDir) {
+			writableSet.add("", tmpDir)
+			writablePaths = append(writablePaths, tmpDir)
 		}
-		if !readableSet.has("", canonTmpDir) {
-			readableSet.add("", canonTmpDir)
-			readablePaths = append(readablePaths, canonTmpDir)
+		if !readableSet.has("", tmpDir) {
+			readableSet.add("", tmpDir)
+			readablePaths = append(readablePaths, tmpDir)
 		}
-		// Use canonicalized path for TMPDIR env var
-		tmpDir = canonTmpDir
 	}
 
-	// Generate unique log tag for violation tracking
-	logTag := fmt.Sprintf("boxedpy-%d-%s", time.Now().Unix(), randomString(8))
-
 	// Inject log tag into base policy
 	fullPolicy := strings.ReplaceAll(seatbeltBasePolicy, "boxedpy-LOGTAG", logTag)
 
@@ -189,6 +245,19 @@ func seatbeltArgs(policy *Policy, name string, argv, envv []string) ([]string, s
 		policyBuilder.WriteString(fmt.Sprintf("  (with message \"%s-write\"))\n", logTag))
 	}
 
+	// Deny masked paths; later rules take precedence over the allows above
+	if len(masks) > 0 {
+		policyBuilder.WriteString("(deny file-read* file-write*\n")
+		for i, m := range masks {
+			if m.dir {
+				policyBuilder.WriteString(fmt.Sprintf("  (subpath (param \"MASKED_%d\"))\n", i))
+			} else {
+				policyBuilder.WriteString(fmt.Sprintf("  (literal (param \"MASKED_%d\"))\n", i))
+			}
+		}
+		policyBuilder.WriteString(fmt.Sprintf("  (with message \"%s-masked\"))\n", logTag))
+	}
+
 	// Add network access rules based on policy
 	if policy.NetworkProxy != nil {
 		// Proxy-based network filtering
@@ -232,11 +301,28 @@ func seatbeltArgs(policy *Policy, name string, argv, envv []string) ([]string, s
 		args = append(args, fmt.Sprintf("-DWRITABLE_ROOT_%d=%s", i, path))
 	}
 
+	// Add -D parameter definitions for masked paths
+	for i, m := range masks {
+		args = append(args, fmt.Sprintf("-DMASKED_%d=%s", i, m.path))
+	}
+
 	// Add separator and command
 	args = append(args, "--")
 	args = append(args, argv...)
 
-	return args, tmpDir, workdir, nil
+	return args, workdir, nil
+}
+
+// checkNotRemapped rejects a mount whose Target differs from its Source: Seatbelt only grants
+// access to host paths and cannot make a directory appear somewhere else.
+func checkNotRemapped(m Mount, canonSrc string) error {
+	if m.Target == m.Source {
+		return nil
+	}
+	if canonTgt, err := canonicalPath(m.Target); err == nil && canonTgt == canonSrc {
+		return nil
+	}
+	return fmt.Errorf("mount target %s differs from source %s: path remapping is not supported on macOS", m.Target, m.Source)
 }
 
 // randomString generates a random alphanumeric string of length n.
@@ -4,9 +4,14 @@ package sandbox
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Linux-specific types for bubblewrap mount handling
@@ -16,8 +21,23 @@ type mount struct {
 	target string
 }
 
-// commandContext implements Linux sandboxing using bubblewrap.
-func (p *Policy) commandContext(ctx context.Context, name string, arg ...string) (*exec.Cmd, error) {
+// defaultBackend is the name of the backend used when Policy.Backend is empty.
+const defaultBackend = "bubblewrap"
+
+func init() {
+	RegisterBackend(bubblewrap{})
+}
+
+// bubblewrap is the Linux Backend, which sandboxes commands with bubblewrap (bwrap). It
+// supports every feature.
+type bubblewrap struct{}
+
+func (bubblewrap) Name() string { return "bubblewrap" }
+
+func (bubblewrap) Supports(f Feature) bool { return true }
+
+// Command implements Linux sandboxing using bubblewrap.
+func (bubblewrap) Command(ctx context.Context, p *Policy, name string, arg ...string) (*exec.Cmd, error) {
 	bwrapPath, err := exec.LookPath("bwrap")
 	if err != nil {
 		return nil, fmt.Errorf("sandbox: bwrap not found: %w", err)
@@ -26,11 +46,11 @@ func (p *Policy) commandContext(ctx context.Context, name string, arg ...string)
 	// Build full argv (name + args)
 	argv := append([]string{name}, arg...)
 
-	// Get current environment (caller can override cmd.Env later)
-	envv := os.Environ()
+	// Build the sandbox environment from the policy (caller can extend cmd.Env later)
+	envv := p.commandEnv()
 
 	// Generate bubblewrap arguments
-	bwrapArgs, err := bubblewrapArgs(p, name, argv, envv)
+	bwrapArgs, files, err := bubblewrapArgs(p, name, argv, envv)
 	if err != nil {
 		return nil, fmt.Errorf("sandbox: build bubblewrap args: %w", err)
 	}
@@ -40,86 +60,156 @@ func (p *Policy) commandContext(ctx context.Context, name string, arg ...string)
 	cmd := exec.CommandContext(ctx, bwrapPath, bwrapArgs[1:]...)
 	cmd.Env = envv
 
-	// If network proxy is configured, add proxy environment variables
-	if p.NetworkProxy != nil {
-		cmd.Env = append(cmd.Env, p.NetworkProxy.Env()...)
+	// Data referenced by file descriptor in the bwrap arguments (e.g., --seccomp)
+	for _, data := range files {
+		f, err := dataPipe(data)
+		if err != nil {
+			for _, f := range cmd.ExtraFiles {
+				f.Close()
+			}
+			return nil, fmt.Errorf("sandbox: %w", err)
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
 	}
 
 	return cmd, nil
 }
 
+// commandEnv returns the environment of the sandboxed process.
+func (p *Policy) commandEnv() []string {
+	var injected []string
+	if p.ProvideTmp {
+		injected = append(injected, "TMPDIR=/tmp")
+	}
+	if p.NetworkProxy != nil {
+		injected = append(injected, proxyShimEnv()...)
+	}
+	if p.Identity != nil {
+		injected = append(injected, p.Identity.env()...)
+	}
+	return p.environ(injected)
+}
+
+// Where the executable and the NetworkProxy's sockets are mounted for the proxy shim.
+// Like sessionServerPath, they lie directly in the sandbox root, which bwrap creates, so
+// that no mount of the policy can get in the way; the sockets' host directory is often
+// below /tmp, which ProvideTmp replaces.
+const (
+	proxyShimPath  = "/.boxedpy-proxy"
+	proxySocketDir = "/.boxedpy-proxy-sockets"
+)
+
 // bubblewrapArgs builds the argument list for bwrap.
-// Returns the full argv including bwrapPath at [0].
-func bubblewrapArgs(policy *Policy, name string, argv, envv []string) ([]string, error) {
-	// Use Policy.WorkDir if specified, otherwise current directory
+// Returns the full argv including bwrapPath at [0], and the contents of the files that
+// the arguments refer to by descriptor number: files[i] must be inherited as fd 3+i.
+func bubblewrapArgs(policy *Policy, name string, argv, envv []string) ([]string, [][]byte, error) {
+	// Use Policy.WorkDir if specified, otherwise the overlay or current directory
 	wd := policy.WorkDir
+	if wd == "" && policy.Overlay != nil {
+		wd = policy.Overlay.Dir()
+	}
 	if wd == "" {
 		var err error
 		wd, err = os.Getwd()
 		if err != nil {
-			return nil, fmt.Errorf("getwd: %w", err)
+			return nil, nil, fmt.Errorf("getwd: %w", err)
 		}
 	}
 
 	bwrapPath, err := exec.LookPath("bwrap")
 	if err != nil {
-		return nil, fmt.Errorf("lookpath bwrap: %w", err)
+		return nil, nil, fmt.Errorf("lookpath bwrap: %w", err)
 	}
 
 	args := []string{bwrapPath}
 	seen := newMountSet()
+	var fds fdTable
+	var visible []Mount // canonical mounts, for resolving MaskedPaths
 
-	// Mount read-only paths from policy (with canonicalization)
+	// Collect mounts from policy (with canonicalization of sources)
+	var mounts []mount
 	for _, m := range policy.ReadOnlyMounts {
 		canonSrc, err := canonicalPath(m.Source)
 		if err != nil {
-			return nil, fmt.Errorf("canonicalize readonly mount %s: %w", m.Source, err)
+			return nil, nil, fmt.Errorf("canonicalize readonly mount %s: %w", m.Source, err)
 		}
-		canonTgt, err := canonicalPath(m.Target)
+		canonTgt, err := mountTarget(m, canonSrc)
 		if err != nil {
-			return nil, fmt.Errorf("canonicalize readonly target %s: %w", m.Target, err)
-		}
-		args, err = appendMount(args, seen, mount{flag: "--ro-bind", source: canonSrc, target: canonTgt})
-		if err != nil {
-			return nil, err
+			return nil, nil, fmt.Errorf("readonly mount %s: %w", m.Source, err)
 		}
+		mounts = append(mounts, mount{flag: "--ro-bind", source: canonSrc, target: canonTgt})
 	}
-
-	// Mount read-write paths from policy (with canonicalization)
 	for _, m := range policy.ReadWriteMounts {
 		canonSrc, err := canonicalPath(m.Source)
 		if err != nil {
-			return nil, fmt.Errorf("canonicalize readwrite mount %s: %w", m.Source, err)
+			return nil, nil, fmt.Errorf("canonicalize readwrite mount %s: %w", m.Source, err)
 		}
-		canonTgt, err := canonicalPath(m.Target)
+		canonTgt, err := mountTarget(m, canonSrc)
 		if err != nil {
-			return nil, fmt.Errorf("canonicalize readwrite target %s: %w", m.Target, err)
+			return nil, nil, fmt.Errorf("readwrite mount %s: %w", m.Source, err)
+		}
+		mounts = append(mounts, mount{flag: "--bind", source: canonSrc, target: canonTgt})
+	}
+	if id := policy.Identity; id != nil {
+		// Private home directory; sorted with the mounts so that mounts below it stay visible
+		mounts = append(mounts, mount{flag: "--tmpfs", target: id.home()})
+	}
+
+	// Mount outer targets before nested ones (e.g., /work before /work/data), so that a
+	// nested mount is not shadowed; otherwise read-only mounts come first, in policy order
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return pathDepth(mounts[i].target) < pathDepth(mounts[j].target)
+	})
+	for _, m := range mounts {
+		if m.flag == "--tmpfs" {
+			args = append(args, m.flag, m.target)
+			continue
 		}
-		args, err = appendMount(args, seen, mount{flag: "--bind", source: canonSrc, target: canonTgt})
+		args, err = appendMount(args, seen, m)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		visible = append(visible, Mount{Source: m.source, Target: m.target})
 	}
 
-	// Mount Unix sockets for network proxy (if configured)
+	// Network proxy: mount its Unix sockets and the executable that runs the proxy shim,
+	// which serves them on loopback TCP ports before executing the command
+	var shim []string
 	if policy.NetworkProxy != nil {
-		// Extract Unix socket paths from proxy addresses
-		httpSock := extractUnixSocketPath(policy.NetworkProxy.HTTPAddr())
-		socksSock := extractUnixSocketPath(policy.NetworkProxy.SOCKSAddr())
-
-		// Mount sockets into sandbox (read-write for bidirectional communication)
-		if httpSock != "" {
-			args, err = appendMount(args, seen, mount{flag: "--bind", source: httpSock, target: httpSock})
-			if err != nil {
-				return nil, fmt.Errorf("mount http proxy socket: %w", err)
-			}
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, nil, fmt.Errorf("locate helper executable: %w", err)
+		}
+		args, err = appendMount(args, seen, mount{flag: "--ro-bind", source: exe, target: proxyShimPath})
+		if err != nil {
+			return nil, nil, fmt.Errorf("mount proxy shim: %w", err)
 		}
-		if socksSock != "" {
-			args, err = appendMount(args, seen, mount{flag: "--bind", source: socksSock, target: socksSock})
+		var cfg helperConfig
+		for _, sock := range []struct {
+			addr string
+			port int
+		}{
+			{policy.NetworkProxy.HTTPAddr(), proxyShimHTTPPort},
+			{policy.NetworkProxy.SOCKSAddr(), proxyShimSOCKSPort},
+		} {
+			path := extractUnixSocketPath(sock.addr)
+			if path == "" {
+				return nil, nil, fmt.Errorf("network proxy address %s is not a Unix socket", sock.addr)
+			}
+			// Read-write, as connecting to a socket requires write access to it
+			target := filepath.Join(proxySocketDir, filepath.Base(path))
+			args, err = appendMount(args, seen, mount{flag: "--bind", source: path, target: target})
 			if err != nil {
-				return nil, fmt.Errorf("mount socks proxy socket: %w", err)
+				return nil, nil, fmt.Errorf("mount proxy socket: %w", err)
 			}
+			cfg.ProxyForwards = append(cfg.ProxyForwards, proxyForward{Port: sock.port, Socket: target})
 		}
+		data, err := json.Marshal(&cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encode proxy shim config: %w", err)
+		}
+		shim = []string{proxyShimPath, string(data), "--"}
+		args = append(args, "--setenv", helperEnvVar, helperModeProxy)
 	}
 
 	// Essential virtual filesystems (always required for process execution)
@@ -163,6 +253,26 @@ func bubblewrapArgs(policy *Policy, name string, argv, envv []string) ([]string,
 	}
 	// else: both shared namespaces and network allowed - no unsharing
 
+	// Synthetic identity: map the host user to the sandbox user and set a neutral hostname
+	if id := policy.Identity; id != nil {
+		args = append(args, "--unshare-user",
+			"--uid", strconv.Itoa(id.uid()),
+			"--gid", strconv.Itoa(id.gid()))
+		if policy.AllowSharedNamespaces {
+			args = append(args, "--unshare-uts")
+		}
+		args = append(args, "--hostname", id.hostname())
+	}
+
+	// System call filter, read by bwrap from an inherited descriptor
+	if policy.Seccomp != nil {
+		prog, err := policy.Seccomp.compile()
+		if err != nil {
+			return nil, nil, fmt.Errorf("compile seccomp profile: %w", err)
+		}
+		args = append(args, "--seccomp", fds.add(prog))
+	}
+
 	// Process lifecycle control
 	if !policy.AllowParentSurvival {
 		args = append(args, "--die-with-parent")
@@ -171,22 +281,125 @@ func bubblewrapArgs(policy *Policy, name string, argv, envv []string) ([]string,
 		args = append(args, "--new-session")
 	}
 
-	// Mount working directory as read-write (with canonicalization)
-	workdir, err := canonicalPath(wd)
-	if err != nil {
-		return nil, fmt.Errorf("canonicalize working directory: %w", err)
+	// Mount working directory as read-write (with canonicalization), unless it names a
+	// location inside a remapped mount (e.g., "/work"): that is a sandbox path which is
+	// already visible and only needs to be entered
+	var workdir string
+	if policy.Overlay == nil && inRemappedMount(visible, wd) {
+		workdir = filepath.Clean(wd)
+	} else {
+		workdir, err = canonicalPath(wd)
+		if err != nil {
+			return nil, nil, fmt.Errorf("canonicalize working directory: %w", err)
+		}
+		if ov := policy.Overlay; ov != nil {
+			// Copy-on-write: writes land in the overlay's upper layer, not in the host directory
+			if workdir != ov.Dir() {
+				return nil, nil, fmt.Errorf("overlay directory %s does not match working directory %s", ov.Dir(), workdir)
+			}
+			upper, work, err := ov.layers()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, "--overlay-src", workdir, "--overlay", upper, work, workdir)
+			seen.add("--overlay", workdir)
+		} else {
+			args, err = appendMount(args, seen, mount{flag: "--bind", source: workdir, target: workdir})
+			if err != nil {
+				return nil, nil, fmt.Errorf("bind working directory: %w", err)
+			}
+		}
+		visible = append(visible, Mount{Source: workdir, Target: workdir})
+	}
+
+	// Inject in-memory files, read by bwrap from inherited descriptors
+	files := policy.Files
+	if id := policy.Identity; id != nil {
+		files = id.files(files)
 	}
-	args, err = appendMount(args, seen, mount{flag: "--bind", source: workdir, target: workdir})
+	for _, target := range fileTargets(files) {
+		f := files[target]
+		flag := "--ro-bind-data"
+		if f.Writable {
+			// --file copies the data to target, which must not be a host directory
+			if m, ok := mountContaining(visible, target, func(m Mount) string { return m.Target }); ok {
+				return nil, nil, fmt.Errorf("writable file %s lies inside mount %s and would be written to the host", target, m.Target)
+			}
+			flag = "--file"
+		}
+		args = append(args, "--perms", fmt.Sprintf("%04o", f.perms()), flag, fds.add(f.Data), target)
+	}
+
+	// Hide masked paths, after all mounts so nothing is mounted over the masks
+	masks, err := expandMasks(policy.MaskedPaths, workdir, visible)
 	if err != nil {
-		return nil, fmt.Errorf("bind working directory: %w", err)
+		return nil, nil, err
 	}
+	for _, m := range masks {
+		if m.dir {
+			args = append(args, "--tmpfs", m.path, "--remount-ro", m.path)
+		} else {
+			args = append(args, "--ro-bind", "/dev/null", m.path)
+		}
+	}
+
 	args = append(args, "--chdir", workdir)
 
 	// Append the separator and the actual command + arguments
 	args = append(args, "--")
+	args = append(args, shim...)
 	args = append(args, argv...)
 
-	return args, nil
+	return args, fds.files, nil
+}
+
+// mountTarget returns where m appears inside the sandbox. A Target equal to Source keeps the
+// host layout and resolves to the canonical source, like every other host path. Any other
+// Target is a path in the sandbox's own namespace, which bwrap creates along with missing
+// parent directories, so it is only required to be absolute and clean.
+func mountTarget(m Mount, canonSrc string) (string, error) {
+	if m.Target == m.Source {
+		return canonSrc, nil
+	}
+	if !filepath.IsAbs(m.Target) || filepath.Clean(m.Target) != m.Target {
+		return "", fmt.Errorf("target %q must be an absolute, clean path", m.Target)
+	}
+	return m.Target, nil
+}
+
+// pathDepth returns the number of components in a clean absolute path.
+func pathDepth(path string) int {
+	if path == "/" {
+		return 0
+	}
+	return strings.Count(path, "/")
+}
+
+// fdTable collects data passed to bwrap through inherited file descriptors.
+// Descriptors are numbered from 3, in the order the data was added.
+type fdTable struct {
+	files [][]byte
+}
+
+// add registers data and returns the descriptor number bwrap will read it from.
+func (t *fdTable) add(data []byte) string {
+	t.files = append(t.files, data)
+	return strconv.Itoa(2 + len(t.files))
+}
+
+// dataPipe returns the read end of a pipe that yields data and then EOF.
+// Data is written from a goroutine so contents larger than the pipe buffer do not block;
+// if the command is never started, the write fails once the read end is closed.
+func dataPipe(data []byte) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create pipe: %w", err)
+	}
+	go func() {
+		defer w.Close()
+		w.Write(data)
+	}()
+	return r, nil
 }
 
 // appendMount adds a mount entry to the bubblewrap args if not already present.
@@ -0,0 +1,296 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"weak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMountTarget(t *testing.T) {
+	t.Parallel()
+
+	target, err := mountTarget(Mount{Source: "/bin", Target: "/bin"}, "/usr/bin")
+	require.NoError(t, err)
+	assert.Equal(t, "/usr/bin", target, "identity mounts follow the canonical source")
+
+	target, err = mountTarget(Mount{Source: "/home/alice/x", Target: "/work"}, "/home/alice/x")
+	require.NoError(t, err)
+	assert.Equal(t, "/work", target)
+
+	for _, bad := range []string{"", "work", "/work/", "/work/../etc", "/work//data"} {
+		_, err := mountTarget(Mount{Source: "/src", Target: bad}, "/src")
+		assert.Error(t, err, "target %q", bad)
+	}
+}
+
+func TestRemappedMountArgs(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	project, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	data, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(project, ".env"), nil, 0o644))
+
+	policy := DefaultPolicy()
+	policy.ReadOnlyMounts = append(policy.ReadOnlyMounts, Mount{Source: data, Target: "/work/data"})
+	policy.ReadWriteMounts = append(policy.ReadWriteMounts, Mount{Source: project, Target: "/work"})
+	policy.WorkDir = "/work"
+	policy.MaskedPaths = []string{".env"}
+
+	args, _, err := bubblewrapArgs(policy, "true", []string{"true"}, nil)
+	require.NoError(t, err)
+	joined := strings.Join(args, " ")
+
+	outer := strings.Index(joined, "--bind "+project+" /work ")
+	inner := strings.Index(joined, "--ro-bind "+data+" /work/data ")
+	require.GreaterOrEqual(t, outer, 0)
+	require.GreaterOrEqual(t, inner, 0)
+	assert.Less(t, outer, inner, "nested target must be mounted after its parent")
+
+	// WorkDir is a sandbox path: entered, not bind-mounted from the host
+	assert.NotContains(t, joined, "--bind /work /work")
+	assert.Contains(t, joined, "--chdir /work ")
+	assert.Contains(t, joined, "--ro-bind /dev/null /work/.env")
+
+	policy.ReadOnlyMounts = append(policy.ReadOnlyMounts, Mount{Source: data, Target: "relative"})
+	_, err = policy.Command(context.Background(), "true")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "absolute, clean path")
+}
+
+func TestFilesArgs(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	policy := DefaultPolicy()
+	policy.WorkDir = dir
+	policy.Files = map[string]File{
+		"/etc/pip.conf": {Data: []byte("[global]\n")},
+		"/tmp/input.py": {Data: []byte("print(1)\n"), Writable: true},
+		"/opt/tool/run": {Data: []byte("#!/bin/sh\n"), Mode: 0o555},
+	}
+	args, files, err := bubblewrapArgs(policy, "true", []string{"true"}, nil)
+	require.NoError(t, err)
+	joined := strings.Join(args, " ")
+
+	// Descriptor 3 carries the seccomp filter; files follow in sorted target order
+	assert.Contains(t, joined, "--perms 0444 --ro-bind-data 4 /etc/pip.conf")
+	assert.Contains(t, joined, "--perms 0555 --ro-bind-data 5 /opt/tool/run")
+	assert.Contains(t, joined, "--perms 0644 --file 6 /tmp/input.py")
+	require.Len(t, files, 4)
+	assert.Equal(t, "[global]\n", string(files[1]))
+	assert.Equal(t, "print(1)\n", string(files[3]))
+	assert.Less(t, strings.Index(joined, "--ro-bind /etc /etc"), strings.Index(joined, "--ro-bind-data 4"),
+		"files are placed after the mounts they shadow")
+	assert.Less(t, strings.Index(joined, "--tmpfs /tmp"), strings.Index(joined, "--file 6"))
+
+	// A writable file inside a mount would be written to the host
+	policy.Files = map[string]File{dir + "/out.txt": {Writable: true}}
+	_, _, err = bubblewrapArgs(policy, "true", []string{"true"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "would be written to the host")
+}
+
+func TestReleaseClosesDataPipes(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	// Larger than a pipe buffer, so the writer blocks until the read end is closed
+	policy := DefaultPolicy()
+	policy.Files = map[string]File{"/opt/big": {Data: make([]byte, 1<<20)}}
+	cmd, err := policy.Command(context.Background(), "true")
+	require.NoError(t, err)
+	require.NotEmpty(t, cmd.ExtraFiles)
+
+	Release(cmd)
+	for _, f := range cmd.ExtraFiles {
+		assert.ErrorIs(t, f.Close(), os.ErrClosed)
+	}
+}
+
+func TestCommandStateCollected(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	// Commands that are dropped without Release must not be kept alive by their state
+	policy := DefaultPolicy()
+	policy.Termination = Termination{Signal: syscall.SIGINT}
+	var keys []weak.Pointer[exec.Cmd]
+	for range 50 {
+		cmd, err := policy.Command(context.Background(), "true")
+		require.NoError(t, err)
+		keys = append(keys, weak.Make(cmd))
+	}
+	require.Eventually(t, func() bool {
+		runtime.GC()
+		for _, key := range keys {
+			if _, ok := cmdStates.Load(key); ok {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProxyShimArgs(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	proxy, err := NewNetworkProxy(nil)
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	policy := DefaultPolicy()
+	policy.NetworkProxy = proxy
+	env := policy.commandEnv()
+	assert.Contains(t, env, "HTTPS_PROXY=http://127.0.0.1:3128")
+	assert.Contains(t, env, "ALL_PROXY=socks5h://127.0.0.1:1080")
+
+	args, _, err := bubblewrapArgs(policy, "python3", []string{"python3", "-V"}, env)
+	require.NoError(t, err)
+	joined := strings.Join(args, " ")
+	httpSock := strings.TrimPrefix(proxy.HTTPAddr(), "unix://")
+	assert.Contains(t, joined, "--bind "+httpSock+" /.boxedpy-proxy-sockets/http.sock")
+	assert.Contains(t, joined, "--setenv "+helperEnvVar+" proxy")
+
+	// The command is started by the shim
+	sep := slices.Index(args, "--")
+	require.GreaterOrEqual(t, sep, 0)
+	tail := args[sep+1:]
+	require.Len(t, tail, 5)
+	assert.Equal(t, "/.boxedpy-proxy", tail[0])
+	var cfg helperConfig
+	require.NoError(t, json.Unmarshal([]byte(tail[1]), &cfg))
+	assert.Equal(t, []proxyForward{
+		{Port: 3128, Socket: "/.boxedpy-proxy-sockets/http.sock"},
+		{Port: 1080, Socket: "/.boxedpy-proxy-sockets/socks.sock"},
+	}, cfg.ProxyForwards)
+	assert.Equal(t, []string{"--", "python3", "-V"}, tail[2:])
+
+	plan, err := bubblewrapPlan(args)
+	require.NoError(t, err)
+	assert.Equal(t, NetworkNone, plan.Network)
+}
+
+func TestIntegrationInjectedFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	dir := t.TempDir()
+	policy := pythonPolicy()
+	policy.WorkDir = dir
+	policy.Files = map[string]File{
+		"/etc/boxedpy.conf": {Data: []byte("injected")},
+		"/tmp/scratch.txt":  {Data: []byte("scratch"), Writable: true},
+	}
+	cmd, err := policy.Command(context.Background(), pythonPath, "-c", `
+print(open("/etc/boxedpy.conf").read())
+with open("/tmp/scratch.txt", "a") as f:
+    f.write("+more")
+print(open("/tmp/scratch.txt").read())
+try:
+    open("/etc/boxedpy.conf", "w")
+except OSError:
+    print("read-only")
+`)
+	require.NoError(t, err)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %s", output)
+
+	assert.Equal(t, "injected\nscratch+more\nread-only\n", string(output))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestIdentityArgs(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	cache, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	policy := DefaultPolicy()
+	policy.WorkDir = t.TempDir()
+	policy.Identity = &Identity{UID: 1500, Hostname: "box"}
+	policy.ReadWriteMounts = append(policy.ReadWriteMounts, Mount{Source: cache, Target: "/home/sandbox/.cache"})
+	args, files, err := bubblewrapArgs(policy, "true", []string{"true"}, nil)
+	require.NoError(t, err)
+	joined := strings.Join(args, " ")
+
+	assert.Contains(t, joined, "--unshare-user --uid 1500 --gid 1000 --hostname box")
+	assert.Contains(t, joined, "--ro-bind-data 4 /etc/group --perms 0444 --ro-bind-data 5 /etc/passwd")
+	assert.Contains(t, string(files[2]), "sandbox:x:1500:1000:sandbox:/home/sandbox:/bin/sh")
+	assert.Less(t, strings.Index(joined, "--tmpfs /home/sandbox"), strings.Index(joined, "/home/sandbox/.cache"),
+		"mounts below HOME are not shadowed by it")
+
+	policy.AllowSharedNamespaces = true
+	args, _, err = bubblewrapArgs(policy, "true", []string{"true"}, nil)
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(args, " "), "--unshare-uts --hostname box")
+}
+
+func TestIntegrationIdentity(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	policy := pythonPolicy()
+	policy.WorkDir = t.TempDir()
+	policy.Identity = &Identity{TZ: "UTC"}
+	cmd, err := policy.Command(context.Background(), pythonPath, "-c", `
+import getpass, os, pwd, socket, time
+print(getpass.getuser(), os.getuid(), pwd.getpwuid(os.getuid()).pw_dir)
+print(os.path.expanduser("~"), socket.gethostname(), time.tzname[0])
+open(os.path.expanduser("~/.probe"), "w").write("ok")
+`)
+	require.NoError(t, err)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %s", output)
+
+	assert.Equal(t, "sandbox 1000 /home/sandbox\n/home/sandbox sandbox UTC\n", string(output))
+}
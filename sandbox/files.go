@@ -0,0 +1,59 @@
+package sandbox
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+// File is an in-memory file placed inside the sandbox by Policy.Files. Its contents are
+// handed to the sandbox through a pipe and never written to the host filesystem.
+type File struct {
+	// Data is the contents of the file.
+	Data []byte
+
+	// Mode holds the permission bits of the file (default 0444, or 0644 when Writable).
+	Mode fs.FileMode
+
+	// Writable makes the file modifiable inside the sandbox. A writable file is a private copy
+	// in the sandbox's memory-backed root filesystem (or its /tmp), so it must not lie inside
+	// a mount or the working directory, where it would be written to the host.
+	Writable bool
+}
+
+// perms returns the permission bits to create the file with.
+func (f *File) perms() fs.FileMode {
+	switch {
+	case f.Mode != 0:
+		return f.Mode.Perm()
+	case f.Writable:
+		return 0o644
+	}
+	return 0o444
+}
+
+// validateFiles checks that every injected file has an absolute, clean target and a mode
+// made of permission bits only.
+func validateFiles(files map[string]File) error {
+	for target, f := range files {
+		if !filepath.IsAbs(target) || filepath.Clean(target) != target || target == "/" {
+			return fmt.Errorf("sandbox: file target %q must be an absolute, clean path", target)
+		}
+		if f.Mode&^fs.ModePerm != 0 {
+			return fmt.Errorf("sandbox: file %s: mode %v has bits other than permissions", target, f.Mode)
+		}
+	}
+	return nil
+}
+
+// fileTargets returns the targets of files in sorted order, so that identical policies
+// produce identical arguments.
+func fileTargets(files map[string]File) []string {
+	targets := make([]string, 0, len(files))
+	for target := range files {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
@@ -0,0 +1,34 @@
+package sandbox
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilePerms(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, fs.FileMode(0o444), (&File{}).perms())
+	assert.Equal(t, fs.FileMode(0o644), (&File{Writable: true}).perms())
+	assert.Equal(t, fs.FileMode(0o755), (&File{Mode: 0o755}).perms())
+}
+
+func TestValidateFiles(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateFiles(map[string]File{"/etc/pip.conf": {}, "/tmp/run.py": {Writable: true}}))
+	assert.Error(t, validateFiles(map[string]File{"etc/pip.conf": {}}))
+	assert.Error(t, validateFiles(map[string]File{"/etc/../pip.conf": {}}))
+	assert.Error(t, validateFiles(map[string]File{"/": {}}))
+	assert.Error(t, validateFiles(map[string]File{"/bin/tool": {Mode: fs.ModeSetuid | 0o755}}))
+
+	policy := DefaultPolicy()
+	policy.Files = map[string]File{"relative.txt": {Data: []byte("x")}}
+	_, err := policy.Command(context.Background(), "true")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "absolute, clean path")
+}
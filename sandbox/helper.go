@@ -0,0 +1,210 @@
+//go:build linux || darwin
+
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// helperEnvVar selects a helper mode when the sandbox package re-executes the current
+// binary. Because the check runs in this package's init function, every program that
+// imports the sandbox package can act as its own helper; no separate binary needs to be
+// installed. The variable is removed from the environment before the helper runs its target.
+const helperEnvVar = "BOXEDPY_SANDBOX_HELPER"
+
+// helperModeExec applies process attributes (rlimits, priorities) to the helper itself
+// and then execs the target, which inherits them.
+const helperModeExec = "exec"
+
+// helperModeAttach runs the target inside a Session rather than executing it; see
+// Session.Command.
+const helperModeAttach = "attach"
+
+func init() {
+	mode, ok := os.LookupEnv(helperEnvVar)
+	if !ok {
+		return
+	}
+	os.Unsetenv(helperEnvVar)
+	// Priorities are per-thread on Linux and must be set on the thread that calls execve.
+	runtime.LockOSThread()
+	os.Exit(runHelper(mode, os.Args[1:]))
+}
+
+// helperConfig is passed JSON-encoded as the helper's first argument.
+type helperConfig struct {
+	Rlimits []helperRlimit `json:"rlimits,omitempty"`
+	Nice    int            `json:"nice,omitempty"`
+	IOPrio  int            `json:"ioprio,omitempty"`
+
+	// NotifyFilter is a seccomp program installed with a user-notification listener, which
+	// is sent over the Unix socket at descriptor NotifySocket (see Policy.ReportViolations).
+	NotifyFilter []byte `json:"notify_filter,omitempty"`
+	NotifySocket int    `json:"notify_socket,omitempty"`
+
+	// Landlock and Seccomp restrict the target when the landlock backend is used: the
+	// Landlock rules are enforced first, then the seccomp programs are installed in order.
+	Landlock *landlockRules `json:"landlock,omitempty"`
+	Seccomp  [][]byte       `json:"seccomp,omitempty"`
+
+	// Session is the descriptor of the request socket of a Session (attach mode).
+	Session int `json:"session,omitempty"`
+
+	// ProxyForwards are the loopback ports of the proxy shim (proxy and forward modes).
+	// In forward mode, ProxyDetach starts the forwarder in a new process and exits, and
+	// ProxyTarget means that the descriptor after the listeners is a pidfd of the target,
+	// whose exit ends the forwarder.
+	ProxyForwards []proxyForward `json:"proxy_forwards,omitempty"`
+	ProxyDetach   bool           `json:"proxy_detach,omitempty"`
+	ProxyTarget   bool           `json:"proxy_target,omitempty"`
+}
+
+// helperRlimit is a single setrlimit(2) call made by the helper.
+type helperRlimit struct {
+	Resource int    `json:"resource"`
+	Cur      uint64 `json:"cur"`
+	Max      uint64 `json:"max"`
+}
+
+// runHelper executes a helper mode. args is [config, "--", argv...].
+// It only returns on failure; the result is used as the process exit status.
+func runHelper(mode string, args []string) int {
+	if len(args) < 3 || args[1] != "--" {
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: malformed arguments\n")
+		return 127
+	}
+	var cfg helperConfig
+	if err := json.Unmarshal([]byte(args[0]), &cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: decode config: %v\n", err)
+		return 127
+	}
+	argv := args[2:]
+	path := argv[0]
+
+	switch mode {
+	case helperModeExec:
+		if err := applyProcessAttrs(&cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: %v\n", err)
+			return 127
+		}
+		if cfg.Landlock != nil {
+			if err := restrictLandlock(cfg.Landlock); err != nil {
+				fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: %v\n", err)
+				return 127
+			}
+		}
+		for _, prog := range cfg.Seccomp {
+			if err := installFilter(prog); err != nil {
+				fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: %v\n", err)
+				return 127
+			}
+		}
+		if cfg.NotifySocket != 0 {
+			// Last, so that nothing the helper does itself is reported
+			if err := installNotifyFilter(cfg.NotifyFilter, cfg.NotifySocket); err != nil {
+				fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: report violations: %v\n", err)
+				return 127
+			}
+		}
+	case helperModeAttach:
+		return runAttach(&cfg, argv)
+	case helperModeProxy:
+		if err := startProxyForwarder(&cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: %v\n", err)
+			return 127
+		}
+		// Inside the sandbox the target is still to be found in PATH, as bwrap would
+		var err error
+		if path, err = exec.LookPath(argv[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: %v\n", err)
+			return 127
+		}
+	case helperModeForward:
+		return runProxyForwarder(&cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: unknown mode %q\n", mode)
+		return 127
+	}
+
+	err := syscall.Exec(path, argv, os.Environ())
+	fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: exec %s: %v\n", path, err)
+	return 127
+}
+
+// applyProcessAttrs sets the rlimits and priorities from cfg on the current process.
+func applyProcessAttrs(cfg *helperConfig) error {
+	for _, rl := range cfg.Rlimits {
+		lim := syscall.Rlimit{Cur: rl.Cur, Max: rl.Max}
+		if err := syscall.Setrlimit(rl.Resource, &lim); err != nil {
+			return fmt.Errorf("setrlimit %d: %w", rl.Resource, err)
+		}
+	}
+	if cfg.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, cfg.Nice); err != nil {
+			return fmt.Errorf("setpriority: %w", err)
+		}
+	}
+	if cfg.IOPrio != 0 {
+		if err := setIOPriority(cfg.IOPrio); err != nil {
+			return fmt.Errorf("ioprio_set: %w", err)
+		}
+	}
+	return nil
+}
+
+// wrapWithHelper rewrites cmd so that it starts the helper in the given mode, which in
+// turn execs the original command. cmd must not have been started.
+func wrapWithHelper(cmd *exec.Cmd, mode string, cfg *helperConfig) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("sandbox: locate helper executable: %w", err)
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("sandbox: encode helper config: %w", err)
+	}
+
+	args := []string{exe, string(data), "--", cmd.Path}
+	args = append(args, cmd.Args[1:]...)
+	cmd.Path = exe
+	cmd.Args = args
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, helperEnvVar+"="+mode)
+	return nil
+}
+
+// apply configures cmd to enforce the limits, creating a per-run cgroup where supported
+// and requesting the helper for rlimits and priorities.
+func (l *Limits) apply(cmd *exec.Cmd, st *cmdState) error {
+	cgroupManaged, err := setupCgroup(cmd, l, st)
+	if err != nil {
+		return err
+	}
+	if !l.needsHelper(cgroupManaged) {
+		return nil
+	}
+	cfg := st.helperConfig()
+	cfg.Rlimits = l.rlimits(cgroupManaged)
+	cfg.Nice = l.Nice
+	cfg.IOPrio = ioprioValue(l.IOClass, l.IOLevel)
+	return nil
+}
+
+// ioprioValue encodes an I/O class and level as expected by ioprio_set(2).
+func ioprioValue(class IOClass, level int) int {
+	const classShift = 13
+	switch class {
+	case IOClassBestEffort:
+		return 2<<classShift | level
+	case IOClassIdle:
+		return 3 << classShift
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default values for the fields of Identity.
+const (
+	defaultIdentityUser     = "sandbox"
+	defaultIdentityID       = 1000
+	defaultIdentityHostname = "sandbox"
+)
+
+// Identity gives the sandboxed process a synthetic system identity instead of the host's:
+// an unprivileged user with its own passwd and group entries, a private writable HOME and a
+// neutral hostname. This makes os.path.expanduser, getpass.getuser() and pip's cache
+// directory work, and hides the host's user names and hostname.
+//
+// The zero value is usable and runs as "sandbox" (uid and gid 1000) with HOME=/home/sandbox
+// on host "sandbox".
+//
+// The following variables are injected into the environment (see Environment):
+//   - HOME, USER and LOGNAME
+//   - TZ and LANG, when set below
+//
+// To pass the host's timezone and locale through instead of pinning them, leave TZ and Locale
+// empty and list the variables in Policy.Env.Inherit (e.g., "TZ", "LANG", "LC_*").
+//
+// Linux only (user and UTS namespaces); Policy.Command fails on macOS when Identity is set.
+type Identity struct {
+	// User is the login name (default "sandbox").
+	User string
+
+	// UID and GID are the user and group IDs inside the sandbox (default 1000). The host
+	// user is mapped to them through a user namespace; the sandbox never runs as root.
+	UID int
+	GID int
+
+	// Home is the home directory, an empty private tmpfs discarded when the sandbox exits
+	// (default "/home/" + User). Mounts whose Target lies below Home remain visible.
+	Home string
+
+	// Hostname is the host name reported inside the sandbox (default "sandbox").
+	Hostname string
+
+	// TZ pins the timezone (e.g., "UTC"), if set.
+	TZ string
+
+	// Locale pins LANG (e.g., "C.UTF-8"), if set.
+	Locale string
+}
+
+var (
+	validUserName = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	validHostname = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]{0,62}[A-Za-z0-9])?$`)
+)
+
+// user, uid, gid, home and hostname return the effective values with defaults applied.
+func (id *Identity) user() string {
+	if id.User == "" {
+		return defaultIdentityUser
+	}
+	return id.User
+}
+
+func (id *Identity) uid() int {
+	if id.UID == 0 {
+		return defaultIdentityID
+	}
+	return id.UID
+}
+
+func (id *Identity) gid() int {
+	if id.GID == 0 {
+		return defaultIdentityID
+	}
+	return id.GID
+}
+
+func (id *Identity) home() string {
+	if id.Home == "" {
+		return "/home/" + id.user()
+	}
+	return id.Home
+}
+
+func (id *Identity) hostname() string {
+	if id.Hostname == "" {
+		return defaultIdentityHostname
+	}
+	return id.Hostname
+}
+
+// validate checks that the identity can be written to passwd and group files and applied.
+func (id *Identity) validate() error {
+	if !validUserName.MatchString(id.user()) {
+		return fmt.Errorf("sandbox: identity: invalid user name %q", id.User)
+	}
+	const maxID int64 = 1<<32 - 2 // (uid_t)-1 is reserved
+	if id.UID < 0 || int64(id.UID) > maxID || id.GID < 0 || int64(id.GID) > maxID {
+		return fmt.Errorf("sandbox: identity: UID and GID must be between 1 and %d", maxID)
+	}
+	if home := id.home(); !filepath.IsAbs(home) || filepath.Clean(home) != home || home == "/" {
+		return fmt.Errorf("sandbox: identity: home %q must be an absolute, clean path", id.Home)
+	}
+	if strings.ContainsAny(id.home(), ":\n") {
+		return fmt.Errorf("sandbox: identity: home %q cannot be written to /etc/passwd", id.Home)
+	}
+	if !validHostname.MatchString(id.hostname()) {
+		return fmt.Errorf("sandbox: identity: invalid hostname %q", id.Hostname)
+	}
+	for name, value := range map[string]string{"TZ": id.TZ, "Locale": id.Locale} {
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("sandbox: identity: %s contains NUL", name)
+		}
+	}
+	return nil
+}
+
+// env returns the "KEY=VALUE" entries the identity injects into the environment.
+func (id *Identity) env() []string {
+	env := []string{
+		"HOME=" + id.home(),
+		"USER=" + id.user(),
+		"LOGNAME=" + id.user(),
+	}
+	if id.TZ != "" {
+		env = append(env, "TZ="+id.TZ)
+	}
+	if id.Locale != "" {
+		env = append(env, "LANG="+id.Locale)
+	}
+	return env
+}
+
+// files returns files with minimal /etc/passwd and /etc/group entries for the identity added,
+// unless the caller already provides those paths. files itself is not modified.
+func (id *Identity) files(files map[string]File) map[string]File {
+	uid, gid := strconv.Itoa(id.uid()), strconv.Itoa(id.gid())
+	passwd := "root:x:0:0:root:/root:/usr/sbin/nologin\n" +
+		id.user() + ":x:" + uid + ":" + gid + ":" + id.user() + ":" + id.home() + ":/bin/sh\n" +
+		"nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin\n"
+	group := "root:x:0:\n" +
+		id.user() + ":x:" + gid + ":\n" +
+		"nogroup:x:65534:\n"
+
+	merged := make(map[string]File, len(files)+2)
+	merged["/etc/passwd"] = File{Data: []byte(passwd)}
+	merged["/etc/group"] = File{Data: []byte(group)}
+	for target, f := range files {
+		merged[target] = f
+	}
+	return merged
+}
@@ -0,0 +1,66 @@
+package sandbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityDefaults(t *testing.T) {
+	t.Parallel()
+
+	id := &Identity{}
+	require.NoError(t, id.validate())
+	assert.Equal(t, []string{"HOME=/home/sandbox", "USER=sandbox", "LOGNAME=sandbox"}, id.env())
+
+	files := id.files(nil)
+	assert.Equal(t, "root:x:0:0:root:/root:/usr/sbin/nologin\n"+
+		"sandbox:x:1000:1000:sandbox:/home/sandbox:/bin/sh\n"+
+		"nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin\n", string(files["/etc/passwd"].Data))
+	assert.Equal(t, "root:x:0:\nsandbox:x:1000:\nnogroup:x:65534:\n", string(files["/etc/group"].Data))
+}
+
+func TestIdentityCustom(t *testing.T) {
+	t.Parallel()
+
+	id := &Identity{User: "agent", UID: 2000, GID: 3000, Home: "/work/home", TZ: "UTC", Locale: "C.UTF-8"}
+	require.NoError(t, id.validate())
+	assert.Equal(t, []string{"HOME=/work/home", "USER=agent", "LOGNAME=agent", "TZ=UTC", "LANG=C.UTF-8"}, id.env())
+
+	// Caller-provided files take precedence, and the caller's map is left alone
+	own := map[string]File{"/etc/group": {Data: []byte("custom\n")}}
+	files := id.files(own)
+	assert.Contains(t, string(files["/etc/passwd"].Data), "agent:x:2000:3000:agent:/work/home:/bin/sh\n")
+	assert.Equal(t, "custom\n", string(files["/etc/group"].Data))
+	assert.Len(t, own, 1)
+}
+
+func TestIdentityValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		id   Identity
+	}{
+		{"user with colon", Identity{User: "a:b"}},
+		{"uppercase user", Identity{User: "Alice"}},
+		{"negative uid", Identity{UID: -1}},
+		{"relative home", Identity{Home: "home"}},
+		{"home with newline", Identity{Home: "/home/a\nroot"}},
+		{"bad hostname", Identity{Hostname: "host name"}},
+		{"TZ with NUL", Identity{TZ: "UTC\x00"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.id.validate())
+		})
+	}
+
+	policy := DefaultPolicy()
+	policy.Identity = &Identity{User: "a:b"}
+	_, err := policy.Command(context.Background(), "true")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid user name")
+}
@@ -0,0 +1,18 @@
+//go:build darwin
+
+package sandbox
+
+import "fmt"
+
+// landlockRules is never requested on macOS.
+type landlockRules struct{}
+
+// restrictLandlock is never requested on macOS.
+func restrictLandlock(rules *landlockRules) error {
+	return fmt.Errorf("landlock is not supported on macOS")
+}
+
+// installFilter is never requested on macOS.
+func installFilter(prog []byte) error {
+	return fmt.Errorf("seccomp is not supported on macOS")
+}
@@ -0,0 +1,396 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+func init() {
+	RegisterBackend(landlock{})
+}
+
+// landlock is a Linux Backend for hosts where bubblewrap cannot create user namespaces.
+// Instead of building a private mount namespace, the helper restricts itself with the
+// Landlock LSM (Linux 5.13+) and then execs the command, which sees the host's file system
+// but can only read and execute below ReadOnlyMounts and /proc, write below
+// ReadWriteMounts, WorkDir and the ProvideTmp directory (removed with the run's other
+// resources, see Release), and use the existing files of /dev.
+//
+// Network access is blocked with Landlock TCP rules on ABI 4+ (Linux 6.7) and a seccomp
+// filter for other socket types and protocols (including MPTCP) and for io_uring, whose
+// socket operations bypass it; on older kernels the filter refuses all IPv4 and IPv6
+// sockets. A NetworkProxy is reached through its Unix sockets. Missing Landlock rights on
+// older kernels are compensated where possible: before ABI 3, truncate(2) is refused by
+// seccomp. On ABI 6+ (Linux 6.12) signals and abstract Unix sockets are scoped to the
+// sandbox unless AllowSharedNamespaces is set.
+//
+// There are no namespaces: the command shares the host's PID, IPC and UTS namespaces and
+// runs as the calling user. Mount targets must equal their sources, and masked paths,
+// overlays, injected files, identities, localhost-only networking, violation reports and
+// sessions are not supported.
+//
+// The isolation is therefore weaker than bubblewrap's in ways no rule can fix:
+//   - Landlock does not restrict connect(2) on Unix sockets, so the command can talk to
+//     every pathname socket the calling user may open, such as /var/run/docker.sock, the
+//     D-Bus and systemd sockets or the sockets of other NetworkProxy instances, and
+//     through them act outside the sandbox.
+//   - Before ABI 6, and with AllowSharedNamespaces, it can also signal the calling
+//     user's other processes and connect to abstract Unix sockets (e.g., X11's).
+//
+// Use it only on hosts where the calling user has no such sockets worth protecting.
+type landlock struct{}
+
+func (landlock) Name() string { return "landlock" }
+
+func (landlock) Supports(f Feature) bool {
+	switch f {
+	case FeatureNetworkProxy, FeatureSeccomp:
+		return true
+	}
+	return false
+}
+
+// Command returns the command wrapped in the helper that applies the restrictions. Its
+// resources are freed by Release.
+func (l landlock) Command(ctx context.Context, p *Policy, name string, arg ...string) (*exec.Cmd, error) {
+	st := &cmdState{}
+	cmd, err := l.helperCommand(ctx, p, st, name, arg...)
+	if err != nil {
+		return nil, err
+	}
+	if err := wrapWithHelper(cmd, helperModeExec, st.helper); err != nil {
+		st.release()
+		return nil, err
+	}
+	st.attach(cmd)
+	return cmd, nil
+}
+
+// helperCommand returns the unwrapped command and records the restrictions in the helper
+// configuration of st, so that Policy.Command can merge them with the limits applied by
+// the same helper. The ProvideTmp directory is removed when st is released.
+func (landlock) helperCommand(ctx context.Context, p *Policy, st *cmdState, name string, arg ...string) (*exec.Cmd, error) {
+	abi, err := landlockABI()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: landlock: %w", err)
+	}
+
+	rules := &landlockRules{
+		ReadOnly:    []string{"/proc"},
+		Devices:     []string{"/dev"},
+		RestrictTCP: !p.AllowNetwork && abi >= 4,
+		Scoped:      !p.AllowSharedNamespaces,
+	}
+	paths := func(mounts []Mount) ([]string, error) {
+		var out []string
+		for _, m := range mounts {
+			src, err := canonicalPath(m.Source)
+			if err != nil {
+				return nil, err
+			}
+			if m.Target != "" && m.Target != m.Source && m.Target != src {
+				return nil, fmt.Errorf("mount target %s differs from source %s: path remapping is not supported", m.Target, m.Source)
+			}
+			out = append(out, src)
+		}
+		return out, nil
+	}
+	ro, err := paths(p.ReadOnlyMounts)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: landlock: %w", err)
+	}
+	rw, err := paths(p.ReadWriteMounts)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: landlock: %w", err)
+	}
+	rules.ReadOnly = append(rules.ReadOnly, ro...)
+	rules.ReadWrite = rw
+
+	workDir := p.WorkDir
+	if workDir == "" {
+		if workDir, err = os.Getwd(); err != nil {
+			return nil, fmt.Errorf("sandbox: landlock: get working directory: %w", err)
+		}
+	}
+	if workDir, err = canonicalPath(workDir); err != nil {
+		return nil, fmt.Errorf("sandbox: landlock: %w", err)
+	}
+	rules.ReadWrite = append(rules.ReadWrite, workDir)
+
+	var injected []string
+	var tmpDir string
+	if p.ProvideTmp {
+		if tmpDir, err = os.MkdirTemp("", "boxedpy-sandbox-*"); err != nil {
+			return nil, fmt.Errorf("sandbox: landlock: create temp directory: %w", err)
+		}
+		rules.ReadWrite = append(rules.ReadWrite, tmpDir)
+		injected = append(injected, "TMPDIR="+tmpDir)
+	}
+	if p.NetworkProxy != nil {
+		injected = append(injected, p.NetworkProxy.Env()...)
+	}
+	env := p.environ(injected)
+
+	filters, err := landlockFilters(p, abi)
+	if err != nil {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+		return nil, fmt.Errorf("sandbox: landlock: %w", err)
+	}
+	path, err := lookPathEnv(name, env)
+	if err != nil {
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+		return nil, fmt.Errorf("sandbox: landlock: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, path, arg...)
+	cmd.Args[0] = name
+	cmd.Env = env
+	cmd.Dir = workDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: !p.AllowSessionControl}
+	if !p.AllowParentSurvival {
+		cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	}
+	cfg := st.helperConfig()
+	cfg.Landlock = rules
+	cfg.Seccomp = filters
+
+	if tmpDir != "" {
+		st.addCleanup(func() { os.RemoveAll(tmpDir) })
+	}
+	return cmd, nil
+}
+
+// landlockFilters returns the seccomp programs that complement Landlock: the policy's own
+// profile, network blocking beyond what Landlock covers at this ABI (including io_uring),
+// and the truncate(2) fallback for ABI 1 and 2.
+func landlockFilters(p *Policy, abi int) ([][]byte, error) {
+	var filters [][]byte
+	if fallback := landlockFallback(p, abi); len(fallback.Rules) > 0 || len(fallback.Deny) > 0 {
+		prog, err := fallback.compile()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, prog)
+	}
+	if p.Seccomp != nil {
+		prog, err := p.Seccomp.compile()
+		if err != nil {
+			return nil, fmt.Errorf("seccomp: %w", err)
+		}
+		filters = append(filters, prog)
+	}
+	return filters, nil
+}
+
+// landlockFallback returns the profile refusing what Landlock cannot at this ABI.
+func landlockFallback(p *Policy, abi int) *SeccompProfile {
+	fallback := &SeccompProfile{DefaultAction: SeccompAllow, Errno: syscall.EACCES}
+	if !p.AllowNetwork {
+		for _, family := range []uint32{syscall.AF_INET, syscall.AF_INET6} {
+			isFamily := SeccompArg{Index: 0, Op: SeccompArgEqual, Value: family}
+			if abi < 4 {
+				fallback.Rules = append(fallback.Rules, SeccompRule{Syscall: "socket", Action: SeccompErrno, Args: []SeccompArg{isFamily}})
+				continue
+			}
+			// Landlock handles TCP; refuse every other type (SOCK_DGRAM, SOCK_RAW,
+			// SOCK_SEQPACKET, SOCK_RDM), each of which has bit 1 or 2 set, and every
+			// protocol but 0 and IPPROTO_TCP (6): Landlock's TCP rights cover neither
+			// MPTCP, which can fall back to plain TCP, nor SCTP. Protocols with a bit
+			// outside 0x6 are refused by mask, 2 and 4 one by one.
+			for _, arg := range []SeccompArg{
+				{Index: 1, Op: SeccompArgMaskedAny, Value: 0x6},
+				{Index: 2, Op: SeccompArgMaskedAny, Value: ^uint32(0x6)},
+				{Index: 2, Op: SeccompArgEqual, Value: 2},
+				{Index: 2, Op: SeccompArgEqual, Value: 4},
+			} {
+				fallback.Rules = append(fallback.Rules, SeccompRule{Syscall: "socket", Action: SeccompErrno, Args: []SeccompArg{isFamily, arg}})
+			}
+		}
+		// io_uring can create and connect sockets without the socket(2) and connect(2)
+		// system calls; it fails as on kernels built without it
+		for _, name := range []string{"io_uring_setup", "io_uring_enter", "io_uring_register"} {
+			fallback.Rules = append(fallback.Rules, SeccompRule{Syscall: name, Action: SeccompErrno, Errno: syscall.ENOSYS})
+		}
+	}
+	if abi < 3 {
+		fallback.Deny = append(fallback.Deny, "truncate")
+	}
+	return fallback
+}
+
+// landlockRules is the part of the helper configuration that the landlock backend adds.
+type landlockRules struct {
+	ReadOnly    []string `json:"read_only,omitempty"`    // read and execute
+	ReadWrite   []string `json:"read_write,omitempty"`   // full access
+	Devices     []string `json:"devices,omitempty"`      // read, write and ioctl on existing files
+	RestrictTCP bool     `json:"restrict_tcp,omitempty"` // refuse TCP bind and connect (ABI 4+)
+	Scoped      bool     `json:"scoped,omitempty"`       // scope signals and abstract sockets (ABI 6+)
+}
+
+// Landlock system calls, numbered identically on every architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+)
+
+// Landlock access rights (include/uapi/linux/landlock.h).
+const (
+	llFSExecute     = 1 << 0
+	llFSWriteFile   = 1 << 1
+	llFSReadFile    = 1 << 2
+	llFSReadDir     = 1 << 3
+	llFSRefer       = 1 << 13 // ABI 2
+	llFSTruncate    = 1 << 14 // ABI 3
+	llFSIoctlDev    = 1 << 15 // ABI 5
+	llFSAllV1       = 1<<13 - 1
+	llNetBindTCP    = 1 << 0 // ABI 4
+	llNetConnTCP    = 1 << 1
+	llScopeAbstract = 1 << 0 // ABI 6
+	llScopeSignal   = 1 << 1
+
+	// Rights that apply to files rather than directories
+	llFSFileRights = llFSExecute | llFSWriteFile | llFSReadFile | llFSTruncate | llFSIoctlDev
+)
+
+// landlockABI returns the Landlock ABI version of the running kernel.
+var landlockABI = sync.OnceValues(func() (int, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	switch errno {
+	case 0:
+		return int(abi), nil
+	case syscall.ENOSYS:
+		return 0, fmt.Errorf("not supported by this kernel (Linux 5.13 or newer is required)")
+	case syscall.EOPNOTSUPP:
+		return 0, fmt.Errorf("disabled on this host (add landlock to the lsm= boot parameter)")
+	default:
+		return 0, fmt.Errorf("landlock_create_ruleset: %w", errno)
+	}
+})
+
+// restrictLandlock enforces rules on the calling thread, which the helper has locked and
+// which execs the command afterwards.
+func restrictLandlock(rules *landlockRules) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+
+	fsAll := uint64(llFSAllV1)
+	if abi >= 2 {
+		fsAll |= llFSRefer
+	}
+	if abi >= 3 {
+		fsAll |= llFSTruncate
+	}
+	if abi >= 5 {
+		fsAll |= llFSIoctlDev
+	}
+	// struct landlock_ruleset_attr; the size passed selects the fields the kernel reads
+	attr := binary.NativeEndian.AppendUint64(nil, fsAll)
+	if abi >= 4 {
+		var net uint64
+		if rules.RestrictTCP {
+			net = llNetBindTCP | llNetConnTCP
+		}
+		attr = binary.NativeEndian.AppendUint64(attr, net)
+	}
+	if abi >= 6 {
+		var scoped uint64
+		if rules.Scoped {
+			scoped = llScopeAbstract | llScopeSignal
+		}
+		attr = binary.NativeEndian.AppendUint64(attr, scoped)
+	}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr[0])), uintptr(len(attr)), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock_create_ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer syscall.Close(ruleset)
+
+	add := func(paths []string, access uint64) error {
+		for _, path := range paths {
+			if err := addLandlockPath(ruleset, path, access&fsAll); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := add(rules.ReadOnly, llFSExecute|llFSReadFile|llFSReadDir); err != nil {
+		return err
+	}
+	if err := add(rules.ReadWrite, fsAll); err != nil {
+		return err
+	}
+	if err := add(rules.Devices, llFSReadFile|llFSWriteFile|llFSReadDir|llFSTruncate|llFSIoctlDev); err != nil {
+		return err
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %w", errno)
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0); errno != 0 {
+		return fmt.Errorf("landlock_restrict_self: %w", errno)
+	}
+	return nil
+}
+
+// addLandlockPath allows access below path, limited to the rights that apply to files if
+// path is not a directory.
+func addLandlockPath(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("landlock: open %s: %w", path, err)
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("landlock: stat %s: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= llFSFileRights
+	}
+	// struct landlock_path_beneath_attr is packed: allowed_access, then parent_fd
+	attr := binary.NativeEndian.AppendUint64(nil, access)
+	attr = binary.NativeEndian.AppendUint32(attr, uint32(fd))
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath,
+		uintptr(unsafe.Pointer(&attr[0])), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock: add rule for %s: %w", path, errno)
+	}
+	return nil
+}
+
+// installFilter installs a seccomp program on the calling thread.
+func installFilter(prog []byte) error {
+	if len(prog) == 0 || len(prog)%8 != 0 {
+		return fmt.Errorf("malformed seccomp program")
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %w", errno)
+	}
+	fprog := sockFprog{len: uint16(len(prog) / 8), filter: &prog[0]}
+	_, _, errno := syscall.RawSyscall(uintptr(syscallNumbers["seccomp"]), seccompSetModeFilter, 0, uintptr(unsafe.Pointer(&fprog)))
+	runtime.KeepAlive(prog)
+	if errno != 0 {
+		return fmt.Errorf("seccomp: %w", errno)
+	}
+	return nil
+}
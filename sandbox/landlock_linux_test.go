@@ -0,0 +1,235 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLandlockFilters(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		policy Policy
+		abi    int
+		want   int
+	}{
+		{"network allowed", Policy{AllowNetwork: true}, 4, 0},
+		{"network blocked", Policy{}, 4, 1},
+		{"old kernel", Policy{AllowNetwork: true}, 2, 1},
+		{"with profile", Policy{Seccomp: DefaultSeccompProfile()}, 6, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters, err := landlockFilters(&tt.policy, tt.abi)
+			require.NoError(t, err)
+			assert.Len(t, filters, tt.want)
+		})
+	}
+}
+
+func TestLandlockFallbackRefusesIOUring(t *testing.T) {
+	t.Parallel()
+
+	arch, err := seccompAuditArch()
+	if err != nil {
+		t.Skip(err)
+	}
+	setup := syscallNumbers["io_uring_setup"]
+
+	prog, err := landlockFallback(&Policy{}, 6).program()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.ENOSYS)), runBPF(t, prog, arch, setup, [6]uint64{}))
+
+	prog, err = landlockFallback(&Policy{AllowNetwork: true}, 2).program()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, setup, [6]uint64{}))
+}
+
+func TestLandlockFallbackRefusesOtherProtocols(t *testing.T) {
+	t.Parallel()
+
+	arch, err := seccompAuditArch()
+	if err != nil {
+		t.Skip(err)
+	}
+	prog, err := landlockFallback(&Policy{}, 4).program()
+	require.NoError(t, err)
+
+	const ipprotoMPTCP = 262
+	refused := uint32(seccompRetErrno | uint32(syscall.EACCES))
+	tests := []struct {
+		name   string
+		family uint64
+		typ    uint64
+		proto  uint64
+		want   uint32
+	}{
+		{"tcp", syscall.AF_INET, syscall.SOCK_STREAM, 0, seccompRetAllow},
+		{"tcp explicit", syscall.AF_INET6, syscall.SOCK_STREAM | syscall.SOCK_CLOEXEC, syscall.IPPROTO_TCP, seccompRetAllow},
+		{"udp", syscall.AF_INET, syscall.SOCK_DGRAM, 0, refused},
+		{"mptcp", syscall.AF_INET, syscall.SOCK_STREAM, ipprotoMPTCP, refused},
+		{"mptcp v6", syscall.AF_INET6, syscall.SOCK_STREAM, ipprotoMPTCP, refused},
+		{"sctp", syscall.AF_INET, syscall.SOCK_STREAM, syscall.IPPROTO_SCTP, refused},
+		{"igmp", syscall.AF_INET, syscall.SOCK_STREAM, syscall.IPPROTO_IGMP, refused},
+		{"ipip", syscall.AF_INET, syscall.SOCK_STREAM, syscall.IPPROTO_IPIP, refused},
+		{"unix", syscall.AF_UNIX, syscall.SOCK_STREAM, 0, seccompRetAllow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := [6]uint64{tt.family, tt.typ, tt.proto}
+			assert.Equal(t, tt.want, runBPF(t, prog, arch, syscallNumbers["socket"], args))
+		})
+	}
+}
+
+func TestLandlockUnsupported(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	p := &Policy{Backend: "landlock", MaskedPaths: []string{".env"}, AllowLocalhostOnly: true}
+	_, err := p.Command(ctx, "true")
+	assert.ErrorContains(t, err, `backend "landlock" does not support masked-paths (Policy.MaskedPaths)`)
+	assert.ErrorContains(t, err, `backend "landlock" does not support localhost-only (Policy.AllowLocalhostOnly)`)
+
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("landlock unavailable: %v", err)
+	}
+	p = &Policy{Backend: "landlock", ReadOnlyMounts: []Mount{{Source: "/usr", Target: "/opt/usr"}}}
+	_, err = p.Command(ctx, "true")
+	assert.ErrorContains(t, err, "path remapping is not supported")
+}
+
+func TestLandlockReleaseRemovesTmp(t *testing.T) {
+	t.Parallel()
+
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("landlock unavailable: %v", err)
+	}
+	p := &Policy{Backend: "landlock", ProvideTmp: true}
+	cmd, err := p.Command(context.Background(), "/bin/true")
+	require.NoError(t, err)
+	var tmp string
+	for _, kv := range cmd.Env {
+		if v, ok := strings.CutPrefix(kv, "TMPDIR="); ok {
+			tmp = v
+		}
+	}
+	require.DirExists(t, tmp)
+
+	Release(cmd)
+	assert.NoDirExists(t, tmp)
+}
+
+func TestIntegrationLandlock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("landlock unavailable: %v", err)
+	}
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	work := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cret"), 0o644))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	policy := pythonPolicy()
+	policy.Backend = "landlock"
+	policy.WorkDir = work
+
+	run := func(t *testing.T, policy *Policy, script string, args ...string) string {
+		t.Helper()
+		res, err := policy.Run(context.Background(), pythonPath, append([]string{"-c", script}, args...)...)
+		require.NoError(t, err)
+		require.True(t, res.Success(), "%s", res.Stderr)
+		return strings.TrimSpace(string(res.Stdout))
+	}
+	attempt := `
+import sys
+def attempt(f):
+    try:
+        f()
+        print("ok")
+    except OSError as e:
+        print(type(e).__name__)
+`
+
+	t.Run("filesystem", func(t *testing.T) {
+		out := run(t, policy, attempt+`
+import os, tempfile
+attempt(lambda: open("out.txt", "w").write("hi"))
+attempt(lambda: open(os.path.join(tempfile.gettempdir(), "t"), "w").write("hi"))
+attempt(lambda: open("/etc/hostname-boxedpy", "w"))
+attempt(lambda: open(sys.argv[1]).read())
+attempt(lambda: open("/dev/null", "w").write("x"))
+`+"\n", secret)
+		assert.Equal(t, "ok\nok\nPermissionError\nPermissionError\nok", out)
+		data, err := os.ReadFile(filepath.Join(work, "out.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "hi", string(data))
+	})
+
+	script := attempt + `
+import socket
+attempt(lambda: socket.create_connection(("127.0.0.1", ` + portOf(t, ln) + `)))
+attempt(lambda: socket.socket(socket.AF_INET, socket.SOCK_DGRAM))
+`
+	t.Run("network blocked", func(t *testing.T) {
+		assert.Equal(t, "PermissionError\nPermissionError", run(t, policy, script))
+		// Landlock's TCP rights do not cover MPTCP, which can fall back to plain TCP
+		out := run(t, policy, attempt+`
+import socket
+attempt(lambda: socket.socket(socket.AF_INET, socket.SOCK_STREAM, 262))
+`)
+		assert.Equal(t, "PermissionError", out)
+	})
+
+	t.Run("network allowed", func(t *testing.T) {
+		open := *policy
+		open.AllowNetwork = true
+		assert.Equal(t, "ok\nok", run(t, &open, script))
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		limited := *policy
+		limited.Limits = Limits{MaxOpenFiles: 64}
+		out := run(t, &limited, attempt+`
+import resource
+print(resource.getrlimit(resource.RLIMIT_NOFILE)[0])
+attempt(lambda: open(sys.argv[1]).read())
+`, secret)
+		assert.Equal(t, "64\nPermissionError", out, "limits and Landlock are applied by the same helper")
+	})
+}
+
+func portOf(t *testing.T, ln net.Listener) string {
+	t.Helper()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+	return port
+}
@@ -0,0 +1,270 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Limits constrains the resources available to a sandboxed process tree.
+// The zero value imposes no limits; each field is only enforced when non-zero.
+//
+// Enforcement mechanisms:
+//   - Linux: memory and process limits use a per-run cgroup v2 when the current cgroup
+//     (or CgroupParent) has the memory/pids controllers delegated to us. Otherwise they
+//     fall back to RLIMIT_AS and RLIMIT_NPROC. CPU time, open files and file size always
+//     use rlimits.
+//   - macOS: all limits use rlimits. The kernel does not enforce RLIMIT_AS, so MemoryBytes
+//     is best-effort only.
+//
+// Rlimits, Nice and IOClass are applied by a small helper: the current binary is re-executed
+// with a marker environment variable, sets the limits on itself, and then execs the sandbox
+// tool. The limits are inherited by every process inside the sandbox.
+//
+// When a run ends because of a limit, pass the error returned by Run or Wait to CheckLimits
+// to get a *LimitError describing which limit was exceeded.
+type Limits struct {
+	// MemoryBytes caps the memory available to the sandbox.
+	// - Linux with cgroup v2: memory.max for the whole process tree (swap disabled)
+	// - Otherwise: RLIMIT_AS for each process (virtual address space, not RSS)
+	MemoryBytes uint64
+
+	// CPUTime caps the CPU time of each process (RLIMIT_CPU). Processes receive SIGXCPU
+	// when the limit is reached and SIGKILL one second later.
+	CPUTime time.Duration
+
+	// WallTime caps the elapsed real time of the run. When it elapses the command is
+	// cancelled exactly as if the context passed to Command had been cancelled.
+	WallTime time.Duration
+
+	// MaxProcesses caps the number of processes and threads.
+	// - Linux with cgroup v2: pids.max for the whole process tree
+	// - Otherwise: RLIMIT_NPROC. Note that RLIMIT_NPROC counts every process owned by the
+	//   same user ID, which on Linux before 5.14 (or without a user namespace) includes the
+	//   host processes of the calling user.
+	MaxProcesses int
+
+	// MaxOpenFiles caps the number of open file descriptors per process (RLIMIT_NOFILE).
+	MaxOpenFiles uint64
+
+	// MaxFileSize caps the size of any file a process may write (RLIMIT_FSIZE).
+	MaxFileSize uint64
+
+	// Nice sets the nice value of the sandbox, from 1 to 19 (lowest priority). Zero leaves
+	// the priority inherited from the caller unchanged.
+	Nice int
+
+	// IOClass sets the I/O scheduling class of the sandbox. Linux only; ignored on macOS.
+	IOClass IOClass
+
+	// IOLevel sets the priority within IOClassBestEffort (0 = highest, 7 = lowest).
+	IOLevel int
+
+	// CgroupParent is a cgroup v2 directory delegated to the current user in which per-run
+	// cgroups are created (e.g., "/sys/fs/cgroup/user.slice/.../boxedpy"). If empty, the
+	// cgroup of the current process is used when it has the required controllers enabled
+	// in cgroup.subtree_control. Linux only; ignored on macOS.
+	CgroupParent string
+}
+
+// IOClass is a Linux I/O scheduling class (see ioprio_set(2)).
+type IOClass int
+
+const (
+	// IOClassDefault leaves the I/O priority unchanged.
+	IOClassDefault IOClass = iota
+	// IOClassBestEffort schedules I/O with the priority given by Limits.IOLevel.
+	IOClassBestEffort
+	// IOClassIdle only schedules I/O when no other process needs the disk.
+	IOClassIdle
+)
+
+// LimitKind identifies which resource limit ended a sandboxed run.
+type LimitKind string
+
+const (
+	LimitMemory    LimitKind = "memory"
+	LimitCPUTime   LimitKind = "cpu-time"
+	LimitWallTime  LimitKind = "wall-time"
+	LimitProcesses LimitKind = "processes"
+	LimitFileSize  LimitKind = "file-size"
+	LimitOutput    LimitKind = "output"
+)
+
+// ErrLimitExceeded matches any *LimitError via errors.Is.
+var ErrLimitExceeded = errors.New("sandbox: resource limit exceeded")
+
+// LimitError reports that a sandboxed run was terminated, or had an operation refused,
+// because it exceeded one of the Policy's Limits.
+type LimitError struct {
+	// Limit is the resource whose limit was exceeded.
+	Limit LimitKind
+	// Err is the error returned by the command's Run or Wait.
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("sandbox: %s limit exceeded: %v", e.Limit, e.Err)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// errWallTimeExceeded is the context cause recorded when Limits.WallTime elapses.
+var errWallTimeExceeded = errors.New("sandbox: wall time limit exceeded")
+
+// CheckLimits inspects the outcome of a command created by Policy.Command and returns a
+// *LimitError wrapping err if the run was ended by one of the policy's Limits. Otherwise
+// err is returned unchanged. It returns nil if err is nil. A run killed because violation
+// reporting failed is reported with an error wrapping both ErrViolationMonitor and err.
+// Once the command has been waited for, CheckLimits also frees its resources, as Release
+// does.
+//
+// Example:
+//
+//	cmd, err := policy.Command(ctx, "python3", "script.py")
+//	if err != nil {
+//	    return err
+//	}
+//	err = sandbox.CheckLimits(cmd, cmd.Run())
+//	var limitErr *sandbox.LimitError
+//	if errors.As(err, &limitErr) {
+//	    log.Printf("script exceeded its %s limit", limitErr.Limit)
+//	}
+func CheckLimits(cmd *exec.Cmd, err error) error {
+	if cmd == nil {
+		return err
+	}
+	var rec *runRecord
+	if st := stateOf(cmd); st == nil {
+		rec = recordOf(cmd)
+	} else if cmd.ProcessState != nil {
+		rec = st.finish(cmd)
+	} else {
+		rec = st.outcome()
+		if err != nil {
+			rec.limit = st.exceededLimit(cmd)
+		}
+	}
+	if rec == nil || err == nil {
+		return err
+	}
+	if rec.monitorErr != nil {
+		return fmt.Errorf("%w: %v: %w", ErrViolationMonitor, rec.monitorErr, err)
+	}
+	if rec.limit != "" {
+		return &LimitError{Limit: rec.limit, Err: err}
+	}
+	return err
+}
+
+// exceededLimit determines which limit, if any, ended the run of cmd.
+func (s *cmdState) exceededLimit(cmd *exec.Cmd) LimitKind {
+	s.mu.Lock()
+	output := s.outputLimit
+	s.mu.Unlock()
+	if output {
+		return LimitOutput
+	}
+	if s.wallCtx != nil && context.Cause(s.wallCtx) == errWallTimeExceeded {
+		return LimitWallTime
+	}
+	if kind := s.cgroupLimitExceeded(); kind != "" {
+		return kind
+	}
+	if cmd.ProcessState == nil {
+		return ""
+	}
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ""
+	}
+	sig := exitSignal(ws, s.bwrap)
+	switch {
+	case sig == syscall.SIGXCPU:
+		return LimitCPUTime
+	case sig == syscall.SIGKILL && s.limits.CPUTime > 0 && cpuTime(cmd) >= s.limits.CPUTime:
+		return LimitCPUTime
+	case sig == syscall.SIGXFSZ:
+		return LimitFileSize
+	}
+	return ""
+}
+
+// exitSignal returns the signal that ended a run with wait status ws, or zero. Processes
+// killed by a signal inside a PID namespace are reported by bubblewrap as exit status
+// 128+signal rather than as a signal, so these are read as signals when bwrap is set;
+// with other backends, and outside 129 to 192, an exit status above 128 is the command's
+// own (e.g., sys.exit(152)).
+func exitSignal(ws syscall.WaitStatus, bwrap bool) syscall.Signal {
+	switch {
+	case ws.Signaled():
+		return ws.Signal()
+	case bwrap && ws.Exited() && ws.ExitStatus() > 128 && ws.ExitStatus() <= 128+64:
+		return syscall.Signal(ws.ExitStatus() - 128)
+	}
+	return 0
+}
+
+// cpuTime returns the total user and system CPU time consumed by cmd and its descendants.
+func cpuTime(cmd *exec.Cmd) time.Duration {
+	return cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+}
+
+// needsHelper reports whether any limit must be applied by the re-exec helper.
+func (l *Limits) needsHelper(cgroupManaged bool) bool {
+	return len(l.rlimits(cgroupManaged)) > 0 || l.Nice != 0 || l.IOClass != IOClassDefault
+}
+
+// rlimits returns the rlimits implementing l. Memory and process limits are omitted when
+// cgroupManaged is true because the per-run cgroup enforces them for the whole tree.
+func (l *Limits) rlimits(cgroupManaged bool) []helperRlimit {
+	var rl []helperRlimit
+	if l.MemoryBytes > 0 && !cgroupManaged {
+		rl = append(rl, helperRlimit{Resource: syscall.RLIMIT_AS, Cur: l.MemoryBytes, Max: l.MemoryBytes})
+	}
+	if l.MaxProcesses > 0 && !cgroupManaged {
+		n := uint64(l.MaxProcesses)
+		rl = append(rl, helperRlimit{Resource: rlimitNproc, Cur: n, Max: n})
+	}
+	if l.CPUTime > 0 {
+		secs := uint64((l.CPUTime + time.Second - 1) / time.Second)
+		// The soft limit delivers SIGXCPU; the hard limit a second later delivers SIGKILL.
+		rl = append(rl, helperRlimit{Resource: syscall.RLIMIT_CPU, Cur: secs, Max: secs + 1})
+	}
+	if l.MaxOpenFiles > 0 {
+		rl = append(rl, helperRlimit{Resource: syscall.RLIMIT_NOFILE, Cur: l.MaxOpenFiles, Max: l.MaxOpenFiles})
+	}
+	if l.MaxFileSize > 0 {
+		rl = append(rl, helperRlimit{Resource: syscall.RLIMIT_FSIZE, Cur: l.MaxFileSize, Max: l.MaxFileSize})
+	}
+	return rl
+}
+
+// validate checks that the limits are within the ranges the kernel accepts.
+func (l *Limits) validate() error {
+	if l.CPUTime < 0 || l.WallTime < 0 {
+		return fmt.Errorf("sandbox: limits: durations must not be negative")
+	}
+	if l.MaxProcesses < 0 {
+		return fmt.Errorf("sandbox: limits: MaxProcesses must not be negative")
+	}
+	if l.Nice < 0 || l.Nice > 19 {
+		return fmt.Errorf("sandbox: limits: Nice must be between 0 and 19, got %d", l.Nice)
+	}
+	if l.IOLevel < 0 || l.IOLevel > 7 {
+		return fmt.Errorf("sandbox: limits: IOLevel must be between 0 and 7, got %d", l.IOLevel)
+	}
+	if l.IOClass < IOClassDefault || l.IOClass > IOClassIdle {
+		return fmt.Errorf("sandbox: limits: unknown IOClass %d", l.IOClass)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build darwin
+
+package sandbox
+
+import "os/exec"
+
+// rlimitNproc is RLIMIT_NPROC, which the syscall package does not export.
+const rlimitNproc = 7
+
+// setIOPriority is a no-op on macOS; Limits.IOClass is Linux-only.
+func setIOPriority(prio int) error {
+	return nil
+}
+
+// setupCgroup is a no-op on macOS, which has no cgroups; all limits use rlimits.
+func setupCgroup(cmd *exec.Cmd, l *Limits, st *cmdState) (bool, error) {
+	return false, nil
+}
+
+// cgroupLimitExceeded always reports no limit on macOS.
+func (s *cmdState) cgroupLimitExceeded() LimitKind {
+	return ""
+}
@@ -0,0 +1,211 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rlimitNproc is RLIMIT_NPROC, which the syscall package does not export.
+const rlimitNproc = 6
+
+// cgroupRoot is where the cgroup v2 unified hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// setIOPriority sets the I/O priority of the calling thread.
+func setIOPriority(prio int) error {
+	const ioprioWhoProcess = 1
+	_, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setupCgroup creates a per-run cgroup enforcing the memory and process limits and
+// configures cmd to start inside it. It returns false (and no error) when no limit needs a
+// cgroup or when cgroup v2 delegation is unavailable and CgroupParent was not set, in which
+// case the caller falls back to rlimits.
+func setupCgroup(cmd *exec.Cmd, l *Limits, st *cmdState) (bool, error) {
+	if l.MemoryBytes == 0 && l.MaxProcesses == 0 {
+		return false, nil
+	}
+	var controllers []string
+	if l.MemoryBytes > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if l.MaxProcesses > 0 {
+		controllers = append(controllers, "pids")
+	}
+
+	parent := l.CgroupParent
+	if parent == "" {
+		var ok bool
+		parent, ok = currentCgroup()
+		if !ok || cgroupDelegated(parent, controllers) != nil {
+			return false, nil
+		}
+	} else if err := cgroupDelegated(parent, controllers); err != nil {
+		return false, fmt.Errorf("sandbox: cgroup parent %s: %w", parent, err)
+	}
+
+	dir, err := os.MkdirTemp(parent, "boxedpy-*")
+	if err != nil {
+		return false, fmt.Errorf("sandbox: create cgroup: %w", err)
+	}
+	if err := writeCgroupLimits(dir, l); err != nil {
+		os.Remove(dir)
+		return false, err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return false, fmt.Errorf("sandbox: open cgroup: %w", err)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+
+	st.cgroupDir = dir
+	st.addCleanup(func() {
+		f.Close()
+		// Keep the verdict for CheckLimits calls made after the cgroup is gone
+		kind := st.cgroupLimitExceeded()
+		st.mu.Lock()
+		st.cgroupDir, st.cgroupLimit = "", kind
+		st.mu.Unlock()
+		// Fails with EBUSY if processes survived the run (e.g., AllowParentSurvival);
+		// the empty cgroup is then left for the administrator to reap.
+		os.Remove(dir)
+	})
+	return true, nil
+}
+
+// writeCgroupLimits writes the controller limits for l into the cgroup at dir.
+func writeCgroupLimits(dir string, l *Limits) error {
+	if l.MemoryBytes > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatUint(l.MemoryBytes, 10)); err != nil {
+			return err
+		}
+		// Without swap accounting memory.swap.max does not exist; memory.max still applies.
+		if err := writeCgroupFile(dir, "memory.swap.max", "0"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if l.MaxProcesses > 0 {
+		if err := writeCgroupFile(dir, "pids.max", strconv.Itoa(l.MaxProcesses)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+		if os.IsNotExist(err) {
+			return err
+		}
+		return fmt.Errorf("sandbox: write cgroup %s: %w", name, err)
+	}
+	return nil
+}
+
+// currentCgroup returns the cgroup v2 directory of the current process.
+func currentCgroup() (string, bool) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		// Not a cgroup v2 (unified) mount.
+		return "", false
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", false
+	}
+	rel, ok := parseCgroupFile(data)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(cgroupRoot, rel), true
+}
+
+// parseCgroupFile extracts the unified hierarchy path from the contents of /proc/self/cgroup.
+// The cgroup v2 entry has the form "0::/path".
+func parseCgroupFile(data []byte) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok && path != "" {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// cgroupDelegated checks that new child cgroups can be created in dir and that each of
+// the given controllers is enabled for them.
+func cgroupDelegated(dir string, controllers []string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("not a cgroup v2 directory: %w", err)
+	}
+	enabled := strings.Fields(string(data))
+	for _, c := range controllers {
+		found := false
+		for _, e := range enabled {
+			if e == c {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("controller %q not enabled in cgroup.subtree_control", c)
+		}
+	}
+	if err := syscall.Access(dir, 0x2 /* W_OK */); err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	return nil
+}
+
+// cgroupLimitExceeded reports which cgroup-enforced limit was hit during the run.
+func (s *cmdState) cgroupLimitExceeded() LimitKind {
+	s.mu.Lock()
+	dir, kind := s.cgroupDir, s.cgroupLimit
+	s.mu.Unlock()
+	if dir == "" {
+		return kind
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "memory.events")); err == nil {
+		if cgroupEventCount(data, "oom_kill") > 0 {
+			return LimitMemory
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "pids.events")); err == nil {
+		if cgroupEventCount(data, "max") > 0 {
+			return LimitProcesses
+		}
+	}
+	return ""
+}
+
+// cgroupEventCount returns the counter named key from a cgroup *.events file.
+func cgroupEventCount(data []byte, key string) uint64 {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseUint(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
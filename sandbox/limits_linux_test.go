@@ -0,0 +1,42 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCgroupFile(t *testing.T) {
+	t.Parallel()
+
+	path, ok := parseCgroupFile([]byte("0::/user.slice/user-1000.slice/session-2.scope\n"))
+	assert.True(t, ok)
+	assert.Equal(t, "/user.slice/user-1000.slice/session-2.scope", path)
+
+	// Hybrid hierarchy: v1 controllers listed alongside the unified entry
+	path, ok = parseCgroupFile([]byte("12:pids:/user.slice\n1:name=systemd:/init.scope\n0::/init.scope\n"))
+	assert.True(t, ok)
+	assert.Equal(t, "/init.scope", path)
+
+	_, ok = parseCgroupFile([]byte("12:pids:/user.slice\n"))
+	assert.False(t, ok)
+}
+
+func TestCgroupEventCount(t *testing.T) {
+	t.Parallel()
+
+	events := []byte("low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\noom_group_kill 0\n")
+	assert.Equal(t, uint64(1), cgroupEventCount(events, "oom_kill"))
+	assert.Equal(t, uint64(12), cgroupEventCount(events, "max"))
+	assert.Equal(t, uint64(0), cgroupEventCount(events, "missing"))
+}
+
+func TestIOPrioValue(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 0, ioprioValue(IOClassDefault, 3))
+	assert.Equal(t, 2<<13|4, ioprioValue(IOClassBestEffort, 4))
+	assert.Equal(t, 3<<13, ioprioValue(IOClassIdle, 0))
+}
@@ -0,0 +1,259 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"weak"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsRlimits(t *testing.T) {
+	t.Parallel()
+
+	l := Limits{
+		MemoryBytes:  1 << 30,
+		CPUTime:      1500 * time.Millisecond,
+		MaxProcesses: 64,
+		MaxOpenFiles: 128,
+		MaxFileSize:  1 << 20,
+	}
+
+	byResource := func(rls []helperRlimit) map[int]helperRlimit {
+		m := make(map[int]helperRlimit)
+		for _, rl := range rls {
+			m[rl.Resource] = rl
+		}
+		return m
+	}
+
+	rls := byResource(l.rlimits(false))
+	assert.Equal(t, helperRlimit{Resource: syscall.RLIMIT_AS, Cur: 1 << 30, Max: 1 << 30}, rls[syscall.RLIMIT_AS])
+	assert.Equal(t, helperRlimit{Resource: rlimitNproc, Cur: 64, Max: 64}, rls[rlimitNproc])
+	// CPU time rounds up to whole seconds, with a one second grace before SIGKILL
+	assert.Equal(t, helperRlimit{Resource: syscall.RLIMIT_CPU, Cur: 2, Max: 3}, rls[syscall.RLIMIT_CPU])
+	assert.Equal(t, uint64(128), rls[syscall.RLIMIT_NOFILE].Cur)
+	assert.Equal(t, uint64(1<<20), rls[syscall.RLIMIT_FSIZE].Max)
+
+	// With a cgroup, memory and process limits are not duplicated as rlimits
+	rls = byResource(l.rlimits(true))
+	assert.NotContains(t, rls, syscall.RLIMIT_AS)
+	assert.NotContains(t, rls, rlimitNproc)
+	assert.Contains(t, rls, syscall.RLIMIT_CPU)
+
+	var zero Limits
+	assert.Empty(t, zero.rlimits(false))
+	assert.False(t, zero.needsHelper(false))
+}
+
+func TestLimitsValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, (&Limits{}).validate())
+	assert.NoError(t, (&Limits{Nice: 10, IOClass: IOClassBestEffort, IOLevel: 7}).validate())
+	assert.Error(t, (&Limits{Nice: 20}).validate())
+	assert.Error(t, (&Limits{Nice: -1}).validate())
+	assert.Error(t, (&Limits{IOLevel: 8}).validate())
+	assert.Error(t, (&Limits{CPUTime: -time.Second}).validate())
+	assert.Error(t, (&Limits{MaxProcesses: -1}).validate())
+
+	policy := DefaultPolicy()
+	policy.Limits.Nice = 42
+	cmd, err := policy.Command(context.Background(), "echo", "hi")
+	require.Error(t, err)
+	assert.Nil(t, cmd)
+	assert.Contains(t, err.Error(), "Nice")
+}
+
+func TestLimitError(t *testing.T) {
+	t.Parallel()
+
+	base := errors.New("signal: killed")
+	err := error(&LimitError{Limit: LimitMemory, Err: base})
+
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.ErrorIs(t, err, base)
+	assert.Contains(t, err.Error(), "memory limit exceeded")
+
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, LimitMemory, limitErr.Limit)
+}
+
+func TestCheckLimitsPassthrough(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, CheckLimits(nil, nil))
+
+	// Commands not created by Policy.Command are returned unchanged
+	base := errors.New("exit status 1")
+	assert.Same(t, base, CheckLimits(exec.Command("true"), base))
+}
+
+func TestCheckLimitsWallTime(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Millisecond, errWallTimeExceeded)
+	defer cancel()
+	<-ctx.Done()
+
+	cmd := exec.Command("true")
+	st := &cmdState{wallCtx: ctx}
+	st.attach(cmd)
+
+	err := CheckLimits(cmd, errors.New("signal: killed"))
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, LimitWallTime, limitErr.Limit)
+}
+
+func TestCheckLimitsExitStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		script string
+		bwrap  bool
+		want   LimitKind
+	}{
+		{"SIGXCPU", "kill -XCPU $$", false, LimitCPUTime},
+		{"SIGXFSZ reported by bubblewrap", "exit 153", true, LimitFileSize},
+		{"SIGXCPU reported by bubblewrap", "exit 152", true, LimitCPUTime},
+		{"exit status", "exit 152", false, ""},
+		{"SIGINT reported by bubblewrap", "exit 130", true, ""},
+		{"exit status above signals", "exit 250", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cmd := exec.Command("sh", "-c", tt.script)
+			st := &cmdState{limits: Limits{CPUTime: time.Second}, bwrap: tt.bwrap}
+			st.attach(cmd)
+			err := CheckLimits(cmd, cmd.Run())
+			require.Error(t, err)
+			var limitErr *LimitError
+			if tt.want == "" {
+				assert.False(t, errors.As(err, &limitErr), "%v", err)
+				return
+			}
+			require.True(t, errors.As(err, &limitErr), "%v", err)
+			assert.Equal(t, tt.want, limitErr.Limit)
+		})
+	}
+}
+
+func TestCheckLimitsReleasesAfterWait(t *testing.T) {
+	t.Parallel()
+
+	cmd := exec.Command("true")
+	st := &cmdState{}
+	released := 0
+	st.addCleanup(func() { released++ })
+	st.attach(cmd)
+
+	// Not waited for yet: the resources must stay
+	assert.NoError(t, CheckLimits(cmd, nil))
+	assert.Equal(t, 0, released)
+
+	require.NoError(t, cmd.Run())
+	assert.NoError(t, CheckLimits(cmd, nil))
+	assert.Equal(t, 1, released)
+
+	Release(cmd)
+	assert.Equal(t, 1, released, "Release after CheckLimits must be a no-op")
+}
+
+func TestReleaseForgetsState(t *testing.T) {
+	t.Parallel()
+
+	cmd := exec.Command("true")
+	st := &cmdState{}
+	st.attach(cmd)
+	key := weak.Make(cmd)
+	require.NoError(t, cmd.Run())
+	st.setTermination(TerminationKill)
+	st.recordViolation(Violation{Op: "write", Path: "/etc/hosts", Err: syscall.EROFS})
+
+	Release(cmd)
+	_, ok := cmdStates.Load(key)
+	assert.False(t, ok, "released state must leave the map")
+	assert.Equal(t, TerminationKill, TerminationOf(cmd))
+	assert.Len(t, Violations(cmd), 1)
+
+	cmd = nil
+	require.Eventually(t, func() bool {
+		runtime.GC()
+		_, ok := cmdRecords.Load(key)
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond, "the record goes away with the Cmd")
+}
+
+func TestCheckLimitsViolationMonitor(t *testing.T) {
+	t.Parallel()
+
+	cmd := exec.Command("true")
+	st := &cmdState{monitorErr: syscall.EBADF}
+	st.attach(cmd)
+
+	err := CheckLimits(cmd, errors.New("signal: killed"))
+	assert.ErrorIs(t, err, ErrViolationMonitor)
+	assert.EqualError(t, err, "sandbox: violation monitor failed: bad file descriptor: signal: killed")
+}
+
+func TestHelperAppliesRlimits(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	l := Limits{MaxOpenFiles: 64}
+	cmd := exec.Command("/bin/sh", "-c", "ulimit -n")
+	require.NoError(t, wrapWithHelper(cmd, helperModeExec, &helperConfig{Rlimits: l.rlimits(false)}))
+
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %s", output)
+	assert.Equal(t, "64", strings.TrimSpace(string(output)))
+}
+
+func TestIntegrationLimitsWallTime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	policy := DefaultPolicy()
+	policy.Limits.WallTime = 200 * time.Millisecond
+
+	cmd, err := policy.Command(context.Background(), "sleep", "10")
+	require.NoError(t, err)
+
+	err = CheckLimits(cmd, cmd.Run())
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr), "expected LimitError, got: %v", err)
+	assert.Equal(t, LimitWallTime, limitErr.Limit)
+}
+
+func TestIntegrationLimitsCPUTime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	policy := pythonPolicy()
+	policy.Limits.CPUTime = time.Second
+
+	cmd, err := policy.Command(context.Background(), pythonPath, "-c", "while True: pass")
+	require.NoError(t, err)
+
+	err = CheckLimits(cmd, cmd.Run())
+	var limitErr *LimitError
+	require.True(t, errors.As(err, &limitErr), "expected LimitError, got: %v", err)
+	assert.Equal(t, LimitCPUTime, limitErr.Limit)
+}
@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maskedPath is a sandbox path hidden by a MaskedPaths entry.
+type maskedPath struct {
+	path string // absolute path inside the sandbox
+	dir  bool   // directories are masked with an empty directory, files with an empty file
+}
+
+// expandMasks resolves the policy's MaskedPaths against the visible mounts (canonical
+// sources and targets, including the working directory) and returns the existing paths
+// to hide, sorted and deduplicated.
+//
+// Patterns are matched on the host through the mount that contains them, so a mask can
+// only ever hide something the sandbox could otherwise see. A pattern outside every mount
+// is an error: it would either be a typo or a sign that the mounts are not what the caller
+// believes them to be. Symlinks are resolved, and masked only if their target is visible.
+func expandMasks(patterns []string, workdir string, mounts []Mount) ([]maskedPath, error) {
+	seen := make(map[string]bool)
+	var masks []maskedPath
+
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return nil, fmt.Errorf("sandbox: masked path must not be empty")
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(workdir, pattern)
+		}
+		pattern = filepath.Clean(pattern)
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("sandbox: masked path %q: %w", pattern, err)
+		}
+
+		m, ok := mountContaining(mounts, pattern, func(m Mount) string { return m.Target })
+		if !ok {
+			return nil, fmt.Errorf("sandbox: masked path %s is not inside any mount", pattern)
+		}
+		hostPattern := rebase(pattern, m.Target, m.Source)
+		matches, err := filepath.Glob(hostPattern)
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: masked path %q: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			info, err := os.Lstat(match)
+			if err != nil {
+				continue
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				resolved, err := filepath.EvalSymlinks(match)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue // dangling links expose nothing
+				}
+				if err != nil {
+					return nil, fmt.Errorf("sandbox: masked path %s: %w", match, err)
+				}
+				if info, err = os.Stat(resolved); err != nil {
+					continue
+				}
+				match = resolved
+			}
+
+			// Map the host path back to where it appears in the sandbox
+			hm, ok := mountContaining(mounts, match, func(m Mount) string { return m.Source })
+			if !ok {
+				continue // symlink to something the sandbox cannot see anyway
+			}
+			target := rebase(match, hm.Source, hm.Target)
+			if seen[target] {
+				continue
+			}
+			seen[target] = true
+			masks = append(masks, maskedPath{path: target, dir: info.IsDir()})
+		}
+	}
+
+	sort.Slice(masks, func(i, j int) bool { return masks[i].path < masks[j].path })
+	return masks, nil
+}
+
+// mountContaining returns the mount whose key(m) is the longest prefix of path,
+// mirroring how the innermost mount shadows outer ones.
+func mountContaining(mounts []Mount, path string, key func(Mount) string) (Mount, bool) {
+	var best Mount
+	found := false
+	for _, m := range mounts {
+		k := key(m)
+		if !pathWithin(path, k) {
+			continue
+		}
+		if !found || len(k) > len(key(best)) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+// inRemappedMount reports whether the absolute path lies inside a mount whose target differs
+// from its source, i.e. whether it names a sandbox path rather than a host path.
+func inRemappedMount(mounts []Mount, path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	m, ok := mountContaining(mounts, filepath.Clean(path), func(m Mount) string { return m.Target })
+	return ok && m.Source != m.Target
+}
+
+// pathWithin reports whether path is dir or lies below it.
+func pathWithin(path, dir string) bool {
+	if dir == "/" {
+		return strings.HasPrefix(path, "/")
+	}
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// rebase moves path, which lies within from, to the same relative location within to.
+func rebase(path, from, to string) string {
+	rel, err := filepath.Rel(from, path)
+	if err != nil {
+		return path
+	}
+	return filepath.Join(to, rel)
+}
@@ -0,0 +1,107 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandMasks(t *testing.T) {
+	t.Parallel()
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	project := filepath.Join(root, "project")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{".git/objects", "secrets", "src"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(project, dir), 0o755))
+	}
+	require.NoError(t, os.MkdirAll(outside, 0o755))
+	for _, file := range []string{".env", "server.pem", "client.pem", "src/main.py"} {
+		require.NoError(t, os.WriteFile(filepath.Join(project, file), nil, 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "token"), nil, 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(project, "secrets"), filepath.Join(project, "link-in")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "token"), filepath.Join(project, "link-out")))
+
+	mounts := []Mount{{Source: project, Target: project}}
+	masks, err := expandMasks([]string{".git", ".env", "*.pem", "missing", project + "/secrets", "link-*"}, project, mounts)
+	require.NoError(t, err)
+	assert.Equal(t, []maskedPath{
+		{path: project + "/.env", dir: false},
+		{path: project + "/.git", dir: true},
+		{path: project + "/client.pem", dir: false},
+		{path: project + "/secrets", dir: true},
+		{path: project + "/server.pem", dir: false},
+	}, masks)
+
+	// Masks follow the mount's remapping
+	masks, err = expandMasks([]string{"/work/src/*.py"}, "/work", []Mount{{Source: project, Target: "/work"}})
+	require.NoError(t, err)
+	assert.Equal(t, []maskedPath{{path: "/work/src/main.py"}}, masks)
+
+	// The innermost mount wins
+	masks, err = expandMasks([]string{"/data/x/token"}, "/", []Mount{
+		{Source: project, Target: "/data"},
+		{Source: outside, Target: "/data/x"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []maskedPath{{path: "/data/x/token"}}, masks)
+
+	_, err = expandMasks([]string{outside + "/token"}, project, mounts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not inside any mount")
+
+	_, err = expandMasks([]string{"["}, project, mounts)
+	require.Error(t, err)
+
+	_, err = expandMasks([]string{""}, project, mounts)
+	require.Error(t, err)
+}
+
+func TestMaskedPathOutsideMounts(t *testing.T) {
+	t.Parallel()
+
+	policy := DefaultPolicy()
+	policy.WorkDir = t.TempDir()
+	policy.MaskedPaths = []string{"/nonexistent-root/secret"}
+	_, err := policy.Command(context.Background(), "true")
+	require.Error(t, err)
+}
+
+func TestIntegrationMaskedPaths(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "secrets"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secrets", "key"), []byte("hunter2"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("TOKEN=hunter2"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.txt"), []byte("visible"), 0o644))
+
+	policy := pythonPolicy()
+	policy.WorkDir = dir
+	policy.MaskedPaths = []string{"secrets", ".env"}
+	cmd, err := policy.Command(context.Background(), pythonPath, "-c", `
+import os
+for name in ["secrets/key", ".env", "data.txt"]:
+    try:
+        print(name, repr(open(name).read()))
+    except OSError as e:
+        print(name, "error")
+`)
+	require.NoError(t, err)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %s", output)
+
+	assert.NotContains(t, string(output), "hunter2")
+	assert.Contains(t, string(output), "data.txt 'visible'")
+}
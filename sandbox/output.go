@@ -0,0 +1,177 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// OutputLimits bounds the output that Run and RunCommand collect from a command, so that
+// a program printing in a loop cannot exhaust the caller's memory. The zero value keeps
+// all output.
+type OutputLimits struct {
+	// MaxBytes caps the bytes kept in memory for each of Result.Stdout, Result.Stderr and
+	// Result.Output. When a stream is longer, its first HeadBytes and its last
+	// MaxBytes-HeadBytes bytes are kept, joined by a line saying how many bytes were
+	// dropped. Zero keeps everything.
+	MaxBytes int
+
+	// HeadBytes is how much of MaxBytes is taken from the beginning of a stream; the rest
+	// holds its end, where tracebacks are. Zero means half of MaxBytes.
+	HeadBytes int
+
+	// SpillDir is a host directory, such as the policy's WorkDir, in which the complete
+	// stdout and stderr are also written to files (see Result.StdoutFile). The caller
+	// removes the files. Empty disables spilling.
+	SpillDir string
+
+	// KillBytes is a hard cap on the total bytes a command may write to stdout and stderr.
+	// When it is exceeded, every process of the sandbox is killed at once, further output
+	// is discarded, and CheckLimits reports LimitOutput. Zero means no cap.
+	KillBytes int64
+}
+
+// validate checks that the sizes are consistent.
+func (o *OutputLimits) validate() error {
+	if o.MaxBytes < 0 || o.HeadBytes < 0 || o.KillBytes < 0 {
+		return fmt.Errorf("sandbox: output limits: sizes must not be negative")
+	}
+	if o.HeadBytes > o.MaxBytes {
+		return fmt.Errorf("sandbox: output limits: HeadBytes (%d) exceeds MaxBytes (%d)", o.HeadBytes, o.MaxBytes)
+	}
+	return nil
+}
+
+func (o *OutputLimits) headBytes() int {
+	if o.HeadBytes == 0 {
+		return o.MaxBytes / 2
+	}
+	return o.HeadBytes
+}
+
+// cappedBuffer keeps the head and the tail of what is written to it, up to max bytes in
+// total; max <= 0 keeps everything.
+type cappedBuffer struct {
+	max, headMax int
+	head         []byte
+	tail         []byte // ring buffer of up to max-headMax bytes, oldest byte at pos when full
+	pos          int
+	total        int64
+}
+
+func newCappedBuffer(limits OutputLimits) cappedBuffer {
+	return cappedBuffer{max: limits.MaxBytes, headMax: limits.headBytes()}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+	if b.max <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+	if room := b.headMax - len(b.head); room > 0 {
+		k := min(room, len(p))
+		b.head = append(b.head, p[:k]...)
+		p = p[k:]
+	}
+	tailMax := b.max - b.headMax
+	if tailMax == 0 || len(p) == 0 {
+		return n, nil
+	}
+	if len(p) >= tailMax {
+		b.tail = append(b.tail[:0], p[len(p)-tailMax:]...)
+		b.pos = 0
+		return n, nil
+	}
+	if room := tailMax - len(b.tail); room > 0 {
+		k := min(room, len(p))
+		b.tail = append(b.tail, p[:k]...)
+		p = p[k:]
+	}
+	for len(p) > 0 {
+		k := copy(b.tail[b.pos:], p)
+		p = p[k:]
+		b.pos = (b.pos + k) % tailMax
+	}
+	return n, nil
+}
+
+// truncated reports whether bytes were dropped.
+func (b *cappedBuffer) truncated() bool {
+	return b.total > int64(len(b.head)+len(b.tail))
+}
+
+// Bytes returns the kept bytes, with a marker where bytes were dropped.
+func (b *cappedBuffer) Bytes() []byte {
+	out := append([]byte(nil), b.head...)
+	if b.truncated() {
+		dropped := b.total - int64(len(b.head)+len(b.tail))
+		out = fmt.Appendf(out, "\n[... %d bytes truncated ...]\n", dropped)
+	}
+	out = append(out, b.tail[b.pos:]...)
+	return append(out, b.tail[:b.pos]...)
+}
+
+// outputCollector records a command's stdout and stderr, separately and interleaved,
+// within the policy's OutputLimits.
+type outputCollector struct {
+	limits   OutputLimits
+	exceeded func() // called once when KillBytes is exceeded
+
+	mu       sync.Mutex
+	stdout   cappedBuffer
+	stderr   cappedBuffer
+	combined cappedBuffer
+	written  int64
+	killed   bool
+	spillErr error
+}
+
+func newOutputCollector(limits OutputLimits, exceeded func()) *outputCollector {
+	return &outputCollector{
+		limits:   limits,
+		exceeded: exceeded,
+		stdout:   newCappedBuffer(limits),
+		stderr:   newCappedBuffer(limits),
+		combined: newCappedBuffer(limits),
+	}
+}
+
+// stream returns a writer for one of the streams, which also appends to the combined
+// output and, if spill is non-nil, to the spill file.
+func (c *outputCollector) stream(buf *cappedBuffer, spill *os.File) *collectorStream {
+	return &collectorStream{c: c, buf: buf, spill: spill}
+}
+
+type collectorStream struct {
+	c     *outputCollector
+	buf   *cappedBuffer
+	spill *os.File
+}
+
+func (s *collectorStream) Write(p []byte) (int, error) {
+	c := s.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(p)
+	if limit := c.limits.KillBytes; limit > 0 && c.written+int64(len(p)) > limit {
+		// Keep draining the pipes until the sandbox is gone, discarding the excess
+		p = p[:limit-c.written]
+		if !c.killed {
+			c.killed = true
+			if c.exceeded != nil {
+				go c.exceeded()
+			}
+		}
+	}
+	c.written += int64(len(p))
+	s.buf.Write(p)
+	c.combined.Write(p)
+	if s.spill != nil && c.spillErr == nil {
+		if _, err := s.spill.Write(p); err != nil {
+			c.spillErr = err
+		}
+	}
+	return n, nil
+}
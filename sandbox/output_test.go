@@ -0,0 +1,137 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputLimitsValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, (&OutputLimits{}).validate())
+	assert.NoError(t, (&OutputLimits{MaxBytes: 10, HeadBytes: 10, KillBytes: 100}).validate())
+	assert.ErrorContains(t, (&OutputLimits{MaxBytes: -1}).validate(), "must not be negative")
+	assert.ErrorContains(t, (&OutputLimits{MaxBytes: 10, HeadBytes: 11}).validate(), "exceeds MaxBytes")
+}
+
+func TestCappedBuffer(t *testing.T) {
+	t.Parallel()
+
+	write := func(limits OutputLimits, chunks ...string) *cappedBuffer {
+		b := newCappedBuffer(limits)
+		for _, c := range chunks {
+			n, err := b.Write([]byte(c))
+			require.NoError(t, err)
+			require.Equal(t, len(c), n)
+		}
+		return &b
+	}
+
+	tests := []struct {
+		name   string
+		limits OutputLimits
+		chunks []string
+		want   string
+	}{
+		{"unlimited", OutputLimits{}, []string{"abc", "def"}, "abcdef"},
+		{"within limit", OutputLimits{MaxBytes: 6}, []string{"abc", "def"}, "abcdef"},
+		{"head and tail", OutputLimits{MaxBytes: 4}, []string{"abcdefgh"}, "ab\n[... 4 bytes truncated ...]\ngh"},
+		{"small writes", OutputLimits{MaxBytes: 4}, strings.Split("abcdefgh", ""), "ab\n[... 4 bytes truncated ...]\ngh"},
+		{"tail wraps", OutputLimits{MaxBytes: 5, HeadBytes: 1}, []string{"ab", "cde", "fg", "h"}, "a\n[... 3 bytes truncated ...]\nefgh"},
+		{"head only", OutputLimits{MaxBytes: 3, HeadBytes: 3}, []string{"abcdef"}, "abc\n[... 3 bytes truncated ...]\n"},
+		{"default head", OutputLimits{MaxBytes: 2}, []string{"abcdef"}, "a\n[... 4 bytes truncated ...]\nf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := write(tt.limits, tt.chunks...)
+			assert.Equal(t, tt.want, string(b.Bytes()))
+			assert.Equal(t, strings.Contains(tt.want, "truncated"), b.truncated())
+		})
+	}
+}
+
+func TestOutputCollectorKillBytes(t *testing.T) {
+	t.Parallel()
+
+	calls := make(chan struct{}, 10)
+	c := newOutputCollector(OutputLimits{KillBytes: 5}, func() { calls <- struct{}{} })
+	stdout, stderr := c.stream(&c.stdout, nil), c.stream(&c.stderr, nil)
+
+	n, err := stdout.Write([]byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = stderr.Write([]byte("defg"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, n, "excess output is consumed so the command does not block")
+	stdout.Write([]byte("hij"))
+
+	<-calls
+	assert.Empty(t, calls, "the callback runs once")
+	assert.Equal(t, "abc", string(c.stdout.Bytes()))
+	assert.Equal(t, "de", string(c.stderr.Bytes()))
+	assert.Equal(t, "abcde", string(c.combined.Bytes()))
+	assert.True(t, c.killed)
+}
+
+func TestRunCommandOutputLimits(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// command returns an unsandboxed command carrying the limits, as Policy.Command would
+	command := func(limits OutputLimits, script string) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "sh", "-c", script)
+		st := &cmdState{output: limits}
+		st.kill = func(cmd *exec.Cmd) error { return cmd.Process.Kill() }
+		st.attach(cmd)
+		return cmd
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		t.Parallel()
+		res, err := RunCommand(ctx, command(OutputLimits{MaxBytes: 8}, "printf 'head-0123456789-tail'; echo oops >&2"))
+		require.NoError(t, err)
+		assert.True(t, res.Truncated)
+		assert.Equal(t, "head\n[... 12 bytes truncated ...]\ntail", string(res.Stdout))
+		assert.Equal(t, "oops\n", string(res.Stderr))
+		assert.True(t, res.Success())
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		res, err := RunCommand(ctx, command(OutputLimits{MaxBytes: 4, SpillDir: dir}, "seq 1000; echo done >&2"))
+		require.NoError(t, err)
+		assert.True(t, res.Truncated)
+		require.NotEmpty(t, res.StdoutFile)
+		assert.Equal(t, dir, filepath.Dir(res.StdoutFile))
+
+		want, err := exec.Command("seq", "1000").Output()
+		require.NoError(t, err)
+		data, err := os.ReadFile(res.StdoutFile)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(data))
+		data, err = os.ReadFile(res.StderrFile)
+		require.NoError(t, err)
+		assert.Equal(t, "done\n", string(data))
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		t.Parallel()
+		cmd := command(OutputLimits{KillBytes: 1 << 16}, "exec yes")
+		res, err := RunCommand(ctx, cmd)
+		require.NoError(t, err)
+		assert.True(t, res.Truncated)
+		assert.Len(t, res.Stdout, 1<<16)
+		assert.False(t, res.Success())
+		var limitErr *LimitError
+		require.ErrorAs(t, res.Err, &limitErr)
+		assert.Equal(t, LimitOutput, limitErr.Limit)
+	})
+}
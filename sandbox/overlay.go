@@ -0,0 +1,415 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Overlay presents a host directory to sandboxed commands through a copy-on-write overlay
+// filesystem, so that a run cannot modify the directory itself. Everything the sandboxed
+// process writes, creates or deletes lands in a private upper layer kept in a temporary
+// directory on the host. After the run the caller inspects the pending changes with Changes
+// and either applies them to the real directory with Commit or throws them away with Discard.
+//
+// The overlay must be explicitly created and closed by the caller:
+//
+//	ov, err := sandbox.NewOverlay("/path/to/workdir")
+//	if err != nil { return err }
+//	defer ov.Close()
+//	policy.WorkDir = ov.Dir()
+//	policy.Overlay = ov
+//
+// Changes accumulate across runs until they are committed or discarded. An Overlay must be
+// used by one command at a time, and the underlying directory must not be modified by other
+// means while a command is running (overlayfs does not define what the sandbox sees then).
+//
+// Linux only (bubblewrap 0.10 or newer); Policy.Command fails on macOS when Overlay is set.
+type Overlay struct {
+	dir      string // canonical host directory (the lower layer)
+	stateDir string // holds the upper and work directories
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// ChangeKind describes how a path differs between the upper layer and the host directory.
+type ChangeKind int
+
+const (
+	// ChangeAdded marks a path that does not exist in the host directory.
+	ChangeAdded ChangeKind = iota
+	// ChangeModified marks a path whose contents, type or permissions differ.
+	ChangeModified
+	// ChangeDeleted marks a path that was removed inside the sandbox.
+	ChangeDeleted
+)
+
+// String returns "added", "modified" or "deleted".
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// Change is one pending modification in an Overlay.
+type Change struct {
+	// Path is relative to Overlay.Dir(), using forward slashes.
+	Path string
+
+	// Kind describes the modification.
+	Kind ChangeKind
+}
+
+// NewOverlay creates an overlay for dir, which must be an existing directory. The upper layer
+// is stored in a new temporary directory (see os.TempDir) that is removed by Close.
+func NewOverlay(dir string) (*Overlay, error) {
+	canon, err := canonicalPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: overlay: %w", err)
+	}
+	info, err := os.Stat(canon)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: overlay: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("sandbox: overlay: %s is not a directory", dir)
+	}
+
+	stateDir, err := os.MkdirTemp("", "boxedpy-overlay-*")
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: overlay: create state directory: %w", err)
+	}
+	o := &Overlay{dir: canon, stateDir: stateDir}
+	if err := o.reset(); err != nil {
+		os.RemoveAll(stateDir)
+		return nil, err
+	}
+	return o, nil
+}
+
+// Dir returns the canonical path of the host directory the overlay is layered on.
+// This is also where the overlay appears inside the sandbox.
+func (o *Overlay) Dir() string {
+	return o.dir
+}
+
+// UpperDir returns the host directory holding the upper layer, for callers that want to
+// examine modified files directly. Its layout is overlayfs's: deleted paths are represented
+// by whiteout entries.
+func (o *Overlay) UpperDir() string {
+	return filepath.Join(o.stateDir, "upper")
+}
+
+func (o *Overlay) workDir() string {
+	return filepath.Join(o.stateDir, "work")
+}
+
+// layers returns the upper and work directories to mount, failing once the overlay is closed.
+func (o *Overlay) layers() (upper, work string, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return "", "", fmt.Errorf("overlay is closed")
+	}
+	return o.UpperDir(), o.workDir(), nil
+}
+
+// Changes returns the pending changes, sorted by path. Directories are only reported when
+// they were created, deleted or had their permissions changed.
+func (o *Overlay) Changes() ([]Change, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil, fmt.Errorf("sandbox: overlay is closed")
+	}
+
+	var changes []Change
+	upper := o.UpperDir()
+	err := filepath.WalkDir(upper, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == upper {
+			return nil
+		}
+		rel, err := filepath.Rel(upper, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		lowerPath := filepath.Join(o.dir, rel)
+		lowerInfo, lowerErr := os.Lstat(lowerPath)
+		if lowerErr != nil && !errors.Is(lowerErr, fs.ErrNotExist) {
+			return lowerErr
+		}
+		inLower := lowerErr == nil
+
+		if isWhiteout(path, info) {
+			if inLower {
+				changes = append(changes, Change{Path: filepath.ToSlash(rel), Kind: ChangeDeleted})
+			}
+			return nil
+		}
+
+		if !inLower {
+			changes = append(changes, Change{Path: filepath.ToSlash(rel), Kind: ChangeAdded})
+			return nil
+		}
+
+		if info.IsDir() && lowerInfo.IsDir() {
+			if info.Mode().Perm() != lowerInfo.Mode().Perm() {
+				changes = append(changes, Change{Path: filepath.ToSlash(rel), Kind: ChangeModified})
+			}
+			if isOpaqueDir(path) {
+				// The directory was deleted and recreated: everything below it in the
+				// host directory that was not recreated is gone.
+				entries, err := os.ReadDir(lowerPath)
+				if err != nil {
+					return err
+				}
+				for _, e := range entries {
+					if _, err := os.Lstat(filepath.Join(path, e.Name())); errors.Is(err, fs.ErrNotExist) {
+						changes = append(changes, Change{Path: filepath.ToSlash(filepath.Join(rel, e.Name())), Kind: ChangeDeleted})
+					}
+				}
+			}
+			return nil
+		}
+
+		same, err := sameFile(path, info, lowerPath, lowerInfo)
+		if err != nil {
+			return err
+		}
+		if !same {
+			changes = append(changes, Change{Path: filepath.ToSlash(rel), Kind: ChangeModified})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: overlay changes: %w", err)
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// Commit applies the pending changes to the host directory and empties the upper layer.
+// Files are written to a temporary name and renamed into place, so a concurrent reader of
+// the host directory never observes a partially written file. If Commit fails, the host
+// directory may contain a subset of the changes; the upper layer is left intact so the
+// commit can be retried.
+func (o *Overlay) Commit() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return fmt.Errorf("sandbox: overlay is closed")
+	}
+
+	upper := o.UpperDir()
+	err := filepath.WalkDir(upper, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == upper {
+			return nil
+		}
+		rel, err := filepath.Rel(upper, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return commitEntry(path, info, filepath.Join(o.dir, rel))
+	})
+	if err != nil {
+		return fmt.Errorf("sandbox: overlay commit: %w", err)
+	}
+	return o.reset()
+}
+
+// commitEntry applies one upper layer entry to the host path dst. Parent directories have
+// already been committed, so dst's parent is a real directory (never a symlink).
+func commitEntry(src string, info fs.FileInfo, dst string) error {
+	dstInfo, err := os.Lstat(dst)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	exists := err == nil
+
+	switch {
+	case isWhiteout(src, info):
+		return os.RemoveAll(dst)
+
+	case info.IsDir():
+		if exists && (!dstInfo.IsDir() || isOpaqueDir(src)) {
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+			exists = false
+		}
+		if !exists {
+			if err := os.Mkdir(dst, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(dst, info.Mode().Perm())
+
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(target, dst)
+
+	case info.Mode().IsRegular():
+		if exists && dstInfo.IsDir() {
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+		}
+		return copyFileAtomic(src, info, dst)
+	}
+	return fmt.Errorf("%s: unsupported file type %s", src, info.Mode().Type())
+}
+
+// copyFileAtomic copies the regular file src to dst through a temporary file in dst's
+// directory, preserving permissions and modification time.
+func copyFileAtomic(src string, info fs.FileInfo, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".boxedpy-commit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// sameFile reports whether an upper layer entry is identical to the host entry it shadows.
+// Files are copied up when opened for writing, so a copy can exist without a change.
+func sameFile(path string, info fs.FileInfo, lowerPath string, lowerInfo fs.FileInfo) (bool, error) {
+	if info.Mode() != lowerInfo.Mode() {
+		return false, nil
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		a, err := os.Readlink(path)
+		if err != nil {
+			return false, err
+		}
+		b, err := os.Readlink(lowerPath)
+		if err != nil {
+			return false, err
+		}
+		return a == b, nil
+	case info.Mode().IsRegular():
+		if info.Size() != lowerInfo.Size() {
+			return false, nil
+		}
+		a, err := os.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		b, err := os.ReadFile(lowerPath)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(a, b), nil
+	}
+	return false, nil
+}
+
+// Discard throws away the pending changes, leaving the host directory as it was.
+func (o *Overlay) Discard() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return fmt.Errorf("sandbox: overlay is closed")
+	}
+	return o.reset()
+}
+
+// Close discards the pending changes and removes the overlay's temporary directory.
+// It is safe to call more than once.
+func (o *Overlay) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	if err := forceRemoveAll(o.stateDir); err != nil {
+		return fmt.Errorf("sandbox: overlay: %w", err)
+	}
+	return nil
+}
+
+// reset recreates empty upper and work directories.
+func (o *Overlay) reset() error {
+	for _, dir := range []string{o.UpperDir(), o.workDir()} {
+		if err := forceRemoveAll(dir); err != nil {
+			return fmt.Errorf("sandbox: overlay: %w", err)
+		}
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			return fmt.Errorf("sandbox: overlay: %w", err)
+		}
+	}
+	return nil
+}
+
+// forceRemoveAll removes path like os.RemoveAll, first making directories accessible:
+// overlayfs leaves mode 000 directories behind in its work directory.
+func forceRemoveAll(path string) error {
+	if err := os.RemoveAll(path); err == nil {
+		return nil
+	}
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if d != nil && d.IsDir() {
+			os.Chmod(p, 0o700)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
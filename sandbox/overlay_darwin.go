@@ -0,0 +1,15 @@
+//go:build darwin
+
+package sandbox
+
+import "io/fs"
+
+// isWhiteout always reports false on macOS, where overlays are never mounted.
+func isWhiteout(path string, info fs.FileInfo) bool {
+	return false
+}
+
+// isOpaqueDir always reports false on macOS, where overlays are never mounted.
+func isOpaqueDir(path string) bool {
+	return false
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Extended attributes overlayfs uses for whiteouts and opaque directories. Mounts inside a
+// user namespace use the "user." namespace (the userxattr mount option).
+var (
+	overlayOpaqueXattrs   = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+	overlayWhiteoutXattrs = []string{"trusted.overlay.whiteout", "user.overlay.whiteout"}
+)
+
+// isWhiteout reports whether an upper layer entry marks a deleted path: a 0/0 character
+// device, or an empty file carrying the overlay whiteout attribute.
+func isWhiteout(path string, info fs.FileInfo) bool {
+	if info.Mode()&fs.ModeCharDevice != 0 {
+		st, ok := info.Sys().(*syscall.Stat_t)
+		return ok && st.Rdev == 0
+	}
+	if info.Mode().IsRegular() && info.Size() == 0 {
+		for _, name := range overlayWhiteoutXattrs {
+			if hasXattr(path, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isOpaqueDir reports whether an upper layer directory hides the lower directory's contents,
+// which overlayfs does when a directory is removed and recreated.
+func isOpaqueDir(path string) bool {
+	for _, name := range overlayOpaqueXattrs {
+		buf := make([]byte, 1)
+		n, err := syscall.Getxattr(path, name, buf)
+		if err == nil && n == 1 && buf[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+func hasXattr(path, name string) bool {
+	_, err := syscall.Getxattr(path, name, nil)
+	return err == nil
+}
@@ -0,0 +1,203 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile creates a file and its parent directories.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+// whiteout records the deletion of rel in the overlay's upper layer the way overlayfs does.
+func whiteout(t *testing.T, ov *Overlay, rel string) {
+	t.Helper()
+	path := filepath.Join(ov.UpperDir(), rel)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	err := syscall.Mknod(path, syscall.S_IFCHR, 0)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("creating whiteout devices requires CAP_MKNOD")
+	}
+	require.NoError(t, err)
+}
+
+func TestOverlayChangesCommitDiscard(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "keep.txt"), "keep")
+	writeFile(t, filepath.Join(dir, "edit.txt"), "old")
+	writeFile(t, filepath.Join(dir, "touched.txt"), "same")
+	writeFile(t, filepath.Join(dir, "gone.txt"), "gone")
+	writeFile(t, filepath.Join(dir, "sub", "a.txt"), "a")
+
+	ov, err := NewOverlay(dir)
+	require.NoError(t, err)
+	defer ov.Close()
+
+	canon, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	assert.Equal(t, canon, ov.Dir())
+
+	// Simulate what the sandboxed process leaves in the upper layer
+	upper := ov.UpperDir()
+	writeFile(t, filepath.Join(upper, "edit.txt"), "new")
+	writeFile(t, filepath.Join(upper, "touched.txt"), "same") // copied up, not changed
+	writeFile(t, filepath.Join(upper, "sub", "b.txt"), "b")
+	writeFile(t, filepath.Join(upper, "new", "c.txt"), "c")
+	require.NoError(t, os.Symlink("keep.txt", filepath.Join(upper, "link")))
+	whiteout(t, ov, "gone.txt")
+
+	changes, err := ov.Changes()
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Path: "edit.txt", Kind: ChangeModified},
+		{Path: "gone.txt", Kind: ChangeDeleted},
+		{Path: "link", Kind: ChangeAdded},
+		{Path: "new", Kind: ChangeAdded},
+		{Path: "new/c.txt", Kind: ChangeAdded},
+		{Path: "sub/b.txt", Kind: ChangeAdded},
+	}, changes)
+
+	// The host directory is untouched until Commit
+	data, err := os.ReadFile(filepath.Join(dir, "edit.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+
+	require.NoError(t, ov.Commit())
+
+	data, err = os.ReadFile(filepath.Join(dir, "edit.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	data, err = os.ReadFile(filepath.Join(dir, "new", "c.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "c", string(data))
+	target, err := os.Readlink(filepath.Join(dir, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "keep.txt", target)
+	assert.NoFileExists(t, filepath.Join(dir, "gone.txt"))
+	assert.FileExists(t, filepath.Join(dir, "sub", "a.txt"))
+	assert.FileExists(t, filepath.Join(dir, "sub", "b.txt"))
+
+	// Committed changes are no longer pending
+	changes, err = ov.Changes()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// Discard leaves the host directory alone
+	writeFile(t, filepath.Join(upper, "keep.txt"), "clobbered")
+	require.NoError(t, ov.Discard())
+	changes, err = ov.Changes()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	data, err = os.ReadFile(filepath.Join(dir, "keep.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "keep", string(data))
+}
+
+func TestOverlayClose(t *testing.T) {
+	t.Parallel()
+
+	ov, err := NewOverlay(t.TempDir())
+	require.NoError(t, err)
+
+	// overlayfs leaves inaccessible directories in its work directory
+	stuck := filepath.Join(ov.workDir(), "work")
+	require.NoError(t, os.Mkdir(stuck, 0))
+
+	require.NoError(t, ov.Close())
+	require.NoError(t, ov.Close())
+	assert.NoDirExists(t, ov.UpperDir())
+
+	_, err = ov.Changes()
+	assert.Error(t, err)
+	assert.Error(t, ov.Commit())
+
+	policy := DefaultPolicy()
+	policy.Overlay = ov
+	_, err = policy.Command(context.Background(), "true")
+	assert.Error(t, err)
+}
+
+func TestOverlayArgs(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	dir := t.TempDir()
+	ov, err := NewOverlay(dir)
+	require.NoError(t, err)
+	defer ov.Close()
+
+	policy := DefaultPolicy()
+	policy.Overlay = ov
+	args, _, err := bubblewrapArgs(policy, "true", []string{"true"}, nil)
+	require.NoError(t, err)
+	joined := strings.Join(args, " ")
+	assert.Contains(t, joined, "--overlay-src "+ov.Dir()+" --overlay "+ov.UpperDir()+" "+ov.workDir()+" "+ov.Dir())
+	assert.NotContains(t, joined, "--bind "+ov.Dir())
+	assert.Contains(t, joined, "--chdir "+ov.Dir())
+
+	policy.WorkDir = t.TempDir()
+	_, _, err = bubblewrapArgs(policy, "true", []string{"true"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match working directory")
+}
+
+func TestIntegrationOverlayWorkDir(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "input.txt"), "input")
+
+	ov, err := NewOverlay(dir)
+	require.NoError(t, err)
+	defer ov.Close()
+
+	policy := pythonPolicy()
+	policy.WorkDir = ov.Dir()
+	policy.Overlay = ov
+	cmd, err := policy.Command(context.Background(), pythonPath, "-c", `
+import os
+open("output.txt", "w").write("output")
+os.remove("input.txt")
+`)
+	require.NoError(t, err)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %s", output)
+
+	assert.FileExists(t, filepath.Join(dir, "input.txt"))
+	assert.NoFileExists(t, filepath.Join(dir, "output.txt"))
+
+	changes, err := ov.Changes()
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Path: "input.txt", Kind: ChangeDeleted},
+		{Path: "output.txt", Kind: ChangeAdded},
+	}, changes)
+
+	require.NoError(t, ov.Commit())
+	assert.NoFileExists(t, filepath.Join(dir, "input.txt"))
+	assert.FileExists(t, filepath.Join(dir, "output.txt"))
+}
@@ -0,0 +1,226 @@
+package sandbox
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// Plan describes exactly what Policy.Command would run for a command, without starting
+// anything: the canonicalized mounts, the namespaces unshared, the network mode, the
+// environment and the final argument vector. It is derived from the same arguments
+// Command passes to bubblewrap or sandbox-exec, so it reflects what the sandbox enforces
+// rather than what the policy asks for (e.g., AllowNetwork has no effect on Linux unless
+// AllowSharedNamespaces is also set, and Plan reports the network as NetworkNone).
+//
+// String renders a plan for logs; Diff compares two plans.
+type Plan struct {
+	// Backend is the sandboxing mechanism: "bubblewrap" (Linux) or "seatbelt" (macOS).
+	Backend string
+
+	// Mounts lists what the sandbox sees, in the order it is set up.
+	Mounts []PlanMount
+
+	// Namespaces lists the Linux namespaces that are unshared, sorted (e.g., "ipc", "net").
+	// Empty on macOS.
+	Namespaces []string
+
+	// Network is the network access of the sandboxed process.
+	Network NetworkMode
+
+	// WorkDir is the directory the command starts in, as seen inside the sandbox.
+	WorkDir string
+
+	// Env is the environment of the sandboxed process (cmd.Env).
+	Env []string
+
+	// Args is the argument vector of the sandbox tool, starting with the path of bwrap or
+	// sandbox-exec and ending with the command and its arguments. When the policy has
+	// Limits or ReportViolations that need the re-exec helper, Command starts the current
+	// executable first, which applies them and then execs Args; that wrapper is not shown.
+	Args []string
+}
+
+// PlanMount is one entry of Plan.Mounts.
+type PlanMount struct {
+	// Kind is how Target is provided.
+	Kind MountKind
+
+	// Source is the host path bound at Target for MountReadOnly, MountReadWrite and
+	// MountOverlay (its lower layer), the link contents for MountSymlink and the descriptor
+	// the data is read from for MountData and MountFile (e.g., "fd 4"). Empty otherwise.
+	Source string
+
+	// Target is the path inside the sandbox.
+	Target string
+
+	// Perms holds the permission bits of MountData and MountFile entries.
+	Perms fs.FileMode
+}
+
+// MountKind classifies a PlanMount.
+type MountKind string
+
+const (
+	MountReadOnly  MountKind = "ro"      // read-only bind mount
+	MountReadWrite MountKind = "rw"      // read-write bind mount
+	MountOverlay   MountKind = "overlay" // copy-on-write overlay (see Policy.Overlay)
+	MountTmpfs     MountKind = "tmpfs"   // empty, private, memory-backed directory
+	MountProc      MountKind = "proc"    // /proc of the sandbox's PID namespace
+	MountDev       MountKind = "dev"     // minimal /dev
+	MountSymlink   MountKind = "symlink" // symbolic link
+	MountData      MountKind = "data"    // read-only injected file (see Policy.Files)
+	MountFile      MountKind = "file"    // writable injected file
+	MountMasked    MountKind = "masked"  // hidden path (see Policy.MaskedPaths)
+)
+
+// NetworkMode describes the network access of a sandboxed process.
+type NetworkMode string
+
+const (
+	// NetworkNone: no network. On Linux the sandbox has a private network namespace with
+	// only its own loopback interface.
+	NetworkNone NetworkMode = "none"
+	// NetworkLocalhost: the host's loopback interface only (macOS AllowLocalhostOnly).
+	NetworkLocalhost NetworkMode = "localhost"
+	// NetworkProxied: only the filtering proxy of Policy.NetworkProxy is reachable.
+	NetworkProxied NetworkMode = "proxy"
+	// NetworkFull: unrestricted network access.
+	NetworkFull NetworkMode = "full"
+)
+
+// Plan returns a description of what Command(ctx, name, arg...) would run, performing the
+// same checks and path resolution but without creating or starting anything. On macOS the
+// per-command temporary directory of ProvideTmp is shown as a placeholder path and the
+// Seatbelt log tag as "boxedpy-PLAN", so that plans of the same policy compare equal.
+// Plans describe the built-in backends only; other backends return an error. Args
+// shows the backend's command, without the helper that Command may wrap it in.
+//
+// Example (log what a failing run was given):
+//
+//	if err := cmd.Run(); err != nil {
+//	    if plan, perr := policy.Plan("python3", "script.py"); perr == nil {
+//	        log.Printf("sandboxed run failed: %v\n%s", err, plan)
+//	    }
+//	}
+func (p *Policy) Plan(name string, arg ...string) (*Plan, error) {
+	if p == nil {
+		return nil, fmt.Errorf("sandbox: policy must not be nil")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("sandbox: command name must not be empty")
+	}
+	if err := p.validateCommand(); err != nil {
+		return nil, err
+	}
+	backend, err := p.selectBackend()
+	if err != nil {
+		return nil, err
+	}
+	if backend.Name() != defaultBackend {
+		return nil, fmt.Errorf("sandbox: Plan is not supported for backend %q", backend.Name())
+	}
+	// Platform-specific implementations in plan_linux.go and plan_darwin.go
+	return p.plan(name, arg...)
+}
+
+// String renders the plan as indented, human-readable text, one item per line.
+func (pl *Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "backend:    %s\n", pl.Backend)
+	fmt.Fprintf(&b, "network:    %s\n", pl.Network)
+	if len(pl.Namespaces) > 0 {
+		fmt.Fprintf(&b, "namespaces: %s\n", strings.Join(pl.Namespaces, " "))
+	}
+	fmt.Fprintf(&b, "workdir:    %s\n", pl.WorkDir)
+	b.WriteString("mounts:\n")
+	for _, m := range pl.Mounts {
+		fmt.Fprintf(&b, "  %s\n", m)
+	}
+	b.WriteString("env:\n")
+	for _, kv := range pl.Env {
+		fmt.Fprintf(&b, "  %s\n", kv)
+	}
+	b.WriteString("args:\n")
+	fmt.Fprintf(&b, "  %s\n", shellJoin(pl.Args))
+	return b.String()
+}
+
+// String renders the mount as "<kind> <target> [<- <source>]", e.g. "ro /work <- /home/a/p".
+func (m PlanMount) String() string {
+	s := fmt.Sprintf("%-7s %s", m.Kind, m.Target)
+	if m.Source != "" {
+		s += " <- " + m.Source
+	}
+	if m.Kind == MountData || m.Kind == MountFile {
+		s += fmt.Sprintf(" (%04o)", m.Perms)
+	}
+	return s
+}
+
+// Diff reports how other differs from pl, one line per difference, e.g.
+// "network: none -> full" or "+ mount rw /data <- /srv/data". It returns nil when the
+// plans are identical. Mounts and environment entries are compared as sets; a change in
+// their order alone is reported as a difference in args.
+func (pl *Plan) Diff(other *Plan) []string {
+	var diff []string
+	scalar := func(name, a, b string) {
+		if a != b {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", name, a, b))
+		}
+	}
+	set := func(name string, a, b []string) {
+		for _, s := range a {
+			if !slices.Contains(b, s) {
+				diff = append(diff, fmt.Sprintf("- %s %s", name, s))
+			}
+		}
+		for _, s := range b {
+			if !slices.Contains(a, s) {
+				diff = append(diff, fmt.Sprintf("+ %s %s", name, s))
+			}
+		}
+	}
+	mounts := func(ms []PlanMount) []string {
+		out := make([]string, len(ms))
+		for i, m := range ms {
+			out[i] = strings.Join(strings.Fields(m.String()), " ")
+		}
+		return out
+	}
+
+	scalar("backend", pl.Backend, other.Backend)
+	scalar("network", string(pl.Network), string(other.Network))
+	scalar("namespaces", strings.Join(pl.Namespaces, " "), strings.Join(other.Namespaces, " "))
+	scalar("workdir", pl.WorkDir, other.WorkDir)
+	set("mount", mounts(pl.Mounts), mounts(other.Mounts))
+	set("env", pl.Env, other.Env)
+	if !slices.Equal(pl.Args, other.Args) {
+		i := 0
+		for i < len(pl.Args) && i < len(other.Args) && pl.Args[i] == other.Args[i] {
+			i++
+		}
+		diff = append(diff, fmt.Sprintf("args: differ from argument %d: %s -> %s",
+			i, shellJoin(pl.Args[i:]), shellJoin(other.Args[i:])))
+	}
+	return diff
+}
+
+// Equal reports whether the two plans are identical.
+func (pl *Plan) Equal(other *Plan) bool {
+	return len(pl.Diff(other)) == 0
+}
+
+// shellJoin joins args into a string that a POSIX shell would split back into args.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+/.,:@%") == "" {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,80 @@
+//go:build darwin
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// planLogTag replaces the per-command Seatbelt log tag in plans.
+const planLogTag = "boxedpy-PLAN"
+
+// plan implements Policy.Plan using the sandbox-exec arguments Command would use.
+func (p *Policy) plan(name string, arg ...string) (*Plan, error) {
+	// Command creates a fresh temporary directory; name it without creating it
+	var tmpDir string
+	if p.ProvideTmp {
+		base, err := canonicalPath(os.TempDir())
+		if err != nil {
+			return nil, fmt.Errorf("seatbelt: %w", err)
+		}
+		tmpDir = filepath.Join(base, "boxedpy-sandbox-*")
+	}
+
+	args, workDir, err := seatbeltArgs(p, append([]string{name}, arg...), tmpDir, planLogTag)
+	if err != nil {
+		return nil, fmt.Errorf("seatbelt: build args: %w", err)
+	}
+
+	plan := &Plan{
+		Backend: "seatbelt",
+		Network: NetworkNone,
+		WorkDir: workDir,
+		Env:     p.commandEnv(tmpDir),
+		Args:    args,
+	}
+	switch {
+	case p.NetworkProxy != nil:
+		plan.Network = NetworkProxied
+	case p.AllowNetwork:
+		plan.Network = NetworkFull
+	case p.AllowLocalhostOnly:
+		plan.Network = NetworkLocalhost
+	}
+
+	// Readable roots are read-only unless they are also writable; masks come last, as in
+	// the Seatbelt policy, where the later deny rules take precedence
+	var readable, masked []string
+	writable := make(map[string]bool)
+	for _, a := range args {
+		if a == "--" {
+			break
+		}
+		param, value, ok := strings.Cut(strings.TrimPrefix(a, "-D"), "=")
+		if !ok || !strings.HasPrefix(a, "-D") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(param, "READABLE_ROOT_"):
+			readable = append(readable, value)
+		case strings.HasPrefix(param, "WRITABLE_ROOT_"):
+			writable[value] = true
+		case strings.HasPrefix(param, "MASKED_"):
+			masked = append(masked, value)
+		}
+	}
+	for _, path := range readable {
+		kind := MountReadOnly
+		if writable[path] {
+			kind = MountReadWrite
+		}
+		plan.Mounts = append(plan.Mounts, PlanMount{Kind: kind, Source: path, Target: path})
+	}
+	for _, path := range masked {
+		plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountMasked, Target: path})
+	}
+	return plan, nil
+}
@@ -0,0 +1,135 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+)
+
+// bwrapArity is the number of values taken by each bubblewrap option bubblewrapArgs emits.
+var bwrapArity = map[string]int{
+	"--ro-bind":         2,
+	"--bind":            2,
+	"--tmpfs":           1,
+	"--remount-ro":      1,
+	"--proc":            1,
+	"--dev":             1,
+	"--symlink":         2,
+	"--overlay-src":     1,
+	"--overlay":         3,
+	"--perms":           1,
+	"--ro-bind-data":    2,
+	"--file":            2,
+	"--seccomp":         1,
+	"--chdir":           1,
+	"--uid":             1,
+	"--gid":             1,
+	"--hostname":        1,
+	"--unshare-all":     0,
+	"--unshare-net":     0,
+	"--unshare-user":    0,
+	"--unshare-uts":     0,
+	"--die-with-parent": 0,
+	"--new-session":     0,
+	"--setenv":          2,
+}
+
+// plan implements Policy.Plan using the bubblewrap arguments Command would use.
+func (p *Policy) plan(name string, arg ...string) (*Plan, error) {
+	envv := p.commandEnv()
+	args, _, err := bubblewrapArgs(p, name, append([]string{name}, arg...), envv)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: build bubblewrap args: %w", err)
+	}
+	plan, err := bubblewrapPlan(args)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %w", err)
+	}
+	plan.Env = envv
+	if p.NetworkProxy != nil {
+		plan.Network = NetworkProxied
+	}
+	return plan, nil
+}
+
+// bubblewrapPlan describes the sandbox set up by a bwrap argument vector.
+func bubblewrapPlan(args []string) (*Plan, error) {
+	plan := &Plan{Backend: "bubblewrap", Network: NetworkFull, Args: args}
+	namespaces := make(map[string]bool)
+	var overlaySrc string
+	var perms fs.FileMode
+
+	for i := 1; i < len(args) && args[i] != "--"; {
+		flag := args[i]
+		n, ok := bwrapArity[flag]
+		if !ok || i+n >= len(args) {
+			return nil, fmt.Errorf("unexpected bubblewrap argument %q", flag)
+		}
+		v := args[i+1 : i+1+n]
+		i += 1 + n
+
+		switch flag {
+		case "--ro-bind":
+			if v[0] == "/dev/null" && v[1] != "/dev/null" {
+				plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountMasked, Target: v[1]})
+			} else {
+				plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountReadOnly, Source: v[0], Target: v[1]})
+			}
+		case "--bind":
+			plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountReadWrite, Source: v[0], Target: v[1]})
+		case "--tmpfs":
+			plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountTmpfs, Target: v[0]})
+		case "--remount-ro":
+			// Masked directories are a tmpfs remounted read-only
+			if last := len(plan.Mounts) - 1; last >= 0 && plan.Mounts[last].Kind == MountTmpfs && plan.Mounts[last].Target == v[0] {
+				plan.Mounts[last].Kind = MountMasked
+			}
+		case "--proc":
+			plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountProc, Target: v[0]})
+		case "--dev":
+			plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountDev, Target: v[0]})
+		case "--symlink":
+			plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountSymlink, Source: v[0], Target: v[1]})
+		case "--overlay-src":
+			overlaySrc = v[0]
+		case "--overlay":
+			plan.Mounts = append(plan.Mounts, PlanMount{Kind: MountOverlay, Source: overlaySrc, Target: v[2]})
+		case "--perms":
+			mode, err := strconv.ParseUint(v[0], 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bubblewrap --perms %q: %w", v[0], err)
+			}
+			perms = fs.FileMode(mode)
+		case "--ro-bind-data", "--file":
+			kind := MountData
+			if flag == "--file" {
+				kind = MountFile
+			}
+			plan.Mounts = append(plan.Mounts, PlanMount{Kind: kind, Source: "fd " + v[0], Target: v[1], Perms: perms})
+		case "--chdir":
+			plan.WorkDir = v[0]
+		case "--unshare-all":
+			// bubblewrap unshares the user and cgroup namespaces where the kernel allows
+			for _, ns := range []string{"cgroup", "ipc", "net", "pid", "user", "uts"} {
+				namespaces[ns] = true
+			}
+		case "--unshare-net", "--unshare-user", "--unshare-uts":
+			namespaces[flag[len("--unshare-"):]] = true
+		}
+		if flag != "--perms" {
+			perms = 0
+		}
+	}
+
+	for ns := range namespaces {
+		plan.Namespaces = append(plan.Namespaces, ns)
+	}
+	sort.Strings(plan.Namespaces)
+	if namespaces["net"] {
+		plan.Network = NetworkNone
+	}
+	return plan, nil
+}
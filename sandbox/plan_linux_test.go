@@ -0,0 +1,105 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBubblewrapPlan(t *testing.T) {
+	t.Parallel()
+
+	plan, err := bubblewrapPlan([]string{
+		"/usr/bin/bwrap",
+		"--ro-bind", "/usr", "/usr",
+		"--bind", "/home/a/project", "/work",
+		"--proc", "/proc", "--dev", "/dev",
+		"--tmpfs", "/tmp",
+		"--symlink", "usr/bin", "/bin",
+		"--unshare-all", "--unshare-user", "--uid", "1000", "--gid", "1000", "--hostname", "sandbox",
+		"--seccomp", "3",
+		"--die-with-parent", "--new-session",
+		"--perms", "0644", "--file", "4", "/tmp/input.py",
+		"--tmpfs", "/work/.git", "--remount-ro", "/work/.git",
+		"--ro-bind", "/dev/null", "/work/.env",
+		"--chdir", "/work",
+		"--", "python3", "--ro-bind", "x",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "bubblewrap", plan.Backend)
+	assert.Equal(t, NetworkNone, plan.Network)
+	assert.Equal(t, []string{"cgroup", "ipc", "net", "pid", "user", "uts"}, plan.Namespaces)
+	assert.Equal(t, "/work", plan.WorkDir)
+	assert.Equal(t, []PlanMount{
+		{Kind: MountReadOnly, Source: "/usr", Target: "/usr"},
+		{Kind: MountReadWrite, Source: "/home/a/project", Target: "/work"},
+		{Kind: MountProc, Target: "/proc"},
+		{Kind: MountDev, Target: "/dev"},
+		{Kind: MountTmpfs, Target: "/tmp"},
+		{Kind: MountSymlink, Source: "usr/bin", Target: "/bin"},
+		{Kind: MountFile, Source: "fd 4", Target: "/tmp/input.py", Perms: 0o644},
+		{Kind: MountMasked, Target: "/work/.git"},
+		{Kind: MountMasked, Target: "/work/.env"},
+	}, plan.Mounts)
+
+	plan, err = bubblewrapPlan([]string{"bwrap", "--unshare-user", "--", "true"})
+	require.NoError(t, err)
+	assert.Equal(t, NetworkFull, plan.Network)
+
+	_, err = bubblewrapPlan([]string{"bwrap", "--share-net", "--", "true"})
+	assert.Error(t, err)
+	_, err = bubblewrapPlan([]string{"bwrap", "--bind", "/a"})
+	assert.Error(t, err)
+}
+
+func TestPolicyPlan(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+
+	policy := DefaultPolicy()
+	policy.WorkDir = dir
+	policy.Env.Set = map[string]string{"PYTHONUNBUFFERED": "1"}
+	plan, err := policy.Plan("python3", "-c", "print(1)")
+	require.NoError(t, err)
+
+	args, _, err := bubblewrapArgs(policy, "python3", []string{"python3", "-c", "print(1)"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, args, plan.Args)
+	assert.Equal(t, NetworkNone, plan.Network)
+	assert.Equal(t, dir, plan.WorkDir)
+	assert.Contains(t, plan.Mounts, PlanMount{Kind: MountReadWrite, Source: dir, Target: dir})
+	assert.Contains(t, plan.Mounts, PlanMount{Kind: MountTmpfs, Target: "/tmp"})
+	assert.Contains(t, plan.Env, "PYTHONUNBUFFERED=1")
+	assert.Contains(t, plan.String(), "rw      "+dir+" <- "+dir)
+
+	// Plans are deterministic, and report what changed between policies
+	again, err := policy.Plan("python3", "-c", "print(1)")
+	require.NoError(t, err)
+	assert.True(t, plan.Equal(again))
+
+	// AllowNetwork only takes effect with shared namespaces
+	policy.AllowNetwork = true
+	networked, err := policy.Plan("python3", "-c", "print(1)")
+	require.NoError(t, err)
+	assert.Equal(t, NetworkNone, networked.Network)
+	policy.AllowSharedNamespaces = true
+	networked, err = policy.Plan("python3", "-c", "print(1)")
+	require.NoError(t, err)
+	assert.Equal(t, NetworkFull, networked.Network)
+	assert.Contains(t, plan.Diff(networked), "network: none -> full")
+
+	_, err = policy.Plan("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanString(t *testing.T) {
+	t.Parallel()
+
+	plan := &Plan{
+		Backend:    "bubblewrap",
+		Network:    NetworkNone,
+		Namespaces: []string{"net", "pid"},
+		WorkDir:    "/work",
+		Mounts: []PlanMount{
+			{Kind: MountReadOnly, Source: "/usr", Target: "/usr"},
+			{Kind: MountData, Source: "fd 4", Target: "/etc/pip.conf", Perms: 0o444},
+			{Kind: MountMasked, Target: "/work/.env"},
+		},
+		Env:  []string{"PATH=/usr/bin"},
+		Args: []string{"/usr/bin/bwrap", "--chdir", "/work", "--", "python3", "-c", "print('hi')"},
+	}
+	assert.Equal(t, `backend:    bubblewrap
+network:    none
+namespaces: net pid
+workdir:    /work
+mounts:
+  ro      /usr <- /usr
+  data    /etc/pip.conf <- fd 4 (0444)
+  masked  /work/.env
+env:
+  PATH=/usr/bin
+args:
+  /usr/bin/bwrap --chdir /work -- python3 -c 'print('\''hi'\'')'
+`, plan.String())
+}
+
+func TestPlanDiff(t *testing.T) {
+	t.Parallel()
+
+	a := &Plan{
+		Backend: "bubblewrap",
+		Network: NetworkNone,
+		Mounts:  []PlanMount{{Kind: MountReadOnly, Source: "/usr", Target: "/usr"}},
+		Env:     []string{"PATH=/usr/bin"},
+		Args:    []string{"bwrap", "--ro-bind", "/usr", "/usr", "--", "true"},
+	}
+	b := &Plan{
+		Backend: "bubblewrap",
+		Network: NetworkFull,
+		Mounts: []PlanMount{
+			{Kind: MountReadOnly, Source: "/usr", Target: "/usr"},
+			{Kind: MountReadWrite, Source: "/srv/data", Target: "/data"},
+		},
+		Env:  []string{"PATH=/usr/bin", "DEBUG=1"},
+		Args: []string{"bwrap", "--ro-bind", "/usr", "/usr", "--bind", "/srv/data", "/data", "--", "true"},
+	}
+	require.True(t, a.Equal(a))
+	assert.Nil(t, a.Diff(a))
+	assert.False(t, a.Equal(b))
+	assert.Equal(t, []string{
+		"network: none -> full",
+		"+ mount rw /data <- /srv/data",
+		"+ env DEBUG=1",
+		"args: differ from argument 4: -- true -> --bind /srv/data /data -- true",
+	}, a.Diff(b))
+}
+
+func TestShellJoin(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `a /b/c 'two words' '' 'it'\''s' '$HOME'`,
+		shellJoin([]string{"a", "/b/c", "two words", "", "it's", "$HOME"}))
+}
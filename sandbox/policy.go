@@ -12,6 +12,7 @@ import (
 // Security model:
 //   - All processes run in isolated namespaces (network, IPC, PID, etc.) by default
 //   - Only explicitly mounted paths are accessible inside the sandbox
+//   - Host environment variables are not inherited unless listed in Env.Inherit
 //   - Read-only mounts prevent modification of system files
 //   - Read-write mounts should be limited to working directories and necessary user data
 //   - Child processes are terminated when the parent exits
@@ -33,6 +34,36 @@ type Policy struct {
 	// Limit these to only what the sandboxed process needs to write.
 	ReadWriteMounts []Mount
 
+	// MaskedPaths hides files and directories that lie inside the mounts above (or WorkDir),
+	// e.g. a project's .git, .env or secrets/ directory. Entries are absolute sandbox paths or
+	// paths relative to WorkDir, and may contain filepath.Match wildcards within the part below
+	// the mount point (e.g., "*.pem", "config/*.key"). Each entry must fall inside some mount;
+	// Command returns an error otherwise. Matching is done when Command is called, so paths
+	// created later are not masked.
+	//
+	// - Linux: Masked directories are replaced by an empty read-only tmpfs, files by /dev/null
+	// - macOS: Seatbelt denies all reads and writes below the masked paths
+	//
+	// Example:
+	//   policy.MaskedPaths = []string{".git", ".env", "secrets", "*.pem"}
+	MaskedPaths []string
+
+	// Files injects in-memory files into the sandbox, keyed by absolute sandbox path (e.g.,
+	// "/etc/pip.conf" or "/tmp/input.py"). The contents are passed through pipes, so they never
+	// touch the host filesystem. Files are placed after all mounts and shadow anything already
+	// at their path; missing parent directories are created. Read-only files are bind-mounted:
+	// inside a read-only mount the path must already exist, and inside a read-write mount an
+	// empty placeholder is left on the host if it did not. See File for writable files.
+	//
+	// - Linux: bubblewrap --ro-bind-data (read-only) or --file (writable) over inherited pipes
+	// - macOS: not supported; Command returns an error
+	//
+	// Example:
+	//   policy.Files = map[string]sandbox.File{
+	//       "/etc/pip.conf": {Data: []byte("[global]\nno-index = true\n")},
+	//   }
+	Files map[string]File
+
 	// WorkDir specifies the working directory for the sandboxed command.
 	// If empty, defaults to the current working directory (os.Getwd()).
 	//
@@ -43,6 +74,10 @@ type Policy struct {
 	//
 	// This design allows specifying the working directory without using os.Chdir(), which is
 	// forbidden in library code as it affects global process state.
+	//
+	// On Linux, WorkDir may instead name a location inside a remapped mount (a Mount whose
+	// Target differs from its Source, e.g. "/work"). It is then a sandbox path: nothing extra
+	// is mounted and the command simply starts there.
 	WorkDir string
 
 	// ProvideTmp controls whether /tmp is available inside the sandbox (default false = no /tmp).
@@ -89,7 +124,12 @@ type Policy struct {
 	// network connections.
 	//
 	// - macOS: Seatbelt restricts network access to only the proxy ports
-	// - Linux: Full network namespace isolation (--unshare-net) with Unix socket mounting
+	// - Linux: Full network namespace isolation (--unshare-net). The proxy's Unix sockets
+	//   are mounted into the sandbox and served on loopback TCP ports (127.0.0.1:3128 for
+	//   HTTP, 127.0.0.1:1080 for SOCKS5) by a forwarder process started before the command,
+	//   so that ordinary HTTP clients such as pip and requests can use them. The forwarder
+	//   counts toward Limits.MaxProcesses and MemoryBytes. The landlock backend passes the
+	//   Unix socket addresses instead.
 	//
 	// The proxy must be explicitly created and closed by the caller:
 	//   proxy, err := NewNetworkProxy(filter)
@@ -97,12 +137,78 @@ type Policy struct {
 	//   defer proxy.Close()
 	//   policy.NetworkProxy = proxy
 	//
+	// Sandboxes running concurrently can share one proxy through NetworkProxy.NewClient,
+	// each with its own endpoint and filter.
+	//
 	// Environment variables (HTTP_PROXY, HTTPS_PROXY, ALL_PROXY) are automatically
 	// set in the sandboxed process to use the proxy.
 	//
 	// Note: If NetworkProxy is set, AllowNetwork and AllowLocalhostOnly are ignored.
 	NetworkProxy *NetworkProxy
 
+	// Overlay, when set, presents WorkDir through a copy-on-write overlay instead of
+	// bind-mounting it read-write: the sandboxed process sees and may modify the directory's
+	// contents, but its changes are kept aside until the caller commits or discards them.
+	// If WorkDir is empty it defaults to Overlay.Dir(); otherwise the two must match.
+	//
+	// - Linux: bubblewrap --overlay (requires bubblewrap 0.10 or newer)
+	// - macOS: not supported; Command returns an error
+	//
+	// The overlay must be explicitly created and closed by the caller:
+	//   ov, err := NewOverlay(workDir)
+	//   if err != nil { return err }
+	//   defer ov.Close()
+	//   policy.Overlay = ov
+	//   ... run the command, then inspect ov.Changes() and call ov.Commit() or ov.Discard()
+	Overlay *Overlay
+
+	// Env controls the environment variables of the sandboxed process (default: nothing is
+	// inherited from the host). Policy.Command builds cmd.Env from it; see Environment for
+	// the variables that are always injected and the order in which entries are applied.
+	//
+	// Example (pass through locale settings, set a fixed value):
+	//   policy.Env.Inherit = []string{"LANG", "LC_*", "TZ"}
+	//   policy.Env.Set = map[string]string{"PYTHONUNBUFFERED": "1"}
+	Env Environment
+
+	// Identity, when set, runs the sandboxed process as a synthetic unprivileged user with
+	// its own /etc/passwd and /etc/group entries, a private writable HOME and a neutral
+	// hostname, instead of exposing the host's (default nil = host identity).
+	//
+	// - Linux: user and UTS namespaces (bubblewrap --uid, --gid, --hostname)
+	// - macOS: not supported; Command returns an error
+	//
+	// Example:
+	//   policy.Identity = &sandbox.Identity{TZ: "UTC", Locale: "C.UTF-8"}
+	Identity *Identity
+
+	// Limits constrains memory, CPU time, wall-clock time, process count, open files,
+	// file size and scheduling priority of the sandboxed process tree (default: no limits).
+	// See Limits for how each limit is enforced per platform, and CheckLimits for telling
+	// a limit-induced failure apart from an ordinary non-zero exit.
+	Limits Limits
+
+	// Termination controls how the sandbox is stopped when the context passed to Command
+	// is done or Limits.WallTime elapses. The default kills every sandboxed process at
+	// once; set Termination.Signal to interrupt the command first and kill the sandbox
+	// only if it has not exited after a grace period. See TerminationOf.
+	Termination Termination
+
+	// Output bounds the stdout and stderr that Run and RunCommand keep in memory, can
+	// spill the complete streams to files, and can kill a command that writes too much
+	// (default: all output is kept). It does not apply to writers the caller connects to
+	// the Cmd itself.
+	Output OutputLimits
+
+	// Backend names the registered Backend that runs sandboxed commands (default empty =
+	// DefaultBackend(): bubblewrap on Linux, Seatbelt on macOS). Command returns an error
+	// if no such backend is registered or it does not support a feature the policy uses.
+	//
+	// Example (a backend registered by another package):
+	//   import _ "example.com/sandbox/nsjail"
+	//   policy.Backend = "nsjail"
+	Backend string
+
 	// The following fields are Linux-specific and ignored on macOS:
 
 	// AllowSharedNamespaces, when true, disables namespace isolation (skips --unshare-all).
@@ -122,6 +228,27 @@ type Policy struct {
 	// Only set to true if the sandboxed process needs terminal control.
 	// Ignored on macOS (Seatbelt doesn't have this concept).
 	AllowSessionControl bool
+
+	// Seccomp, when set, installs a seccomp-BPF system call filter in the sandbox
+	// (default nil = no filter). DefaultPolicy() uses DefaultSeccompProfile(), which blocks
+	// ptrace, keyctl, bpf, userfaultfd, perf_event_open, mount and namespace operations.
+	// The profile is only read, so one profile may be shared by many policies.
+	// Ignored on macOS (Seatbelt already restricts process and kernel operations).
+	Seccomp *SeccompProfile
+
+	// ReportViolations, when true, records attempts by the sandboxed process to write to
+	// read-only or inaccessible paths and to connect to network addresses it cannot reach,
+	// as Violation events on the Cmd (see Violations and NotifyViolations). Operations are
+	// only observed, never changed: they fail inside the sandbox exactly as they would
+	// without reporting.
+	//
+	// Requires Linux 5.8 or newer, seccomp user notification and bubblewrap running without
+	// the setuid bit (unprivileged user namespaces). Each watched system call is paused
+	// while the host inspects it, which slows down programs that create many files. If the
+	// host can no longer inspect them, the run is killed and CheckLimits reports
+	// ErrViolationMonitor. Ignored on macOS, where Seatbelt logs denials to the system log
+	// instead.
+	ReportViolations bool
 }
 
 // Mount represents a filesystem path binding into the sandbox.
@@ -132,7 +259,14 @@ type Mount struct {
 	Source string
 
 	// Target is the absolute path inside the sandbox where Source will appear.
-	// Typically this is the same as Source to maintain path consistency.
+	// Typically this is the same as Source to maintain path consistency, in which case
+	// symlinks are resolved as for Source.
+	//
+	// - Linux: A different Target remaps the path (e.g., a project directory at /work, a
+	//   virtualenv at /venv). Target must be absolute and clean but need not exist on the
+	//   host; bubblewrap creates it and any missing parents inside the sandbox. Outer targets
+	//   are mounted before nested ones, so /work/data may be mounted inside /work.
+	// - macOS: Seatbelt cannot remap paths; Command returns an error if Target differs.
 	Target string
 }
 
@@ -156,6 +290,7 @@ type Mount struct {
 //   - For applications needing IPC via TCP (like Jupyter), use AllowLocalhostOnly: true
 //     to allow localhost communication while blocking external internet
 //   - All Linux isolation flags enabled (namespace isolation, die-with-parent, new session)
+//   - Linux: DefaultSeccompProfile() system call filter
 //   - Working directory is automatically mounted read-write at execution time
 //
 // Application-specific mounts (like /opt for Homebrew, virtualenv paths, user data directories)
@@ -165,6 +300,7 @@ func DefaultPolicy() *Policy {
 		ReadOnlyMounts:  make([]Mount, 0, 10),
 		ReadWriteMounts: make([]Mount, 0, 5),
 		ProvideTmp:      true,
+		Seccomp:         DefaultSeccompProfile(),
 		// AllowNetwork defaults to false (network blocked)
 		// All other security bools default to false (maximum security)
 	}
@@ -0,0 +1,771 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyFileVersion is the version of the policy file schema written by MarshalPolicy.
+// UnmarshalPolicy rejects files declaring any other version.
+const PolicyFileVersion = 1
+
+// Format is the encoding of a policy or configuration file.
+type Format int
+
+const (
+	// FormatYAML is YAML.
+	FormatYAML Format = iota
+	// FormatJSON is JSON.
+	FormatJSON
+)
+
+// FormatOf returns the format implied by the extension of path: FormatJSON for ".json",
+// FormatYAML otherwise.
+func FormatOf(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// policyFile is the on-disk representation of a Policy. Fields are only ever added to a
+// schema version; renaming or removing one requires a new PolicyFileVersion.
+type policyFile struct {
+	Version         int                 `json:"version" yaml:"version"`
+	Backend         string              `json:"backend,omitempty" yaml:"backend,omitempty"`
+	ReadOnlyMounts  []mountSpec         `json:"read_only_mounts,omitempty" yaml:"read_only_mounts,omitempty"`
+	ReadWriteMounts []mountSpec         `json:"read_write_mounts,omitempty" yaml:"read_write_mounts,omitempty"`
+	MaskedPaths     []string            `json:"masked_paths,omitempty" yaml:"masked_paths,omitempty"`
+	Files           map[string]fileSpec `json:"files,omitempty" yaml:"files,omitempty"`
+	WorkDir         string              `json:"work_dir,omitempty" yaml:"work_dir,omitempty"`
+	ProvideTmp      bool                `json:"provide_tmp,omitempty" yaml:"provide_tmp,omitempty"`
+	Network         *networkSpec        `json:"network,omitempty" yaml:"network,omitempty"`
+	Env             *envSpec            `json:"env,omitempty" yaml:"env,omitempty"`
+	Identity        *identitySpec       `json:"identity,omitempty" yaml:"identity,omitempty"`
+	Limits          *limitsSpec         `json:"limits,omitempty" yaml:"limits,omitempty"`
+	Termination     *terminationSpec    `json:"termination,omitempty" yaml:"termination,omitempty"`
+	Output          *outputSpec         `json:"output,omitempty" yaml:"output,omitempty"`
+	Linux           *linuxSpec          `json:"linux,omitempty" yaml:"linux,omitempty"`
+}
+
+type mountSpec struct {
+	Source string `json:"source" yaml:"source"`
+	Target string `json:"target,omitempty" yaml:"target,omitempty"`
+}
+
+type fileSpec struct {
+	Content  string `json:"content" yaml:"content"`
+	Mode     string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Writable bool   `json:"writable,omitempty" yaml:"writable,omitempty"`
+}
+
+type networkSpec struct {
+	AllowNetwork       bool       `json:"allow_network,omitempty" yaml:"allow_network,omitempty"`
+	AllowLocalhostOnly bool       `json:"allow_localhost_only,omitempty" yaml:"allow_localhost_only,omitempty"`
+	Proxy              *proxySpec `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+}
+
+type proxySpec struct {
+	AllowHosts            []string `json:"allow_hosts,omitempty" yaml:"allow_hosts,omitempty"`
+	DenyHosts             []string `json:"deny_hosts,omitempty" yaml:"deny_hosts,omitempty"`
+	AllowPrivateAddresses bool     `json:"allow_private_addresses,omitempty" yaml:"allow_private_addresses,omitempty"`
+}
+
+type envSpec struct {
+	Inherit []string          `json:"inherit,omitempty" yaml:"inherit,omitempty"`
+	Set     map[string]string `json:"set,omitempty" yaml:"set,omitempty"`
+	Unset   []string          `json:"unset,omitempty" yaml:"unset,omitempty"`
+}
+
+type identitySpec struct {
+	User     string `json:"user,omitempty" yaml:"user,omitempty"`
+	UID      int    `json:"uid,omitempty" yaml:"uid,omitempty"`
+	GID      int    `json:"gid,omitempty" yaml:"gid,omitempty"`
+	Home     string `json:"home,omitempty" yaml:"home,omitempty"`
+	Hostname string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	TZ       string `json:"tz,omitempty" yaml:"tz,omitempty"`
+	Locale   string `json:"locale,omitempty" yaml:"locale,omitempty"`
+}
+
+type limitsSpec struct {
+	MemoryBytes  uint64 `json:"memory_bytes,omitempty" yaml:"memory_bytes,omitempty"`
+	CPUTime      string `json:"cpu_time,omitempty" yaml:"cpu_time,omitempty"`
+	WallTime     string `json:"wall_time,omitempty" yaml:"wall_time,omitempty"`
+	MaxProcesses int    `json:"max_processes,omitempty" yaml:"max_processes,omitempty"`
+	MaxOpenFiles uint64 `json:"max_open_files,omitempty" yaml:"max_open_files,omitempty"`
+	MaxFileSize  uint64 `json:"max_file_size,omitempty" yaml:"max_file_size,omitempty"`
+	Nice         int    `json:"nice,omitempty" yaml:"nice,omitempty"`
+	IOClass      string `json:"io_class,omitempty" yaml:"io_class,omitempty"`
+	IOLevel      int    `json:"io_level,omitempty" yaml:"io_level,omitempty"`
+	CgroupParent string `json:"cgroup_parent,omitempty" yaml:"cgroup_parent,omitempty"`
+}
+
+type terminationSpec struct {
+	Signal      string `json:"signal,omitempty" yaml:"signal,omitempty"`
+	GracePeriod string `json:"grace_period,omitempty" yaml:"grace_period,omitempty"`
+}
+
+type outputSpec struct {
+	MaxBytes  int    `json:"max_bytes,omitempty" yaml:"max_bytes,omitempty"`
+	HeadBytes int    `json:"head_bytes,omitempty" yaml:"head_bytes,omitempty"`
+	SpillDir  string `json:"spill_dir,omitempty" yaml:"spill_dir,omitempty"`
+	KillBytes int64  `json:"kill_bytes,omitempty" yaml:"kill_bytes,omitempty"`
+}
+
+type linuxSpec struct {
+	AllowSharedNamespaces bool         `json:"allow_shared_namespaces,omitempty" yaml:"allow_shared_namespaces,omitempty"`
+	AllowParentSurvival   bool         `json:"allow_parent_survival,omitempty" yaml:"allow_parent_survival,omitempty"`
+	AllowSessionControl   bool         `json:"allow_session_control,omitempty" yaml:"allow_session_control,omitempty"`
+	Seccomp               *seccompSpec `json:"seccomp,omitempty" yaml:"seccomp,omitempty"`
+}
+
+type seccompSpec struct {
+	Base          string            `json:"base,omitempty" yaml:"base,omitempty"`
+	DefaultAction string            `json:"default_action,omitempty" yaml:"default_action,omitempty"`
+	Errno         int               `json:"errno,omitempty" yaml:"errno,omitempty"`
+	Allow         []string          `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny          []string          `json:"deny,omitempty" yaml:"deny,omitempty"`
+	Rules         []seccompRuleSpec `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+type seccompRuleSpec struct {
+	Syscall string           `json:"syscall" yaml:"syscall"`
+	Action  string           `json:"action" yaml:"action"`
+	Errno   int              `json:"errno,omitempty" yaml:"errno,omitempty"`
+	Args    []seccompArgSpec `json:"args,omitempty" yaml:"args,omitempty"`
+}
+
+type seccompArgSpec struct {
+	Index int    `json:"index" yaml:"index"`
+	Op    string `json:"op" yaml:"op"`
+	Value uint32 `json:"value" yaml:"value"`
+}
+
+// Names used for enumerations in policy files.
+var (
+	ioClassNames       = map[IOClass]string{IOClassDefault: "", IOClassBestEffort: "best-effort", IOClassIdle: "idle"}
+	seccompActionNames = map[SeccompAction]string{SeccompAllow: "allow", SeccompErrno: "errno", SeccompKill: "kill"}
+	seccompOpNames     = map[SeccompOp]string{SeccompArgEqual: "equal", SeccompArgMaskedAny: "masked-any"}
+)
+
+// LoadPolicy reads a policy file, in the format given by its extension (see FormatOf).
+// See UnmarshalPolicy.
+func LoadPolicy(path string, vars map[string]string) (*Policy, *NetworkFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sandbox: policy file: %w", err)
+	}
+	p, filter, err := UnmarshalPolicy(data, FormatOf(path), vars)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, filter, nil
+}
+
+// UnmarshalPolicy decodes a policy file. The file must declare "version: 1" (see
+// PolicyFileVersion); unknown fields are rejected so that a misspelled setting can never be
+// silently ignored. ${NAME} references in paths (mount sources and targets, masked paths,
+// work_dir, file targets, identity home, cgroup_parent) and in env.set values are replaced
+// using vars, as described for ExpandVars.
+//
+// A policy file only contains what is written in it: unlike DefaultPolicy, a file without
+// mounts or a seccomp section mounts nothing and installs no system call filter. The
+// seccomp section may set "base: default" to extend DefaultSeccompProfile.
+//
+// NetworkProxy cannot be stored in a file. When the file has a network.proxy section, the
+// returned filter is non-nil and the caller creates and closes the proxy:
+//
+//	policy, filter, err := sandbox.LoadPolicy("policy.yaml", map[string]string{"WORKDIR": dir})
+//	if err != nil { return err }
+//	if filter != nil {
+//	    proxy, err := sandbox.NewNetworkProxy(filter)
+//	    if err != nil { return err }
+//	    defer proxy.Close()
+//	    policy.NetworkProxy = proxy
+//	}
+//
+// The policy is decoded, not validated: call Policy.Validate on the machine that runs it.
+//
+// Example file:
+//
+//	version: 1
+//	read_only_mounts:
+//	  - source: /usr
+//	  - source: ${VENV}
+//	    target: /venv
+//	read_write_mounts:
+//	  - source: ${WORKDIR}
+//	    target: /work
+//	masked_paths: [.git, .env]
+//	work_dir: /work
+//	provide_tmp: true
+//	network:
+//	  proxy:
+//	    allow_hosts: [pypi.org, "*.pythonhosted.org"]
+//	env:
+//	  inherit: [LANG, "LC_*"]
+//	  set: {PYTHONUNBUFFERED: "1"}
+//	limits:
+//	  memory_bytes: 2147483648
+//	  cpu_time: 30s
+//	  wall_time: 1m
+//	termination:
+//	  signal: SIGINT
+//	  grace_period: 10s
+//	output:
+//	  max_bytes: 65536
+//	  kill_bytes: 104857600
+//	linux:
+//	  seccomp:
+//	    base: default
+//	    deny: [socket]
+func UnmarshalPolicy(data []byte, format Format, vars map[string]string) (*Policy, *NetworkFilter, error) {
+	var f policyFile
+	if err := DecodeStrict(data, format, &f); err != nil {
+		return nil, nil, fmt.Errorf("sandbox: policy file: %w", err)
+	}
+	switch f.Version {
+	case PolicyFileVersion:
+	case 0:
+		return nil, nil, fmt.Errorf("sandbox: policy file: missing version")
+	default:
+		return nil, nil, fmt.Errorf("sandbox: policy file: unsupported version %d (want %d)", f.Version, PolicyFileVersion)
+	}
+	return f.policy(vars)
+}
+
+// LoadFilter reads a network filter file, in the format given by its extension (see
+// FormatOf). See UnmarshalFilter.
+func LoadFilter(path string) (*NetworkFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: filter file: %w", err)
+	}
+	filter, err := UnmarshalFilter(data, FormatOf(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return filter, nil
+}
+
+// UnmarshalFilter decodes a network filter file, which has the fields of the network.proxy
+// section of a policy file. Unknown fields and malformed patterns are rejected. Filter files
+// let a running proxy's filter be changed from outside the program; see
+// NetworkProxy.WatchFilterFile.
+//
+// Example file:
+//
+//	allow_hosts: [pypi.org, "*.pythonhosted.org:443"]
+//	deny_hosts: [10.0.0.0/8]
+func UnmarshalFilter(data []byte, format Format) (*NetworkFilter, error) {
+	var s proxySpec
+	if err := DecodeStrict(data, format, &s); err != nil {
+		return nil, fmt.Errorf("sandbox: filter file: %w", err)
+	}
+	filter, errs := s.filter("sandbox: filter file: ")
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// MarshalPolicy encodes p as a policy file. The filter of p.NetworkProxy is stored in place
+// of the proxy itself. Policies with an Overlay, or with Files whose contents are not valid
+// UTF-8, cannot be represented.
+func MarshalPolicy(p *Policy, format Format) ([]byte, error) {
+	if p == nil {
+		return nil, fmt.Errorf("sandbox: policy must not be nil")
+	}
+	f, err := newPolicyFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: policy file: %w", err)
+		}
+		return append(data, '\n'), nil
+	case FormatYAML:
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(f); err != nil {
+			return nil, fmt.Errorf("sandbox: policy file: %w", err)
+		}
+		if err := enc.Close(); err != nil {
+			return nil, fmt.Errorf("sandbox: policy file: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("sandbox: unknown format %d", format)
+}
+
+// DecodeStrict decodes a single document in format into v the way policy files are
+// decoded: unknown fields are rejected, as is data after the top-level JSON object. It
+// lets files layered on the same conventions, such as boxedpy's config files, be
+// decoded consistently.
+func DecodeStrict(data []byte, format Format, v any) error {
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+		if dec.More() {
+			return fmt.Errorf("unexpected data after the top-level object")
+		}
+		return nil
+	case FormatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(v); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("empty document")
+			}
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %d", format)
+}
+
+// ExpandVars replaces each ${NAME} in s with vars[NAME]; "$$" stands for a literal "$".
+// Referencing a variable that is not in vars, or any other use of "$", is an error, so a
+// typo can never silently turn a path into "" or "/".
+func ExpandVars(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			continue
+		}
+		rest := s[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "$"):
+			b.WriteByte('$')
+			i++
+		case strings.HasPrefix(rest, "{"):
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			name := rest[1:end]
+			value, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("undefined variable ${%s} in %q", name, s)
+			}
+			b.WriteString(value)
+			i += end + 1
+		default:
+			return "", fmt.Errorf("unescaped $ in %q (use ${NAME}, or $$ for a literal $)", s)
+		}
+	}
+	return b.String(), nil
+}
+
+// policy converts the file into a Policy, collecting every conversion problem.
+func (f *policyFile) policy(vars map[string]string) (*Policy, *NetworkFilter, error) {
+	var errs []error
+	expand := func(s string) string {
+		out, err := ExpandVars(s, vars)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sandbox: policy file: %w", err))
+		}
+		return out
+	}
+	duration := func(field, s string) time.Duration {
+		if s == "" {
+			return 0
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sandbox: policy file: %s: %w", field, err))
+		}
+		return d
+	}
+	mounts := func(specs []mountSpec) []Mount {
+		var ms []Mount
+		for _, s := range specs {
+			m := Mount{Source: expand(s.Source), Target: expand(s.Target)}
+			if m.Target == "" {
+				m.Target = m.Source
+			}
+			ms = append(ms, m)
+		}
+		return ms
+	}
+
+	p := &Policy{
+		ReadOnlyMounts:  mounts(f.ReadOnlyMounts),
+		ReadWriteMounts: mounts(f.ReadWriteMounts),
+		WorkDir:         expand(f.WorkDir),
+		ProvideTmp:      f.ProvideTmp,
+		Backend:         f.Backend,
+	}
+	for _, m := range f.MaskedPaths {
+		p.MaskedPaths = append(p.MaskedPaths, expand(m))
+	}
+	sources := make(map[string]string) // expanded target to the target written in the file
+	targets := make([]string, 0, len(f.Files))
+	for target := range f.Files {
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+	for _, target := range targets {
+		s := f.Files[target]
+		if p.Files == nil {
+			p.Files = make(map[string]File)
+		}
+		expanded := expand(target)
+		if prev, ok := sources[expanded]; ok {
+			errs = append(errs, fmt.Errorf("sandbox: policy file: files %s and %s are both placed at %s", prev, target, expanded))
+			continue
+		}
+		sources[expanded] = target
+		file := File{Data: []byte(s.Content), Writable: s.Writable}
+		if s.Mode != "" {
+			mode, err := strconv.ParseUint(s.Mode, 8, 32)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("sandbox: policy file: file %s: invalid mode %q", target, s.Mode))
+			}
+			file.Mode = os.FileMode(mode)
+		}
+		p.Files[expanded] = file
+	}
+
+	var filter *NetworkFilter
+	if n := f.Network; n != nil {
+		p.AllowNetwork = n.AllowNetwork
+		p.AllowLocalhostOnly = n.AllowLocalhostOnly
+		if n.Proxy != nil {
+			var proxyErrs []error
+			filter, proxyErrs = n.Proxy.filter("sandbox: policy file: network.proxy.")
+			errs = append(errs, proxyErrs...)
+		}
+	}
+
+	if e := f.Env; e != nil {
+		p.Env.Inherit = e.Inherit
+		p.Env.Unset = e.Unset
+		for name, value := range e.Set {
+			if p.Env.Set == nil {
+				p.Env.Set = make(map[string]string)
+			}
+			p.Env.Set[name] = expand(value)
+		}
+	}
+
+	if id := f.Identity; id != nil {
+		p.Identity = &Identity{
+			User:     id.User,
+			UID:      id.UID,
+			GID:      id.GID,
+			Home:     expand(id.Home),
+			Hostname: id.Hostname,
+			TZ:       id.TZ,
+			Locale:   id.Locale,
+		}
+	}
+
+	if l := f.Limits; l != nil {
+		p.Limits = Limits{
+			MemoryBytes:  l.MemoryBytes,
+			CPUTime:      duration("limits.cpu_time", l.CPUTime),
+			WallTime:     duration("limits.wall_time", l.WallTime),
+			MaxProcesses: l.MaxProcesses,
+			MaxOpenFiles: l.MaxOpenFiles,
+			MaxFileSize:  l.MaxFileSize,
+			Nice:         l.Nice,
+			IOLevel:      l.IOLevel,
+			CgroupParent: expand(l.CgroupParent),
+		}
+		class, ok := lookupName(ioClassNames, l.IOClass)
+		if !ok {
+			errs = append(errs, fmt.Errorf("sandbox: policy file: unknown io_class %q", l.IOClass))
+		}
+		p.Limits.IOClass = class
+	}
+
+	if t := f.Termination; t != nil {
+		p.Termination.GracePeriod = duration("termination.grace_period", t.GracePeriod)
+		if t.Signal != "" {
+			sig, ok := lookupName(terminationSignals, t.Signal)
+			if !ok {
+				errs = append(errs, fmt.Errorf("sandbox: policy file: unknown termination signal %q", t.Signal))
+			}
+			p.Termination.Signal = sig
+		}
+	}
+
+	if o := f.Output; o != nil {
+		p.Output = OutputLimits{
+			MaxBytes:  o.MaxBytes,
+			HeadBytes: o.HeadBytes,
+			SpillDir:  expand(o.SpillDir),
+			KillBytes: o.KillBytes,
+		}
+	}
+
+	if lx := f.Linux; lx != nil {
+		p.AllowSharedNamespaces = lx.AllowSharedNamespaces
+		p.AllowParentSurvival = lx.AllowParentSurvival
+		p.AllowSessionControl = lx.AllowSessionControl
+		if lx.Seccomp != nil {
+			profile, err := lx.Seccomp.profile()
+			if err != nil {
+				errs = append(errs, err)
+			}
+			p.Seccomp = profile
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return p, filter, nil
+}
+
+// filter converts the proxy section into a NetworkFilter, collecting every invalid pattern.
+// prefix starts the error messages.
+func (s *proxySpec) filter(prefix string) (*NetworkFilter, []error) {
+	var errs []error
+	for _, pattern := range s.AllowHosts {
+		if _, err := ParseRule(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("%sallow_hosts: %w", prefix, err))
+		}
+	}
+	for _, pattern := range s.DenyHosts {
+		if _, err := ParseRule(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("%sdeny_hosts: %w", prefix, err))
+		}
+	}
+	return &NetworkFilter{
+		AllowHosts:            s.AllowHosts,
+		DenyHosts:             s.DenyHosts,
+		AllowPrivateAddresses: s.AllowPrivateAddresses,
+	}, errs
+}
+
+// newProxySpec returns the proxy section storing filter, which may be nil.
+func newProxySpec(filter *NetworkFilter) *proxySpec {
+	s := &proxySpec{}
+	if filter != nil {
+		s.AllowHosts = appendRules(filter.AllowHosts, filter.AllowRules)
+		s.DenyHosts = appendRules(filter.DenyHosts, filter.DenyRules)
+		s.AllowPrivateAddresses = filter.AllowPrivateAddresses
+	}
+	return s
+}
+
+// profile converts the seccomp section into a SeccompProfile.
+func (s *seccompSpec) profile() (*SeccompProfile, error) {
+	var errs []error
+	profile := &SeccompProfile{}
+	switch s.Base {
+	case "":
+	case "default":
+		profile = DefaultSeccompProfile()
+	default:
+		errs = append(errs, fmt.Errorf("sandbox: policy file: unknown seccomp base %q", s.Base))
+	}
+
+	if s.DefaultAction != "" {
+		action, ok := lookupName(seccompActionNames, s.DefaultAction)
+		if !ok {
+			errs = append(errs, fmt.Errorf("sandbox: policy file: unknown seccomp action %q", s.DefaultAction))
+		}
+		profile.DefaultAction = action
+	}
+	if s.Errno != 0 {
+		profile.Errno = syscall.Errno(s.Errno)
+	}
+	profile.Allow = append(profile.Allow, s.Allow...)
+	profile.Deny = append(profile.Deny, s.Deny...)
+	for _, r := range s.Rules {
+		action, ok := lookupName(seccompActionNames, r.Action)
+		if !ok {
+			errs = append(errs, fmt.Errorf("sandbox: policy file: seccomp rule %s: unknown action %q", r.Syscall, r.Action))
+		}
+		rule := SeccompRule{Syscall: r.Syscall, Action: action, Errno: syscall.Errno(r.Errno)}
+		for _, a := range r.Args {
+			op, ok := lookupName(seccompOpNames, a.Op)
+			if !ok {
+				errs = append(errs, fmt.Errorf("sandbox: policy file: seccomp rule %s: unknown op %q", r.Syscall, a.Op))
+			}
+			rule.Args = append(rule.Args, SeccompArg{Index: a.Index, Op: op, Value: a.Value})
+		}
+		profile.Rules = append(profile.Rules, rule)
+	}
+	return profile, errors.Join(errs...)
+}
+
+// newPolicyFile converts p into its on-disk representation.
+func newPolicyFile(p *Policy) (*policyFile, error) {
+	if p.Overlay != nil {
+		return nil, fmt.Errorf("sandbox: policy file: Overlay cannot be stored in a policy file")
+	}
+	mounts := func(ms []Mount) []mountSpec {
+		var specs []mountSpec
+		for _, m := range ms {
+			s := mountSpec{Source: m.Source}
+			if m.Target != m.Source {
+				s.Target = m.Target
+			}
+			specs = append(specs, s)
+		}
+		return specs
+	}
+	duration := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+
+	f := &policyFile{
+		Version:         PolicyFileVersion,
+		ReadOnlyMounts:  mounts(p.ReadOnlyMounts),
+		ReadWriteMounts: mounts(p.ReadWriteMounts),
+		MaskedPaths:     p.MaskedPaths,
+		WorkDir:         p.WorkDir,
+		ProvideTmp:      p.ProvideTmp,
+		Backend:         p.Backend,
+	}
+
+	for _, target := range fileTargets(p.Files) {
+		file := p.Files[target]
+		if !utf8.Valid(file.Data) {
+			return nil, fmt.Errorf("sandbox: policy file: contents of file %s are not valid UTF-8", target)
+		}
+		if f.Files == nil {
+			f.Files = make(map[string]fileSpec)
+		}
+		s := fileSpec{Content: string(file.Data), Writable: file.Writable}
+		if file.Mode != 0 {
+			s.Mode = fmt.Sprintf("%04o", file.Mode.Perm())
+		}
+		f.Files[target] = s
+	}
+
+	if p.AllowNetwork || p.AllowLocalhostOnly || p.NetworkProxy != nil {
+		f.Network = &networkSpec{AllowNetwork: p.AllowNetwork, AllowLocalhostOnly: p.AllowLocalhostOnly}
+		if p.NetworkProxy != nil {
+			f.Network.Proxy = newProxySpec(p.NetworkProxy.Filter())
+		}
+	}
+
+	if e := p.Env; len(e.Inherit) > 0 || len(e.Set) > 0 || len(e.Unset) > 0 {
+		f.Env = &envSpec{Inherit: e.Inherit, Set: e.Set, Unset: e.Unset}
+	}
+
+	if id := p.Identity; id != nil {
+		f.Identity = &identitySpec{
+			User:     id.User,
+			UID:      id.UID,
+			GID:      id.GID,
+			Home:     id.Home,
+			Hostname: id.Hostname,
+			TZ:       id.TZ,
+			Locale:   id.Locale,
+		}
+	}
+
+	if l := p.Limits; l != (Limits{}) {
+		f.Limits = &limitsSpec{
+			MemoryBytes:  l.MemoryBytes,
+			CPUTime:      duration(l.CPUTime),
+			WallTime:     duration(l.WallTime),
+			MaxProcesses: l.MaxProcesses,
+			MaxOpenFiles: l.MaxOpenFiles,
+			MaxFileSize:  l.MaxFileSize,
+			Nice:         l.Nice,
+			IOClass:      ioClassNames[l.IOClass],
+			IOLevel:      l.IOLevel,
+			CgroupParent: l.CgroupParent,
+		}
+	}
+
+	if t := p.Termination; t != (Termination{}) {
+		f.Termination = &terminationSpec{
+			Signal:      terminationSignals[t.Signal],
+			GracePeriod: duration(t.GracePeriod),
+		}
+	}
+
+	if o := p.Output; o != (OutputLimits{}) {
+		f.Output = &outputSpec{
+			MaxBytes:  o.MaxBytes,
+			HeadBytes: o.HeadBytes,
+			SpillDir:  o.SpillDir,
+			KillBytes: o.KillBytes,
+		}
+	}
+
+	if p.AllowSharedNamespaces || p.AllowParentSurvival || p.AllowSessionControl || p.Seccomp != nil {
+		f.Linux = &linuxSpec{
+			AllowSharedNamespaces: p.AllowSharedNamespaces,
+			AllowParentSurvival:   p.AllowParentSurvival,
+			AllowSessionControl:   p.AllowSessionControl,
+		}
+		if sp := p.Seccomp; sp != nil {
+			s := &seccompSpec{
+				DefaultAction: seccompActionNames[sp.DefaultAction],
+				Errno:         int(sp.Errno),
+				Allow:         sp.Allow,
+				Deny:          sp.Deny,
+			}
+			for _, r := range sp.Rules {
+				rs := seccompRuleSpec{Syscall: r.Syscall, Action: seccompActionNames[r.Action], Errno: int(r.Errno)}
+				for _, a := range r.Args {
+					rs.Args = append(rs.Args, seccompArgSpec{Index: a.Index, Op: seccompOpNames[a.Op], Value: a.Value})
+				}
+				s.Rules = append(s.Rules, rs)
+			}
+			f.Linux.Seccomp = s
+		}
+	}
+	return f, nil
+}
+
+// lookupName returns the key of names whose value is name.
+func lookupName[K comparable](names map[K]string, name string) (K, bool) {
+	for k, v := range names {
+		if v == name {
+			return k, true
+		}
+	}
+	var zero K
+	return zero, false
+}
+
+// appendRules returns patterns followed by the host patterns of rules.
+func appendRules(patterns []string, rules []Rule) []string {
+	if len(rules) == 0 {
+		return patterns
+	}
+	out := slices.Clone(patterns)
+	for _, r := range rules {
+		out = append(out, r.String())
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package sandbox
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyFileRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{
+		AllowHosts:            []string{"pypi.org", "*.pythonhosted.org"},
+		AllowPrivateAddresses: true,
+	})
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	policy := DefaultPolicy()
+	policy.ReadWriteMounts = []Mount{{Source: "/home/alice/project", Target: "/work"}}
+	policy.MaskedPaths = []string{".env"}
+	policy.WorkDir = "/work"
+	policy.ProvideTmp = true
+	policy.Files = map[string]File{"/etc/pip.conf": {Data: []byte("[global]\n"), Mode: 0o440}}
+	policy.NetworkProxy = proxy
+	policy.Env = Environment{Inherit: []string{"LANG", "LC_*"}, Set: map[string]string{"PYTHONUNBUFFERED": "1"}}
+	policy.Identity = &Identity{UID: 1500, TZ: "UTC"}
+	policy.Limits = Limits{MemoryBytes: 1 << 30, CPUTime: 30 * time.Second, IOClass: IOClassIdle}
+	policy.Termination = Termination{Signal: syscall.SIGINT, GracePeriod: 10 * time.Second}
+	policy.Backend = "nsjail"
+	policy.Output = OutputLimits{MaxBytes: 1 << 16, HeadBytes: 1 << 12, SpillDir: "/tmp", KillBytes: 1 << 30}
+
+	for _, format := range []Format{FormatYAML, FormatJSON} {
+		data, err := MarshalPolicy(policy, format)
+		require.NoError(t, err)
+
+		decoded, filter, err := UnmarshalPolicy(data, format, nil)
+		require.NoError(t, err, "%s", data)
+		require.NotNil(t, filter)
+		assert.Equal(t, []string{"pypi.org", "*.pythonhosted.org"}, filter.AllowHosts)
+		assert.True(t, filter.AllowPrivateAddresses)
+		assert.Nil(t, decoded.NetworkProxy)
+
+		decoded.NetworkProxy = proxy
+		assert.Equal(t, policy, decoded)
+	}
+
+	policy.Overlay = &Overlay{}
+	_, err = MarshalPolicy(policy, FormatYAML)
+	assert.Error(t, err)
+}
+
+func TestUnmarshalPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, filter, err := UnmarshalPolicy([]byte(`
+version: 1
+read_only_mounts:
+  - source: ${VENV}
+read_write_mounts:
+  - source: ${WORKDIR}
+    target: /work
+work_dir: /work
+files:
+  /opt/run.sh: {content: "#!/bin/sh\n", mode: "0555"}
+env:
+  set: {PRICE: "$$5"}
+linux:
+  seccomp:
+    base: default
+    deny: [socket]
+`), FormatYAML, map[string]string{"VENV": "/opt/venv", "WORKDIR": "/tmp/w"})
+	require.NoError(t, err)
+	assert.Nil(t, filter)
+	assert.Equal(t, []Mount{{Source: "/opt/venv", Target: "/opt/venv"}}, policy.ReadOnlyMounts)
+	assert.Equal(t, []Mount{{Source: "/tmp/w", Target: "/work"}}, policy.ReadWriteMounts)
+	assert.Equal(t, File{Data: []byte("#!/bin/sh\n"), Mode: 0o555}, policy.Files["/opt/run.sh"])
+	assert.Equal(t, "$5", policy.Env.Set["PRICE"])
+	assert.Contains(t, policy.Seccomp.Deny, "socket")
+	assert.Equal(t, DefaultSeccompProfile().Allow, policy.Seccomp.Allow)
+
+	_, _, err = UnmarshalPolicy([]byte(`{"version": 1, "work_dir": "/w"}`), FormatJSON, nil)
+	require.NoError(t, err)
+}
+
+func TestUnmarshalPolicyErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		format Format
+		data   string
+		want   []string
+	}{
+		{"missing version", FormatYAML, "work_dir: /w\n", []string{"missing version"}},
+		{"future version", FormatJSON, `{"version": 2}`, []string{"unsupported version 2"}},
+		{"unknown field yaml", FormatYAML, "version: 1\nallow_netwrok: true\n", []string{"allow_netwrok"}},
+		{"unknown field json", FormatJSON, `{"version": 1, "network": {"allow_all": true}}`, []string{"allow_all"}},
+		{"empty", FormatYAML, "", []string{"empty document"}},
+		{"trailing json", FormatJSON, `{"version": 1} {}`, []string{"unexpected data"}},
+		{
+			"bad proxy pattern",
+			FormatYAML,
+			"version: 1\nnetwork:\n  proxy:\n    allow_hosts: [pypi.org, \"pypi.org:443:443\"]\n",
+			[]string{"network.proxy.allow_hosts", `"pypi.org:443:443"`},
+		},
+		{
+			"every conversion problem",
+			FormatYAML,
+			"version: 1\nwork_dir: ${NOPE}\nlimits: {cpu_time: soon, io_class: realtime}\ntermination: {signal: SIGUSR1}\n",
+			[]string{"${NOPE}", "limits.cpu_time", `"realtime"`, `"SIGUSR1"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, _, err := UnmarshalPolicy([]byte(tt.data), tt.format, nil)
+			require.Error(t, err)
+			for _, want := range tt.want {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalPolicyDuplicateFiles(t *testing.T) {
+	t.Parallel()
+
+	data := "version: 1\nfiles:\n  ${ETC}/pip.conf: {content: a}\n  /etc/pip.conf: {content: b}\n"
+	_, _, err := UnmarshalPolicy([]byte(data), FormatYAML, map[string]string{"ETC": "/etc"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "files ${ETC}/pip.conf and /etc/pip.conf are both placed at /etc/pip.conf")
+}
+
+func TestUnmarshalFilter(t *testing.T) {
+	t.Parallel()
+
+	filter, err := UnmarshalFilter([]byte("allow_hosts: [pypi.org, \"*.pythonhosted.org:443\"]\nallow_private_addresses: true\n"), FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, &NetworkFilter{AllowHosts: []string{"pypi.org", "*.pythonhosted.org:443"}, AllowPrivateAddresses: true}, filter)
+
+	filter, err = UnmarshalFilter([]byte(`{"deny_hosts": ["10.0.0.0/8"]}`), FormatJSON)
+	require.NoError(t, err)
+	assert.Equal(t, &NetworkFilter{DenyHosts: []string{"10.0.0.0/8"}}, filter)
+
+	for data, want := range map[string]string{
+		"allow_host: [pypi.org]\n":        "allow_host",
+		"deny_hosts: [\"10.0.0.0/40\"]\n": "deny_hosts",
+		"":                                "empty document",
+	} {
+		_, err := UnmarshalFilter([]byte(data), FormatYAML)
+		assert.ErrorContains(t, err, want)
+		assert.ErrorContains(t, err, "sandbox: filter file:")
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	t.Parallel()
+
+	vars := map[string]string{"WORKDIR": "/tmp/w", "EMPTY": ""}
+	for in, want := range map[string]string{
+		"/plain":             "/plain",
+		"${WORKDIR}/src":     "/tmp/w/src",
+		"${WORKDIR}${EMPTY}": "/tmp/w",
+		"cost $$5":           "cost $5",
+	} {
+		got, err := ExpandVars(in, vars)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"${MISSING}", "$WORKDIR", "${WORKDIR", "trailing $"} {
+		_, err := ExpandVars(in, vars)
+		assert.Error(t, err, in)
+	}
+}
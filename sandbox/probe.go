@@ -0,0 +1,178 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Capability reports whether a sandboxing feature works on the host.
+type Capability struct {
+	// Available is true if the feature was found to work.
+	Available bool
+
+	// Detail describes what was found (a version, a path) or why the feature is unavailable.
+	Detail string
+}
+
+func (c Capability) String() string {
+	switch {
+	case c.Available && c.Detail != "":
+		return "yes (" + c.Detail + ")"
+	case c.Available:
+		return "yes"
+	default:
+		return "no: " + c.Detail
+	}
+}
+
+// Capabilities describes the sandboxing features of the host, as found by Probe. Features
+// of the other platform are reported unavailable.
+type Capabilities struct {
+	// BwrapPath and BwrapVersion identify the bubblewrap binary in PATH (Linux), and
+	// BwrapSetuid reports whether it is installed setuid root.
+	BwrapPath    string
+	BwrapVersion string
+	BwrapSetuid  bool
+
+	// Bubblewrap reports whether bwrap can create a sandbox with all namespaces unshared,
+	// as the default Linux backend does.
+	Bubblewrap Capability
+
+	// UserNamespaces reports whether this process can create a user namespace.
+	UserNamespaces Capability
+
+	// Overlay reports whether bwrap can mount an overlay file system inside its user
+	// namespace (Policy.Overlay; bubblewrap 0.10+ and Linux 5.11+).
+	Overlay Capability
+
+	// CgroupDelegation reports whether per-run cgroups with the memory and pids controllers
+	// can be created below the current cgroup (Limits.MemoryBytes, Limits.MaxProcesses);
+	// without it, those limits fall back to rlimits.
+	CgroupDelegation Capability
+
+	// Landlock reports whether the Landlock LSM is enabled (the landlock backend), and
+	// LandlockABI its ABI version.
+	Landlock    Capability
+	LandlockABI int
+
+	// Seccomp reports whether seccomp filters can be installed (Policy.Seccomp), and
+	// SeccompNotify whether seccomp user notification works (Policy.ReportViolations).
+	Seccomp       Capability
+	SeccompNotify Capability
+
+	// ProxySockets reports whether a NetworkProxy can create its listeners: Unix sockets in
+	// the temporary directory on Linux, loopback TCP ports on macOS.
+	ProxySockets Capability
+
+	// Seatbelt reports whether sandbox-exec works (macOS).
+	Seatbelt Capability
+}
+
+// Probe inspects the host and reports which sandboxing features work, by running small
+// test sandboxes where a static check would not be reliable. It takes up to a few hundred
+// milliseconds; ctx bounds the commands it runs. Use it at startup to refuse to run, or to
+// adjust policies, with a clear reason instead of failing on the first command:
+//
+//	caps := sandbox.Probe(ctx)
+//	if err := caps.Check(policy); err != nil {
+//	    log.Fatalf("sandboxing unavailable: %v\n%s", err, caps)
+//	}
+func Probe(ctx context.Context) *Capabilities {
+	unsupported := Capability{Detail: "not supported on " + runtime.GOOS}
+	c := &Capabilities{
+		Bubblewrap:       unsupported,
+		UserNamespaces:   unsupported,
+		Overlay:          unsupported,
+		CgroupDelegation: unsupported,
+		Landlock:         unsupported,
+		Seccomp:          unsupported,
+		SeccompNotify:    unsupported,
+		Seatbelt:         unsupported,
+	}
+	c.ProxySockets = probeProxySockets()
+	// Platform-specific implementations in probe_linux.go and probe_darwin.go
+	probe(ctx, c)
+	return c
+}
+
+// probeProxySockets checks that a NetworkProxy could create its listeners.
+func probeProxySockets() Capability {
+	httpLn, socksLn, tmpDir, err := createListeners()
+	if err != nil {
+		return Capability{Detail: err.Error()}
+	}
+	httpLn.Close()
+	socksLn.Close()
+	detail := "loopback TCP"
+	if tmpDir != "" {
+		detail = "Unix sockets in " + filepath.Dir(tmpDir)
+		os.RemoveAll(tmpDir)
+	}
+	return Capability{Available: true, Detail: detail}
+}
+
+// Check reports, joined with errors.Join, every reason why commands of the policy cannot
+// run on a host with these capabilities. Only the built-in backends are checked, and only
+// requirements that Probe can determine; Command may still fail for other reasons, such
+// as a missing mount source.
+func (c *Capabilities) Check(p *Policy) error {
+	var errs []error
+	need := func(what string, cap Capability) {
+		if !cap.Available {
+			errs = append(errs, fmt.Errorf("sandbox: %s unavailable: %s", what, cap.Detail))
+		}
+	}
+
+	backend := p.Backend
+	if backend == "" {
+		backend = defaultBackend
+	}
+	linux := runtime.GOOS == "linux"
+	switch backend {
+	case "bubblewrap":
+		need("bubblewrap", c.Bubblewrap)
+		if p.Overlay != nil {
+			need("overlay", c.Overlay)
+		}
+		if p.ReportViolations && c.BwrapSetuid {
+			errs = append(errs, fmt.Errorf("sandbox: violation reports unavailable: bwrap at %s is setuid", c.BwrapPath))
+		}
+	case "landlock":
+		need("landlock", c.Landlock)
+	case "seatbelt":
+		need("seatbelt", c.Seatbelt)
+	}
+	if linux && p.Seccomp != nil {
+		need("seccomp", c.Seccomp)
+	}
+	if linux && p.ReportViolations {
+		need("violation reports", c.SeccompNotify)
+	}
+	if p.NetworkProxy != nil {
+		need("network proxy", c.ProxySockets)
+	}
+	return errors.Join(errs...)
+}
+
+// String renders the capabilities one per line, for logs.
+func (c *Capabilities) String() string {
+	var b strings.Builder
+	line := func(name string, cap Capability) {
+		fmt.Fprintf(&b, "%-18s %v\n", name+":", cap)
+	}
+	line("bubblewrap", c.Bubblewrap)
+	line("user namespaces", c.UserNamespaces)
+	line("overlay", c.Overlay)
+	line("cgroup delegation", c.CgroupDelegation)
+	line("landlock", c.Landlock)
+	line("seccomp", c.Seccomp)
+	line("seccomp notify", c.SeccompNotify)
+	line("proxy sockets", c.ProxySockets)
+	line("seatbelt", c.Seatbelt)
+	return b.String()
+}
@@ -0,0 +1,26 @@
+//go:build darwin
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// probe implements Probe on macOS.
+func probe(ctx context.Context, c *Capabilities) {
+	cmd := exec.CommandContext(ctx, seatbeltPath, "-p", "(version 1)(allow default)", "/usr/bin/true")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		detail := strings.TrimSpace(stderr.String())
+		if detail == "" {
+			detail = err.Error()
+		}
+		c.Seatbelt = Capability{Detail: detail}
+		return
+	}
+	c.Seatbelt = Capability{Available: true, Detail: seatbeltPath}
+}
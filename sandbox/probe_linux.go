@@ -0,0 +1,173 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Arguments of seccomp(2) used by the probe.
+const (
+	seccompGetActionAvail = 2
+	prGetSeccomp          = 21
+)
+
+// probe implements Probe on Linux.
+func probe(ctx context.Context, c *Capabilities) {
+	truePath, err := exec.LookPath("true")
+	if err != nil {
+		truePath = "/bin/true"
+	}
+
+	c.UserNamespaces = probeUserNamespaces(ctx, truePath)
+	probeBwrap(ctx, c, truePath)
+	c.CgroupDelegation = probeCgroupDelegation()
+
+	if abi, err := landlockABI(); err != nil {
+		c.Landlock = Capability{Detail: err.Error()}
+	} else {
+		c.LandlockABI = abi
+		c.Landlock = Capability{Available: true, Detail: fmt.Sprintf("ABI %d", abi)}
+	}
+
+	c.Seccomp, c.SeccompNotify = probeSeccomp()
+}
+
+// probeBwrap locates bubblewrap and checks that it can create the sandboxes Command and
+// overlays need.
+func probeBwrap(ctx context.Context, c *Capabilities, truePath string) {
+	path, err := exec.LookPath("bwrap")
+	if err != nil {
+		c.Bubblewrap = Capability{Detail: "bwrap not found in PATH"}
+		c.Overlay = c.Bubblewrap
+		return
+	}
+	c.BwrapPath = path
+	if info, err := os.Stat(path); err == nil {
+		c.BwrapSetuid = info.Mode()&os.ModeSetuid != 0
+	}
+	if out, err := exec.CommandContext(ctx, path, "--version").Output(); err == nil {
+		c.BwrapVersion = strings.TrimSpace(strings.TrimPrefix(string(out), "bubblewrap"))
+	}
+
+	run := func(args ...string) Capability {
+		args = append([]string{"--unshare-all", "--die-with-parent", "--ro-bind", "/", "/"}, args...)
+		cmd := exec.CommandContext(ctx, path, append(args, truePath)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return Capability{Detail: msg}
+			}
+			return Capability{Detail: err.Error()}
+		}
+		return Capability{Available: true}
+	}
+
+	c.Bubblewrap = run()
+	if c.Bubblewrap.Available {
+		c.Bubblewrap.Detail = strings.TrimSpace("bubblewrap "+c.BwrapVersion) + " at " + path
+		if c.BwrapSetuid {
+			c.Bubblewrap.Detail += ", setuid"
+		}
+	} else {
+		c.Overlay = Capability{Detail: "requires bubblewrap"}
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "boxedpy-probe-*")
+	if err != nil {
+		c.Overlay = Capability{Detail: err.Error()}
+		return
+	}
+	defer os.RemoveAll(dir)
+	src, upper, work := filepath.Join(dir, "src"), filepath.Join(dir, "upper"), filepath.Join(dir, "work")
+	for _, d := range []string{src, upper, work} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			c.Overlay = Capability{Detail: err.Error()}
+			return
+		}
+	}
+	c.Overlay = run("--overlay-src", src, "--overlay", upper, work, src)
+}
+
+// probeUserNamespaces starts a process in a new user namespace, as bubblewrap does when it
+// is not installed setuid.
+func probeUserNamespaces(ctx context.Context, truePath string) Capability {
+	cmd := exec.CommandContext(ctx, truePath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	if err := cmd.Run(); err != nil {
+		detail := err.Error()
+		if data, err := os.ReadFile("/proc/sys/kernel/apparmor_restrict_unprivileged_userns"); err == nil && strings.TrimSpace(string(data)) == "1" {
+			detail += " (restricted by AppArmor: kernel.apparmor_restrict_unprivileged_userns=1)"
+		} else if data, err := os.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil && strings.TrimSpace(string(data)) == "0" {
+			detail += " (disabled: user.max_user_namespaces=0)"
+		}
+		return Capability{Detail: detail}
+	}
+	if os.Getuid() == 0 {
+		return Capability{Available: true, Detail: "running as root; unprivileged use not verified"}
+	}
+	return Capability{Available: true}
+}
+
+// probeCgroupDelegation checks the current cgroup as setupCgroup would.
+func probeCgroupDelegation() Capability {
+	dir, ok := currentCgroup()
+	if !ok {
+		return Capability{Detail: "cgroup v2 not mounted at " + cgroupRoot}
+	}
+	if err := cgroupDelegated(dir, []string{"memory", "pids"}); err != nil {
+		return Capability{Detail: fmt.Sprintf("%s: %v", dir, err)}
+	}
+	return Capability{Available: true, Detail: dir}
+}
+
+// probeSeccomp checks that seccomp filters and user notification are available.
+func probeSeccomp() (filter, notify Capability) {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prGetSeccomp, 0, 0); errno != 0 {
+		unavailable := Capability{Detail: fmt.Sprintf("not enabled in this kernel: %v", errno)}
+		return unavailable, unavailable
+	}
+	filter = Capability{Available: true}
+
+	action := uint32(seccompRetUserNotif)
+	_, _, errno := syscall.RawSyscall(uintptr(syscallNumbers["seccomp"]), seccompGetActionAvail, 0, uintptr(unsafe.Pointer(&action)))
+	if errno != 0 {
+		return filter, Capability{Detail: fmt.Sprintf("user notification unsupported: %v", errno)}
+	}
+	// Violation reports also rely on SECCOMP_USER_NOTIF_FLAG_CONTINUE and openat2 (5.8)
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err == nil {
+		var major, minor int
+		release := utsString(uts.Release[:])
+		if _, err := fmt.Sscanf(release, "%d.%d", &major, &minor); err == nil && (major < 5 || major == 5 && minor < 8) {
+			return filter, Capability{Detail: "Linux 5.8 or newer is required, found " + release}
+		}
+	}
+	return filter, Capability{Available: true}
+}
+
+// utsString converts a NUL-terminated field of syscall.Utsname.
+func utsString[T int8 | uint8](field []T) string {
+	var b strings.Builder
+	for _, c := range field {
+		if c == 0 {
+			break
+		}
+		b.WriteByte(byte(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,108 @@
+package sandbox
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	c := Probe(context.Background())
+	require.NotNil(t, c)
+	assert.True(t, c.ProxySockets.Available, "%s", c.ProxySockets.Detail)
+	assert.Contains(t, c.String(), "proxy sockets:")
+
+	switch runtime.GOOS {
+	case "linux":
+		assert.False(t, c.Seatbelt.Available)
+		if c.BwrapPath == "" {
+			assert.False(t, c.Bubblewrap.Available)
+			assert.False(t, c.Overlay.Available)
+		}
+		assert.Equal(t, c.Landlock.Available, c.LandlockABI > 0)
+	case "darwin":
+		assert.False(t, c.Bubblewrap.Available)
+		assert.False(t, c.Landlock.Available)
+	}
+
+	// Check agrees with Probe about the default policy's backend.
+	err := c.Check(DefaultPolicy())
+	switch runtime.GOOS {
+	case "linux":
+		assert.Equal(t, c.Bubblewrap.Available, err == nil, "%v", err)
+	case "darwin":
+		assert.Equal(t, c.Seatbelt.Available, err == nil, "%v", err)
+	}
+}
+
+func TestCapabilitiesCheck(t *testing.T) {
+	t.Parallel()
+
+	yes := Capability{Available: true}
+	no := Capability{Detail: "missing"}
+	all := Capabilities{
+		Bubblewrap:    yes,
+		Overlay:       yes,
+		Landlock:      yes,
+		Seccomp:       yes,
+		SeccompNotify: yes,
+		ProxySockets:  yes,
+		Seatbelt:      yes,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Capabilities)
+		policy Policy
+		want   []string
+	}{
+		{"all available", func(*Capabilities) {}, Policy{Overlay: &Overlay{}, NetworkProxy: &NetworkProxy{}}, nil},
+		{"no proxy sockets", func(c *Capabilities) { c.ProxySockets = no }, Policy{NetworkProxy: &NetworkProxy{}}, []string{"network proxy unavailable: missing"}},
+		{"proxy unused", func(c *Capabilities) { c.ProxySockets = no }, Policy{}, nil},
+		{"unknown backend", func(c *Capabilities) { *c = Capabilities{} }, Policy{Backend: "test-direct"}, nil},
+	}
+	if runtime.GOOS == "linux" {
+		tests = append(tests, []struct {
+			name   string
+			modify func(c *Capabilities)
+			policy Policy
+			want   []string
+		}{
+			{"no bubblewrap", func(c *Capabilities) { c.Bubblewrap = no }, Policy{}, []string{"bubblewrap unavailable: missing"}},
+			{"no overlay", func(c *Capabilities) { c.Overlay = no }, Policy{Overlay: &Overlay{}}, []string{"overlay unavailable: missing"}},
+			{"overlay on landlock", func(c *Capabilities) { c.Overlay = no }, Policy{Backend: "landlock"}, nil},
+			{"no landlock", func(c *Capabilities) { c.Landlock = no }, Policy{Backend: "landlock"}, []string{"landlock unavailable: missing"}},
+			{
+				"setuid bwrap",
+				func(c *Capabilities) { c.BwrapSetuid, c.BwrapPath = true, "/usr/bin/bwrap" },
+				Policy{ReportViolations: true},
+				[]string{"bwrap at /usr/bin/bwrap is setuid"},
+			},
+			{
+				"no seccomp",
+				func(c *Capabilities) { c.Seccomp, c.SeccompNotify = no, no },
+				Policy{Seccomp: DefaultSeccompProfile(), ReportViolations: true},
+				[]string{"seccomp unavailable: missing", "violation reports unavailable: missing"},
+			},
+		}...)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := all
+			tt.modify(&c)
+			err := c.Check(&tt.policy)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			for _, want := range tt.want {
+				assert.ErrorContains(t, err, want)
+			}
+		})
+	}
+}
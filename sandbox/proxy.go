@@ -3,30 +3,54 @@ package sandbox
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // NetworkFilter specifies allowed and denied network destinations for proxy filtering.
-// Patterns support wildcards (e.g., "*.github.com" matches "api.github.com" but not "github.com").
+// Patterns support wildcards (e.g., "*.github.com" matches "api.github.com" but not "github.com"),
+// CIDR blocks, and port lists and ranges; see ParseRule for the syntax.
 // Deny rules take precedence over allow rules.
-// If AllowHosts is empty, all destinations are allowed (unless explicitly denied).
-// If AllowHosts is non-empty, only matching destinations are allowed.
+// If there are no allow rules, all destinations are allowed (unless explicitly denied).
+// Otherwise, only matching destinations are allowed.
+// Rules by address apply to IP literals and to the addresses host names resolve to.
+//
+// Independently of the host patterns, the proxy resolves every destination itself and only
+// connects to public addresses, unless AllowPrivateAddresses is set. A nil filter allows
+// every public destination.
+//
+// NewNetworkProxy rejects filters with malformed patterns or rules.
 type NetworkFilter struct {
 	// AllowHosts contains patterns for allowed destinations.
-	// Examples: "github.com", "*.npmjs.org", "example.com:443"
+	// Examples: "github.com", "*.npmjs.org", "example.com:443", "10.0.0.0/8", "[::1]:8000-8100"
 	AllowHosts []string
 
 	// DenyHosts contains patterns for denied destinations.
 	// Deny takes precedence over allow.
 	DenyHosts []string
+
+	// AllowRules and DenyRules are structured alternatives to AllowHosts and DenyHosts;
+	// both forms can be combined.
+	AllowRules []Rule
+	DenyRules  []Rule
+
+	// AllowPrivateAddresses lets connections reach loopback, private, link-local, shared
+	// (carrier-grade NAT) and other special-purpose addresses, including cloud metadata
+	// endpoints such as 169.254.169.254. By default these addresses are refused even for
+	// allowed hosts, so that a name resolving to an internal address cannot reach it.
+	AllowPrivateAddresses bool
 }
 
 // NetworkProxy manages HTTP and SOCKS5 proxy servers with optional domain filtering.
@@ -49,8 +73,11 @@ type NetworkFilter struct {
 //
 //	// Use proxy.Env() to configure sandboxed processes
 //	policy.NetworkProxy = proxy
+//
+// The filter can be changed while the proxy runs, with SetFilter, UpdateFilter or
+// WatchFilterFile. Sandboxes running at the same time can share one proxy, each with its
+// own endpoint, filter and quota, through NewClient.
 type NetworkProxy struct {
-	filter      *NetworkFilter
 	httpAddr    string
 	socksAddr   string
 	httpLn      net.Listener
@@ -60,37 +87,93 @@ type NetworkProxy struct {
 	closed      chan struct{}
 	wg          sync.WaitGroup
 
+	// ctx is cancelled by Close, to interrupt blocked requests.
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	mu         sync.Mutex
 	httpServer *http.Server
+	decider    ProxyDecider
+	watchers   []chan<- ProxyEvent
+
+	// filter is the current filter and rules its compiled rules; gen counts filter
+	// changes. active holds the established connections, for CloseDisallowed.
+	filter *NetworkFilter
+	rules  *ruleSet
+	gen    uint64
+	active map[*proxyCall]struct{}
+
+	// filterMu serializes filter changes.
+	filterMu sync.Mutex
+
+	// parent is the proxy a client belongs to, nil for a proxy created by NewNetworkProxy,
+	// and clients the clients of a proxy.
+	parent  *NetworkProxy
+	clients map[*NetworkProxy]struct{}
+
+	// label and quota are set by NewClient. open and requests count the requests in
+	// progress and all requests admitted under the quota, bytes the payload relayed.
+	label    string
+	quota    ProxyQuota
+	open     int
+	requests int
+	bytes    atomic.Int64
+
+	// transport forwards plain HTTP requests, dialing through dial.
+	transport *http.Transport
 }
 
 // NewNetworkProxy creates and starts HTTP and SOCKS5 proxy servers with the given filter.
 // The proxies begin accepting connections immediately.
 // The returned proxy must be closed via Close() to prevent resource leaks.
 func NewNetworkProxy(filter *NetworkFilter) (*NetworkProxy, error) {
+	rules, err := filter.rules()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: network filter: %w", err)
+	}
+
 	httpLn, socksLn, tmpDir, err := createListeners()
 	if err != nil {
 		return nil, fmt.Errorf("create listeners: %w", err)
 	}
 
 	p := &NetworkProxy{
-		filter:      filter,
+		filter:      filter.clone(),
+		rules:       rules,
 		httpLn:      httpLn,
 		socksLn:     socksLn,
 		socksTmpDir: tmpDir,
 		closed:      make(chan struct{}),
 	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, ok := ctx.Value(callKey{}).(*proxyCall)
+			if !ok {
+				return nil, fmt.Errorf("dial %s: no proxy request", addr)
+			}
+			conn, _, err := p.dial(ctx, c)
+			return conn, err
+		},
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	p.start()
+	return p, nil
+}
 
+// start begins serving the proxy's listeners.
+func (p *NetworkProxy) start() {
 	// Get listener addresses
-	p.httpAddr = formatHTTPAddress(httpLn.Addr())
-	p.socksAddr = formatSOCKSAddress(socksLn.Addr())
+	p.httpAddr = formatHTTPAddress(p.httpLn.Addr())
+	p.socksAddr = formatSOCKSAddress(p.socksLn.Addr())
 
 	// Start HTTP proxy server
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
-		ctx := context.Background()
-		if err := p.serveHTTP(ctx); err != nil {
+		if err := p.serveHTTP(p.ctx); err != nil {
 			// Shutdown errors are expected, ignore them
 		}
 	}()
@@ -99,13 +182,10 @@ func NewNetworkProxy(filter *NetworkFilter) (*NetworkProxy, error) {
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
-		ctx := context.Background()
-		if err := p.serveSOCKS(ctx); err != nil {
+		if err := p.serveSOCKS(p.ctx); err != nil {
 			// Shutdown errors are expected, ignore them
 		}
 	}()
-
-	return p, nil
 }
 
 // HTTPAddr returns the HTTP proxy address in a format suitable for HTTP_PROXY environment variables.
@@ -125,6 +205,8 @@ func (p *NetworkProxy) SOCKSAddr() string {
 // Env returns environment variables configuring HTTP and SOCKS5 proxies.
 // Includes both uppercase and lowercase variants for maximum compatibility.
 // The caller should append these to cmd.Env when executing sandboxed commands.
+// Policy.Command sets them itself; on Linux, the bubblewrap backend sets loopback
+// addresses served inside the sandbox instead (see Policy.NetworkProxy).
 func (p *NetworkProxy) Env() []string {
 	httpAddr := p.HTTPAddr()
 	socksAddr := p.SOCKSAddr()
@@ -156,6 +238,7 @@ func (p *NetworkProxy) Env() []string {
 
 // Close gracefully shuts down the proxy servers and cleans up resources.
 // It waits for all active connections to complete before returning.
+// Closing a proxy closes its clients too.
 // Close is safe to call multiple times (idempotent).
 func (p *NetworkProxy) Close() error {
 	var closeErr error
@@ -163,6 +246,22 @@ func (p *NetworkProxy) Close() error {
 	p.closeOnce.Do(func() {
 		// Signal shutdown to all goroutines
 		close(p.closed)
+		p.cancel()
+
+		p.mu.Lock()
+		clients := make([]*NetworkProxy, 0, len(p.clients))
+		for client := range p.clients {
+			clients = append(clients, client)
+		}
+		p.mu.Unlock()
+		for _, client := range clients {
+			client.Close()
+		}
+		if p.parent != nil {
+			p.parent.mu.Lock()
+			delete(p.parent.clients, p)
+			p.parent.mu.Unlock()
+		}
 
 		// Stop accepting new connections
 		if p.httpLn != nil {
@@ -185,6 +284,9 @@ func (p *NetworkProxy) Close() error {
 
 		// Wait for all connection handlers to finish
 		p.wg.Wait()
+		if p.parent == nil {
+			p.transport.CloseIdleConnections()
+		}
 
 		// Clean up Unix sockets on Linux
 		if p.socksTmpDir != "" {
@@ -200,7 +302,10 @@ func (p *NetworkProxy) Close() error {
 // serveHTTP runs the HTTP proxy server. It blocks until the listener is closed.
 func (p *NetworkProxy) serveHTTP(ctx context.Context) error {
 	handler := http.HandlerFunc(p.handleHTTPRequest)
-	server := &http.Server{Handler: handler}
+	server := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
 
 	p.mu.Lock()
 	p.httpServer = server
@@ -230,7 +335,7 @@ func (p *NetworkProxy) serveSOCKS(ctx context.Context) error {
 		p.wg.Add(1)
 		go func() {
 			defer p.wg.Done()
-			p.handleSOCKS(conn)
+			p.handleSOCKS(ctx, conn)
 		}()
 	}
 }
@@ -266,8 +371,18 @@ func (p *NetworkProxy) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	c, err := p.newCall("http", hostname, port)
+	if err != nil {
+		http.Error(w, "Bad Request: invalid port", http.StatusBadRequest)
+		return
+	}
+	c.ev.Method = r.Method
+	c.ev.URL = r.URL.String()
+	defer p.finish(c)
+
 	// Check filter
-	if !p.isAllowed(hostname, port) {
+	if !p.authorize(r.Context(), c) {
+		c.ev.Status = http.StatusForbidden
 		http.Error(w, "Forbidden: destination not allowed", http.StatusForbidden)
 		return
 	}
@@ -279,8 +394,33 @@ func (p *NetworkProxy) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Create a new request to the target
-	proxyReq, err := http.NewRequest(r.Method, targetURL.String(), r.Body)
+	var body io.ReadCloser
+	var sent *countingReader
+	if r.Body != nil && r.Body != http.NoBody {
+		sent = &countingReader{ReadCloser: r.Body, p: p}
+		body = sent
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	ctx = context.WithValue(ctx, callKey{}, c)
+	var trackErr error
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.ev.Addr = info.Conn.RemoteAddr().String()
+			if trackErr = p.track(c, remoteAddr(info.Conn), cancel); trackErr != nil {
+				cancel()
+			}
+		},
+	})
+	defer func() {
+		if err := p.untrack(c); err != nil {
+			c.ev.Err = err
+		}
+	}()
+	proxyReq, err := http.NewRequestWithContext(ctx, r.Method, targetURL.String(), body)
 	if err != nil {
+		c.ev.Err = err
+		c.ev.Status = http.StatusBadRequest
 		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -292,10 +432,22 @@ func (p *NetworkProxy) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(proxyReq)
-	if err != nil {
+	// Make the request. Redirects are returned to the client rather than followed, so
+	// that their destinations go through the filter.
+	resp, err := p.transport.RoundTrip(proxyReq)
+	if sent != nil {
+		c.ev.BytesSent = sent.n.Load()
+	}
+	if trackErr != nil {
+		err = trackErr
+	}
+	if c.blocked(err) {
+		c.ev.Status = http.StatusForbidden
+		http.Error(w, "Forbidden: destination not allowed", http.StatusForbidden)
+		return
+	} else if err != nil {
+		c.ev.Err = err
+		c.ev.Status = http.StatusBadGateway
 		http.Error(w, "Bad Gateway: "+err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -309,10 +461,14 @@ func (p *NetworkProxy) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Write status code
+	c.ev.Status = resp.StatusCode
 	w.WriteHeader(resp.StatusCode)
 
 	// Copy response body
-	io.Copy(w, resp.Body)
+	c.ev.BytesReceived, err = io.Copy(w, &countingReader{ReadCloser: resp.Body, p: p})
+	if err != nil {
+		c.ev.Err = err
+	}
 }
 
 // handleConnect handles HTTP CONNECT requests for HTTPS tunneling.
@@ -336,101 +492,189 @@ func (p *NetworkProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	c, err := p.newCall("connect", host, port)
+	if err != nil {
+		http.Error(w, "Bad Request: invalid port", http.StatusBadRequest)
+		return
+	}
+	defer p.finish(c)
+
 	// Check filter
-	if !p.isAllowed(host, port) {
+	if !p.authorize(r.Context(), c) {
+		c.ev.Status = http.StatusForbidden
 		http.Error(w, "Forbidden: destination not allowed", http.StatusForbidden)
 		return
 	}
 
 	// Dial target
-	targetConn, err := net.Dial("tcp", targetAddr)
-	if err != nil {
+	targetConn, d, err := p.dial(r.Context(), c)
+	if c.blocked(err) {
+		c.ev.Status = http.StatusForbidden
+		http.Error(w, "Forbidden: destination not allowed", http.StatusForbidden)
+		return
+	} else if err != nil {
+		c.ev.Err = err
+		c.ev.Status = http.StatusBadGateway
 		http.Error(w, "Bad Gateway: "+err.Error(), http.StatusBadGateway)
 		return
 	}
 	defer targetConn.Close()
+	c.connected(targetConn, d)
 
 	// Hijack the connection to get raw TCP access
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
+		c.ev.Status = http.StatusInternalServerError
 		http.Error(w, "Internal Server Error: hijacking not supported", http.StatusInternalServerError)
 		return
 	}
 
 	clientConn, _, err := hijacker.Hijack()
 	if err != nil {
+		c.ev.Err = err
+		c.ev.Status = http.StatusInternalServerError
 		http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer clientConn.Close()
 
+	err = p.track(c, remoteAddr(targetConn), func() {
+		targetConn.Close()
+		clientConn.Close()
+	})
+	if c.blocked(err) {
+		// The filter changed while connecting
+		c.ev.Status = http.StatusForbidden
+		clientConn.Write([]byte("HTTP/1.1 403 Forbidden\r\nConnection: close\r\nContent-Length: 0\r\n\r\n"))
+		return
+	}
+	defer func() {
+		if err := p.untrack(c); err != nil {
+			c.ev.Err = err
+		}
+	}()
+
 	// Send success response to client
+	c.ev.Status = http.StatusOK
 	_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 	if err != nil {
+		c.ev.Err = err
 		return
 	}
 
 	// Start bidirectional copy
-	bidirectionalCopy(targetConn, clientConn)
+	c.ev.BytesSent, c.ev.BytesReceived = p.bidirectionalCopy(targetConn, clientConn)
 }
 
-// isAllowed checks if a connection to the given host and port is allowed by the filter.
-func (p *NetworkProxy) isAllowed(host, port string) bool {
-	if p.filter == nil {
-		return true
+// errDestinationBlocked is returned by dial when none of a destination's addresses may be
+// connected to.
+var errDestinationBlocked = errors.New("destination address not allowed")
+
+// blockedError is the error dial returns when it refuses every address of a destination,
+// with the decision that refused the first one.
+type blockedError struct {
+	host string
+	d    decision
+}
+
+func (e *blockedError) Error() string { return e.host + ": " + errDestinationBlocked.Error() }
+func (e *blockedError) Unwrap() error { return errDestinationBlocked }
+
+// dial connects to the destination of c on behalf of a client. It resolves the host itself
+// and only connects to the addresses that pass the checks, so the connection goes to an
+// address that was checked: a name cannot be rebound to another address after the check,
+// as it could if the dialer resolved it again. It returns the decision for the address it
+// connected to. dial does not modify c, since the HTTP transport may still be dialing
+// after the request is complete.
+func (p *NetworkProxy) dial(ctx context.Context, c *proxyCall) (net.Conn, decision, error) {
+	host, port := c.ev.Host, c.ev.Port
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return nil, decision{}, err
 	}
 
-	// Check deny list first (deny takes precedence)
-	for _, pattern := range p.filter.DenyHosts {
-		if matchesPattern(pattern, host, port) {
-			return false
+	var refused *decision
+	var dialer net.Dialer
+	var dialErr error
+	for _, addr := range addrs {
+		d := c.checkAddr(c.rules, addr)
+		if !d.allowed {
+			if refused == nil {
+				refused = &d
+			}
+			continue
+		}
+
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.String(), strconv.Itoa(int(port))))
+		if err == nil {
+			return conn, d, nil
+		}
+		if dialErr == nil {
+			dialErr = err
 		}
 	}
+	if dialErr != nil {
+		return nil, decision{}, dialErr
+	}
+	if refused == nil {
+		refused = &decision{false, "", ReasonNoRuleMatched}
+	}
+	return nil, decision{}, &blockedError{host: host, d: *refused}
+}
 
-	// If allow list is empty, allow everything (unless already denied above)
-	if len(p.filter.AllowHosts) == 0 {
-		return true
+// connected records in c's event that the proxy connected to conn after decision d.
+func (c *proxyCall) connected(conn net.Conn, d decision) {
+	c.ev.Addr = conn.RemoteAddr().String()
+	if !c.decided {
+		c.ev.Rule, c.ev.Reason = d.rule, d.reason
 	}
+}
 
-	// Check allow list - must match at least one pattern
-	for _, pattern := range p.filter.AllowHosts {
-		if matchesPattern(pattern, host, port) {
-			return true
-		}
+// blocked records in c's event that dial refused the destination, if err says so.
+func (c *proxyCall) blocked(err error) bool {
+	var be *blockedError
+	if !errors.As(err, &be) {
+		return false
 	}
+	c.ev.Allowed, c.ev.Rule, c.ev.Reason = false, be.d.rule, be.d.reason
+	return true
+}
 
-	// Allow list exists but no match found
-	return false
+// specialPrefixes are ranges that net/netip does not classify as loopback, private,
+// link-local or multicast, but that do not lead to the public internet either.
+var specialPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // shared address space (carrier-grade NAT)
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("100::/64"),       // discard-only
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use IPv4/IPv6 translation
+	netip.MustParsePrefix("2002::/16"),      // 6to4, which embeds arbitrary IPv4 addresses
 }
 
-// matchesPattern checks if a host:port matches a given pattern.
-// Patterns support wildcards and optional port specifications:
-//   - "example.com" matches "example.com" with any port
-//   - "example.com:443" matches "example.com" only with port 443
-//   - "*.example.com" matches "api.example.com" but NOT "example.com"
-//   - "*.example.com:443" matches subdomains of example.com on port 443
-func matchesPattern(pattern, host, port string) bool {
-	// Parse pattern into host and port parts
-	var patternHost, patternPort string
-
-	// Check if pattern contains a port
-	if idx := lastIndexByte(pattern, ':'); idx >= 0 {
-		// Pattern has a port
-		patternHost = pattern[:idx]
-		patternPort = pattern[idx+1:]
-	} else {
-		// Pattern has no port - match any port
-		patternHost = pattern
-		patternPort = ""
-	}
+// nat64Prefix is the well-known NAT64 prefix: its addresses reach the embedded IPv4 address.
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
 
-	// If pattern specifies a port, it must match exactly
-	if patternPort != "" && patternPort != port {
+// isPublicAddr reports whether addr is a globally reachable unicast address. IPv4-mapped
+// and NAT64 addresses are judged by the IPv4 address they lead to.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if nat64Prefix.Contains(addr) {
+		b := addr.As16()
+		return isPublicAddr(netip.AddrFrom4([4]byte(b[12:])))
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
 		return false
 	}
-
-	// Check host matching (with wildcard support)
-	return matchesHost(patternHost, host)
+	for _, prefix := range specialPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
 }
 
 // matchesHost checks if a host matches a pattern with wildcard support.
@@ -457,24 +701,13 @@ func matchesHost(pattern, host string) bool {
 	return false
 }
 
-// lastIndexByte finds the last occurrence of byte c in string s.
-// Returns -1 if not found.
-func lastIndexByte(s string, c byte) int {
-	for i := len(s) - 1; i >= 0; i-- {
-		if s[i] == c {
-			return i
-		}
-	}
-	return -1
-}
-
 // hasSuffix checks if string s ends with suffix.
 func hasSuffix(s, suffix string) bool {
 	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
 }
 
 // handleSOCKS processes a SOCKS5 connection.
-func (p *NetworkProxy) handleSOCKS(clientConn net.Conn) error {
+func (p *NetworkProxy) handleSOCKS(ctx context.Context, clientConn net.Conn) error {
 	defer clientConn.Close()
 
 	// SOCKS5 handshake
@@ -489,28 +722,62 @@ func (p *NetworkProxy) handleSOCKS(clientConn net.Conn) error {
 		return fmt.Errorf("socks5 read request: %w", err)
 	}
 
+	c, err := p.newCall("socks5", host, port)
+	if err != nil {
+		socks5SendReply(clientConn, 0x01) // General failure
+		return fmt.Errorf("socks5 read request: %w", err)
+	}
+	defer p.finish(c)
+
 	// Check filter
-	if !p.isAllowed(host, port) {
+	if !p.authorize(ctx, c) {
 		socks5SendReply(clientConn, 0x02) // Connection not allowed
 		return fmt.Errorf("socks5: destination %s:%s not allowed", host, port)
 	}
 
 	// Dial target
 	targetAddr := net.JoinHostPort(host, port)
-	targetConn, err := net.Dial("tcp", targetAddr)
+	targetConn, d, err := p.dial(ctx, c)
 	if err != nil {
-		socks5SendReply(clientConn, 0x05) // Connection refused
+		var dnsErr *net.DNSError
+		switch {
+		case c.blocked(err):
+			socks5SendReply(clientConn, 0x02) // Connection not allowed
+			return fmt.Errorf("socks5: destination %s not allowed", targetAddr)
+		case errors.As(err, &dnsErr):
+			socks5SendReply(clientConn, 0x04) // Host unreachable
+		default:
+			socks5SendReply(clientConn, 0x05) // Connection refused
+		}
+		c.ev.Err = err
 		return fmt.Errorf("socks5 dial %s: %w", targetAddr, err)
 	}
 	defer targetConn.Close()
+	c.connected(targetConn, d)
+
+	err = p.track(c, remoteAddr(targetConn), func() {
+		targetConn.Close()
+		clientConn.Close()
+	})
+	if c.blocked(err) {
+		// The filter changed while connecting
+		socks5SendReply(clientConn, 0x02) // Connection not allowed
+		return fmt.Errorf("socks5: destination %s not allowed", targetAddr)
+	}
+	defer func() {
+		if err := p.untrack(c); err != nil {
+			c.ev.Err = err
+		}
+	}()
 
 	// Send success reply
 	if err := socks5SendReply(clientConn, 0x00); err != nil {
+		c.ev.Err = err
 		return fmt.Errorf("socks5 send reply: %w", err)
 	}
 
 	// Start bidirectional copy
-	bidirectionalCopy(targetConn, clientConn)
+	c.ev.BytesSent, c.ev.BytesReceived = p.bidirectionalCopy(targetConn, clientConn)
 	return nil
 }
 
@@ -646,7 +913,8 @@ func socks5SendReply(conn net.Conn, rep byte) error {
 
 // createListeners creates HTTP and SOCKS5 listeners appropriate for the platform.
 // Returns (httpListener, socksListener, tmpDir, error).
-// On Linux, tmpDir contains the Unix socket files and must be cleaned up.
+// On Linux, tmpDir contains the Unix socket files and must be cleaned up; it is created in
+// dir, which clients set to the directory of their proxy.
 // On macOS, tmpDir is empty.
 func createListeners() (httpLn, socksLn net.Listener, tmpDir string, err error) {
 	if runtime.GOOS == "linux" {
@@ -729,26 +997,29 @@ func formatSOCKSAddress(addr net.Addr) string {
 	}
 }
 
-// bidirectionalCopy copies data bidirectionally between two connections.
+// bidirectionalCopy copies data bidirectionally between two connections, charging it to
+// the proxy's byte quota.
 // It closes both connections when either direction finishes or encounters an error.
-func bidirectionalCopy(dst, src net.Conn) {
+// It returns the number of bytes copied from src to dst and from dst to src.
+func (p *NetworkProxy) bidirectionalCopy(dst, src net.Conn) (toDst, toSrc int64) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	copy := func(dst, src net.Conn) {
+	copy := func(dst, src net.Conn, n *int64) {
 		defer wg.Done()
-		io.Copy(dst, src)
-		// Close write side to signal EOF to peer
-		if tcpConn, ok := dst.(*net.TCPConn); ok {
-			tcpConn.CloseWrite()
+		*n, _ = io.Copy(dst, &countingReader{ReadCloser: src, p: p})
+		// Close write side to signal EOF to peer (TCP, or Unix sockets on Linux)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
 		}
 	}
 
-	go copy(dst, src)
-	go copy(src, dst)
+	go copy(dst, src, &toDst)
+	go copy(src, dst, &toSrc)
 
 	wg.Wait()
 
 	dst.Close()
 	src.Close()
+	return toDst, toSrc
 }
@@ -1,11 +1,13 @@
 package sandbox
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"runtime"
 	"strconv"
@@ -96,8 +98,8 @@ func TestNetworkProxy_HTTPConnect(t *testing.T) {
 	testServer.Start(t)
 	defer testServer.Stop()
 
-	// Create proxy with no filter (allow all)
-	proxy, err := NewNetworkProxy(nil)
+	// Create proxy allowing all hosts, including the local test server
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowPrivateAddresses: true})
 	require.NoError(t, err)
 	defer proxy.Close()
 
@@ -148,8 +150,8 @@ func TestNetworkProxy_SOCKS5(t *testing.T) {
 	targetHost := targetURL.Hostname()
 	targetPort := targetURL.Port()
 
-	// Create proxy with no filter (allow all)
-	proxy, err := NewNetworkProxy(nil)
+	// Create proxy allowing all hosts, including the local test server
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowPrivateAddresses: true})
 	require.NoError(t, err)
 	defer proxy.Close()
 
@@ -236,35 +238,47 @@ func TestNetworkFilter_Wildcards(t *testing.T) {
 		name    string
 		pattern string
 		host    string
-		port    string
+		port    uint16
 		want    bool
 	}{
 		// Exact matches
-		{"exact match", "example.com", "example.com", "80", true},
-		{"exact match different port", "example.com", "example.com", "443", true},
-		{"exact no match", "example.com", "other.com", "80", false},
+		{"exact match", "example.com", "example.com", 80, true},
+		{"exact match different port", "example.com", "example.com", 443, true},
+		{"exact no match", "example.com", "other.com", 80, false},
 
 		// Wildcard matches
-		{"wildcard matches subdomain", "*.example.com", "api.example.com", "80", true},
-		{"wildcard matches nested subdomain", "*.example.com", "foo.bar.example.com", "80", true},
-		{"wildcard does not match base", "*.example.com", "example.com", "80", false},
-		{"wildcard does not match different domain", "*.example.com", "example.org", "80", false},
+		{"wildcard matches subdomain", "*.example.com", "api.example.com", 80, true},
+		{"wildcard matches nested subdomain", "*.example.com", "foo.bar.example.com", 80, true},
+		{"wildcard does not match base", "*.example.com", "example.com", 80, false},
+		{"wildcard does not match different domain", "*.example.com", "example.org", 80, false},
 
 		// Port-specific patterns
-		{"port match", "example.com:443", "example.com", "443", true},
-		{"port no match", "example.com:443", "example.com", "80", false},
-		{"wildcard with port match", "*.example.com:443", "api.example.com", "443", true},
-		{"wildcard with port no match", "*.example.com:443", "api.example.com", "80", false},
+		{"port match", "example.com:443", "example.com", 443, true},
+		{"port no match", "example.com:443", "example.com", 80, false},
+		{"wildcard with port match", "*.example.com:443", "api.example.com", 443, true},
+		{"wildcard with port no match", "*.example.com:443", "api.example.com", 80, false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := matchesPattern(tt.pattern, tt.host, tt.port)
-			assert.Equal(t, tt.want, got)
+			rule, err := ParseRule(tt.pattern)
+			require.NoError(t, err)
+			addr, _ := netip.ParseAddr(tt.host)
+			assert.Equal(t, tt.want, rule.matches(tt.host, addr, tt.port, false))
 		})
 	}
 }
 
+// allowedBy reports whether the rules of filter allow a connection to host:port, as the
+// proxy decides before host is resolved.
+func allowedBy(t *testing.T, filter *NetworkFilter, host string, port uint16) bool {
+	t.Helper()
+	rs, err := filter.rules()
+	require.NoError(t, err)
+	addr, _ := netip.ParseAddr(host)
+	return rs.decide(host, addr, port).allowed
+}
+
 func TestNetworkFilter_AllowList(t *testing.T) {
 	t.Parallel()
 
@@ -272,19 +286,17 @@ func TestNetworkFilter_AllowList(t *testing.T) {
 		AllowHosts: []string{"github.com", "*.npmjs.org"},
 	}
 
-	proxy := &NetworkProxy{filter: filter}
-
 	// Should allow github.com
-	assert.True(t, proxy.isAllowed("github.com", "443"))
+	assert.True(t, allowedBy(t, filter, "github.com", 443))
 
 	// Should allow npmjs.org subdomains
-	assert.True(t, proxy.isAllowed("registry.npmjs.org", "443"))
+	assert.True(t, allowedBy(t, filter, "registry.npmjs.org", 443))
 
 	// Should NOT allow npmjs.org itself
-	assert.False(t, proxy.isAllowed("npmjs.org", "443"))
+	assert.False(t, allowedBy(t, filter, "npmjs.org", 443))
 
 	// Should NOT allow other domains
-	assert.False(t, proxy.isAllowed("evil.com", "80"))
+	assert.False(t, allowedBy(t, filter, "evil.com", 80))
 }
 
 func TestNetworkFilter_DenyList(t *testing.T) {
@@ -294,17 +306,15 @@ func TestNetworkFilter_DenyList(t *testing.T) {
 		DenyHosts: []string{"evil.com", "*.malware.org"},
 	}
 
-	proxy := &NetworkProxy{filter: filter}
-
 	// Should deny evil.com
-	assert.False(t, proxy.isAllowed("evil.com", "80"))
+	assert.False(t, allowedBy(t, filter, "evil.com", 80))
 
 	// Should deny malware.org subdomains
-	assert.False(t, proxy.isAllowed("download.malware.org", "80"))
+	assert.False(t, allowedBy(t, filter, "download.malware.org", 80))
 
 	// Should allow everything else (no allow list)
-	assert.True(t, proxy.isAllowed("github.com", "443"))
-	assert.True(t, proxy.isAllowed("example.com", "80"))
+	assert.True(t, allowedBy(t, filter, "github.com", 443))
+	assert.True(t, allowedBy(t, filter, "example.com", 80))
 }
 
 func TestNetworkFilter_DenyPrecedence(t *testing.T) {
@@ -315,13 +325,11 @@ func TestNetworkFilter_DenyPrecedence(t *testing.T) {
 		DenyHosts:  []string{"bad.example.com"},
 	}
 
-	proxy := &NetworkProxy{filter: filter}
-
 	// Should allow other subdomains
-	assert.True(t, proxy.isAllowed("api.example.com", "80"))
+	assert.True(t, allowedBy(t, filter, "api.example.com", 80))
 
 	// Should deny bad.example.com (deny wins)
-	assert.False(t, proxy.isAllowed("bad.example.com", "80"))
+	assert.False(t, allowedBy(t, filter, "bad.example.com", 80))
 }
 
 func TestNetworkFilter_PortMatching(t *testing.T) {
@@ -331,22 +339,178 @@ func TestNetworkFilter_PortMatching(t *testing.T) {
 		AllowHosts: []string{"example.com:443", "api.example.com"},
 	}
 
-	proxy := &NetworkProxy{filter: filter}
-
 	// Should allow example.com:443
-	assert.True(t, proxy.isAllowed("example.com", "443"))
+	assert.True(t, allowedBy(t, filter, "example.com", 443))
 
 	// Should NOT allow example.com:80
-	assert.False(t, proxy.isAllowed("example.com", "80"))
+	assert.False(t, allowedBy(t, filter, "example.com", 80))
 
 	// Should allow api.example.com on any port
-	assert.True(t, proxy.isAllowed("api.example.com", "80"))
-	assert.True(t, proxy.isAllowed("api.example.com", "443"))
-	assert.True(t, proxy.isAllowed("api.example.com", "8080"))
+	assert.True(t, allowedBy(t, filter, "api.example.com", 80))
+	assert.True(t, allowedBy(t, filter, "api.example.com", 443))
+	assert.True(t, allowedBy(t, filter, "api.example.com", 8080))
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"fd00:ec2::254", false},   // cloud metadata (IPv6)
+		{"fe80::1", false},
+		{"100.100.100.200", false}, // shared address space
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:93.184.215.14", true},
+		{"64:ff9b::a9fe:a9fe", false}, // NAT64 of 169.254.169.254
+		{"64:ff9b::5db8:d70e", true},  // NAT64 of 93.184.215.14
+		{"2002:7f00:1::", false},      // 6to4 of 127.0.0.1
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			assert.Equal(t, tt.want, isPublicAddr(netip.MustParseAddr(tt.addr)))
+		})
+	}
+}
+
+func TestNetworkProxy_PrivateAddresses(t *testing.T) {
+	t.Parallel()
+
+	target := &testHTTPServer{}
+	target.Start(t)
+	defer target.Stop()
+	targetURL, err := url.Parse(target.URL)
+	require.NoError(t, err)
+	port := targetURL.Port()
+
+	tests := []struct {
+		name   string
+		filter *NetworkFilter
+		want   bool
+	}{
+		{"nil filter", nil, false},
+		{"allowed host", &NetworkFilter{AllowHosts: []string{"localhost"}}, false},
+		{"private addresses allowed", &NetworkFilter{AllowPrivateAddresses: true}, true},
+		{"allowed CIDR block", &NetworkFilter{AllowHosts: []string{"127.0.0.0/8"}}, true},
+		{"allowed address and port", &NetworkFilter{AllowRules: []Rule{{
+			CIDR:  netip.MustParsePrefix("127.0.0.1/32"),
+			Ports: []PortRange{{First: 1, Last: 65535}},
+		}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := NewNetworkProxy(tt.filter)
+			require.NoError(t, err)
+			defer proxy.Close()
+
+			// An address literal, and a name that resolves to a loopback address
+			for _, host := range []string{"127.0.0.1", "localhost"} {
+				hostPort := net.JoinHostPort(host, port)
+
+				rec := httptest.NewRecorder()
+				proxy.handleHTTPRequest(rec, httptest.NewRequest(http.MethodGet, "http://"+hostPort+"/test", nil))
+				if tt.want {
+					assert.Equal(t, http.StatusOK, rec.Code, "GET %s", hostPort)
+				} else {
+					assert.Equal(t, http.StatusForbidden, rec.Code, "GET %s", hostPort)
+				}
+
+				conn := dialProxy(t, proxy.HTTPAddr())
+				fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", hostPort, hostPort)
+				resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				require.NoError(t, err)
+				conn.Close()
+				if tt.want {
+					assert.Equal(t, http.StatusOK, resp.StatusCode, "CONNECT %s", hostPort)
+				} else {
+					assert.Equal(t, http.StatusForbidden, resp.StatusCode, "CONNECT %s", hostPort)
+				}
+
+				rep := socks5Connect(t, proxy.SOCKSAddr(), host, port)
+				if tt.want {
+					assert.Equal(t, byte(0x00), rep, "SOCKS %s", hostPort)
+				} else {
+					assert.Equal(t, byte(0x02), rep, "SOCKS %s", hostPort)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkProxy_RedirectNotFollowed(t *testing.T) {
+	t.Parallel()
+
+	// The redirect leads to a host outside the allow list
+	target := httptest.NewServer(http.RedirectHandler("http://internal.example/", http.StatusFound))
+	defer target.Close()
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowPrivateAddresses: true})
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	rec := httptest.NewRecorder()
+	proxy.handleHTTPRequest(rec, httptest.NewRequest(http.MethodGet, target.URL+"/", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "http://internal.example/", rec.Header().Get("Location"))
 }
 
 // Test helpers
 
+// dialProxy connects to a proxy address as returned by HTTPAddr or SOCKSAddr.
+func dialProxy(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	network, addr := "tcp", strings.TrimPrefix(addr, "http://")
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		network, addr = "unix", path
+	}
+	conn, err := net.Dial(network, addr)
+	require.NoError(t, err)
+	return conn
+}
+
+// socks5Connect asks the SOCKS5 proxy at addr to connect to host:port and returns the
+// reply code.
+func socks5Connect(t *testing.T, addr, host, port string) byte {
+	t.Helper()
+	conn := dialProxy(t, addr)
+	defer conn.Close()
+
+	_, err := conn.Write([]byte{0x05, 0x01, 0x00})
+	require.NoError(t, err)
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	request := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, byte(portNum>>8), byte(portNum&0xff))
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+
+	reply = make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	return reply[1]
+}
+
 // testHTTPServer is a simple HTTP server for testing proxy functionality.
 type testHTTPServer struct {
 	server *httptest.Server
@@ -0,0 +1,252 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+// ProxyRequest describes a destination a sandboxed process asked a NetworkProxy to reach.
+type ProxyRequest struct {
+	// Protocol is how the destination was requested: "http" for a plain HTTP request,
+	// "connect" for an HTTP CONNECT tunnel and "socks5" for a SOCKS5 connect.
+	Protocol string
+
+	// Host is the requested host name or IP address, and Port the requested port.
+	Host string
+	Port uint16
+
+	// Method and URL are the request method and URL of "http" requests.
+	Method string
+	URL    string
+
+	// Label is the label of the proxy client that received the request (see
+	// NetworkProxy.NewClient), empty for requests to the proxy itself.
+	Label string
+}
+
+// Decision results reported in ProxyEvent.Reason.
+const (
+	ReasonAllowedByRule  = "allowed by rule"
+	ReasonDeniedByRule   = "denied by rule"
+	ReasonNoAllowRules   = "no allow rules"
+	ReasonNoRuleMatched  = "no allow rule matched"
+	ReasonPrivateAddress = "private address"
+	ReasonQuotaExceeded  = "quota exceeded"
+)
+
+// ProxyEvent records one request or connection handled by a NetworkProxy. It is sent
+// once the request is complete, or as soon as it is denied.
+type ProxyEvent struct {
+	ProxyRequest
+
+	// Time is when the request arrived, and Duration how long it lasted.
+	Time     time.Time
+	Duration time.Duration
+
+	// Addr is the "ip:port" address the proxy connected to, empty if it did not connect.
+	Addr string
+
+	// Allowed reports whether the destination was allowed.
+	Allowed bool
+
+	// Rule is the filter rule that allowed or denied the destination, as returned by
+	// Rule.String. It is empty if no rule matched or a ProxyDecider decided.
+	Rule string
+
+	// Reason explains the decision: one of the Reason constants, or the reason returned
+	// by the ProxyDecider.
+	Reason string
+
+	// BytesSent and BytesReceived count the payload relayed to and from the destination:
+	// request and response bodies for "http", the tunneled stream otherwise.
+	BytesSent     int64
+	BytesReceived int64
+
+	// Status is the HTTP status code returned to the client for "http" and "connect".
+	Status int
+
+	// Err is the error that made an allowed request fail, e.g. a refused connection.
+	Err error
+}
+
+// String describes the event, e.g. "connect pypi.org:443: allowed (allowed by rule pypi.org)",
+// preceded by "label: " for events of a labeled client.
+func (e ProxyEvent) String() string {
+	verdict := "denied"
+	if e.Allowed {
+		verdict = "allowed"
+	}
+	reason := e.Reason
+	if e.Rule != "" {
+		reason += " " + e.Rule
+	}
+	s := fmt.Sprintf("%s %s: %s (%s)", e.Protocol, net.JoinHostPort(e.Host, fmt.Sprint(e.Port)), verdict, reason)
+	if e.Label != "" {
+		s = e.Label + ": " + s
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// ProxyDecider decides which destinations a NetworkProxy may reach, in place of the
+// NetworkFilter's rules. Decide is called for every request, concurrently; it may block,
+// for example to ask a human, and ctx is cancelled when the client goes away or the proxy
+// is closed. The returned reason is reported in ProxyEvent.Reason.
+//
+// Allowed destinations are still resolved by the proxy, and their private addresses
+// refused unless the filter sets AllowPrivateAddresses.
+type ProxyDecider interface {
+	Decide(ctx context.Context, req ProxyRequest) (allow bool, reason string)
+}
+
+// ProxyDeciderFunc adapts a function to the ProxyDecider interface.
+type ProxyDeciderFunc func(ctx context.Context, req ProxyRequest) (allow bool, reason string)
+
+// Decide calls f(ctx, req).
+func (f ProxyDeciderFunc) Decide(ctx context.Context, req ProxyRequest) (bool, string) {
+	return f(ctx, req)
+}
+
+// SetDecider makes d decide which destinations the proxy may reach, in place of the
+// filter's rules. A nil d restores the filter. Requests already decided are not affected.
+func (p *NetworkProxy) SetDecider(d ProxyDecider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.decider = d
+}
+
+// NotifyEvents causes an event to be relayed to ch for every request handled by the
+// proxy, including those of its clients. As with signal.Notify, the proxy does not block
+// sending to ch: the caller must ensure that ch has sufficient buffer space, and events
+// that do not fit are dropped.
+func (p *NetworkProxy) NotifyEvents(ch chan<- ProxyEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.watchers = append(p.watchers, ch)
+}
+
+// finish ends c: it releases the quota taken by c and relays its event to the channels
+// registered with the proxy and, for a client, with the proxy it belongs to.
+func (p *NetworkProxy) finish(c *proxyCall) {
+	c.ev.Duration = time.Since(c.ev.Time)
+	for q := p; q != nil; q = q.parent {
+		q.mu.Lock()
+		if q == p && c.admitted {
+			p.open--
+		}
+		for _, ch := range q.watchers {
+			select {
+			case ch <- c.ev:
+			default:
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+// proxyCall is a request in progress.
+type proxyCall struct {
+	ev ProxyEvent
+
+	// rules are the filter rules in effect when the request was authorized, checked again
+	// by dial for every address the destination resolves to, and gen the generation of
+	// the filter they belong to. decided reports that a ProxyDecider decided instead.
+	rules   *ruleSet
+	gen     uint64
+	decided bool
+
+	// admitted reports that the request counts toward the proxy's quota.
+	admitted bool
+
+	// While the connection is established (see NetworkProxy.track), addr is the address it
+	// leads to and stop closes it; revoked is the reason it was closed by the proxy, if it
+	// was. These are guarded by the proxy's mu.
+	addr    netip.Addr
+	stop    func()
+	revoked error
+}
+
+// callKey is the context key of the proxyCall of a plain HTTP request, read by the
+// transport's dialer.
+type callKey struct{}
+
+// newCall starts a request for host:port.
+func (p *NetworkProxy) newCall(protocol, host, port string) (*proxyCall, error) {
+	portNum, err := parsePort(port)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyCall{ev: ProxyEvent{
+		ProxyRequest: ProxyRequest{Protocol: protocol, Host: host, Port: portNum, Label: p.label},
+		Time:         time.Now(),
+	}}, nil
+}
+
+// authorize decides whether the destination of c may be reached, before it is resolved,
+// and records the decision in c's event. Requests beyond the proxy's quota are refused.
+func (p *NetworkProxy) authorize(ctx context.Context, c *proxyCall) bool {
+	ev := &c.ev
+	p.mu.Lock()
+	decider := p.decider
+	c.rules, c.gen = p.rules, p.gen
+	c.admitted = p.admit()
+	p.mu.Unlock()
+	if !c.admitted {
+		ev.Allowed, ev.Reason = false, ReasonQuotaExceeded
+		return false
+	}
+	if decider != nil {
+		c.decided = true
+		ev.Allowed, ev.Reason = decider.Decide(ctx, ev.ProxyRequest)
+		return ev.Allowed
+	}
+
+	addr, _ := netip.ParseAddr(ev.Host)
+	d := c.rules.decide(ev.Host, addr, ev.Port)
+	ev.Allowed, ev.Rule, ev.Reason = d.allowed, d.rule, d.reason
+	return ev.Allowed
+}
+
+// checkAddr decides whether c may connect to addr, one of the addresses its host resolves
+// to, under the rules rs. For requests allowed by a ProxyDecider, only private addresses
+// are checked.
+func (c *proxyCall) checkAddr(rs *ruleSet, addr netip.Addr) decision {
+	if c.decided {
+		if rs.allowPrivate || isPublicAddr(addr) {
+			return decision{allowed: true}
+		}
+		return decision{false, "", ReasonPrivateAddress}
+	}
+	d := rs.decide(c.ev.Host, addr, c.ev.Port)
+	if d.allowed && !rs.allowsAddr(addr, c.ev.Port) {
+		d = decision{false, "", ReasonPrivateAddress}
+	}
+	return d
+}
+
+// countingReader counts the bytes read through it, and charges them to the byte quota of
+// the proxy p.
+type countingReader struct {
+	io.ReadCloser
+	p *NetworkProxy
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 {
+		var quotaErr error
+		if n, quotaErr = r.p.charge(n); quotaErr != nil {
+			err = quotaErr
+		}
+	}
+	r.n.Add(int64(n))
+	return n, err
+}
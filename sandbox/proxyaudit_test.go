@@ -0,0 +1,256 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent waits for the next event on ch.
+func nextEvent(t *testing.T, ch <-chan ProxyEvent) ProxyEvent {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no proxy event")
+		return ProxyEvent{}
+	}
+}
+
+func TestNetworkProxyEvents(t *testing.T) {
+	t.Parallel()
+
+	target := &testHTTPServer{}
+	target.Start(t)
+	defer target.Stop()
+	targetURL, err := url.Parse(target.URL)
+	require.NoError(t, err)
+	hostPort := targetURL.Host
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{
+		AllowHosts: []string{"127.0.0.1"},
+		DenyHosts:  []string{"*.internal"},
+	})
+	require.NoError(t, err)
+	defer proxy.Close()
+	events := make(chan ProxyEvent, 10)
+	proxy.NotifyEvents(events)
+
+	// Plain HTTP request with a body
+	conn := dialProxy(t, proxy.HTTPAddr())
+	fmt.Fprintf(conn, "POST %s/echo HTTP/1.1\r\nHost: %s\r\nContent-Length: 4\r\nConnection: close\r\n\r\nping", target.URL, hostPort)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	conn.Close()
+
+	ev := nextEvent(t, events)
+	assert.Equal(t, ProxyRequest{
+		Protocol: "http",
+		Host:     "127.0.0.1",
+		Port:     mustPort(targetURL.Port()),
+		Method:   http.MethodPost,
+		URL:      target.URL + "/echo",
+	}, ev.ProxyRequest)
+	assert.True(t, ev.Allowed)
+	assert.Equal(t, "127.0.0.1", ev.Rule)
+	assert.Equal(t, ReasonAllowedByRule, ev.Reason)
+	assert.Equal(t, hostPort, ev.Addr)
+	assert.Equal(t, http.StatusOK, ev.Status)
+	assert.Equal(t, int64(4), ev.BytesSent)
+	assert.Equal(t, int64(len(body)), ev.BytesReceived)
+	assert.NoError(t, ev.Err)
+	assert.False(t, ev.Time.IsZero())
+	assert.Positive(t, ev.Duration)
+
+	// CONNECT to a denied host
+	conn = dialProxy(t, proxy.HTTPAddr())
+	fmt.Fprintf(conn, "CONNECT db.internal:5432 HTTP/1.1\r\nHost: db.internal:5432\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	ev = nextEvent(t, events)
+	assert.Equal(t, "connect", ev.Protocol)
+	assert.Equal(t, "db.internal", ev.Host)
+	assert.Equal(t, uint16(5432), ev.Port)
+	assert.False(t, ev.Allowed)
+	assert.Equal(t, "*.internal", ev.Rule)
+	assert.Equal(t, ReasonDeniedByRule, ev.Reason)
+	assert.Equal(t, http.StatusForbidden, ev.Status)
+	assert.Empty(t, ev.Addr)
+	assert.Equal(t, "connect db.internal:5432: denied (denied by rule *.internal)", ev.String())
+
+	// SOCKS5 tunnel to an allowed address
+	conn = dialProxy(t, proxy.SOCKSAddr())
+	require.Equal(t, byte(0x00), socks5Request(conn, "127.0.0.1", targetURL.Port()))
+	request := fmt.Sprintf("GET /tunnel HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", hostPort)
+	_, err = io.WriteString(conn, request)
+	require.NoError(t, err)
+	response, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Contains(t, string(response), "test response from /tunnel")
+
+	ev = nextEvent(t, events)
+	assert.Equal(t, "socks5", ev.Protocol)
+	assert.True(t, ev.Allowed)
+	assert.Equal(t, hostPort, ev.Addr)
+	assert.Equal(t, int64(len(request)), ev.BytesSent)
+	assert.Equal(t, int64(len(response)), ev.BytesReceived)
+}
+
+func TestNetworkProxyEventsPrivateAddress(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowHosts: []string{"localhost"}})
+	require.NoError(t, err)
+	defer proxy.Close()
+	events := make(chan ProxyEvent, 10)
+	proxy.NotifyEvents(events)
+
+	// An allowed name that resolves to a private address
+	assert.Equal(t, byte(0x02), socks5Connect(t, proxy.SOCKSAddr(), "localhost", "80"))
+	ev := nextEvent(t, events)
+	assert.Equal(t, "socks5", ev.Protocol)
+	assert.False(t, ev.Allowed)
+	assert.Empty(t, ev.Rule)
+	assert.Equal(t, ReasonPrivateAddress, ev.Reason)
+}
+
+func TestNetworkProxyDecider(t *testing.T) {
+	t.Parallel()
+
+	target := &testHTTPServer{}
+	target.Start(t)
+	defer target.Stop()
+	targetURL, err := url.Parse(target.URL)
+	require.NoError(t, err)
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowHosts: []string{"example.com"}, AllowPrivateAddresses: true})
+	require.NoError(t, err)
+	defer proxy.Close()
+	events := make(chan ProxyEvent, 10)
+	proxy.NotifyEvents(events)
+
+	requests := make(chan ProxyRequest, 10)
+	proxy.SetDecider(ProxyDeciderFunc(func(ctx context.Context, req ProxyRequest) (bool, string) {
+		requests <- req
+		if req.Host == "127.0.0.1" {
+			return true, "approved"
+		}
+		return false, "not on the task allowlist"
+	}))
+
+	// The decider replaces the filter's rules
+	assert.Equal(t, byte(0x00), socks5Connect(t, proxy.SOCKSAddr(), "127.0.0.1", targetURL.Port()))
+	assert.Equal(t, ProxyRequest{Protocol: "socks5", Host: "127.0.0.1", Port: mustPort(targetURL.Port())}, <-requests)
+	ev := nextEvent(t, events)
+	assert.True(t, ev.Allowed)
+	assert.Equal(t, "approved", ev.Reason)
+	assert.Empty(t, ev.Rule)
+
+	assert.Equal(t, byte(0x02), socks5Connect(t, proxy.SOCKSAddr(), "example.com", "443"))
+	<-requests
+	ev = nextEvent(t, events)
+	assert.False(t, ev.Allowed)
+	assert.Equal(t, "not on the task allowlist", ev.Reason)
+
+	// Without a decider, the filter decides again
+	proxy.SetDecider(nil)
+	assert.Equal(t, byte(0x02), socks5Connect(t, proxy.SOCKSAddr(), "127.0.0.1", targetURL.Port()))
+	ev = nextEvent(t, events)
+	assert.Equal(t, ReasonNoRuleMatched, ev.Reason)
+	assert.Empty(t, requests)
+}
+
+func TestNetworkProxyDeciderPrivateAddresses(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := NewNetworkProxy(nil)
+	require.NoError(t, err)
+	defer proxy.Close()
+	events := make(chan ProxyEvent, 10)
+	proxy.NotifyEvents(events)
+	proxy.SetDecider(ProxyDeciderFunc(func(context.Context, ProxyRequest) (bool, string) {
+		return true, "approved"
+	}))
+
+	assert.Equal(t, byte(0x02), socks5Connect(t, proxy.SOCKSAddr(), "169.254.169.254", "80"))
+	ev := nextEvent(t, events)
+	assert.False(t, ev.Allowed)
+	assert.Equal(t, ReasonPrivateAddress, ev.Reason)
+}
+
+func TestNetworkProxyCloseCancelsDecider(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := NewNetworkProxy(nil)
+	require.NoError(t, err)
+	deciding := make(chan struct{})
+	proxy.SetDecider(ProxyDeciderFunc(func(ctx context.Context, req ProxyRequest) (bool, string) {
+		close(deciding)
+		<-ctx.Done()
+		return false, "cancelled"
+	}))
+
+	go func() {
+		conn := dialProxy(t, proxy.SOCKSAddr())
+		defer conn.Close()
+		socks5Request(conn, "example.com", "443")
+	}()
+	<-deciding
+
+	closed := make(chan error)
+	go func() { closed <- proxy.Close() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a pending decision")
+	}
+}
+
+// socks5Request negotiates a SOCKS5 connect to host:port on conn and returns the reply
+// code, leaving conn ready for the tunneled stream.
+func socks5Request(conn net.Conn, host, port string) byte {
+	_, err := conn.Write([]byte{0x05, 0x01, 0x00})
+	if err != nil {
+		return 0xff
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return 0xff
+	}
+	p := mustPort(port)
+	request := append([]byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}, host...)
+	request = append(request, byte(p>>8), byte(p))
+	if _, err := conn.Write(request); err != nil {
+		return 0xff
+	}
+	reply = make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return 0xff
+	}
+	return reply[1]
+}
+
+func mustPort(port string) uint16 {
+	p, err := parsePort(port)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
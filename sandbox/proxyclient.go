@@ -0,0 +1,164 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrProxyQuotaExceeded is reported in ProxyEvent.Err for connections closed because their
+// client used up the byte limit of its ProxyQuota.
+var ErrProxyQuotaExceeded = errors.New("sandbox: proxy quota exceeded")
+
+// ProxyQuota limits what a client of a NetworkProxy may do. Zero fields are unlimited.
+// Requests beyond the quota are refused with ReasonQuotaExceeded.
+type ProxyQuota struct {
+	// MaxConnections limits the requests and tunnels in progress at the same time.
+	MaxConnections int
+
+	// MaxRequests limits the number of requests, allowed or not, over the client's
+	// lifetime: plain HTTP requests, CONNECT tunnels and SOCKS5 connects.
+	MaxRequests int
+
+	// MaxBytes limits the payload relayed in both directions over the client's lifetime.
+	// Once it is reached, the client's connections are closed and further requests are
+	// refused.
+	MaxBytes int64
+}
+
+// ProxyClientConfig configures a client created by NetworkProxy.NewClient.
+type ProxyClientConfig struct {
+	// Label identifies the client in its events and in the requests passed to a
+	// ProxyDecider (ProxyRequest.Label), e.g. the ID of the job running in the sandbox.
+	Label string
+
+	// Filter is the client's filter, as for NewNetworkProxy. The filter of the proxy does
+	// not apply to its clients.
+	Filter *NetworkFilter
+
+	// Quota limits the client's use of the proxy.
+	Quota ProxyQuota
+}
+
+// NewClient creates a client of the proxy: a NetworkProxy with its own endpoint, filter,
+// quota and label, for one sandbox among many sharing the proxy. Assign it to
+// Policy.NetworkProxy; requests through it are only checked against the client's filter.
+//
+// The client's endpoint is a pair of Unix sockets in a directory of its own on Linux, and
+// a pair of loopback TCP ports on macOS. The bubblewrap and Seatbelt backends only let the
+// sandbox reach its own endpoint. The landlock backend cannot: Landlock does not restrict
+// connecting to Unix sockets, so a sandbox there can use the endpoint of the proxy or of
+// any other client, with that endpoint's filter and quota.
+//
+// Clients share the proxy's connections to HTTP servers, and their events are relayed to
+// the channels registered with the proxy's NotifyEvents as well as their own. A client
+// has its own ProxyDecider, set with SetDecider, and can have its filter changed like any
+// proxy.
+//
+// Close the client when its sandbox is done; closing the proxy closes its clients. The
+// clients of a client are clients of its proxy.
+//
+//	client, err := proxy.NewClient(sandbox.ProxyClientConfig{
+//	    Label:  jobID,
+//	    Filter: &sandbox.NetworkFilter{AllowHosts: []string{"pypi.org", "files.pythonhosted.org"}},
+//	    Quota:  sandbox.ProxyQuota{MaxConnections: 16, MaxBytes: 1 << 30},
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	defer client.Close()
+//	policy.NetworkProxy = client
+func (p *NetworkProxy) NewClient(cfg ProxyClientConfig) (*NetworkProxy, error) {
+	if p.parent != nil {
+		return p.parent.NewClient(cfg)
+	}
+	q := cfg.Quota
+	if q.MaxConnections < 0 || q.MaxRequests < 0 || q.MaxBytes < 0 {
+		return nil, fmt.Errorf("sandbox: proxy quota: limits must not be negative")
+	}
+	rules, err := cfg.Filter.rules()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: network filter: %w", err)
+	}
+
+	httpLn, socksLn, tmpDir, err := createListeners()
+	if err != nil {
+		return nil, fmt.Errorf("create listeners: %w", err)
+	}
+	c := &NetworkProxy{
+		filter:      cfg.Filter.clone(),
+		rules:       rules,
+		httpLn:      httpLn,
+		socksLn:     socksLn,
+		socksTmpDir: tmpDir,
+		closed:      make(chan struct{}),
+		parent:      p,
+		label:       cfg.Label,
+		quota:       q,
+		transport:   p.transport,
+	}
+	c.ctx, c.cancel = context.WithCancel(p.ctx)
+
+	// Started while registering, so that a concurrent Close of the proxy either closes
+	// the client or makes NewClient fail
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx.Err() != nil {
+		c.cancel()
+		httpLn.Close()
+		socksLn.Close()
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
+		return nil, fmt.Errorf("sandbox: network proxy is closed")
+	}
+	if p.clients == nil {
+		p.clients = make(map[*NetworkProxy]struct{})
+	}
+	p.clients[c] = struct{}{}
+	c.start()
+	return c, nil
+}
+
+// admit takes a slot of the quota for a new request, and reports whether one was left.
+// The caller holds p.mu.
+func (p *NetworkProxy) admit() bool {
+	q := p.quota
+	switch {
+	case q.MaxConnections > 0 && p.open >= q.MaxConnections:
+		return false
+	case q.MaxRequests > 0 && p.requests >= q.MaxRequests:
+		return false
+	case q.MaxBytes > 0 && p.bytes.Load() >= q.MaxBytes:
+		return false
+	}
+	p.open++
+	p.requests++
+	return true
+}
+
+// charge counts n bytes relayed for the proxy against its byte quota, and returns how many
+// of them may still be relayed. Once the quota is used up, it closes the proxy's
+// connections and returns ErrProxyQuotaExceeded.
+func (p *NetworkProxy) charge(n int) (int, error) {
+	limit := p.quota.MaxBytes
+	if limit <= 0 {
+		return n, nil
+	}
+	total := p.bytes.Add(int64(n))
+	if total <= limit {
+		return n, nil
+	}
+	n -= int(min(total-limit, int64(n)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c := range p.active {
+		if c.revoked == nil {
+			c.revoked = ErrProxyQuotaExceeded
+			c.stop()
+		}
+	}
+	return n, ErrProxyQuotaExceeded
+}
@@ -0,0 +1,208 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkProxyClients(t *testing.T) {
+	t.Parallel()
+
+	echo := startEchoServer(t)
+	_, port, err := net.SplitHostPort(echo)
+	require.NoError(t, err)
+	target := &testHTTPServer{}
+	target.Start(t)
+	defer target.Stop()
+	targetURL, err := url.Parse(target.URL)
+	require.NoError(t, err)
+
+	proxy, err := NewNetworkProxy(nil)
+	require.NoError(t, err)
+	defer proxy.Close()
+	events := make(chan ProxyEvent, 10)
+	proxy.NotifyEvents(events)
+
+	a, err := proxy.NewClient(ProxyClientConfig{
+		Label:  "job-a",
+		Filter: &NetworkFilter{AllowHosts: []string{"127.0.0.1"}, AllowPrivateAddresses: true},
+	})
+	require.NoError(t, err)
+	defer a.Close()
+	aEvents := make(chan ProxyEvent, 10)
+	a.NotifyEvents(aEvents)
+
+	b, err := proxy.NewClient(ProxyClientConfig{
+		Label:  "job-b",
+		Filter: &NetworkFilter{AllowHosts: []string{"example.com"}},
+	})
+	require.NoError(t, err)
+
+	// Every client has its own endpoint and filter
+	addrs := []string{proxy.HTTPAddr(), proxy.SOCKSAddr(), a.HTTPAddr(), a.SOCKSAddr(), b.HTTPAddr(), b.SOCKSAddr()}
+	for i := range addrs {
+		for j := range i {
+			assert.NotEqual(t, addrs[i], addrs[j])
+		}
+	}
+	assert.Equal(t, []string{"127.0.0.1"}, a.Filter().AllowHosts)
+	assert.Equal(t, byte(0x02), socks5Connect(t, proxy.SOCKSAddr(), "127.0.0.1", port))
+	assert.Equal(t, byte(0x00), socks5Connect(t, a.SOCKSAddr(), "127.0.0.1", port))
+	assert.Equal(t, byte(0x02), socks5Connect(t, b.SOCKSAddr(), "127.0.0.1", port))
+
+	// Events are labeled, and relayed to the proxy as well as the client. A tunnel's event
+	// is sent when it closes, so they may arrive in any order.
+	byLabel := make(map[string]ProxyEvent)
+	for range 3 {
+		ev := nextEvent(t, events)
+		byLabel[ev.Label] = ev
+	}
+	assert.False(t, byLabel[""].Allowed)
+	assert.True(t, byLabel["job-a"].Allowed)
+	assert.Equal(t, byLabel["job-a"], nextEvent(t, aEvents))
+	assert.Equal(t, "job-b: socks5 127.0.0.1:"+port+": denied (no allow rule matched)", byLabel["job-b"].String())
+	assert.Empty(t, aEvents)
+
+	// Plain HTTP requests go through the proxy's shared transport
+	conn := dialProxy(t, a.HTTPAddr())
+	fmt.Fprintf(conn, "GET %s/a HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", target.URL, targetURL.Host)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "test response from /a", string(body))
+	assert.Equal(t, "job-a", nextEvent(t, events).Label)
+
+	// A client's decider sees its label
+	labels := make(chan string, 1)
+	b.SetDecider(ProxyDeciderFunc(func(ctx context.Context, req ProxyRequest) (bool, string) {
+		labels <- req.Label
+		return false, "no"
+	}))
+	assert.Equal(t, byte(0x02), socks5Connect(t, b.SOCKSAddr(), "example.com", "443"))
+	assert.Equal(t, "job-b", <-labels)
+
+	// Clients of a client belong to the proxy
+	c, err := b.NewClient(ProxyClientConfig{Label: "job-c"})
+	require.NoError(t, err)
+	assert.Same(t, proxy, c.parent)
+
+	// Closing a client leaves the proxy and the other clients running; closing the proxy
+	// closes the remaining ones
+	require.NoError(t, a.Close())
+	assert.False(t, proxyReachable(a.SOCKSAddr()))
+	assert.Equal(t, byte(0x02), socks5Connect(t, b.SOCKSAddr(), "127.0.0.1", port))
+	require.NoError(t, proxy.Close())
+	assert.False(t, proxyReachable(b.SOCKSAddr()))
+	assert.False(t, proxyReachable(c.HTTPAddr()))
+	_, err = proxy.NewClient(ProxyClientConfig{})
+	assert.Error(t, err)
+}
+
+func TestNetworkProxyClientQuota(t *testing.T) {
+	t.Parallel()
+
+	echo := startEchoServer(t)
+	_, port, err := net.SplitHostPort(echo)
+	require.NoError(t, err)
+
+	proxy, err := NewNetworkProxy(nil)
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	_, err = proxy.NewClient(ProxyClientConfig{Quota: ProxyQuota{MaxBytes: -1}})
+	assert.Error(t, err)
+	_, err = proxy.NewClient(ProxyClientConfig{Filter: &NetworkFilter{AllowHosts: []string{"*"}}})
+	assert.Error(t, err)
+
+	filter := &NetworkFilter{AllowHosts: []string{"127.0.0.1"}, AllowPrivateAddresses: true}
+
+	t.Run("connections", func(t *testing.T) {
+		client, err := proxy.NewClient(ProxyClientConfig{Filter: filter, Quota: ProxyQuota{MaxConnections: 1}})
+		require.NoError(t, err)
+		defer client.Close()
+		events := make(chan ProxyEvent, 10)
+		client.NotifyEvents(events)
+
+		tunnel := dialProxy(t, client.SOCKSAddr())
+		require.Equal(t, byte(0x00), socks5Request(tunnel, "127.0.0.1", port))
+		assert.Equal(t, byte(0x02), socks5Connect(t, client.SOCKSAddr(), "127.0.0.1", port))
+		ev := nextEvent(t, events)
+		assert.False(t, ev.Allowed)
+		assert.Equal(t, ReasonQuotaExceeded, ev.Reason)
+
+		tunnel.Close()
+		assert.True(t, nextEvent(t, events).Allowed)
+		assert.Equal(t, byte(0x00), socks5Connect(t, client.SOCKSAddr(), "127.0.0.1", port))
+	})
+
+	t.Run("requests", func(t *testing.T) {
+		client, err := proxy.NewClient(ProxyClientConfig{Filter: filter, Quota: ProxyQuota{MaxRequests: 2}})
+		require.NoError(t, err)
+		defer client.Close()
+
+		assert.Equal(t, byte(0x02), socks5Connect(t, client.SOCKSAddr(), "198.51.100.1", "443"))
+		assert.Equal(t, byte(0x00), socks5Connect(t, client.SOCKSAddr(), "127.0.0.1", port))
+		assert.Equal(t, byte(0x02), socks5Connect(t, client.SOCKSAddr(), "127.0.0.1", port))
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		client, err := proxy.NewClient(ProxyClientConfig{Filter: filter, Quota: ProxyQuota{MaxBytes: 10}})
+		require.NoError(t, err)
+		defer client.Close()
+		events := make(chan ProxyEvent, 10)
+		client.NotifyEvents(events)
+
+		idle := dialProxy(t, client.SOCKSAddr())
+		defer idle.Close()
+		require.Equal(t, byte(0x00), socks5Request(idle, "127.0.0.1", port))
+
+		tunnel := dialProxy(t, client.SOCKSAddr())
+		defer tunnel.Close()
+		require.Equal(t, byte(0x00), socks5Request(tunnel, "127.0.0.1", port))
+		_, err = io.WriteString(tunnel, "0123456789abcdef")
+		require.NoError(t, err)
+		data, err := io.ReadAll(tunnel)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(data), 10)
+
+		// Every connection of the client is closed
+		data, err = io.ReadAll(idle)
+		require.NoError(t, err)
+		assert.Empty(t, data)
+		for range 2 {
+			ev := nextEvent(t, events)
+			assert.ErrorIs(t, ev.Err, ErrProxyQuotaExceeded)
+			assert.LessOrEqual(t, ev.BytesSent+ev.BytesReceived, int64(10))
+		}
+
+		assert.Equal(t, byte(0x02), socks5Connect(t, client.SOCKSAddr(), "127.0.0.1", port))
+		assert.Equal(t, ReasonQuotaExceeded, nextEvent(t, events).Reason)
+	})
+}
+
+// proxyReachable reports whether a proxy address as returned by HTTPAddr or SOCKSAddr
+// accepts connections.
+func proxyReachable(addr string) bool {
+	network, addr := "tcp", strings.TrimPrefix(addr, "http://")
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		network, addr = "unix", path
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,243 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+)
+
+// ErrConnectionRevoked is reported in ProxyEvent.Err for connections closed by
+// CloseDisallowed because the filter no longer allowed them.
+var ErrConnectionRevoked = errors.New("sandbox: connection closed: destination no longer allowed")
+
+// Filter returns a copy of the proxy's current filter, or nil if it has none.
+func (p *NetworkProxy) Filter() *NetworkFilter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.filter.clone()
+}
+
+// SetFilter atomically replaces the proxy's filter. filter is validated as by
+// NewNetworkProxy, and the current filter is kept if it is invalid. Requests arriving
+// afterwards, and requests still connecting, are checked against the new filter;
+// established connections are kept, unless CloseDisallowed is called. filter is copied, so
+// the caller may modify it afterwards.
+func (p *NetworkProxy) SetFilter(filter *NetworkFilter) error {
+	p.filterMu.Lock()
+	defer p.filterMu.Unlock()
+	return p.setFilter(filter)
+}
+
+// UpdateFilter atomically modifies the proxy's filter: update is called with a copy of the
+// current filter (an empty one if the proxy has none), which then replaces it as by
+// SetFilter. Concurrent changes are applied one after the other, so none is lost.
+//
+//	err := proxy.UpdateFilter(func(f *sandbox.NetworkFilter) {
+//	    f.AllowHosts = append(f.AllowHosts, "files.pythonhosted.org")
+//	})
+func (p *NetworkProxy) UpdateFilter(update func(f *NetworkFilter)) error {
+	p.filterMu.Lock()
+	defer p.filterMu.Unlock()
+	filter := p.Filter()
+	if filter == nil {
+		filter = &NetworkFilter{}
+	}
+	update(filter)
+	return p.setFilter(filter)
+}
+
+func (p *NetworkProxy) setFilter(filter *NetworkFilter) error {
+	rs, err := filter.rules()
+	if err != nil {
+		return fmt.Errorf("sandbox: network filter: %w", err)
+	}
+	p.mu.Lock()
+	p.filter, p.rules = filter.clone(), rs
+	p.gen++
+	p.mu.Unlock()
+
+	// Idle connections to HTTP servers were checked against the previous filter
+	p.transport.CloseIdleConnections()
+	return nil
+}
+
+// CloseDisallowed closes the established connections, CONNECT and SOCKS5 tunnels as well
+// as plain HTTP requests in progress, that the current filter does not allow, and returns
+// how many it closed. Their events report ErrConnectionRevoked. Connections allowed by a
+// ProxyDecider are only closed if they lead to a private address the filter does not
+// allow.
+func (p *NetworkProxy) CloseDisallowed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for c := range p.active {
+		if c.revoked != nil || c.checkAddr(p.rules, c.addr).allowed {
+			continue
+		}
+		c.revoked = ErrConnectionRevoked
+		c.stop()
+		n++
+	}
+	return n
+}
+
+// track registers c, connected to addr, as established until untrack is called; stop
+// closes its connection. The address is checked first, against the current filter if it
+// changed since c was authorized, and a *blockedError is returned if it is refused: plain
+// HTTP requests may reuse a connection dialed for another request, possibly of another
+// client of the proxy.
+func (p *NetworkProxy) track(c *proxyCall, addr netip.Addr, stop func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rs := c.rules
+	if c.gen != p.gen {
+		rs = p.rules
+	}
+	if d := c.checkAddr(rs, addr); !d.allowed {
+		return &blockedError{host: c.ev.Host, d: d}
+	}
+	c.addr, c.stop = addr, stop
+	if p.active == nil {
+		p.active = make(map[*proxyCall]struct{})
+	}
+	p.active[c] = struct{}{}
+	return nil
+}
+
+// untrack ends the registration of c. It returns ErrConnectionRevoked or
+// ErrProxyQuotaExceeded if the proxy closed the connection.
+func (p *NetworkProxy) untrack(c *proxyCall) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, c)
+	return c.revoked
+}
+
+// remoteAddr returns the IP address conn is connected to.
+func remoteAddr(conn net.Conn) netip.Addr {
+	addrPort, _ := netip.ParseAddrPort(conn.RemoteAddr().String())
+	return addrPort.Addr()
+}
+
+// clone returns a deep copy of f.
+func (f *NetworkFilter) clone() *NetworkFilter {
+	if f == nil {
+		return nil
+	}
+	c := *f
+	c.AllowHosts = slices.Clone(f.AllowHosts)
+	c.DenyHosts = slices.Clone(f.DenyHosts)
+	c.AllowRules = cloneRules(f.AllowRules)
+	c.DenyRules = cloneRules(f.DenyRules)
+	return &c
+}
+
+func cloneRules(rules []Rule) []Rule {
+	rules = slices.Clone(rules)
+	for i := range rules {
+		rules[i].Ports = slices.Clone(rules[i].Ports)
+	}
+	return rules
+}
+
+// FilterFileOptions configures WatchFilterFile.
+type FilterFileOptions struct {
+	// Interval is how often the file is checked for changes (default 1s).
+	Interval time.Duration
+
+	// CloseDisallowed closes, after every reload, the established connections the new
+	// filter does not allow (see NetworkProxy.CloseDisallowed).
+	CloseDisallowed bool
+
+	// OnReload, if set, is called after every attempt to reload the file: with nil once
+	// the new filter is in effect, or with the error that kept the previous filter in
+	// place.
+	OnReload func(err error)
+}
+
+// WatchFilterFile sets the proxy's filter from the filter file at path (see LoadFilter),
+// then reloads it whenever the file changes, until the proxy is closed. Changes are
+// detected by polling the file's size, modification time and identity, so that replacing
+// the file by renaming another one over it is picked up too. A file that cannot be read or
+// decoded leaves the current filter in place; only the initial load returns an error.
+func (p *NetworkProxy) WatchFilterFile(path string, opts *FilterFileOptions) error {
+	if opts == nil {
+		opts = &FilterFileOptions{}
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if p.ctx.Err() != nil {
+		return fmt.Errorf("sandbox: network proxy is closed")
+	}
+
+	last, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("sandbox: filter file: %w", err)
+	}
+	if err := p.reloadFilterFile(path, opts.CloseDisallowed); err != nil {
+		return err
+	}
+
+	report := func(err error) {
+		if opts.OnReload != nil {
+			opts.OnReload(err)
+		}
+	}
+	// Registered under mu, as NewClient does, so that a concurrent Close either waits
+	// for the watcher or makes WatchFilterFile fail
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ctx.Err() != nil {
+		return fmt.Errorf("sandbox: network proxy is closed")
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			fi, err := os.Stat(path)
+			if err != nil {
+				// Reported once, until the file is back
+				if last != nil {
+					last = nil
+					report(fmt.Errorf("sandbox: filter file: %w", err))
+				}
+				continue
+			}
+			if last != nil && os.SameFile(last, fi) && fi.Size() == last.Size() && fi.ModTime().Equal(last.ModTime()) {
+				continue
+			}
+			last = fi
+			report(p.reloadFilterFile(path, opts.CloseDisallowed))
+		}
+	}()
+	return nil
+}
+
+// reloadFilterFile sets the proxy's filter from the filter file at path.
+func (p *NetworkProxy) reloadFilterFile(path string, closeDisallowed bool) error {
+	filter, err := LoadFilter(path)
+	if err != nil {
+		return err
+	}
+	if err := p.SetFilter(filter); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if closeDisallowed {
+		p.CloseDisallowed()
+	}
+	return nil
+}
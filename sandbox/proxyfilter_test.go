@@ -0,0 +1,247 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkProxySetFilter(t *testing.T) {
+	t.Parallel()
+
+	echo := startEchoServer(t)
+	_, port, err := net.SplitHostPort(echo)
+	require.NoError(t, err)
+
+	filter := &NetworkFilter{AllowHosts: []string{"example.com"}, AllowPrivateAddresses: true}
+	proxy, err := NewNetworkProxy(filter)
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	// The proxy keeps its own copy of the filter
+	filter.AllowHosts[0] = "127.0.0.1"
+	assert.Equal(t, byte(0x02), socks5Connect(t, proxy.SOCKSAddr(), "127.0.0.1", port))
+
+	require.NoError(t, proxy.SetFilter(&NetworkFilter{AllowHosts: []string{"127.0.0.1"}, AllowPrivateAddresses: true}))
+	assert.Equal(t, byte(0x00), socks5Connect(t, proxy.SOCKSAddr(), "127.0.0.1", port))
+	assert.Equal(t, []string{"127.0.0.1"}, proxy.Filter().AllowHosts)
+
+	// An invalid filter is refused and the current one kept
+	err = proxy.SetFilter(&NetworkFilter{AllowHosts: []string{"127.0.0.1:0"}})
+	assert.ErrorContains(t, err, "sandbox: network filter: AllowHosts")
+	assert.Equal(t, byte(0x00), socks5Connect(t, proxy.SOCKSAddr(), "127.0.0.1", port))
+
+	// Filter returns a copy
+	proxy.Filter().AllowHosts[0] = "example.com"
+	assert.Equal(t, []string{"127.0.0.1"}, proxy.Filter().AllowHosts)
+
+	require.NoError(t, proxy.SetFilter(nil))
+	assert.Nil(t, proxy.Filter())
+	assert.Equal(t, byte(0x02), socks5Connect(t, proxy.SOCKSAddr(), "127.0.0.1", port), "a nil filter refuses private addresses")
+}
+
+func TestNetworkProxyUpdateFilter(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := NewNetworkProxy(nil)
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := proxy.UpdateFilter(func(f *NetworkFilter) {
+				f.AllowHosts = append(f.AllowHosts, fmt.Sprintf("host%d.example.com", i))
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, proxy.Filter().AllowHosts, 20, "no update is lost")
+
+	err = proxy.UpdateFilter(func(f *NetworkFilter) { f.DenyHosts = []string{"bad pattern"} })
+	assert.Error(t, err)
+	assert.Empty(t, proxy.Filter().DenyHosts)
+}
+
+func TestNetworkProxyCloseDisallowed(t *testing.T) {
+	t.Parallel()
+
+	echo := startEchoServer(t)
+	_, port, err := net.SplitHostPort(echo)
+	require.NoError(t, err)
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowHosts: []string{"127.0.0.1", "localhost"}, AllowPrivateAddresses: true})
+	require.NoError(t, err)
+	defer proxy.Close()
+	events := make(chan ProxyEvent, 10)
+	proxy.NotifyEvents(events)
+
+	socks := dialProxy(t, proxy.SOCKSAddr())
+	defer socks.Close()
+	require.Equal(t, byte(0x00), socks5Request(socks, "127.0.0.1", port))
+
+	connect := dialProxy(t, proxy.HTTPAddr())
+	defer connect.Close()
+	fmt.Fprintf(connect, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", echo, echo)
+	connectReader := bufio.NewReader(connect)
+	resp, err := http.ReadResponse(connectReader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	kept := dialProxy(t, proxy.SOCKSAddr())
+	defer kept.Close()
+	require.Equal(t, byte(0x00), socks5Request(kept, "localhost", port))
+
+	assertEcho := func(conn net.Conn, r io.Reader) {
+		t.Helper()
+		_, err := io.WriteString(conn, "ping")
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(r, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+	}
+
+	// Established connections survive a filter change...
+	require.NoError(t, proxy.SetFilter(&NetworkFilter{AllowHosts: []string{"localhost"}, AllowPrivateAddresses: true}))
+	assertEcho(socks, socks)
+	assertEcho(connect, connectReader)
+	assertEcho(kept, kept)
+
+	// ...until CloseDisallowed closes those the new filter refuses
+	assert.Equal(t, 2, proxy.CloseDisallowed())
+	for _, r := range []io.Reader{socks, connectReader} {
+		_, err := io.ReadAll(r)
+		assert.NoError(t, err, "the tunnel is closed")
+	}
+	for range 2 {
+		ev := nextEvent(t, events)
+		assert.ErrorIs(t, ev.Err, ErrConnectionRevoked)
+		assert.Equal(t, "127.0.0.1", ev.Host)
+	}
+	assertEcho(kept, kept)
+	assert.Equal(t, 0, proxy.CloseDisallowed())
+}
+
+func TestNetworkProxyFilterChangeWhileConnecting(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowHosts: []string{"example.com"}})
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	c, err := proxy.newCall("socks5", "example.com", "443")
+	require.NoError(t, err)
+	require.True(t, proxy.authorize(context.Background(), c))
+
+	// The connection is checked again if the filter changed since it was authorized
+	require.NoError(t, proxy.SetFilter(&NetworkFilter{AllowHosts: []string{"pypi.org"}}))
+	err = proxy.track(c, netip.MustParseAddr("93.184.215.14"), func() {})
+	require.ErrorIs(t, err, errDestinationBlocked)
+	assert.True(t, c.blocked(err))
+	assert.Equal(t, ReasonNoRuleMatched, c.ev.Reason)
+	assert.Empty(t, proxy.active)
+
+	c, err = proxy.newCall("socks5", "pypi.org", "443")
+	require.NoError(t, err)
+	require.True(t, proxy.authorize(context.Background(), c))
+	require.NoError(t, proxy.track(c, netip.MustParseAddr("151.101.0.223"), func() {}))
+	assert.NoError(t, proxy.untrack(c))
+}
+
+func TestNetworkProxyWatchFilterFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "filter.yaml")
+	// write replaces the file by renaming a new one over it, as editors and config
+	// management tools do.
+	write := func(content string) {
+		tmp := filepath.Join(dir, "filter.tmp")
+		require.NoError(t, os.WriteFile(tmp, []byte(content), 0o644))
+		require.NoError(t, os.Rename(tmp, path))
+	}
+	write("allow_hosts: [pypi.org]\n")
+
+	proxy, err := NewNetworkProxy(nil)
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	reloads := make(chan error, 10)
+	err = proxy.WatchFilterFile(path, &FilterFileOptions{
+		Interval: 10 * time.Millisecond,
+		OnReload: func(err error) { reloads <- err },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pypi.org"}, proxy.Filter().AllowHosts)
+
+	nextReload := func() error {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("filter file not reloaded")
+			return nil
+		}
+	}
+
+	write("allow_hosts: [pypi.org, files.pythonhosted.org]\ndeny_hosts: [10.0.0.0/8]\n")
+	require.NoError(t, nextReload())
+	assert.Equal(t, &NetworkFilter{
+		AllowHosts: []string{"pypi.org", "files.pythonhosted.org"},
+		DenyHosts:  []string{"10.0.0.0/8"},
+	}, proxy.Filter())
+
+	// A broken file keeps the current filter
+	write("allow_hosts: [pypi.org:https]\n")
+	assert.ErrorContains(t, nextReload(), "allow_hosts")
+	assert.Equal(t, []string{"pypi.org", "files.pythonhosted.org"}, proxy.Filter().AllowHosts)
+
+	require.NoError(t, os.Remove(path))
+	assert.ErrorIs(t, nextReload(), os.ErrNotExist)
+	write("allow_hosts: [example.com]\n")
+	require.NoError(t, nextReload())
+	assert.Equal(t, []string{"example.com"}, proxy.Filter().AllowHosts)
+
+	assert.Error(t, proxy.WatchFilterFile(filepath.Join(dir, "missing.yaml"), nil))
+	require.NoError(t, proxy.Close())
+	assert.Error(t, proxy.WatchFilterFile(path, nil))
+}
+
+// startEchoServer starts a TCP server on loopback that echoes what it receives, and
+// returns its address.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
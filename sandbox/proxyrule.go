@@ -0,0 +1,323 @@
+package sandbox
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Rule is a destination rule of a NetworkFilter, the structured form of a host pattern. A
+// rule matches destinations either by name (Host) or by address (CIDR), optionally on
+// selected ports only.
+type Rule struct {
+	// Host is a host name ("example.com") or a wildcard matching its subdomains
+	// ("*.example.com"). IP address literals are stored in CIDR instead.
+	Host string
+
+	// CIDR matches destinations by address: IP literals, and the addresses host names
+	// resolve to. An allow rule by address also lets connections reach the private and
+	// special-purpose addresses it contains (see NetworkFilter.AllowPrivateAddresses).
+	CIDR netip.Prefix
+
+	// Ports restricts the rule to these port ranges. An empty list matches every port.
+	Ports []PortRange
+}
+
+// PortRange is an inclusive range of TCP ports.
+type PortRange struct {
+	First, Last uint16
+}
+
+// ParseRule parses a host pattern as accepted in NetworkFilter.AllowHosts and DenyHosts:
+//
+//	example.com            host name, any port
+//	*.example.com          subdomains of example.com (not example.com itself)
+//	example.com:443        a single port
+//	example.com:80,443     a list of ports
+//	example.com:8000-8100  a range of ports (lists may mix ports and ranges)
+//	203.0.113.7            IPv4 address
+//	10.0.0.0/8:443         CIDR block
+//	::1, [::1]:443         IPv6 address; brackets are required with a port
+//	[fd00::/8]:80          IPv6 CIDR block with a port
+func ParseRule(pattern string) (Rule, error) {
+	host, ports := pattern, ""
+	bracketed := strings.HasPrefix(pattern, "[")
+	if bracketed {
+		end := strings.IndexByte(pattern, ']')
+		if end < 0 {
+			return Rule{}, fmt.Errorf("invalid pattern %q: missing ]", pattern)
+		}
+		host = pattern[1:end]
+		if rest := pattern[end+1:]; rest != "" {
+			var ok bool
+			if ports, ok = strings.CutPrefix(rest, ":"); !ok {
+				return Rule{}, fmt.Errorf("invalid pattern %q: unexpected %q after ]", pattern, rest)
+			}
+		}
+	} else if strings.Count(pattern, ":") == 1 {
+		// More than one colon is an IPv6 literal without a port
+		host, ports, _ = strings.Cut(pattern, ":")
+	}
+
+	var rule Rule
+	switch addr, err := netip.ParseAddr(host); {
+	case strings.Contains(host, "/"):
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		rule.CIDR = prefix
+	case err == nil && addr.Zone() != "":
+		return Rule{}, fmt.Errorf("invalid pattern %q: address has a zone", pattern)
+	case err == nil:
+		rule.CIDR = netip.PrefixFrom(addr, addr.BitLen())
+	case bracketed:
+		return Rule{}, fmt.Errorf("invalid pattern %q: %q is not an IPv6 address", pattern, host)
+	default:
+		rule.Host = host
+	}
+	if bracketed && rule.CIDR.Addr().Is4() {
+		return Rule{}, fmt.Errorf("invalid pattern %q: only IPv6 addresses are bracketed", pattern)
+	}
+
+	if ports != "" || strings.HasSuffix(pattern, ":") {
+		for _, field := range strings.Split(ports, ",") {
+			r, err := parsePortRange(field)
+			if err != nil {
+				return Rule{}, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+			rule.Ports = append(rule.Ports, r)
+		}
+	}
+
+	rule, err := rule.normalize()
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return rule, nil
+}
+
+// parsePortRange parses "443" or "8000-8100".
+func parsePortRange(s string) (PortRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	lo, err := parsePort(first)
+	if err != nil {
+		return PortRange{}, err
+	}
+	hi := lo
+	if isRange {
+		if hi, err = parsePort(last); err != nil {
+			return PortRange{}, err
+		}
+	}
+	return PortRange{First: lo, Last: hi}, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(n), nil
+}
+
+// normalize checks that r is well-formed and returns it in canonical form: lower-case host
+// names, and the masked prefix of CIDR blocks in their unmapped form.
+func (r Rule) normalize() (Rule, error) {
+	switch {
+	case r.Host != "" && r.CIDR.IsValid():
+		return Rule{}, fmt.Errorf("rule has both a host and a CIDR block")
+	case r.CIDR.IsValid():
+		addr := r.CIDR.Addr()
+		if addr.Zone() != "" {
+			return Rule{}, fmt.Errorf("address %s has a zone", addr)
+		}
+		bits := r.CIDR.Bits()
+		if addr.Is4In6() {
+			if bits < 96 {
+				return Rule{}, fmt.Errorf("IPv4-mapped prefix %s is shorter than /96", r.CIDR)
+			}
+			addr, bits = addr.Unmap(), bits-96
+		}
+		r.CIDR = netip.PrefixFrom(addr, bits).Masked()
+	case r.Host != "":
+		name, wildcard := strings.CutPrefix(strings.ToLower(strings.TrimSuffix(r.Host, ".")), "*.")
+		if !validHostName(name) {
+			return Rule{}, fmt.Errorf("invalid host %q", r.Host)
+		}
+		if wildcard {
+			name = "*." + name
+		}
+		r.Host = name
+	default:
+		return Rule{}, fmt.Errorf("rule has neither a host nor a CIDR block")
+	}
+
+	for _, pr := range r.Ports {
+		if pr.First == 0 || pr.First > pr.Last {
+			return Rule{}, fmt.Errorf("invalid port range %d-%d", pr.First, pr.Last)
+		}
+	}
+	return r, nil
+}
+
+// validHostName reports whether name is a DNS name: dot-separated labels of letters,
+// digits, hyphens and underscores.
+func validHostName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the rule as a host pattern accepted by ParseRule.
+func (r Rule) String() string {
+	var b strings.Builder
+	switch {
+	case r.CIDR.IsValid() && r.CIDR.IsSingleIP():
+		b.WriteString(r.CIDR.Addr().String())
+	case r.CIDR.IsValid():
+		b.WriteString(r.CIDR.String())
+	default:
+		b.WriteString(r.Host)
+	}
+	if len(r.Ports) == 0 {
+		return b.String()
+	}
+	s := b.String()
+	if r.CIDR.IsValid() && r.CIDR.Addr().Is6() {
+		s = "[" + s + "]"
+	}
+	ports := make([]string, len(r.Ports))
+	for i, pr := range r.Ports {
+		ports[i] = strconv.Itoa(int(pr.First))
+		if pr.Last != pr.First {
+			ports[i] += "-" + strconv.Itoa(int(pr.Last))
+		}
+	}
+	return s + ":" + strings.Join(ports, ",")
+}
+
+// matches reports whether r matches a connection to host at addr and port. addr is the
+// zero Addr while a host name is not resolved yet; rules by address then report
+// unresolved.
+func (r Rule) matches(host string, addr netip.Addr, port uint16, unresolved bool) bool {
+	if !r.matchesPort(port) {
+		return false
+	}
+	if r.CIDR.IsValid() {
+		if !addr.IsValid() {
+			return unresolved
+		}
+		return r.CIDR.Contains(addr.Unmap())
+	}
+	return matchesHost(r.Host, strings.ToLower(strings.TrimSuffix(host, ".")))
+}
+
+func (r Rule) matchesPort(port uint16) bool {
+	if len(r.Ports) == 0 {
+		return true
+	}
+	for _, pr := range r.Ports {
+		if port >= pr.First && port <= pr.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleSet holds the rules of a NetworkFilter, parsed and validated.
+type ruleSet struct {
+	allow, deny  []Rule
+	allowPrivate bool
+}
+
+// rules parses and validates the filter's patterns and rules. A nil filter has no rules.
+func (f *NetworkFilter) rules() (*ruleSet, error) {
+	rs := &ruleSet{}
+	if f == nil {
+		return rs, nil
+	}
+	rs.allowPrivate = f.AllowPrivateAddresses
+	var err error
+	if rs.allow, err = compileRules("AllowHosts", f.AllowHosts, "AllowRules", f.AllowRules); err != nil {
+		return nil, err
+	}
+	if rs.deny, err = compileRules("DenyHosts", f.DenyHosts, "DenyRules", f.DenyRules); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func compileRules(patternsField string, patterns []string, rulesField string, rules []Rule) ([]Rule, error) {
+	compiled := make([]Rule, 0, len(patterns)+len(rules))
+	for _, pattern := range patterns {
+		rule, err := ParseRule(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", patternsField, err)
+		}
+		compiled = append(compiled, rule)
+	}
+	for i, rule := range rules {
+		rule, err := rule.normalize()
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", rulesField, i, err)
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// decision is the outcome of checking a destination against a ruleSet.
+type decision struct {
+	allowed bool
+	rule    string // the rule that matched, if any
+	reason  string // one of the Reason constants
+}
+
+// decide checks a connection to host at addr and port. While a host name is not resolved
+// yet, addr is the zero Addr: deny rules by address cannot apply yet and allow rules by
+// address might, so the decision is only final once every address the name resolves to
+// has been checked too.
+func (rs *ruleSet) decide(host string, addr netip.Addr, port uint16) decision {
+	for _, r := range rs.deny {
+		if r.matches(host, addr, port, false) {
+			return decision{false, r.String(), ReasonDeniedByRule}
+		}
+	}
+	if len(rs.allow) == 0 {
+		return decision{true, "", ReasonNoAllowRules}
+	}
+	for _, r := range rs.allow {
+		if r.matches(host, addr, port, true) {
+			return decision{true, r.String(), ReasonAllowedByRule}
+		}
+	}
+	return decision{false, "", ReasonNoRuleMatched}
+}
+
+// allowsAddr reports whether the proxy may connect to addr on port: public addresses
+// always, other addresses if AllowPrivateAddresses is set or an allow rule by address
+// names them explicitly.
+func (rs *ruleSet) allowsAddr(addr netip.Addr, port uint16) bool {
+	if rs.allowPrivate || isPublicAddr(addr) {
+		return true
+	}
+	for _, r := range rs.allow {
+		if r.CIDR.IsValid() && r.matches("", addr, port, false) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package sandbox
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern string
+		want    Rule
+		str     string
+	}{
+		{"example.com", Rule{Host: "example.com"}, "example.com"},
+		{"*.Example.COM.", Rule{Host: "*.example.com"}, "*.example.com"},
+		{"example.com:443", Rule{Host: "example.com", Ports: []PortRange{{443, 443}}}, "example.com:443"},
+		{"example.com:80,443", Rule{Host: "example.com", Ports: []PortRange{{80, 80}, {443, 443}}}, "example.com:80,443"},
+		{"example.com:8000-8100,443", Rule{Host: "example.com", Ports: []PortRange{{8000, 8100}, {443, 443}}}, "example.com:8000-8100,443"},
+		{"203.0.113.7", Rule{CIDR: netip.MustParsePrefix("203.0.113.7/32")}, "203.0.113.7"},
+		{"10.0.0.0/8:443", Rule{CIDR: netip.MustParsePrefix("10.0.0.0/8"), Ports: []PortRange{{443, 443}}}, "10.0.0.0/8:443"},
+		{"10.1.2.3/8", Rule{CIDR: netip.MustParsePrefix("10.0.0.0/8")}, "10.0.0.0/8"},
+		{"::1", Rule{CIDR: netip.MustParsePrefix("::1/128")}, "::1"},
+		{"[::1]", Rule{CIDR: netip.MustParsePrefix("::1/128")}, "::1"},
+		{"[::1]:443", Rule{CIDR: netip.MustParsePrefix("::1/128"), Ports: []PortRange{{443, 443}}}, "[::1]:443"},
+		{"fd00::/8", Rule{CIDR: netip.MustParsePrefix("fd00::/8")}, "fd00::/8"},
+		{"[fd00::/8]:80-90", Rule{CIDR: netip.MustParsePrefix("fd00::/8"), Ports: []PortRange{{80, 90}}}, "[fd00::/8]:80-90"},
+		{"::ffff:10.0.0.0/104", Rule{CIDR: netip.MustParsePrefix("10.0.0.0/8")}, "10.0.0.0/8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			rule, err := ParseRule(tt.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rule)
+			assert.Equal(t, tt.str, rule.String())
+
+			reparsed, err := ParseRule(rule.String())
+			require.NoError(t, err)
+			assert.Equal(t, rule, reparsed)
+		})
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, pattern := range []string{
+		"",
+		":443",
+		"example.com:",
+		"example.com:https",
+		"example.com:0",
+		"example.com:65536",
+		"example.com:443,",
+		"example.com:100-80",
+		"example.com/8",
+		"exa mple.com",
+		"api.*.example.com",
+		"*",
+		"-example.com",
+		"10.0.0.0/33",
+		"[10.0.0.1]:80",
+		"[::1",
+		"[::1]443",
+		"[example.com]:443",
+		"fe80::1%eth0",
+	} {
+		t.Run(pattern, func(t *testing.T) {
+			_, err := ParseRule(pattern)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewNetworkProxyRejectsInvalidRules(t *testing.T) {
+	t.Parallel()
+
+	for _, filter := range []*NetworkFilter{
+		{AllowHosts: []string{"pypi.org", "files.pythonhosted.org:443:443"}},
+		{DenyHosts: []string{"10.0.0.0/40"}},
+		{AllowRules: []Rule{{}}},
+		{AllowRules: []Rule{{Host: "example.com", CIDR: netip.MustParsePrefix("10.0.0.0/8")}}},
+		{DenyRules: []Rule{{Host: "example.com", Ports: []PortRange{{443, 80}}}}},
+	} {
+		proxy, err := NewNetworkProxy(filter)
+		if !assert.Error(t, err, "%+v", filter) {
+			proxy.Close()
+		}
+	}
+}
+
+func TestNetworkFilter_Rules(t *testing.T) {
+	t.Parallel()
+
+	filter := &NetworkFilter{
+		AllowHosts: []string{"example.com:8000-8100,443", "[2001:db8::1]:443"},
+		AllowRules: []Rule{{Host: "*.example.org", Ports: []PortRange{{80, 80}}}},
+	}
+
+	assert.True(t, allowedBy(t, filter, "example.com", 443))
+	assert.True(t, allowedBy(t, filter, "example.com", 8050))
+	assert.True(t, allowedBy(t, filter, "EXAMPLE.com.", 8100))
+	assert.False(t, allowedBy(t, filter, "example.com", 80))
+	assert.False(t, allowedBy(t, filter, "example.com", 8101))
+
+	assert.True(t, allowedBy(t, filter, "2001:db8::1", 443))
+	assert.True(t, allowedBy(t, filter, "2001:db8:0::1", 443))
+	assert.False(t, allowedBy(t, filter, "2001:db8::1", 80))
+
+	assert.True(t, allowedBy(t, filter, "www.example.org", 80))
+	assert.False(t, allowedBy(t, filter, "www.example.org", 8080))
+
+	filter = &NetworkFilter{
+		AllowHosts: []string{"198.51.100.0/24"},
+		DenyHosts:  []string{"198.51.100.7"},
+	}
+
+	assert.True(t, allowedBy(t, filter, "198.51.100.1", 22))
+	assert.False(t, allowedBy(t, filter, "198.51.100.7", 22))
+	assert.False(t, allowedBy(t, filter, "198.51.101.1", 22))
+
+	// Names may resolve into an allowed block: dial decides once they are resolved
+	rs, err := filter.rules()
+	require.NoError(t, err)
+	assert.True(t, allowedBy(t, filter, "internal.example.net", 22))
+	assert.True(t, rs.decide("internal.example.net", netip.MustParseAddr("198.51.100.1"), 22).allowed)
+	assert.False(t, rs.decide("internal.example.net", netip.MustParseAddr("198.51.100.7"), 22).allowed)
+	assert.False(t, rs.decide("internal.example.net", netip.MustParseAddr("203.0.113.1"), 22).allowed)
+}
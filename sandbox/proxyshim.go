@@ -0,0 +1,119 @@
+package sandbox
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Loopback ports of the proxy shim. Most Python clients (urllib, requests, pip, httpx) do
+// not understand proxy URLs naming a Unix socket, so on Linux the bubblewrap backend
+// serves the NetworkProxy inside the sandbox on these TCP ports. The sandbox has a network
+// namespace of its own, so the ports never collide with the host's.
+const (
+	proxyShimHTTPPort  = 3128
+	proxyShimSOCKSPort = 1080
+)
+
+// helperModeProxy runs inside a sandbox: it listens on the proxy shim's loopback ports,
+// starts a forwarder process for them and then execs the target, which keeps the process
+// ID, process group and session that bubblewrap gave the shim.
+const helperModeProxy = "proxy"
+
+// helperModeForward relays connections accepted on the listeners inherited from the proxy
+// helper to the proxy's Unix sockets, until the target exits. The proxy helper runs it
+// twice: once to detach (see ProxyDetach) and once as the forwarder itself.
+const helperModeForward = "forward"
+
+// proxyForward is one loopback port of the proxy shim and the Unix socket it relays to.
+type proxyForward struct {
+	Port   int    `json:"port"`
+	Socket string `json:"socket"`
+}
+
+// proxyShimEnv returns the proxy variables for commands behind the proxy shim. SOCKS
+// clients are asked to resolve names through the proxy (socks5h), since the sandbox has
+// no DNS.
+func proxyShimEnv() []string {
+	httpURL := "http://127.0.0.1:" + strconv.Itoa(proxyShimHTTPPort)
+	socksURL := "socks5h://127.0.0.1:" + strconv.Itoa(proxyShimSOCKSPort)
+	return []string{
+		"HTTP_PROXY=" + httpURL,
+		"HTTPS_PROXY=" + httpURL,
+		"http_proxy=" + httpURL,
+		"https_proxy=" + httpURL,
+		"ALL_PROXY=" + socksURL,
+		"all_proxy=" + socksURL,
+	}
+}
+
+// runProxyForwarder serves the listeners inherited at descriptors 3 and up until the
+// target exits. It returns the process exit status.
+func runProxyForwarder(cfg *helperConfig) int {
+	if cfg.ProxyDetach {
+		return detachProxyForwarder(cfg)
+	}
+	// Graceful termination signals every process in the sandbox: leave them to the target,
+	// so that it can still reach the network while cleaning up.
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	if cfg.ProxyTarget {
+		go func() {
+			if err := waitProcessExit(3 + len(cfg.ProxyForwards)); err != nil {
+				fmt.Fprintf(os.Stderr, "boxedpy proxy forwarder: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}()
+	}
+
+	var wg sync.WaitGroup
+	for i, fw := range cfg.ProxyForwards {
+		ln, err := net.FileListener(os.NewFile(uintptr(3+i), "proxy-listener"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "boxedpy proxy forwarder: %v\n", err)
+			return 1
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveProxyForward(ln, fw.Socket)
+		}()
+	}
+	wg.Wait()
+	return 1
+}
+
+// serveProxyForward relays every connection accepted on ln to the Unix socket at path,
+// until ln is closed.
+func serveProxyForward(ln net.Listener, path string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			upstream, err := net.Dial("unix", path)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+			done := make(chan struct{})
+			go func() {
+				io.Copy(upstream, conn)
+				upstream.(*net.UnixConn).CloseWrite()
+				close(done)
+			}()
+			io.Copy(conn, upstream)
+			if tc, ok := conn.(*net.TCPConn); ok {
+				tc.CloseWrite()
+			}
+			<-done
+		}()
+	}
+}
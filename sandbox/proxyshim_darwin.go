@@ -0,0 +1,21 @@
+//go:build darwin
+
+package sandbox
+
+import "fmt"
+
+// startProxyForwarder is never requested on macOS, where Seatbelt lets commands reach the
+// proxy's loopback listeners directly.
+func startProxyForwarder(cfg *helperConfig) error {
+	return fmt.Errorf("the proxy shim is not supported on macOS")
+}
+
+// detachProxyForwarder is never requested on macOS.
+func detachProxyForwarder(cfg *helperConfig) int {
+	return 127
+}
+
+// waitProcessExit is never called on macOS.
+func waitProcessExit(fd int) error {
+	return fmt.Errorf("pidfds are not supported on macOS")
+}
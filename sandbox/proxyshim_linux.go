@@ -0,0 +1,124 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// sysPidfdOpen is pidfd_open(2), numbered identically on every architecture.
+const sysPidfdOpen = 434
+
+// startProxyForwarder listens on the loopback ports in cfg and starts a forwarder process
+// serving them until the calling process, which then execs the target, exits. The
+// forwarder is started by an intermediate process that exits at once, so that it is not a
+// child of the target, where a shell's wait or Python's os.wait would see it; it is
+// reparented to bubblewrap's init instead, and dies with the sandbox's PID namespace.
+func startProxyForwarder(cfg *helperConfig) error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, fw := range cfg.ProxyForwards {
+		ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(fw.Port)))
+		if err != nil {
+			return fmt.Errorf("proxy shim: %w", err)
+		}
+		f, err := ln.(*net.TCPListener).File()
+		ln.Close()
+		if err != nil {
+			return fmt.Errorf("proxy shim: %w", err)
+		}
+		files = append(files, f)
+	}
+	// The process keeps its ID when it execs the target, so this tracks the target
+	pidfd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(os.Getpid()), 0, 0)
+	if errno == syscall.ENOSYS {
+		// Before Linux 5.3 the forwarder stays a child of the target, killed when it exits
+		cmd, err := forwarderCommand(&helperConfig{ProxyForwards: cfg.ProxyForwards}, files)
+		if err != nil {
+			return err
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("proxy shim: start forwarder: %w", err)
+		}
+		return nil
+	}
+	if errno != 0 {
+		return fmt.Errorf("proxy shim: pidfd_open: %w", errno)
+	}
+	files = append(files, os.NewFile(pidfd, "target-pidfd"))
+
+	cmd, err := forwarderCommand(&helperConfig{ProxyForwards: cfg.ProxyForwards, ProxyDetach: true}, files)
+	if err != nil {
+		return err
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("proxy shim: start forwarder: %w", err)
+	}
+	return nil
+}
+
+// detachProxyForwarder runs in the intermediate process: it starts the forwarder with the
+// inherited listeners and target pidfd, and returns without waiting for it.
+func detachProxyForwarder(cfg *helperConfig) int {
+	files := make([]*os.File, len(cfg.ProxyForwards)+1)
+	for i := range files {
+		files[i] = os.NewFile(uintptr(3+i), "proxy-forwarder-file")
+	}
+	cmd, err := forwarderCommand(&helperConfig{ProxyForwards: cfg.ProxyForwards, ProxyTarget: true}, files)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "boxedpy proxy forwarder: %v\n", err)
+		return 1
+	}
+	// A session of its own keeps the forwarder out of the target's job control
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "boxedpy proxy forwarder: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// forwarderCommand returns the command that runs the forward helper mode with cfg,
+// passing files as descriptors 3 and up.
+func forwarderCommand(cfg *helperConfig, files []*os.File) (*exec.Cmd, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("proxy shim: %w", err)
+	}
+	cmd := exec.Command("/proc/self/exe", string(data), "--", "forward")
+	cmd.Args[0] = "boxedpy-proxy-forwarder"
+	cmd.Env = append(os.Environ(), helperEnvVar+"="+helperModeForward)
+	cmd.ExtraFiles = files
+	return cmd, nil
+}
+
+// waitProcessExit blocks until the process that the pidfd at descriptor fd refers to
+// has exited.
+func waitProcessExit(fd int) error {
+	pfd := [1]struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{{fd: int32(fd), events: 0x1}} // POLLIN
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd[0])), 1, 0, 0, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		default:
+			return errno
+		}
+	}
+}
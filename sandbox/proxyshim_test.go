@@ -0,0 +1,194 @@
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeProxyForward(t *testing.T) {
+	t.Parallel()
+
+	sock := filepath.Join(t.TempDir(), "echo.sock")
+	upstream, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go serveProxyForward(ln, sock)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data), "data is relayed both ways and EOF is propagated")
+}
+
+// TestIntegrationProxyShimHelper runs the proxy helper on the host, as it would run inside a
+// sandbox, and fetches a page through the proxy from the command it executes.
+func TestIntegrationProxyShimHelper(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("the proxy shim is only used on Linux")
+	}
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello through the shim")
+	})}
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(target)
+	defer srv.Close()
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowPrivateAddresses: true})
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	// A free port stands in for the shim's fixed port, which the host may be using
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	cfg, err := json.Marshal(&helperConfig{ProxyForwards: []proxyForward{
+		{Port: port, Socket: strings.TrimPrefix(proxy.HTTPAddr(), "unix://")},
+	}})
+	require.NoError(t, err)
+
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, string(cfg), "--", filepath.Base(pythonPath), "-c",
+		"import sys, urllib.request; print(urllib.request.urlopen(sys.argv[1], timeout=10).read().decode())",
+		"http://"+target.Addr().String()+"/")
+	cmd.Env = append(os.Environ(), helperEnvVar+"="+helperModeProxy,
+		"http_proxy=http://127.0.0.1:"+strconv.Itoa(port), "no_proxy=")
+	cmd.Env = append(cmd.Env, "PATH="+filepath.Dir(pythonPath)+":"+os.Getenv("PATH"))
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "%s", out)
+	line, _ := bufio.NewReader(strings.NewReader(string(out))).ReadString('\n')
+	assert.Equal(t, "hello through the shim", strings.TrimSpace(line))
+}
+
+// TestIntegrationProxyShimDetached checks that the forwarder is not a child of the target,
+// where waiting for any child would find it, and that it exits with the target.
+func TestIntegrationProxyShimDetached(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if runtime.GOOS != "linux" {
+		t.Skip("the proxy shim is only used on Linux")
+	}
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+	cfg, err := json.Marshal(&helperConfig{ProxyForwards: []proxyForward{
+		{Port: port, Socket: filepath.Join(t.TempDir(), "unused.sock")},
+	}})
+	require.NoError(t, err)
+
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, string(cfg), "--", pythonPath, "-c", `
+import os
+children = []
+for pid in filter(str.isdigit, os.listdir("/proc")):
+    try:
+        stat = open(f"/proc/{pid}/stat").read()
+    except OSError:
+        continue
+    if int(stat.rsplit(")", 1)[1].split()[1]) == os.getpid():
+        children.append(pid)
+print(len(children))
+`)
+	cmd.Env = append(os.Environ(), helperEnvVar+"="+helperModeProxy)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "%s", out)
+	assert.Equal(t, "0\n", string(out))
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, 10*time.Second, 50*time.Millisecond, "the forwarder exits with the target")
+}
+
+func TestIntegrationProxyShimPip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+	if err := exec.Command(pythonPath, "-m", "pip", "--version").Run(); err != nil {
+		t.Skip("pip is not installed")
+	}
+
+	proxy, err := NewNetworkProxy(&NetworkFilter{AllowHosts: []string{"pypi.org", "files.pythonhosted.org"}})
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	work := t.TempDir()
+	policy := pythonPolicy()
+	policy.WorkDir = work
+	policy.NetworkProxy = proxy
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := policy.Run(ctx, pythonPath, "-m", "pip", "download", "--no-deps", "--no-cache-dir",
+		"--disable-pip-version-check", "-d", work, "six==1.16.0")
+	require.NoError(t, err)
+	require.True(t, res.Success(), "%s", res.Output)
+	_, err = os.Stat(filepath.Join(work, "six-1.16.0-py2.py3-none-any.whl"))
+	assert.NoError(t, err)
+
+	// Hosts outside the allowlist stay unreachable
+	res, err = policy.Run(ctx, pythonPath, "-c",
+		"import urllib.request; urllib.request.urlopen('https://example.com', timeout=10)")
+	require.NoError(t, err)
+	assert.False(t, res.Success(), "%s", res.Output)
+}
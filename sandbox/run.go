@@ -0,0 +1,192 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Result is the outcome of a run started by Policy.Run.
+type Result struct {
+	// Stdout and Stderr hold what the command wrote to each stream, within the policy's
+	// OutputLimits.
+	Stdout []byte
+	Stderr []byte
+
+	// Output holds both streams interleaved in the order the writes were received, as a
+	// terminal would show them.
+	Output []byte
+
+	// Truncated reports whether Stdout, Stderr or Output lack bytes the command wrote,
+	// because of OutputLimits.MaxBytes or KillBytes.
+	Truncated bool
+
+	// StdoutFile and StderrFile name the files holding the complete streams when
+	// OutputLimits.SpillDir is set.
+	StdoutFile string
+	StderrFile string
+
+	// ExitCode is the exit status of the run, or -1 if it was killed by a signal.
+	ExitCode int
+
+	// Signal is the signal that terminated the command, or zero. On Linux, bubblewrap
+	// reports a command killed inside the sandbox as exit status 128+signal; such runs have
+	// both ExitCode (e.g., 130) and Signal (e.g., SIGINT) set. With other backends, an exit
+	// status above 128 is the command's own and leaves Signal zero.
+	Signal syscall.Signal
+
+	// WallTime is the elapsed real time from start to exit.
+	WallTime time.Duration
+
+	// CPUTime is the user and system CPU time consumed by the sandbox's processes.
+	CPUTime time.Duration
+
+	// PeakRSS is the largest resident set size, in bytes, of any process of the run.
+	PeakRSS uint64
+
+	// TimedOut reports whether the run was stopped because the context's deadline passed
+	// or Limits.WallTime elapsed.
+	TimedOut bool
+
+	// Termination reports whether and how the sandbox stopped the run (see Termination).
+	Termination TerminationStage
+
+	// Err is the error returned by Wait, passed through CheckLimits: nil if the command
+	// exited with status 0, an *exec.ExitError for other exit statuses, or a *LimitError if
+	// a limit ended the run.
+	Err error
+}
+
+// Success reports whether the command exited with status 0.
+func (r *Result) Success() bool {
+	return r.Err == nil
+}
+
+// Run runs the specified command inside a sandbox, as Command does, and returns its
+// output and resource usage once it has exited. Standard input is empty. The error is
+// only non-nil if the command could not be started; how it exited is reported in the
+// Result.
+//
+// Example:
+//
+//	res, err := policy.Run(ctx, "python3", "-c", "print('hello')")
+//	if err != nil {
+//	    return err
+//	}
+//	if !res.Success() {
+//	    log.Printf("exit %d after %v: %s", res.ExitCode, res.WallTime, res.Stderr)
+//	}
+func (p *Policy) Run(ctx context.Context, name string, arg ...string) (*Result, error) {
+	cmd, err := p.Command(ctx, name, arg...)
+	if err != nil {
+		return nil, err
+	}
+	return RunCommand(ctx, cmd)
+}
+
+// RunCommand starts a command created by Policy.Command or Session.Command, collects its
+// output within the policy's OutputLimits and waits for it, as Policy.Run does. It lets
+// callers set Stdin, Env or other fields of the Cmd first. Stdout and Stderr must not be
+// set. ctx must be the context the command was created with.
+//
+// If the output could not be written to the spill files, the Result is returned together
+// with the error. The command's resources are freed before RunCommand returns, as by Release.
+func RunCommand(ctx context.Context, cmd *exec.Cmd) (*Result, error) {
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return nil, fmt.Errorf("sandbox: Stdout and Stderr must not be set")
+	}
+	defer Release(cmd)
+	var limits OutputLimits
+	var exceeded func()
+	var bwrap bool
+	if st := stateOf(cmd); st != nil {
+		limits = st.output
+		exceeded = st.outputExceeded
+		bwrap = st.bwrap
+	}
+	res := &Result{}
+	var stdoutSpill, stderrSpill *os.File
+	if limits.SpillDir != "" {
+		var err error
+		if stdoutSpill, err = os.CreateTemp(limits.SpillDir, "boxedpy-stdout-*.log"); err != nil {
+			return nil, fmt.Errorf("sandbox: spill output: %w", err)
+		}
+		defer stdoutSpill.Close()
+		if stderrSpill, err = os.CreateTemp(limits.SpillDir, "boxedpy-stderr-*.log"); err != nil {
+			os.Remove(stdoutSpill.Name())
+			return nil, fmt.Errorf("sandbox: spill output: %w", err)
+		}
+		defer stderrSpill.Close()
+		res.StdoutFile, res.StderrFile = stdoutSpill.Name(), stderrSpill.Name()
+	}
+	out := newOutputCollector(limits, exceeded)
+	cmd.Stdout = out.stream(&out.stdout, stdoutSpill)
+	cmd.Stderr = out.stream(&out.stderr, stderrSpill)
+
+	// Record whether the context actually stopped the command: its deadline may also pass
+	// after the command exited on its own, before Wait returns
+	var stopped atomic.Bool
+	if cancel := cmd.Cancel; cancel != nil {
+		cmd.Cancel = func() error {
+			err := cancel()
+			if !errors.Is(err, os.ErrProcessDone) {
+				stopped.Store(true)
+			}
+			return err
+		}
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		if stdoutSpill != nil {
+			os.Remove(stdoutSpill.Name())
+			os.Remove(stderrSpill.Name())
+		}
+		return nil, fmt.Errorf("sandbox: start: %w", err)
+	}
+	err := cmd.Wait()
+	wall := time.Since(start)
+	state := cmd.ProcessState
+	if state == nil {
+		return nil, fmt.Errorf("sandbox: wait: %w", err)
+	}
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	*res = Result{
+		Stdout:      out.stdout.Bytes(),
+		Stderr:      out.stderr.Bytes(),
+		Output:      out.combined.Bytes(),
+		Truncated:   out.killed || out.stdout.truncated() || out.stderr.truncated() || out.combined.truncated(),
+		StdoutFile:  res.StdoutFile,
+		StderrFile:  res.StderrFile,
+		ExitCode:    state.ExitCode(),
+		WallTime:    wall,
+		CPUTime:     state.UserTime() + state.SystemTime(),
+		Termination: TerminationOf(cmd),
+		Err:         CheckLimits(cmd, err),
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok {
+		res.Signal = exitSignal(ws, bwrap)
+	}
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok && ru.Maxrss > 0 {
+		res.PeakRSS = uint64(ru.Maxrss)
+		if runtime.GOOS == "linux" {
+			// Reported in kilobytes on Linux and in bytes on macOS
+			res.PeakRSS *= 1024
+		}
+	}
+	var limitErr *LimitError
+	res.TimedOut = stopped.Load() && (errors.Is(ctx.Err(), context.DeadlineExceeded) ||
+		(errors.As(res.Err, &limitErr) && limitErr.Limit == LimitWallTime))
+	if out.spillErr != nil {
+		return res, fmt.Errorf("sandbox: spill output: %w", out.spillErr)
+	}
+	return res, nil
+}
@@ -0,0 +1,108 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RunCommand is tested with unsandboxed commands so that result collection can be checked
+// without a sandbox backend; Policy.Run only adds Command.
+func TestRunCommand(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("output and exit status", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", "echo out; sleep 0.05; echo err >&2; sleep 0.05; echo out2; exit 3")
+		res, err := RunCommand(ctx, cmd)
+		require.NoError(t, err)
+		assert.Equal(t, "out\nout2\n", string(res.Stdout))
+		assert.Equal(t, "err\n", string(res.Stderr))
+		assert.Equal(t, "out\nerr\nout2\n", string(res.Output))
+		assert.Equal(t, 3, res.ExitCode)
+		assert.Zero(t, res.Signal)
+		assert.False(t, res.Success())
+		assert.False(t, res.TimedOut)
+		assert.GreaterOrEqual(t, res.WallTime, 100*time.Millisecond)
+		assert.Positive(t, res.PeakRSS)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		t.Parallel()
+		res, err := RunCommand(ctx, exec.CommandContext(ctx, "true"))
+		require.NoError(t, err)
+		assert.True(t, res.Success())
+		assert.Zero(t, res.ExitCode)
+		assert.NoError(t, res.Err)
+	})
+
+	t.Run("signal", func(t *testing.T) {
+		t.Parallel()
+		res, err := RunCommand(ctx, exec.CommandContext(ctx, "sh", "-c", "kill -TERM $$"))
+		require.NoError(t, err)
+		assert.Equal(t, -1, res.ExitCode)
+		assert.Equal(t, syscall.SIGTERM, res.Signal)
+	})
+
+	t.Run("exit status above 128", func(t *testing.T) {
+		t.Parallel()
+		res, err := RunCommand(ctx, exec.CommandContext(ctx, "sh", "-c", "exit 152"))
+		require.NoError(t, err)
+		assert.Equal(t, 152, res.ExitCode)
+		assert.Zero(t, res.Signal, "only bubblewrap reports signals as exit statuses")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		res, err := RunCommand(ctx, exec.CommandContext(ctx, "sleep", "5"))
+		require.NoError(t, err)
+		assert.True(t, res.TimedOut)
+		assert.Equal(t, syscall.SIGKILL, res.Signal)
+		assert.Less(t, res.WallTime, 5*time.Second)
+	})
+
+	t.Run("deadline after exit", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		// The shell exits at once, but Wait only returns once the background sleep has
+		// closed the output, after the deadline
+		res, err := RunCommand(ctx, exec.CommandContext(ctx, "sh", "-c", "sleep 0.3 & exit 0"))
+		require.NoError(t, err)
+		require.Error(t, ctx.Err())
+		assert.True(t, res.Success())
+		assert.False(t, res.TimedOut)
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.CommandContext(ctx, "cat")
+		cmd.Stdin = bytes.NewReader([]byte("input"))
+		res, err := RunCommand(ctx, cmd)
+		require.NoError(t, err)
+		assert.Equal(t, "input", string(res.Stdout))
+	})
+
+	t.Run("output already set", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.CommandContext(ctx, "true")
+		cmd.Stdout = &bytes.Buffer{}
+		_, err := RunCommand(ctx, cmd)
+		assert.ErrorContains(t, err, "must not be set")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		t.Parallel()
+		_, err := RunCommand(ctx, exec.CommandContext(ctx, "boxedpy-no-such-command"))
+		assert.ErrorContains(t, err, "sandbox: start")
+	})
+}
@@ -0,0 +1,417 @@
+package sandboxtest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checks maps each property to the function checking it.
+var checks = map[Property]func(ctx context.Context, s *suite) Result{
+	WriteOutsideMounts: checkWriteOutsideMounts,
+	ReadHome:           checkReadHome,
+	Network:            checkNetwork,
+	PtraceParent:       checkPtraceParent,
+	TerminalInjection:  checkTerminalInjection,
+	ProcSelfExe:        checkProcSelfExe,
+	SymlinkRace:        checkSymlinkRace,
+	ProxySocketDir:     checkProxySocketDir,
+}
+
+func checkWriteOutsideMounts(ctx context.Context, s *suite) Result {
+	// Each target is a sandbox path and the host path a successful write would create.
+	type target struct{ sandbox, host string }
+	var targets []target
+	home, _ := os.UserHomeDir()
+	for _, parent := range []string{home, os.TempDir()} {
+		if dir := s.scratch(parent); dir != "" {
+			path := filepath.Join(dir, "written")
+			targets = append(targets, target{path, path})
+		}
+	}
+	name := ".boxedpy-sandboxtest-" + s.token[:8]
+	for _, m := range s.policy.ReadOnlyMounts {
+		if info, err := os.Stat(m.Source); err == nil && info.IsDir() {
+			targets = append(targets, target{filepath.Join(m.Target, name), filepath.Join(m.Source, name)})
+		}
+	}
+	if len(targets) == 0 {
+		return skipped("no read-only mounts, and the home and temporary directories are mounted")
+	}
+
+	args := make([]string, len(targets))
+	for i, t := range targets {
+		args[i] = t.sandbox
+	}
+	pr, err := s.probe(ctx, nil, `
+for p in sys.argv[1:]:
+    attempt("create " + p, lambda: open(p, "x").write("boxedpy"))
+`, args...)
+	if err != nil {
+		return failed(err)
+	}
+
+	// Writes to a private tmpfs succeed inside the sandbox; only the host matters.
+	var problems []string
+	for _, t := range targets {
+		if _, err := os.Lstat(t.host); err == nil {
+			problems = append(problems, "created "+t.host+" on the host")
+			os.Remove(t.host)
+		}
+	}
+	return pr.verdict(func(attempt) bool { return false }, problems,
+		fmt.Sprintf("no write reached the host (%d paths)", len(targets)))
+}
+
+func checkReadHome(ctx context.Context, s *suite) Result {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return skipped("no home directory: %v", err)
+	}
+	if s.exposed(home) {
+		return skipped("the policy mounts the home directory %s", home)
+	}
+	secret := s.secret(home)
+	if secret == "" {
+		return skipped("cannot create a file in the home directory %s", home)
+	}
+
+	pr, err := s.probe(ctx, nil, `
+attempt("read " + sys.argv[1], lambda: open(sys.argv[1]).read())
+attempt("list " + os.path.dirname(sys.argv[1]), lambda: os.listdir(os.path.dirname(sys.argv[1])))
+`, secret)
+	if err != nil {
+		return failed(err)
+	}
+	var problems []string
+	if s.leaked(pr) {
+		problems = append(problems, "the content of "+secret+" was read")
+	}
+	return pr.verdict(always, problems, "files in "+home+" are not readable")
+}
+
+func checkNetwork(ctx context.Context, s *suite) Result {
+	if s.policy.AllowNetwork {
+		return skipped("the policy sets AllowNetwork")
+	}
+	if s.policy.AllowLocalhostOnly && runtime.GOOS == "darwin" {
+		return skipped("AllowLocalhostOnly allows connections to the host's loopback addresses on macOS")
+	}
+
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return failed(err)
+	}
+	defer ln.Close()
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return failed(err)
+	}
+	defer pc.Close()
+
+	hosts := []string{"127.0.0.1"}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() && len(hosts) < 5 {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+	args := append([]string{portOf(ln.Addr()), portOf(pc.LocalAddr())}, hosts...)
+	pr, err := s.probe(ctx, nil, `
+import socket
+tcp, udp = int(sys.argv[1]), int(sys.argv[2])
+for host in sys.argv[3:]:
+    family = socket.AF_INET6 if ":" in host else socket.AF_INET
+    attempt("tcp " + host, lambda: socket.create_connection((host, tcp), timeout=2).close())
+    attempt("udp " + host, lambda: socket.socket(family, socket.SOCK_DGRAM).sendto(b"boxedpy", (host, udp)))
+`, args...)
+	if err != nil {
+		return failed(err)
+	}
+
+	// Completed connections and datagrams are queued by the kernel even if the probe
+	// has exited, so a short deadline is enough to collect them.
+	var problems []string
+	deadline := time.Now().Add(100 * time.Millisecond)
+	ln.(*net.TCPListener).SetDeadline(deadline)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		problems = append(problems, "accepted a TCP connection from "+conn.RemoteAddr().String())
+		conn.Close()
+	}
+	pc.SetReadDeadline(deadline)
+	buf := make([]byte, 64)
+	for {
+		_, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		problems = append(problems, "received a UDP datagram from "+addr.String())
+	}
+	// A datagram sent on the sandbox's own loopback is "allowed" without reaching the host.
+	return pr.verdict(func(attempt) bool { return false }, problems,
+		"no connection or datagram reached "+strings.Join(hosts, ", "))
+}
+
+func checkPtraceParent(ctx context.Context, s *suite) Result {
+	// PTRACE_SEIZE does not stop the tracee, so a successful attach cannot hang the
+	// suite; the tracee is detached when the probe exits. macOS has no such request.
+	attach := "0x4206" // PTRACE_SEIZE
+	if runtime.GOOS == "darwin" {
+		attach = "14" // PT_ATTACHEXC
+	}
+	pr, err := s.probe(ctx, nil, `
+import ctypes
+libc = ctypes.CDLL(None, use_errno=True)
+def ptrace(pid):
+    def f():
+        if libc.ptrace(int(sys.argv[1]), pid, None, 0) != 0:
+            errno = ctypes.get_errno()
+            raise OSError(errno, os.strerror(errno))
+        if sys.platform == "darwin":
+            libc.ptrace(11, pid, ctypes.c_void_p(1), 0)  # PT_DETACH
+    return f
+for pid in sorted({os.getppid(), 1, int(sys.argv[2])}):
+    if pid > 0:
+        attempt("attach to pid %d" % pid, ptrace(pid))
+`, attach, strconv.Itoa(os.Getpid()))
+	if err != nil {
+		return failed(err)
+	}
+	return pr.verdict(always, nil, "ptrace attach to the parent, pid 1 and the host process was denied")
+}
+
+func checkTerminalInjection(ctx context.Context, s *suite) Result {
+	if s.policy.AllowSessionControl {
+		return skipped("the policy sets AllowSessionControl")
+	}
+	master, tty, err := openPTY()
+	if err != nil {
+		return skipped("cannot open a pseudo-terminal: %v", err)
+	}
+	defer master.Close()
+	defer tty.Close()
+
+	// Make the terminal the controlling terminal of the session the sandbox is started
+	// from, as when a program runs in an interactive shell. Backends that start the
+	// sandbox in a new session themselves are left alone.
+	setup := func(cmd *exec.Cmd) {
+		cmd.Stdin = tty
+		setControllingTerminal(cmd)
+	}
+	pr, err := s.probe(ctx, setup, `
+import fcntl, termios
+attempt("TIOCSTI on standard input", lambda: fcntl.ioctl(0, termios.TIOCSTI, b" "))
+def tty():
+    fd = os.open("/dev/tty", os.O_RDWR)
+    try:
+        fcntl.ioctl(fd, termios.TIOCSTI, b" ")
+    finally:
+        os.close(fd)
+attempt("TIOCSTI on /dev/tty", tty)
+`)
+	if err != nil {
+		return failed(err)
+	}
+	return pr.verdict(always, nil, "TIOCSTI was denied on the inherited terminal")
+}
+
+func checkProcSelfExe(ctx context.Context, s *suite) Result {
+	if runtime.GOOS != "linux" {
+		return skipped("no /proc on %s", runtime.GOOS)
+	}
+	home, _ := os.UserHomeDir()
+	secret := s.secret(home)
+	if secret == "" {
+		secret = s.secret(os.TempDir())
+	}
+
+	pr, err := s.probe(ctx, nil, `
+secret, host = sys.argv[1], sys.argv[2]
+for pid in ["self", str(os.getppid()), "1", host]:
+    attempt("write /proc/%s/exe" % pid, lambda: open("/proc/%s/exe" % pid, "r+b").close())
+    if pid != "self":
+        attempt("write /proc/%s/mem" % pid, lambda: open("/proc/%s/mem" % pid, "r+b").close())
+    if secret:
+        attempt("read /proc/%s/root%s" % (pid, secret), lambda: open("/proc/%s/root%s" % (pid, secret)).read())
+`, secret, strconv.Itoa(os.Getpid()))
+	if err != nil {
+		return failed(err)
+	}
+	var problems []string
+	if s.leaked(pr) {
+		problems = append(problems, "the content of "+secret+" was read through /proc")
+	}
+	return pr.verdict(always, problems, "/proc did not give write access to executables or memory, or access to host files")
+}
+
+func checkSymlinkRace(ctx context.Context, s *suite) Result {
+	home, _ := os.UserHomeDir()
+	secret := s.secret(home)
+	if secret == "" {
+		secret = s.secret(os.TempDir())
+	}
+	if secret == "" {
+		return skipped("the home and temporary directories are mounted")
+	}
+	wd := s.workDir()
+	if wd == "" {
+		return skipped("the working directory is not known")
+	}
+	dir, err := os.MkdirTemp(wd, ".boxedpy-sandboxtest-*")
+	if err != nil {
+		return skipped("cannot create a directory in the working directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// Links a previous run could have left behind, pointing to the secret outside the
+	// mounts.
+	if err := os.Symlink(secret, filepath.Join(dir, "planted")); err != nil {
+		return failed(err)
+	}
+	if err := os.Symlink(filepath.Dir(secret), filepath.Join(dir, "planted-dir")); err != nil {
+		return failed(err)
+	}
+
+	pr, err := s.probe(ctx, nil, `
+import threading, time
+d, secret = sys.argv[1], sys.argv[2]
+attempt("read planted link", lambda: open(os.path.join(d, "planted")).read())
+attempt("list planted directory link", lambda: os.listdir(os.path.join(d, "planted-dir")))
+attempt("hard link the secret", lambda: os.link(secret, os.path.join(d, "hardlink")))
+
+# Swap a path between a regular file and a link to the secret while reading it.
+race, stop, leaked = os.path.join(d, "race"), threading.Event(), []
+def swap():
+    i = 0
+    while not stop.is_set():
+        tmp = race + ".tmp"
+        try:
+            if i % 2:
+                os.symlink(secret, tmp)
+            else:
+                with open(tmp, "w") as f:
+                    f.write("decoy")
+            os.replace(tmp, race)
+        except OSError:
+            pass
+        i += 1
+t = threading.Thread(target=swap)
+t.start()
+deadline = time.monotonic() + 1
+while time.monotonic() < deadline and not leaked:
+    try:
+        with open(race) as f:
+            data = f.read()
+    except OSError:
+        continue
+    if data != "decoy":
+        leaked.append(data)
+stop.set()
+t.join()
+attempt("read through a swapped link", lambda: leaked[0])
+`, filepath.Base(dir), secret)
+	if err != nil {
+		return failed(err)
+	}
+	var problems []string
+	if s.leaked(pr) {
+		problems = append(problems, "the content of "+secret+" was read")
+	}
+	if info, err := os.Stat(filepath.Join(dir, "hardlink")); err == nil {
+		if orig, err := os.Stat(secret); err == nil && os.SameFile(info, orig) {
+			problems = append(problems, "a hard link to "+secret+" was created on the host")
+		}
+	}
+	return pr.verdict(always, problems, "links did not lead outside the mounts")
+}
+
+func checkProxySocketDir(ctx context.Context, s *suite) Result {
+	proxy := s.policy.NetworkProxy
+	if proxy == nil {
+		return skipped("the policy has no NetworkProxy")
+	}
+	httpSock, ok := strings.CutPrefix(proxy.HTTPAddr(), "unix://")
+	if !ok {
+		return skipped("the proxy listens on %s", proxy.HTTPAddr())
+	}
+	dir := filepath.Dir(httpSock)
+	before, err := snapshot(dir)
+	if err != nil {
+		return failed(err)
+	}
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pr, err := s.probe(ctx, nil, `
+import socket
+d = sys.argv[1]
+attempt("create a file", lambda: open(os.path.join(d, "evil"), "x").close())
+for name in sys.argv[2:]:
+    p = os.path.join(d, name)
+    attempt("rename " + name, lambda: os.rename(p, p + ".moved"))
+    attempt("remove " + name, lambda: os.unlink(p))
+def bind():
+    s = socket.socket(socket.AF_UNIX)
+    s.bind(os.path.join(d, "evil.sock"))
+attempt("bind a socket", bind)
+`, append([]string{dir}, names...)...)
+	if err != nil {
+		return failed(err)
+	}
+
+	// Inside the sandbox, the directory may be a private copy holding bind mounts of the
+	// sockets; only changes visible on the host matter.
+	after, err := snapshot(dir)
+	if err != nil {
+		return failed(err)
+	}
+	var problems []string
+	for name, info := range after {
+		if orig, ok := before[name]; !ok {
+			problems = append(problems, "created "+filepath.Join(dir, name)+" on the host")
+		} else if !os.SameFile(orig, info) {
+			problems = append(problems, "replaced "+filepath.Join(dir, name)+" on the host")
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			problems = append(problems, "removed "+filepath.Join(dir, name)+" on the host")
+		}
+	}
+	return pr.verdict(func(attempt) bool { return false }, problems, dir+" was not modified")
+}
+
+// snapshot returns the entries of dir.
+func snapshot(dir string) (map[string]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]os.FileInfo, len(entries))
+	for _, e := range entries {
+		if infos[e.Name()], err = e.Info(); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+func portOf(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
@@ -0,0 +1,57 @@
+//go:build darwin
+
+package sandboxtest
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl requests from <sys/ttycom.h>.
+const (
+	tiocptygrant = 0x20007454
+	tiocptyunlk  = 0x20007452
+	tiocptygname = 0x40807453
+)
+
+// openPTY opens a new pseudo-terminal and returns its master and terminal sides.
+func openPTY() (master, tty *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var name [128]byte
+	for _, req := range []uintptr{tiocptygrant, tiocptyunlk, tiocptygname} {
+		var arg unsafe.Pointer
+		if req == tiocptygname {
+			arg = unsafe.Pointer(&name[0])
+		}
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), req, uintptr(arg)); errno != 0 {
+			master.Close()
+			return nil, nil, errno
+		}
+	}
+	if i := bytes.IndexByte(name[:], 0); i >= 0 {
+		tty, err = os.OpenFile(string(name[:i]), os.O_RDWR|syscall.O_NOCTTY, 0)
+	} else {
+		err = syscall.EINVAL
+	}
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
+
+// setControllingTerminal starts cmd in a new session controlled by its standard input.
+func setControllingTerminal(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+}
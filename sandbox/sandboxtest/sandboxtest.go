@@ -0,0 +1,391 @@
+// Package sandboxtest checks that a sandbox.Policy enforces its isolation on the current
+// host. Run starts one Python probe per property in a sandbox created from the policy; each
+// probe attempts an escape (writing outside the mounts, reading the home directory, reaching
+// the network, ptracing its parent, ...) and the host verifies that the attempt had no
+// effect. Properties the policy does not promise, such as the network when AllowNetwork is
+// set, are skipped.
+//
+// The policy must let Python run: on macOS, Homebrew installations need their prefix in
+// ReadOnlyMounts. Use Test from your own test suite to check the policies you ship:
+//
+//	func TestSandboxConformance(t *testing.T) {
+//	    if testing.Short() {
+//	        t.Skip("integration test")
+//	    }
+//	    sandboxtest.Test(t, myapp.SandboxPolicy(), nil)
+//	}
+package sandboxtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bpowers/boxedpy/sandbox"
+)
+
+// Property names an isolation property checked by the suite.
+type Property string
+
+const (
+	// WriteOutsideMounts: files cannot be created in read-only mounts or in host
+	// directories that are not mounted.
+	WriteOutsideMounts Property = "write-outside-mounts"
+
+	// ReadHome: files in the home directory cannot be read unless it is mounted.
+	ReadHome Property = "read-home"
+
+	// Network: TCP connections and UDP datagrams to the host's addresses do not arrive.
+	// Skipped if AllowNetwork is set, or AllowLocalhostOnly on macOS.
+	Network Property = "network"
+
+	// PtraceParent: the sandbox cannot attach to its parent, to pid 1 or to the process
+	// running the suite.
+	PtraceParent Property = "ptrace-parent"
+
+	// TerminalInjection: the sandbox cannot push input into a terminal inherited as
+	// standard input with TIOCSTI. Skipped if AllowSessionControl is set.
+	TerminalInjection Property = "terminal-injection"
+
+	// ProcSelfExe: /proc/<pid>/exe and /proc/<pid>/mem of the sandbox's ancestors cannot be
+	// opened for writing, and /proc/<pid>/root does not lead to unmounted host files (Linux).
+	ProcSelfExe Property = "proc-self-exe"
+
+	// SymlinkRace: symbolic links planted in the working directory, links swapped while
+	// being opened and hard links do not give access to unmounted host files.
+	SymlinkRace Property = "symlink-race"
+
+	// ProxySocketDir: no entry can be created, renamed or removed in the directory holding
+	// a NetworkProxy's Unix sockets, so the sockets cannot be replaced for other sandboxes
+	// (Linux; skipped without NetworkProxy).
+	ProxySocketDir Property = "proxy-socket-dir"
+)
+
+// Properties returns all properties checked by the suite, in the order Run checks them.
+func Properties() []Property {
+	return []Property{
+		WriteOutsideMounts,
+		ReadHome,
+		Network,
+		PtraceParent,
+		TerminalInjection,
+		ProcSelfExe,
+		SymlinkRace,
+		ProxySocketDir,
+	}
+}
+
+// Status is the outcome of checking a property.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	Skip Status = "skip"
+)
+
+// Result reports the outcome of checking one property.
+type Result struct {
+	Property Property
+	Status   Status
+
+	// Detail explains the outcome: what was attempted, what got through, or why the
+	// property was skipped.
+	Detail string
+
+	// Output is the probe's combined output, one line per attempt.
+	Output string
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%s: %s: %s", r.Property, r.Status, r.Detail)
+}
+
+// Options configures Run. The zero value checks every property with python3 from PATH.
+type Options struct {
+	// Python is the interpreter started inside the sandbox (default: python3 or python
+	// from PATH). It must be reachable through the policy's mounts.
+	Python string
+
+	// Properties restricts the suite to the listed properties (default: Properties()).
+	Properties []Property
+}
+
+// Run checks the properties in opts against sandboxes created from p and reports one
+// Result per property. The error is only non-nil if the suite could not run at all, for
+// example because Python cannot start inside the sandbox; a probe that fails for any other
+// reason fails its property, since it could not be verified.
+//
+// The policy is used as is, except that the terminal-injection probe gets a pseudo-terminal
+// as standard input. Probes create and remove scratch files in the home directory, the
+// temporary directory and the policy's working directory.
+func Run(ctx context.Context, p *sandbox.Policy, opts *Options) ([]Result, error) {
+	if p == nil {
+		return nil, errors.New("sandboxtest: policy is nil")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	props := opts.Properties
+	if len(props) == 0 {
+		props = Properties()
+	}
+	for _, prop := range props {
+		if checks[prop] == nil {
+			return nil, fmt.Errorf("sandboxtest: unknown property %q", prop)
+		}
+	}
+
+	python := opts.Python
+	if python == "" {
+		for _, name := range []string{"python3", "python"} {
+			if path, err := exec.LookPath(name); err == nil {
+				python = path
+				break
+			}
+		}
+		if python == "" {
+			return nil, errors.New("sandboxtest: python not found in PATH")
+		}
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("sandboxtest: %w", err)
+	}
+	s := &suite{policy: p, python: python, token: hex.EncodeToString(token)}
+	defer s.cleanup()
+
+	if res, err := p.Run(ctx, python, "-c", "print('ok')"); err != nil {
+		return nil, fmt.Errorf("sandboxtest: %w", err)
+	} else if !res.Success() {
+		return nil, fmt.Errorf("sandboxtest: %s cannot run in the sandbox: %v\n%s", python, res.Err, res.Output)
+	}
+
+	results := make([]Result, 0, len(props))
+	for _, prop := range props {
+		res := checks[prop](ctx, s)
+		res.Property = prop
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// Test runs the suite as Run does and reports each property as a subtest of t, which fails
+// for properties that do not hold and is skipped for properties the policy does not
+// promise.
+func Test(t *testing.T, p *sandbox.Policy, opts *Options) {
+	t.Helper()
+	results, err := Run(context.Background(), p, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		t.Run(string(res.Property), func(t *testing.T) {
+			switch res.Status {
+			case Skip:
+				t.Skip(res.Detail)
+			case Fail:
+				t.Errorf("%s\nprobe output:\n%s", res.Detail, res.Output)
+			default:
+				t.Log(res.Detail)
+			}
+		})
+	}
+}
+
+// suite holds the state shared by the checks of one Run.
+type suite struct {
+	policy *sandbox.Policy
+	python string
+
+	// token is the content of every secret file; it must never appear in a probe's output.
+	token string
+
+	cleanups []func()
+}
+
+func (s *suite) cleanup() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i]()
+	}
+}
+
+// probe runs script with the attempt helper in the sandbox. setup, if not nil, may adjust
+// the command before it starts.
+func (s *suite) probe(ctx context.Context, setup func(*exec.Cmd), script string, args ...string) (*probeResult, error) {
+	cmd, err := s.policy.Command(ctx, s.python, append([]string{"-c", attemptPrelude + script}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	if setup != nil {
+		setup(cmd)
+	}
+	res, err := sandbox.RunCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	pr := &probeResult{output: string(res.Output), err: res.Err}
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) == 3 {
+			pr.attempts = append(pr.attempts, attempt{label: fields[0], allowed: fields[1] == "allowed", detail: fields[2]})
+		}
+	}
+	return pr, nil
+}
+
+// secret creates a file holding the suite's token in a new directory below parent, and
+// returns its path. It returns "" if parent is visible in the sandbox or cannot be used.
+func (s *suite) secret(parent string) string {
+	dir := s.scratch(parent)
+	if dir == "" {
+		return ""
+	}
+	path := filepath.Join(dir, "secret")
+	if err := os.WriteFile(path, []byte(s.token), 0o644); err != nil {
+		return ""
+	}
+	return path
+}
+
+// scratch creates a directory below parent, removed when the suite ends. It returns "" if
+// parent is visible in the sandbox or cannot be used.
+func (s *suite) scratch(parent string) string {
+	if parent == "" || s.exposed(parent) {
+		return ""
+	}
+	dir, err := os.MkdirTemp(parent, ".boxedpy-sandboxtest-*")
+	if err != nil {
+		return ""
+	}
+	s.cleanups = append(s.cleanups, func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// exposed reports whether the host path lies inside one of the policy's mounts or its
+// working directory.
+func (s *suite) exposed(path string) bool {
+	path = canonical(path)
+	for _, dir := range s.visible() {
+		if within(path, canonical(dir)) {
+			return true
+		}
+	}
+	return false
+}
+
+// visible returns the host directories the policy mounts into the sandbox.
+func (s *suite) visible() []string {
+	var dirs []string
+	for _, m := range s.policy.ReadOnlyMounts {
+		dirs = append(dirs, m.Source)
+	}
+	for _, m := range s.policy.ReadWriteMounts {
+		dirs = append(dirs, m.Source)
+	}
+	if wd := s.workDir(); wd != "" {
+		dirs = append(dirs, wd)
+	}
+	return dirs
+}
+
+// workDir returns the host directory the sandbox starts in, or "" if it is not known.
+func (s *suite) workDir() string {
+	wd := s.policy.WorkDir
+	if wd == "" {
+		wd, _ = os.Getwd()
+		return wd
+	}
+	// WorkDir may be a sandbox path inside a remapped mount (Linux).
+	for _, m := range s.policy.ReadWriteMounts {
+		if m.Target != m.Source && within(wd, m.Target) {
+			rel, _ := filepath.Rel(m.Target, wd)
+			return filepath.Join(m.Source, rel)
+		}
+	}
+	return wd
+}
+
+// leaked reports whether the suite's token appears in the output.
+func (s *suite) leaked(pr *probeResult) bool {
+	return strings.Contains(pr.output, s.token)
+}
+
+// attemptPrelude defines attempt(label, f), which calls f and prints one tab-separated
+// line: the label, "allowed" or "denied", and f's result or the exception's type.
+const attemptPrelude = `import os, sys
+def attempt(label, f):
+    try:
+        r = f()
+    except BaseException as e:
+        print(label, "denied", type(e).__name__ + ": " + str(e).replace("\n", " "), sep="\t", flush=True)
+    else:
+        print(label, "allowed", "" if r is None else repr(r)[:200], sep="\t", flush=True)
+`
+
+// attempt is one line printed by attempt() in a probe.
+type attempt struct {
+	label   string
+	allowed bool
+	detail  string
+}
+
+// probeResult is the outcome of a probe.
+type probeResult struct {
+	attempts []attempt
+	output   string
+	err      error // the probe's exit error, nil if it exited with status 0
+}
+
+// verdict turns a probe into a Result: the property fails if the probe did not complete,
+// if any attempt for which denied is true was allowed, or if problems is not empty.
+func (pr *probeResult) verdict(denied func(attempt) bool, problems []string, passed string) Result {
+	res := Result{Output: pr.output}
+	if pr.err != nil {
+		problems = append(problems, fmt.Sprintf("probe did not complete: %v", pr.err))
+	}
+	for _, a := range pr.attempts {
+		if a.allowed && denied(a) {
+			problems = append(problems, a.label+" was allowed")
+		}
+	}
+	if len(problems) > 0 {
+		res.Status = Fail
+		res.Detail = strings.Join(problems, "; ")
+		return res
+	}
+	res.Status = Pass
+	res.Detail = passed
+	return res
+}
+
+func always(attempt) bool { return true }
+
+func canonical(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+// within reports whether path is dir or lies below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func failed(err error) Result {
+	return Result{Status: Fail, Detail: fmt.Sprintf("probe did not run: %v", err)}
+}
+
+func skipped(format string, args ...any) Result {
+	return Result{Status: Skip, Detail: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,105 @@
+package sandboxtest
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bpowers/boxedpy/sandbox"
+)
+
+// unconfined runs commands directly on the host, to check that the suite detects escapes.
+type unconfined struct{}
+
+func (unconfined) Name() string                  { return "sandboxtest-unconfined" }
+func (unconfined) Supports(sandbox.Feature) bool { return true }
+
+func (unconfined) Command(ctx context.Context, p *sandbox.Policy, name string, arg ...string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Env = p.Environ()
+	cmd.Dir = p.WorkDir
+	return cmd, nil
+}
+
+func init() { sandbox.RegisterBackend(unconfined{}) }
+
+// pythonPolicy returns the default policy with the mounts Homebrew's Python needs on macOS.
+func pythonPolicy() *sandbox.Policy {
+	policy := sandbox.DefaultPolicy()
+	if runtime.GOOS == "darwin" {
+		policy.ReadOnlyMounts = append(policy.ReadOnlyMounts,
+			sandbox.Mount{Source: "/opt", Target: "/opt"},
+			sandbox.Mount{Source: "/usr/local", Target: "/usr/local"},
+		)
+	}
+	return policy
+}
+
+func TestRunErrors(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	_, err := Run(ctx, nil, nil)
+	assert.ErrorContains(t, err, "policy is nil")
+
+	_, err = Run(ctx, pythonPolicy(), &Options{Properties: []Property{"teleport"}})
+	assert.ErrorContains(t, err, `unknown property "teleport"`)
+}
+
+func TestWithin(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, within("/home/user", "/home/user"))
+	assert.True(t, within("/home/user/project", "/home/user"))
+	assert.False(t, within("/home/username", "/home/user"))
+	assert.False(t, within("/home", "/home/user"))
+	assert.True(t, within("/home/user", "/"))
+}
+
+func TestIntegrationUnconfined(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	policy := pythonPolicy()
+	policy.Backend = "sandboxtest-unconfined"
+	policy.WorkDir = t.TempDir()
+	results, err := Run(context.Background(), policy, &Options{
+		Properties: []Property{ReadHome, Network, SymlinkRace},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for _, res := range results {
+		assert.Equal(t, Fail, res.Status, "%s", res)
+	}
+}
+
+func TestIntegrationConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	for _, backend := range []string{"", "landlock"} {
+		name := backend
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			policy := pythonPolicy()
+			policy.Backend = backend
+			if err := sandbox.Probe(context.Background()).Check(policy); err != nil {
+				t.Skip(err)
+			}
+			proxy, err := sandbox.NewNetworkProxy(&sandbox.NetworkFilter{AllowHosts: []string{"example.com"}})
+			require.NoError(t, err)
+			defer proxy.Close()
+			policy.NetworkProxy = proxy
+
+			Test(t, policy, nil)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package sandbox
+
+import "syscall"
+
+// SeccompProfile describes a seccomp-BPF system call filter applied to every process in
+// the sandbox. The profile is compiled to a classic BPF program in pure Go and handed to
+// bubblewrap through an inherited file descriptor (bwrap --seccomp).
+//
+// A system call is matched against, in order: Rules (first match wins), Deny, Allow and
+// finally DefaultAction. Names use the kernel's spelling (e.g., "openat", "newfstatat") for
+// the architecture the program runs on; an unknown name is an error when the command is built,
+// so a typo can never silently leave a system call unfiltered.
+//
+// Profiles with a non-allow DefaultAction are strict allowlists: they must allow everything
+// the sandboxed program needs, starting with execve.
+//
+// Linux only; ignored on macOS.
+type SeccompProfile struct {
+	// DefaultAction applies to system calls not matched by Rules, Deny or Allow.
+	DefaultAction SeccompAction
+
+	// Errno is returned by system calls refused with SeccompErrno (default EPERM).
+	Errno syscall.Errno
+
+	// Allow lists system calls that are always permitted.
+	Allow []string
+
+	// Deny lists system calls that fail with Errno.
+	Deny []string
+
+	// Rules match system calls by name and argument values, for cases that need more
+	// precision than Allow and Deny (e.g., refusing only one ioctl request).
+	Rules []SeccompRule
+}
+
+// SeccompAction is the outcome of a seccomp filter decision.
+type SeccompAction int
+
+const (
+	// SeccompAllow lets the system call proceed.
+	SeccompAllow SeccompAction = iota
+	// SeccompErrno makes the system call fail with an error number.
+	SeccompErrno
+	// SeccompKill terminates the whole process with SIGSYS.
+	SeccompKill
+)
+
+// SeccompRule matches a system call, optionally restricted by its arguments.
+type SeccompRule struct {
+	// Syscall is the system call name.
+	Syscall string
+
+	// Action is taken when the rule matches.
+	Action SeccompAction
+
+	// Errno overrides SeccompProfile.Errno for this rule when Action is SeccompErrno.
+	Errno syscall.Errno
+
+	// Args must all match for the rule to apply. An empty list matches every call.
+	Args []SeccompArg
+}
+
+// SeccompArg is a condition on one system call argument. Only the low 32 bits of the
+// argument are compared, which covers flags and ioctl request numbers.
+type SeccompArg struct {
+	// Index is the argument position (0-5).
+	Index int
+
+	// Op selects how the argument is compared with Value.
+	Op SeccompOp
+
+	// Value is the operand of the comparison.
+	Value uint32
+}
+
+// SeccompOp is a comparison used by SeccompArg.
+type SeccompOp int
+
+const (
+	// SeccompArgEqual matches when the argument equals Value.
+	SeccompArgEqual SeccompOp = iota
+	// SeccompArgMaskedAny matches when the argument has any of the bits in Value set.
+	SeccompArgMaskedAny
+)
+
+// defaultSeccompDeny lists system calls that CPython and its common extension modules
+// never need but that enlarge the kernel attack surface or allow escaping the sandbox:
+// debugging other processes, kernel keyrings, eBPF, userfaultfd, perf events, mounting and
+// namespace manipulation, module loading, clock and system administration.
+var defaultSeccompDeny = []string{
+	"_sysctl", "acct", "add_key", "adjtimex", "bpf", "clock_adjtime", "clock_settime",
+	"create_module", "delete_module", "finit_module", "fsconfig", "fsmount", "fsopen",
+	"fspick", "get_kernel_syms", "init_module", "io_uring_enter", "io_uring_register",
+	"io_uring_setup", "ioperm", "iopl", "kcmp", "kexec_file_load", "kexec_load", "keyctl",
+	"lookup_dcookie", "mount", "mount_setattr", "move_mount", "name_to_handle_at", "nfsservctl",
+	"open_by_handle_at", "open_tree", "perf_event_open", "pivot_root", "process_vm_readv",
+	"process_vm_writev", "ptrace", "query_module", "quotactl", "quotactl_fd", "reboot",
+	"request_key", "setns", "settimeofday", "swapoff", "swapon", "syslog", "umount2",
+	"unshare", "uselib", "userfaultfd", "vhangup",
+}
+
+// Argument values used by the default profile's rules.
+const (
+	ioctlTIOCSTI   = 0x5412 // inject input into the controlling terminal
+	ioctlTIOCLINUX = 0x541c // virtual console operations, including selection pasting
+
+	// CLONE_NEWNS | CLONE_NEWCGROUP | CLONE_NEWUTS | CLONE_NEWIPC | CLONE_NEWUSER |
+	// CLONE_NEWPID | CLONE_NEWNET
+	cloneNamespaceFlags = 0x00020000 | 0x02000000 | 0x04000000 | 0x08000000 |
+		0x10000000 | 0x20000000 | 0x40000000
+)
+
+// DefaultSeccompProfile returns a denylist profile tuned for CPython: every system call is
+// allowed except those in a fixed list of dangerous ones (ptrace, keyctl, bpf, userfaultfd,
+// perf_event_open, mount and namespace operations, module loading, ...), terminal input
+// injection via TIOCSTI, and creation of new namespaces via clone. clone3 fails with ENOSYS
+// because its flags cannot be inspected, which makes glibc fall back to clone.
+//
+// The returned profile is a fresh copy that callers may extend, for example:
+//
+//	profile := sandbox.DefaultSeccompProfile()
+//	profile.Deny = append(profile.Deny, "socket") // no sockets at all
+//	policy.Seccomp = profile
+func DefaultSeccompProfile() *SeccompProfile {
+	deny := make([]string, 0, len(defaultSeccompDeny))
+	for _, name := range defaultSeccompDeny {
+		// Many legacy system calls only exist on some architectures.
+		if knownSyscall(name) {
+			deny = append(deny, name)
+		}
+	}
+
+	return &SeccompProfile{
+		DefaultAction: SeccompAllow,
+		Errno:         syscall.EPERM,
+		Deny:          deny,
+		Rules: []SeccompRule{
+			{Syscall: "ioctl", Action: SeccompErrno, Args: []SeccompArg{{Index: 1, Op: SeccompArgEqual, Value: ioctlTIOCSTI}}},
+			{Syscall: "ioctl", Action: SeccompErrno, Args: []SeccompArg{{Index: 1, Op: SeccompArgEqual, Value: ioctlTIOCLINUX}}},
+			{Syscall: "clone", Action: SeccompErrno, Args: []SeccompArg{{Index: 0, Op: SeccompArgMaskedAny, Value: cloneNamespaceFlags}}},
+			{Syscall: "clone3", Action: SeccompErrno, Errno: syscall.ENOSYS},
+		},
+	}
+}
@@ -0,0 +1,8 @@
+//go:build darwin
+
+package sandbox
+
+// knownSyscall accepts every name on macOS, where seccomp profiles are ignored.
+func knownSyscall(name string) bool {
+	return true
+}
@@ -0,0 +1,213 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// Classic BPF opcodes (linux/filter.h) used by the seccomp compiler.
+const (
+	bpfLD   = 0x00
+	bpfW    = 0x00
+	bpfABS  = 0x20
+	bpfJMP  = 0x05
+	bpfJEQ  = 0x10
+	bpfJGE  = 0x30
+	bpfJSET = 0x40
+	bpfK    = 0x00
+	bpfRET  = 0x06
+
+	bpfMaxInstructions = 4096
+)
+
+// Seccomp return values (linux/seccomp.h).
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetUserNotif   = 0x7fc00000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// seccompNotify is an internal action that pauses the system call and notifies the
+// supervisor holding the filter's listener (see Policy.ReportViolations).
+const seccompNotify SeccompAction = -1
+
+// Offsets into struct seccomp_data.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// x32SyscallBit marks x32 ABI system calls on amd64, which share AUDIT_ARCH_X86_64
+// and must be refused so they cannot bypass the filter.
+const x32SyscallBit = 0x40000000
+
+// sockFilter is struct sock_filter, one classic BPF instruction.
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+func bpfStmt(code uint16, k uint32) sockFilter {
+	return sockFilter{code: code, k: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) sockFilter {
+	return sockFilter{code: code, jt: jt, jf: jf, k: k}
+}
+
+// knownSyscall reports whether name is a system call on the current architecture.
+func knownSyscall(name string) bool {
+	_, ok := syscallNumbers[name]
+	return ok
+}
+
+// seccompAuditArch returns the AUDIT_ARCH_* value for the current architecture.
+func seccompAuditArch() (uint32, error) {
+	switch runtime.GOARCH {
+	case "amd64":
+		return 0xc000003e, nil
+	case "arm64":
+		return 0xc00000b7, nil
+	}
+	return 0, fmt.Errorf("seccomp profiles are not supported on %s", runtime.GOARCH)
+}
+
+// compile translates the profile into a BPF program for the current architecture,
+// serialized as the array of struct sock_filter expected by bwrap --seccomp.
+func (sp *SeccompProfile) compile() ([]byte, error) {
+	insns, err := sp.program()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(insns)*8)
+	for _, in := range insns {
+		buf = binary.NativeEndian.AppendUint16(buf, in.code)
+		buf = append(buf, in.jt, in.jf)
+		buf = binary.NativeEndian.AppendUint32(buf, in.k)
+	}
+	return buf, nil
+}
+
+// program builds the BPF instructions for the profile.
+func (sp *SeccompProfile) program() ([]sockFilter, error) {
+	arch, err := seccompAuditArch()
+	if err != nil {
+		return nil, err
+	}
+	errno := sp.Errno
+	if errno == 0 {
+		errno = syscall.EPERM
+	}
+
+	ret := func(action SeccompAction, ruleErrno syscall.Errno) (sockFilter, error) {
+		switch action {
+		case SeccompAllow:
+			return bpfStmt(bpfRET|bpfK, seccompRetAllow), nil
+		case SeccompErrno:
+			if ruleErrno == 0 {
+				ruleErrno = errno
+			}
+			return bpfStmt(bpfRET|bpfK, seccompRetErrno|uint32(ruleErrno)&0xffff), nil
+		case SeccompKill:
+			return bpfStmt(bpfRET|bpfK, seccompRetKillProcess), nil
+		case seccompNotify:
+			return bpfStmt(bpfRET|bpfK, seccompRetUserNotif), nil
+		}
+		return sockFilter{}, fmt.Errorf("unknown seccomp action %d", action)
+	}
+	lookup := func(name string) (uint32, error) {
+		nr, ok := syscallNumbers[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown system call %q on %s", name, runtime.GOARCH)
+		}
+		return nr, nil
+	}
+	loadNr := bpfStmt(bpfLD|bpfW|bpfABS, seccompDataNr)
+
+	// Kill processes using a foreign system call ABI, whose numbers mean something else.
+	prog := []sockFilter{
+		bpfStmt(bpfLD|bpfW|bpfABS, seccompDataArch),
+		bpfJump(bpfJMP|bpfJEQ|bpfK, arch, 1, 0),
+		bpfStmt(bpfRET|bpfK, seccompRetKillProcess),
+		loadNr,
+	}
+	if runtime.GOARCH == "amd64" {
+		deny, _ := ret(SeccompErrno, 0)
+		prog = append(prog, bpfJump(bpfJMP|bpfJGE|bpfK, x32SyscallBit, 0, 1), deny)
+	}
+
+	for _, rule := range sp.Rules {
+		nr, err := lookup(rule.Syscall)
+		if err != nil {
+			return nil, err
+		}
+		action, err := ret(rule.Action, rule.Errno)
+		if err != nil {
+			return nil, err
+		}
+
+		// Block layout: match nr, then (load arg, test arg) per condition, return the action.
+		// When arguments were loaded, the block ends by reloading nr for the next rule and
+		// failed conditions jump to that reload.
+		var block []sockFilter
+		for i, arg := range rule.Args {
+			if arg.Index < 0 || arg.Index > 5 {
+				return nil, fmt.Errorf("rule for %s: argument index %d out of range", rule.Syscall, arg.Index)
+			}
+			// Instructions between this test and the reload: the remaining conditions and the return.
+			skip := uint8(2*(len(rule.Args)-i-1) + 1)
+			block = append(block, bpfStmt(bpfLD|bpfW|bpfABS, uint32(seccompDataArgs+8*arg.Index)))
+			switch arg.Op {
+			case SeccompArgEqual:
+				block = append(block, bpfJump(bpfJMP|bpfJEQ|bpfK, arg.Value, 0, skip))
+			case SeccompArgMaskedAny:
+				block = append(block, bpfJump(bpfJMP|bpfJSET|bpfK, arg.Value, 0, skip))
+			default:
+				return nil, fmt.Errorf("rule for %s: unknown argument operator %d", rule.Syscall, arg.Op)
+			}
+		}
+		block = append(block, action)
+		if len(rule.Args) > 0 {
+			block = append(block, loadNr)
+		}
+		prog = append(prog, bpfJump(bpfJMP|bpfJEQ|bpfK, nr, 0, uint8(len(block))))
+		prog = append(prog, block...)
+	}
+
+	lists := []struct {
+		names  []string
+		action SeccompAction
+	}{
+		{sp.Deny, SeccompErrno},
+		{sp.Allow, SeccompAllow},
+	}
+	for _, list := range lists {
+		action, _ := ret(list.action, 0)
+		for _, name := range list.names {
+			nr, err := lookup(name)
+			if err != nil {
+				return nil, err
+			}
+			prog = append(prog, bpfJump(bpfJMP|bpfJEQ|bpfK, nr, 0, 1), action)
+		}
+	}
+
+	def, err := ret(sp.DefaultAction, 0)
+	if err != nil {
+		return nil, err
+	}
+	prog = append(prog, def)
+
+	if len(prog) > bpfMaxInstructions {
+		return nil, fmt.Errorf("seccomp program has %d instructions, the kernel limit is %d", len(prog), bpfMaxInstructions)
+	}
+	return prog, nil
+}
@@ -0,0 +1,180 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/binary"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runBPF interprets a seccomp program for a system call with the given number and
+// arguments, returning the filter's verdict.
+func runBPF(t *testing.T, prog []sockFilter, arch, nr uint32, args [6]uint64) uint32 {
+	t.Helper()
+
+	data := make([]byte, 64)
+	binary.NativeEndian.PutUint32(data[seccompDataNr:], nr)
+	binary.NativeEndian.PutUint32(data[seccompDataArch:], arch)
+	for i, a := range args {
+		binary.NativeEndian.PutUint64(data[seccompDataArgs+8*i:], a)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		in := prog[pc]
+		switch in.code {
+		case bpfLD | bpfW | bpfABS:
+			acc = binary.NativeEndian.Uint32(data[in.k:])
+		case bpfJMP | bpfJEQ | bpfK:
+			if acc == in.k {
+				pc += int(in.jt)
+			} else {
+				pc += int(in.jf)
+			}
+		case bpfJMP | bpfJGE | bpfK:
+			if acc >= in.k {
+				pc += int(in.jt)
+			} else {
+				pc += int(in.jf)
+			}
+		case bpfJMP | bpfJSET | bpfK:
+			if acc&in.k != 0 {
+				pc += int(in.jt)
+			} else {
+				pc += int(in.jf)
+			}
+		case bpfRET | bpfK:
+			return in.k
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", in.code, pc)
+		}
+	}
+	t.Fatalf("program fell off the end")
+	return 0
+}
+
+func TestSeccompDefaultProfile(t *testing.T) {
+	t.Parallel()
+
+	arch, err := seccompAuditArch()
+	if err != nil {
+		t.Skip(err)
+	}
+	prog, err := DefaultSeccompProfile().program()
+	require.NoError(t, err)
+
+	nr := func(name string) uint32 {
+		n, ok := syscallNumbers[name]
+		require.True(t, ok, name)
+		return n
+	}
+	eperm := uint32(seccompRetErrno | uint32(syscall.EPERM))
+
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, nr("read"), [6]uint64{}))
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, nr("openat"), [6]uint64{}))
+	assert.Equal(t, eperm, runBPF(t, prog, arch, nr("ptrace"), [6]uint64{}))
+	assert.Equal(t, eperm, runBPF(t, prog, arch, nr("bpf"), [6]uint64{}))
+	assert.Equal(t, eperm, runBPF(t, prog, arch, nr("userfaultfd"), [6]uint64{}))
+
+	// ioctl is only refused for terminal injection
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, nr("ioctl"), [6]uint64{1, 0x5401}))
+	assert.Equal(t, eperm, runBPF(t, prog, arch, nr("ioctl"), [6]uint64{1, ioctlTIOCSTI}))
+
+	// clone is only refused when creating namespaces
+	const sigchld = 17
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, nr("clone"), [6]uint64{sigchld}))
+	assert.Equal(t, eperm, runBPF(t, prog, arch, nr("clone"), [6]uint64{sigchld | 0x10000000}))
+	assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.ENOSYS)), runBPF(t, prog, arch, nr("clone3"), [6]uint64{}))
+
+	// Foreign architectures are killed outright
+	assert.Equal(t, uint32(seccompRetKillProcess), runBPF(t, prog, 0x40000003, nr("read"), [6]uint64{}))
+
+	if runtime.GOARCH == "amd64" {
+		assert.Equal(t, eperm, runBPF(t, prog, arch, x32SyscallBit|nr("read"), [6]uint64{}))
+	}
+}
+
+func TestSeccompCustomProfile(t *testing.T) {
+	t.Parallel()
+
+	arch, err := seccompAuditArch()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	profile := &SeccompProfile{
+		DefaultAction: SeccompKill,
+		Errno:         syscall.EACCES,
+		Allow:         []string{"read", "write", "socket"},
+		Deny:          []string{"socket"},
+		Rules: []SeccompRule{
+			{Syscall: "write", Action: SeccompErrno, Errno: syscall.EBADF, Args: []SeccompArg{
+				{Index: 0, Op: SeccompArgEqual, Value: 2},
+				{Index: 2, Op: SeccompArgMaskedAny, Value: 0x1},
+			}},
+		},
+	}
+	prog, err := profile.program()
+	require.NoError(t, err)
+
+	read, write, socket := syscallNumbers["read"], syscallNumbers["write"], syscallNumbers["socket"]
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, read, [6]uint64{}))
+	// Deny takes precedence over Allow
+	assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.EACCES)), runBPF(t, prog, arch, socket, [6]uint64{}))
+	// Rules need every argument condition to match
+	assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.EBADF)), runBPF(t, prog, arch, write, [6]uint64{2, 0, 3}))
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, write, [6]uint64{2, 0, 2}))
+	assert.Equal(t, uint32(seccompRetAllow), runBPF(t, prog, arch, write, [6]uint64{1, 0, 3}))
+	// Anything else hits the default action
+	assert.Equal(t, uint32(seccompRetKillProcess), runBPF(t, prog, arch, syscallNumbers["getpid"], [6]uint64{}))
+
+	data, err := profile.compile()
+	require.NoError(t, err)
+	assert.Len(t, data, 8*len(prog))
+}
+
+func TestSeccompUnknownSyscall(t *testing.T) {
+	t.Parallel()
+
+	if _, err := seccompAuditArch(); err != nil {
+		t.Skip(err)
+	}
+
+	_, err := (&SeccompProfile{Deny: []string{"ptrase"}}).compile()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown system call "ptrase"`)
+
+	policy := DefaultPolicy()
+	policy.Seccomp = &SeccompProfile{Deny: []string{"ptrase"}}
+	_, err = policy.Command(context.Background(), "echo", "hi")
+	require.Error(t, err)
+}
+
+func TestIntegrationSeccompBlocksPtrace(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	policy := pythonPolicy()
+	cmd, err := policy.Command(context.Background(), pythonPath, "-c", `
+import ctypes, os
+libc = ctypes.CDLL(None, use_errno=True)
+PTRACE_TRACEME = 0
+res = libc.ptrace(PTRACE_TRACEME, 0, None, None)
+print(res, os.strerror(ctypes.get_errno()))
+`)
+	require.NoError(t, err)
+
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %s", output)
+	assert.Contains(t, string(output), "-1 Operation not permitted")
+}
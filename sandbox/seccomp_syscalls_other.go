@@ -0,0 +1,7 @@
+//go:build linux && !amd64 && !arm64
+
+package sandbox
+
+// syscallNumbers is empty on architectures without a syscall table; compiling a
+// SeccompProfile fails with an error there.
+var syscallNumbers = map[string]uint32{}
@@ -0,0 +1,211 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sessionCloseTimeout is how long Close waits for the session to exit after asking it to,
+// before killing it.
+const sessionCloseTimeout = 5 * time.Second
+
+var errSessionClosed = errors.New("sandbox: session is closed")
+
+// Session is a long-lived sandbox in which many commands can be run. Unlike commands
+// created by Policy.Command, which each start a fresh sandbox, the commands of a session
+// share its namespaces and mounts: files written to the session's /tmp, background
+// processes and other state persist from one command to the next until Close.
+//
+// A Session is safe for concurrent use; its commands may run at the same time.
+type Session struct {
+	cmd      *exec.Cmd      // sandbox running the session server
+	lifeline io.WriteCloser // the server's stdin; closing it shuts the session down
+	reqs     *os.File       // request socket, inherited by each command's client
+	env      []string       // default environment of commands
+	term     Termination    // how commands are stopped when their context is done
+	output   OutputLimits   // bounds the output RunCommand collects from commands
+	stderr   bytes.Buffer   // diagnostics of the session server
+
+	done    chan struct{} // closed once cmd has been waited for
+	waitErr error
+
+	mu        sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSession starts a sandbox configured by the policy and returns a Session that runs
+// commands inside it. The sandbox runs until Close is called or ctx is done. Limits apply
+// to the sandbox as a whole, as they would to a single command that starts the session's
+// commands as subprocesses.
+//
+// Sessions are only supported on Linux. The program using the sandbox package is
+// mounted read-only into the sandbox to serve the session, so it must be able to run
+// with the mounts of the policy (statically linked Go programs always can).
+//
+// Example:
+//
+//	session, err := policy.NewSession(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	defer session.Close()
+//
+//	cmd, err := session.Command(ctx, "pip", "install", "--target", "/tmp/deps", "requests")
+//	...
+//	cmd, err = session.Command(ctx, "python3", "analyze.py") // sees /tmp/deps
+func (p *Policy) NewSession(ctx context.Context) (*Session, error) {
+	if p == nil {
+		return nil, fmt.Errorf("sandbox: policy must not be nil")
+	}
+	if err := p.validateCommand(); err != nil {
+		return nil, err
+	}
+	if p.ReportViolations {
+		return nil, fmt.Errorf("sandbox: ReportViolations is not supported for sessions")
+	}
+	backend, err := p.selectBackend()
+	if err != nil {
+		return nil, err
+	}
+	if !backend.Supports(FeatureSessions) {
+		return nil, fmt.Errorf("sandbox: backend %q does not support %s", backend.Name(), FeatureSessions)
+	}
+	// Platform-specific implementations in session_linux.go and session_darwin.go
+	return p.startSession(ctx)
+}
+
+// Command returns an *exec.Cmd that runs the specified command inside the session. As
+// with Policy.Command, the Cmd has not been started; its Stdin, Stdout and Stderr are
+// connected to the sandboxed command, and its Env (initially the policy's environment)
+// becomes the command's environment. Wait reports the command's exit status. The command
+// starts in the policy's working directory; the Cmd's Dir must not be set.
+//
+// When ctx is done, the command is stopped as configured by the policy's Termination, and
+// TerminationOf reports the stage that ended it. Whenever the Cmd's process is killed, the
+// command and the other processes in its process group are killed too. SIGINT, SIGTERM,
+// SIGHUP and SIGQUIT sent to the Cmd's process are forwarded to the command's process group.
+//
+// The Cmd runs a small client on the host that hands its standard streams to the
+// session; the client is the process seen as cmd.Process.
+func (s *Session) Command(ctx context.Context, name string, arg ...string) (*exec.Cmd, error) {
+	if name == "" {
+		return nil, fmt.Errorf("sandbox: command name must not be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errSessionClosed
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: locate helper executable: %w", err)
+	}
+	// The request socket is the Cmd's first extra file, descriptor 3
+	data, err := json.Marshal(&helperConfig{Session: 3})
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: encode helper config: %w", err)
+	}
+
+	args := append([]string{string(data), "--", name}, arg...)
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Env = append(slices.Clone(s.env), helperEnvVar+"="+helperModeAttach)
+	cmd.ExtraFiles = []*os.File{s.reqs}
+
+	st := &cmdState{output: s.output}
+	st.kill = func(cmd *exec.Cmd) error { return cmd.Process.Kill() }
+	s.term.apply(cmd, st, func(sig syscall.Signal) error { return cmd.Process.Signal(sig) })
+	st.attach(cmd)
+	return cmd, nil
+}
+
+// Close shuts the session down: commands still running are killed, and so is every other
+// process in the sandbox. It waits for the sandbox to exit and returns an error if the
+// session had failed. Commands cannot be created after Close; calling it again returns
+// the result of the first call.
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		s.mu.Unlock()
+
+		s.lifeline.Close()
+		s.reqs.Close()
+
+		killed := false
+		select {
+		case <-s.done:
+		case <-time.After(sessionCloseTimeout):
+			s.cmd.Process.Kill()
+			killed = true
+			<-s.done
+		}
+		switch {
+		case killed:
+			s.closeErr = fmt.Errorf("sandbox: session did not exit within %v and was killed", sessionCloseTimeout)
+		case s.waitErr != nil:
+			s.closeErr = fmt.Errorf("sandbox: session: %w%s", s.waitErr, s.diagnostics())
+		}
+	})
+	return s.closeErr
+}
+
+// start starts cmd, which runs the session server, and waits until the server is ready.
+func (s *Session) start(cmd *exec.Cmd) error {
+	s.cmd = cmd
+	s.done = make(chan struct{})
+	lifeline, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("sandbox: start session: %w", err)
+	}
+	ready, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("sandbox: start session: %w", err)
+	}
+	cmd.Stderr = &s.stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("sandbox: start session: %w", err)
+	}
+	s.lifeline = lifeline
+	go func() {
+		s.waitErr = cmd.Wait()
+		close(s.done)
+	}()
+
+	// The server announces itself on stdout once it accepts requests
+	buf := make([]byte, len(sessionReady))
+	if _, err := io.ReadFull(ready, buf); err != nil || string(buf) != sessionReady {
+		lifeline.Close()
+		<-s.done
+		if s.waitErr != nil {
+			err = s.waitErr
+		} else if err == nil {
+			err = fmt.Errorf("unexpected output %q", buf)
+		}
+		return fmt.Errorf("sandbox: start session: %w%s", err, s.diagnostics())
+	}
+	return nil
+}
+
+// diagnostics returns what the session server wrote to stderr, formatted for appending to
+// an error message. It may only be called once the server has been waited for.
+func (s *Session) diagnostics() string {
+	msg := strings.TrimSpace(s.stderr.String())
+	if msg == "" {
+		return ""
+	}
+	return ": " + msg
+}
@@ -0,0 +1,24 @@
+//go:build darwin
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// sessionReady is written by the session server to stdout once it accepts requests.
+const sessionReady = "ready\n"
+
+// startSession is not supported on macOS: Seatbelt has no namespaces that could outlive
+// a single command.
+func (p *Policy) startSession(ctx context.Context) (*Session, error) {
+	return nil, fmt.Errorf("sandbox: sessions are not supported on macOS")
+}
+
+// runAttach is never requested on macOS.
+func runAttach(cfg *helperConfig, argv []string) int {
+	fmt.Fprintf(os.Stderr, "boxedpy sandbox helper: sessions are not supported on macOS\n")
+	return 127
+}
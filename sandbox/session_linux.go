@@ -0,0 +1,389 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// sessionEnvVar starts the session server when the sandbox package is executed inside a
+// session's sandbox. Its value is the descriptor of the request socket. It is a separate
+// variable from helperEnvVar because the exec helper may run first, on the host, to apply
+// limits to bwrap.
+const sessionEnvVar = "BOXEDPY_SANDBOX_SESSION"
+
+// sessionServerPath is where the executable is mounted inside a session's sandbox.
+// It lies directly in the sandbox root, which bwrap creates, so that no mount of the
+// policy can get in the way.
+const sessionServerPath = "/.boxedpy-session"
+
+// sessionReady is written by the session server to stdout once it accepts requests.
+const sessionReady = "ready\n"
+
+// maxSessionMessage bounds the size of a request, which holds the argv and environment.
+const maxSessionMessage = 1 << 20
+
+// sessionRequest asks the session server to start a command. It is sent over the request
+// socket together with four descriptors: stdin, stdout and stderr of the command, and a
+// connection on which the server replies and the client forwards signals.
+type sessionRequest struct {
+	Argv []string `json:"argv"`
+	Env  []string `json:"env"`
+}
+
+// sessionReply reports how a command ended, or why it could not be started.
+type sessionReply struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// sessionSignal is sent by the client to forward a signal to the command.
+type sessionSignal struct {
+	Signal int `json:"signal"`
+}
+
+// startSession starts the session server in a sandbox configured by the policy.
+func (p *Policy) startSession(ctx context.Context) (*Session, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: locate helper executable: %w", err)
+	}
+	sp := *p
+	sp.ReadOnlyMounts = append(slices.Clip(p.ReadOnlyMounts), Mount{Source: exe, Target: sessionServerPath})
+	cmd, err := sp.Command(ctx, sessionServerPath)
+	if err != nil {
+		return nil, err
+	}
+	s, err := startSessionServer(cmd, sp.commandEnv())
+	if err != nil {
+		return nil, err
+	}
+	s.term = p.Termination
+	s.output = p.Output
+	return s, nil
+}
+
+// startSessionServer starts cmd, which must run the session server, and returns the
+// session it serves. env is the default environment of the session's commands.
+func startSessionServer(cmd *exec.Cmd, env []string) (*Session, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: create session socket: %w", err)
+	}
+	reqs := os.NewFile(uintptr(fds[0]), "session-requests")
+	server := os.NewFile(uintptr(fds[1]), "session-server")
+	defer server.Close()
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, server)
+	cmd.Env = append(cmd.Env, sessionEnvVar+"="+strconv.Itoa(2+len(cmd.ExtraFiles)))
+
+	s := &Session{reqs: reqs, env: env}
+	if err := s.start(cmd); err != nil {
+		reqs.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func init() {
+	fd, ok := os.LookupEnv(sessionEnvVar)
+	if !ok {
+		return
+	}
+	if _, ok := os.LookupEnv(helperEnvVar); ok {
+		// The exec helper runs first and leaves the variable for the sandboxed server
+		return
+	}
+	os.Unsetenv(sessionEnvVar)
+	os.Exit(runSessionServer(fd))
+}
+
+// sessionServer starts the commands of a session inside its sandbox.
+type sessionServer struct {
+	mu      sync.Mutex
+	running map[int]struct{} // process groups of running commands
+}
+
+// runSessionServer serves requests received on the socket at descriptor fd until stdin
+// is closed. It only returns on failure; the result is used as the process exit status.
+func runSessionServer(fd string) int {
+	reqs, err := strconv.Atoi(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox session: malformed descriptor %q\n", fd)
+		return 127
+	}
+	// Commands must not inherit the request socket, through which they could take the
+	// requests of other commands and their standard streams, or any other descriptor the
+	// server was started with
+	syscall.CloseOnExec(reqs)
+	if entries, err := os.ReadDir("/proc/self/fd"); err == nil {
+		for _, e := range entries {
+			if fd, err := strconv.Atoi(e.Name()); err == nil && fd > 2 {
+				syscall.CloseOnExec(fd)
+			}
+		}
+	}
+	srv := &sessionServer{running: make(map[int]struct{})}
+
+	// The host closes stdin to end the session, or by exiting
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		srv.shutdown()
+	}()
+	if _, err := os.Stdout.WriteString(sessionReady); err != nil {
+		return 127
+	}
+	os.Stdout.Close()
+
+	buf := make([]byte, maxSessionMessage)
+	oob := make([]byte, syscall.CmsgSpace(4*4))
+	for {
+		n, oobn, flags, _, err := syscall.Recvmsg(reqs, buf, oob, syscall.MSG_CMSG_CLOEXEC)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "boxedpy sandbox session: receive request: %v\n", err)
+			srv.shutdown()
+		}
+		fds := receivedFds(oob[:oobn])
+		if n == 0 && len(fds) == 0 {
+			// Every client and the host have closed the socket
+			srv.shutdown()
+		}
+		if flags&(syscall.MSG_TRUNC|syscall.MSG_CTRUNC) != 0 || len(fds) != 4 {
+			for _, fd := range fds {
+				syscall.Close(fd)
+			}
+			continue
+		}
+		go srv.serve(slices.Clone(buf[:n]), fds)
+	}
+}
+
+// receivedFds returns the descriptors passed in a control message.
+func receivedFds(oob []byte) []int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	var fds []int
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return fds
+}
+
+// serve starts the command of a request and reports its exit status. fds holds the
+// command's standard streams followed by the connection to the client.
+func (s *sessionServer) serve(data []byte, fds []int) {
+	syscall.SetNonblock(fds[3], true)
+	conn := os.NewFile(uintptr(fds[3]), "session-conn")
+	defer conn.Close()
+	stdio := []*os.File{
+		os.NewFile(uintptr(fds[0]), "stdin"),
+		os.NewFile(uintptr(fds[1]), "stdout"),
+		os.NewFile(uintptr(fds[2]), "stderr"),
+	}
+
+	proc, err := startRequest(data, stdio)
+	for _, f := range stdio {
+		f.Close()
+	}
+	if err != nil {
+		writeMessage(conn, sessionReply{Error: err.Error()})
+		return
+	}
+	pgid := proc.Pid
+	s.track(pgid, true)
+
+	// Forward signals from the client; when the client goes away, the command is killed
+	var emu sync.Mutex
+	exited := false
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			sig := syscall.SIGKILL
+			n, err := conn.Read(buf)
+			if err == nil {
+				var msg sessionSignal
+				if json.Unmarshal(buf[:n], &msg) != nil {
+					continue
+				}
+				sig = syscall.Signal(msg.Signal)
+			}
+			emu.Lock()
+			if !exited {
+				syscall.Kill(-pgid, sig)
+			}
+			emu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	state, err := proc.Wait()
+	emu.Lock()
+	exited = true
+	emu.Unlock()
+	s.track(pgid, false)
+	if err != nil {
+		writeMessage(conn, sessionReply{Error: err.Error()})
+		return
+	}
+	writeMessage(conn, sessionReply{Status: int(state.Sys().(syscall.WaitStatus))})
+}
+
+// startRequest decodes a request and starts its command in a new process group.
+func startRequest(data []byte, stdio []*os.File) (*os.Process, error) {
+	var req sessionRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("decode request: %w", err)
+	}
+	if len(req.Argv) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	path, err := lookPathEnv(req.Argv[0], req.Env)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := os.StartProcess(path, req.Argv, &os.ProcAttr{
+		Env:   req.Env,
+		Files: stdio,
+		Sys:   &syscall.SysProcAttr{Setpgid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec %s: %w", req.Argv[0], err)
+	}
+	return proc, nil
+}
+
+// lookPathEnv is exec.LookPath using the PATH of env rather than of the current process.
+func lookPathEnv(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	var path string
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "PATH="); ok {
+			path = v
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, file)
+		if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+			return candidate, nil
+		}
+	}
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+}
+
+// track records whether the process group pgid belongs to a running command.
+func (s *sessionServer) track(pgid int, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if running {
+		s.running[pgid] = struct{}{}
+	} else {
+		delete(s.running, pgid)
+	}
+}
+
+// shutdown kills the running commands and exits. In a PID namespace, bwrap then kills
+// every remaining process, including those started in the background.
+func (s *sessionServer) shutdown() {
+	s.mu.Lock()
+	for pgid := range s.running {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+	os.Exit(0)
+}
+
+// writeMessage sends v, JSON-encoded, as one message on a SOCK_SEQPACKET connection.
+func writeMessage(conn *os.File, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// runAttach is the client run by Session.Command on the host: it passes its standard
+// streams to the session server along with argv and its environment, then exits the way
+// the command did. It only returns on failure or to report the command's exit code.
+func runAttach(cfg *helperConfig, argv []string) int {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox session: create socket: %v\n", err)
+		return 127
+	}
+	conn := os.NewFile(uintptr(fds[0]), "session-conn")
+	req, err := json.Marshal(sessionRequest{Argv: argv, Env: os.Environ()})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox session: encode request: %v\n", err)
+		return 127
+	}
+	err = syscall.Sendmsg(cfg.Session, req, syscall.UnixRights(0, 1, 2, fds[1]), nil, 0)
+	syscall.Close(fds[1])
+	syscall.Close(cfg.Session)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox session: send request (session closed?): %v\n", err)
+		return 127
+	}
+
+	sigs := make(chan os.Signal, 1)
+	for sig := range terminationSignals {
+		signal.Notify(sigs, sig)
+	}
+	go func() {
+		for sig := range sigs {
+			writeMessage(conn, sessionSignal{Signal: int(sig.(syscall.Signal))})
+		}
+	}()
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	var reply sessionReply
+	if err != nil || json.Unmarshal(buf[:n], &reply) != nil {
+		// The session was closed, and the command killed along with it
+		return dieFromSignal(syscall.SIGKILL)
+	}
+	if reply.Error != "" {
+		fmt.Fprintf(os.Stderr, "boxedpy sandbox session: %s\n", reply.Error)
+		return 127
+	}
+	ws := syscall.WaitStatus(reply.Status)
+	if ws.Signaled() {
+		return dieFromSignal(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// dieFromSignal terminates the current process with sig, so that its parent observes the
+// same wait status as for the command. If the signal does not terminate the process, it
+// returns the exit status a shell would report.
+func dieFromSignal(sig syscall.Signal) int {
+	signal.Reset(sig)
+	syscall.Kill(os.Getpid(), sig)
+	return 128 + int(sig)
+}
@@ -0,0 +1,181 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSession starts a session server directly on the host, without bubblewrap, so
+// that the session protocol can be tested where bubblewrap is unavailable.
+func newTestSession(t *testing.T) *Session {
+	t.Helper()
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	cmd := exec.Command(exe)
+	cmd.Dir = t.TempDir()
+	env := []string{"PATH=/usr/bin:/bin", "LANG=C.UTF-8"}
+	cmd.Env = env
+	s, err := startSessionServer(cmd, env)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSession(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	ctx := context.Background()
+	s := newTestSession(t)
+
+	t.Run("output and exit status", func(t *testing.T) {
+		cmd, err := s.Command(ctx, "sh", "-c", "echo out; echo err >&2; exit 3")
+		require.NoError(t, err)
+		var stdout, stderr strings.Builder
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 3, exitErr.ExitCode())
+		assert.Equal(t, "out\n", stdout.String())
+		assert.Equal(t, "err\n", stderr.String())
+	})
+
+	t.Run("stdin and environment", func(t *testing.T) {
+		cmd, err := s.Command(ctx, "sh", "-c", `cat; echo "$GREETING in $PWD"`)
+		require.NoError(t, err)
+		cmd.Env = append(cmd.Env, "GREETING=hello")
+		cmd.Stdin = strings.NewReader("input\n")
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		assert.Equal(t, "input\nhello in "+s.cmd.Dir+"\n", string(out), "commands start in the session's directory")
+	})
+
+	t.Run("background processes persist", func(t *testing.T) {
+		cmd, err := s.Command(ctx, "sh", "-c", "sleep 60 >/dev/null 2>&1 & echo $!")
+		require.NoError(t, err)
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+		require.NoError(t, err)
+		defer syscall.Kill(pid, syscall.SIGKILL)
+
+		cmd, err = s.Command(ctx, "kill", "-0", strconv.Itoa(pid))
+		require.NoError(t, err)
+		require.NoError(t, cmd.Run())
+	})
+
+	t.Run("only standard streams are inherited", func(t *testing.T) {
+		// The request socket would let the command take other commands' requests
+		cmd, err := s.Command(ctx, "sh", "-c", "ls /proc/$$/fd")
+		require.NoError(t, err)
+		out, err := cmd.Output()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"0", "1", "2"}, strings.Fields(string(out)))
+	})
+
+	t.Run("command not found", func(t *testing.T) {
+		cmd, err := s.Command(ctx, "boxedpy-no-such-command")
+		require.NoError(t, err)
+		out, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, 127, exitErr.ExitCode())
+		assert.Contains(t, string(out), "executable file not found")
+	})
+
+	t.Run("signals are forwarded", func(t *testing.T) {
+		cmd, err := s.Command(ctx, "sleep", "60")
+		require.NoError(t, err)
+		require.NoError(t, cmd.Start())
+		time.Sleep(200 * time.Millisecond)
+		require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+		err = cmd.Wait()
+		assert.EqualError(t, err, "signal: terminated")
+	})
+
+	t.Run("cancellation kills the command", func(t *testing.T) {
+		marker := filepath.Join(t.TempDir(), "marker")
+		ctx, cancel := context.WithCancel(ctx)
+		cmd, err := s.Command(ctx, "sh", "-c", "sleep 1 && touch "+marker)
+		require.NoError(t, err)
+		require.NoError(t, cmd.Start())
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+		assert.EqualError(t, cmd.Wait(), "signal: killed")
+		time.Sleep(1500 * time.Millisecond)
+		assert.NoFileExists(t, marker)
+	})
+}
+
+func TestSessionClose(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	ctx := context.Background()
+	s := newTestSession(t)
+
+	cmd, err := s.Command(ctx, "sleep", "60")
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	time.Sleep(200 * time.Millisecond)
+
+	require.NoError(t, s.Close())
+	assert.EqualError(t, cmd.Wait(), "signal: killed")
+	assert.NoError(t, s.Close(), "Close is idempotent")
+
+	_, err = s.Command(ctx, "true")
+	assert.ErrorIs(t, err, errSessionClosed)
+}
+
+func TestIntegrationSession(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+	ctx := context.Background()
+
+	policy := DefaultPolicy()
+	policy.WorkDir = t.TempDir()
+	s, err := policy.NewSession(ctx)
+	require.NoError(t, err)
+	defer s.Close()
+
+	cmd, err := s.Command(ctx, "sh", "-c", "echo persisted > /tmp/state")
+	require.NoError(t, err)
+	require.NoError(t, cmd.Run())
+
+	cmd, err = s.Command(ctx, "cat", "/tmp/state")
+	require.NoError(t, err)
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	assert.Equal(t, "persisted\n", string(out))
+
+	// Commands share the session's PID namespace
+	cmd, err = s.Command(ctx, "sh", "-c", "sleep 60 >/dev/null 2>&1 & echo $!")
+	require.NoError(t, err)
+	out, err = cmd.Output()
+	require.NoError(t, err)
+	cmd, err = s.Command(ctx, "kill", "-0", strings.TrimSpace(string(out)))
+	require.NoError(t, err)
+	require.NoError(t, cmd.Run())
+
+	require.NoError(t, s.Close())
+	_, err = s.Command(ctx, "true")
+	assert.ErrorIs(t, err, errSessionClosed)
+}
@@ -0,0 +1,132 @@
+package sandbox
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// DefaultGracePeriod is the time a command has to exit after Termination.Signal when
+// Termination.GracePeriod is zero.
+const DefaultGracePeriod = 5 * time.Second
+
+// Termination configures how a command is stopped when the context passed to Command is
+// done or Limits.WallTime elapses. The zero value kills the whole sandbox immediately.
+//
+// With a Signal, the run is stopped in two stages: the signal is sent to the process group
+// of the sandboxed command, which can react to it (Python raises KeyboardInterrupt for
+// SIGINT, so that finally blocks run and a traceback is printed), and if the command has
+// not exited after GracePeriod, every process in the sandbox is killed. Use TerminationOf
+// to learn which stage ended a run.
+//
+// Linux: the sandbox is killed through its PID namespace, and through the per-run cgroup
+// when there is one, so processes that left the command's process group are killed too.
+// macOS: Seatbelt has no namespaces; the signal and the kill are sent to the command process.
+type Termination struct {
+	// Signal is sent first: syscall.SIGINT, SIGTERM, SIGHUP or SIGQUIT. Zero skips this stage.
+	Signal syscall.Signal
+
+	// GracePeriod is how long the command has to exit after Signal before the sandbox is
+	// killed. Zero means DefaultGracePeriod. Ignored when Signal is zero.
+	GracePeriod time.Duration
+}
+
+// terminationSignals are the signals accepted for Termination.Signal, with the names used
+// in policy files. Sessions forward them from the host client to the command.
+var terminationSignals = map[syscall.Signal]string{
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGQUIT: "SIGQUIT",
+}
+
+// TerminationStage reports whether and how the sandbox stopped a run.
+type TerminationStage string
+
+const (
+	// TerminationNone means the run was not stopped by the sandbox: it exited on its own,
+	// or has not been waited for yet.
+	TerminationNone TerminationStage = ""
+	// TerminationSignal means the command was sent Termination.Signal and exited within
+	// the grace period.
+	TerminationSignal TerminationStage = "signal"
+	// TerminationKill means the sandbox was killed, either immediately or because the
+	// command outlived the grace period.
+	TerminationKill TerminationStage = "kill"
+)
+
+// TerminationOf reports how the run of a command created by Policy.Command or
+// Session.Command was stopped after its context was done. It is meaningful once Wait has
+// returned, and returns TerminationNone for other commands.
+//
+// Example:
+//
+//	policy.Termination = sandbox.Termination{Signal: syscall.SIGINT, GracePeriod: 10 * time.Second}
+//	cmd, _ := policy.Command(ctx, "python3", "train.py")
+//	err := cmd.Run()
+//	switch sandbox.TerminationOf(cmd) {
+//	case sandbox.TerminationSignal:
+//	    // interrupted cleanly; the output holds the KeyboardInterrupt traceback
+//	case sandbox.TerminationKill:
+//	    // the script ignored the interrupt and was killed
+//	}
+func TerminationOf(cmd *exec.Cmd) TerminationStage {
+	rec := outcomeOf(cmd)
+	if rec == nil {
+		return TerminationNone
+	}
+	return rec.termination
+}
+
+// validate checks that the signal is one the sandbox can deliver.
+func (t *Termination) validate() error {
+	if t.Signal != 0 {
+		if _, ok := terminationSignals[t.Signal]; !ok {
+			return fmt.Errorf("sandbox: termination: unsupported signal %v (use SIGINT, SIGTERM, SIGHUP or SIGQUIT)", t.Signal)
+		}
+	}
+	if t.GracePeriod < 0 {
+		return fmt.Errorf("sandbox: termination: GracePeriod must not be negative")
+	}
+	return nil
+}
+
+func (t *Termination) gracePeriod() time.Duration {
+	if t.GracePeriod == 0 {
+		return DefaultGracePeriod
+	}
+	return t.GracePeriod
+}
+
+// apply sets cmd.Cancel to stop the run in stages, recording the stage in st. signal
+// delivers the termination signal to the command; st.kill ends the run. Both are only
+// called once cmd has been started.
+func (t Termination) apply(cmd *exec.Cmd, st *cmdState, signal func(syscall.Signal) error) {
+	cmd.Cancel = func() error {
+		if t.Signal == 0 {
+			st.setTermination(TerminationKill)
+			return st.kill(cmd)
+		}
+		if err := signal(t.Signal); err != nil {
+			return err
+		}
+		st.setTermination(TerminationSignal)
+		time.AfterFunc(t.gracePeriod(), func() {
+			// Signal 0 fails once the command has been waited for
+			if cmd.Process.Signal(syscall.Signal(0)) != nil {
+				return
+			}
+			st.setTermination(TerminationKill)
+			st.kill(cmd)
+		})
+		return nil
+	}
+}
+
+// setTermination records the stage that stopped the run.
+func (s *cmdState) setTermination(stage TerminationStage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.termination = stage
+}
@@ -0,0 +1,19 @@
+//go:build darwin
+
+package sandbox
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// signalSandbox sends sig to the command, which sandbox-exec has replaced itself with.
+func signalSandbox(cmd *exec.Cmd, sig syscall.Signal) error {
+	return cmd.Process.Signal(sig)
+}
+
+// killSandbox kills the command. Seatbelt has no namespace through which processes the
+// command started could be found.
+func killSandbox(cmd *exec.Cmd, st *cmdState) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,171 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// procEntry is a process as described by /proc/<pid>/stat.
+type procEntry struct {
+	pid  int
+	pgid int
+	comm string
+}
+
+// sysPidfdSendSignal is pidfd_send_signal(2), numbered identically on every architecture.
+const sysPidfdSendSignal = 424
+
+// childProcesses returns the processes of the host, grouped by parent process ID.
+func childProcesses() map[int][]procEntry {
+	children := make(map[int][]procEntry)
+	dirs, _ := os.ReadDir("/proc")
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		if e, ppid, ok := readProcStat(pid); ok {
+			children[ppid] = append(children[ppid], e)
+		}
+	}
+	return children
+}
+
+// readProcStat returns the process pid and its parent process ID as described by
+// /proc/<pid>/stat.
+func readProcStat(pid int) (e procEntry, ppid int, ok bool) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procEntry{}, 0, false
+	}
+	// pid (comm) state ppid pgrp ...; comm may itself contain spaces and parentheses
+	open, end := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if open < 0 || end < open {
+		return procEntry{}, 0, false
+	}
+	fields := bytes.Fields(data[end+1:])
+	if len(fields) < 3 {
+		return procEntry{}, 0, false
+	}
+	ppid, _ = strconv.Atoi(string(fields[1]))
+	pgid, _ := strconv.Atoi(string(fields[2]))
+	return procEntry{pid: pid, pgid: pgid, comm: string(data[open+1 : end])}, ppid, true
+}
+
+// sandboxedProcesses returns the outermost processes below bwrap (pid), skipping bwrap's
+// own monitor and init processes: normally just the command.
+func sandboxedProcesses(pid int) []procEntry {
+	children := childProcesses()
+	var found []procEntry
+	queue := []int{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, p := range children[parent] {
+			if p.comm == "bwrap" {
+				queue = append(queue, p.pid)
+			} else {
+				found = append(found, p)
+			}
+		}
+	}
+	return found
+}
+
+// signalSandbox sends sig to the process group of the sandboxed command. The command
+// leads its own process group unless AllowSessionControl is set, in which case the group
+// may be the caller's and only the command itself is signalled.
+func signalSandbox(cmd *exec.Cmd, sig syscall.Signal) error {
+	procs := sandboxedProcesses(cmd.Process.Pid)
+	if len(procs) == 0 {
+		// Not started yet, or already gone
+		return cmd.Process.Signal(sig)
+	}
+	own := syscall.Getpgrp()
+	for _, p := range procs {
+		if p.pgid == p.pid && p.pgid != own {
+			syscall.Kill(-p.pgid, sig)
+		} else {
+			syscall.Kill(p.pid, sig)
+		}
+	}
+	return nil
+}
+
+// killSandbox kills every process of the sandbox. Killing bwrap's init process, the first
+// process of the PID namespace, makes the kernel kill the rest of the namespace; the
+// per-run cgroup (Linux 5.14+) and the descendants found in /proc cover sandboxes that
+// share the caller's PID namespace.
+func killSandbox(cmd *exec.Cmd, st *cmdState) error {
+	return killProcessTree(cmd.Process.Pid, cmd.Process.Kill, st)
+}
+
+// killProcessTree kills the process pid, which bwrap or the helper runs as, using kill,
+// and then every process of the run, as described for killSandbox. pid must not have been
+// waited for.
+//
+// The descendants are signalled through pidfds opened before bwrap is killed: killing it
+// tears down the PID namespace and frees the descendants' PIDs, which could then be
+// reused by unrelated processes. Without pidfd_open (Linux 5.3+), only the cgroup and the
+// namespace are relied on.
+func killProcessTree(pid int, kill func() error, st *cmdState) error {
+	st.mu.Lock()
+	cgroupDir := st.cgroupDir
+	st.mu.Unlock()
+	if cgroupDir != "" {
+		os.WriteFile(filepath.Join(cgroupDir, "cgroup.kill"), []byte("1"), 0)
+	}
+	// Find the descendants first: once bwrap is killed, they are no longer its children
+	pidfds := descendantPidfds(pid)
+	// Kill bwrap first, so that the run reports SIGKILL rather than the exit status of a
+	// command that bwrap saw being killed
+	err := kill()
+	for _, fd := range pidfds {
+		syscall.Syscall6(sysPidfdSendSignal, uintptr(fd), uintptr(syscall.SIGKILL), 0, 0, 0, 0)
+		syscall.Close(fd)
+	}
+	return err
+}
+
+// descendantPidfds returns pidfds for the descendants of the process pid, which must not
+// have been waited for. A PID found in /proc may be reused before its pidfd is opened, so
+// each process is only kept if, once pinned by the pidfd, it is still the child of a
+// process already known to belong to the tree.
+func descendantPidfds(pid int) []int {
+	children := childProcesses()
+	var pidfds []int
+	queue := []int{pid}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, p := range children[parent] {
+			fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(p.pid), 0, 0)
+			if errno == syscall.ENOSYS {
+				for _, fd := range pidfds {
+					syscall.Close(fd)
+				}
+				return nil
+			}
+			if errno != 0 {
+				continue // already gone
+			}
+			_, ppid, ok := readProcStat(p.pid)
+			// The process is still alive if it can be signalled, so the check above saw it
+			// rather than a process that reused its PID
+			_, _, errno = syscall.Syscall6(sysPidfdSendSignal, fd, 0, 0, 0, 0, 0)
+			if !ok || ppid != parent || errno != 0 {
+				syscall.Close(int(fd))
+				continue
+			}
+			pidfds = append(pidfds, int(fd))
+			queue = append(queue, p.pid)
+		}
+	}
+	return pidfds
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminationValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, (&Termination{}).validate())
+	assert.NoError(t, (&Termination{Signal: syscall.SIGTERM, GracePeriod: time.Second}).validate())
+	assert.ErrorContains(t, (&Termination{Signal: syscall.SIGKILL}).validate(), "unsupported signal")
+	assert.ErrorContains(t, (&Termination{GracePeriod: -time.Second}).validate(), "must not be negative")
+
+	_, err := (&Policy{Termination: Termination{Signal: syscall.SIGUSR1}}).Command(context.Background(), "true")
+	assert.ErrorContains(t, err, "unsupported signal")
+}
+
+// fakeBwrapCommand returns a command whose process tree resembles a sandbox: a shell named
+// "bwrap" running python, which prints "started" once it handles SIGINT as given.
+func fakeBwrapCommand(t *testing.T, ctx context.Context, onInterrupt string) *exec.Cmd {
+	t.Helper()
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+	sh, err := exec.LookPath("sh")
+	require.NoError(t, err)
+	bwrap := filepath.Join(t.TempDir(), "bwrap")
+	require.NoError(t, os.Symlink(sh, bwrap))
+
+	script := `
+import signal, sys, time
+` + onInterrupt + `
+try:
+    print("started", flush=True)
+    time.sleep(60)
+except KeyboardInterrupt:
+    print("interrupted", flush=True)
+`
+	// The trailing command keeps the shell from exec'ing python
+	return exec.CommandContext(ctx, bwrap, "-c", `"$0" -c "$1"; true`, pythonPath, script)
+}
+
+func TestTerminationStages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	tests := []struct {
+		name        string
+		termination Termination
+		onInterrupt string
+		wantStage   TerminationStage
+		wantOutput  string
+	}{
+		{"immediate kill", Termination{}, "", TerminationKill, "started\n"},
+		{"interrupt", Termination{Signal: syscall.SIGINT}, "", TerminationSignal, "started\ninterrupted\n"},
+		{
+			"interrupt ignored",
+			Termination{Signal: syscall.SIGINT, GracePeriod: 300 * time.Millisecond},
+			"signal.signal(signal.SIGINT, signal.SIG_IGN)",
+			TerminationKill,
+			"started\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cmd := fakeBwrapCommand(t, ctx, tt.onInterrupt)
+			st := &cmdState{}
+			st.kill = func(cmd *exec.Cmd) error { return killSandbox(cmd, st) }
+			tt.termination.apply(cmd, st, func(sig syscall.Signal) error { return signalSandbox(cmd, sig) })
+			st.attach(cmd)
+
+			stdout, err := cmd.StdoutPipe()
+			require.NoError(t, err)
+			require.NoError(t, cmd.Start())
+			assert.Equal(t, TerminationNone, TerminationOf(cmd))
+			out := bufio.NewReader(stdout)
+			started, err := out.ReadString('\n')
+			require.NoError(t, err)
+
+			cancel()
+			rest, _ := io.ReadAll(out)
+			err = cmd.Wait()
+			require.Error(t, err)
+			assert.Equal(t, tt.wantStage, TerminationOf(cmd))
+			assert.Equal(t, tt.wantOutput, started+string(rest))
+			if tt.wantStage == TerminationKill {
+				assert.EqualError(t, err, "signal: killed")
+			} else {
+				assert.ErrorIs(t, err, context.Canceled, "the command exited cleanly after the interrupt")
+			}
+		})
+	}
+}
+
+func TestKillProcessTree(t *testing.T) {
+	t.Parallel()
+
+	// The background sleep shares the caller's PID namespace, as under Landlock
+	cmd := exec.Command("sh", "-c", "sleep 60 & echo $!; wait")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	sleepPID, err := strconv.Atoi(strings.TrimSpace(line))
+	require.NoError(t, err)
+	defer syscall.Kill(sleepPID, syscall.SIGKILL)
+
+	pidfds := descendantPidfds(cmd.Process.Pid)
+	assert.Len(t, pidfds, 1, "only the sleep descends from the shell")
+	for _, fd := range pidfds {
+		syscall.Close(fd)
+	}
+
+	require.NoError(t, killProcessTree(cmd.Process.Pid, cmd.Process.Kill, &cmdState{}))
+	assert.EqualError(t, cmd.Wait(), "signal: killed")
+	assert.Eventually(t, func() bool {
+		// Gone, or a zombie waiting to be reaped by its new parent
+		data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(sleepPID), "stat"))
+		return err != nil || strings.Contains(string(data), ") Z ")
+	}, 5*time.Second, 10*time.Millisecond)
+}
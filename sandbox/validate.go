@@ -0,0 +1,170 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Validate checks the policy and reports every problem it finds at once, joined with
+// errors.Join. It is intended for policies loaded from files (see LoadPolicy) and for
+// reviewing configuration before it is used, and is stricter than Command:
+//   - mount sources, WorkDir and CgroupParent must be absolute and must exist on this host
+//   - mount targets that differ from their source must be absolute and clean (Linux only)
+//   - AllowNetwork, AllowLocalhostOnly and NetworkProxy are mutually exclusive
+//   - masked paths, files, environment, identity, limits, termination and seccomp system
+//     call names must be well-formed
+//   - the Backend must be registered and support the features the policy uses
+//
+// Validate does not start anything and does not modify the policy.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return fmt.Errorf("sandbox: policy must not be nil")
+	}
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, m := range p.ReadOnlyMounts {
+		check(validateMount("read-only", m))
+	}
+	for _, m := range p.ReadWriteMounts {
+		check(validateMount("read-write", m))
+	}
+
+	if p.WorkDir != "" {
+		mounts := append(append([]Mount(nil), p.ReadOnlyMounts...), p.ReadWriteMounts...)
+		switch {
+		case !filepath.IsAbs(p.WorkDir):
+			errs = append(errs, fmt.Errorf("sandbox: work directory %q is not an absolute path", p.WorkDir))
+		case runtime.GOOS == "linux" && p.Overlay == nil && inRemappedMount(mounts, p.WorkDir):
+			// A sandbox path inside a remapped mount; the mount's source is checked above
+		default:
+			check(validateDir("work directory", p.WorkDir))
+		}
+	}
+	if p.Overlay != nil && p.WorkDir != "" {
+		if wd, err := canonicalPath(p.WorkDir); err == nil && wd != p.Overlay.Dir() {
+			errs = append(errs, fmt.Errorf("sandbox: overlay directory %s does not match work directory %s", p.Overlay.Dir(), wd))
+		}
+	}
+
+	for _, pattern := range p.MaskedPaths {
+		if pattern == "" {
+			errs = append(errs, fmt.Errorf("sandbox: masked path must not be empty"))
+		} else if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("sandbox: masked path %q: %w", pattern, err))
+		}
+	}
+
+	if p.AllowNetwork && p.AllowLocalhostOnly {
+		errs = append(errs, fmt.Errorf("sandbox: AllowNetwork and AllowLocalhostOnly are both set"))
+	}
+	if p.NetworkProxy != nil && (p.AllowNetwork || p.AllowLocalhostOnly) {
+		errs = append(errs, fmt.Errorf("sandbox: NetworkProxy is set together with AllowNetwork or AllowLocalhostOnly, which it overrides"))
+	}
+
+	check(validateFiles(p.Files))
+	check(p.Env.validate())
+	if p.Identity != nil {
+		check(p.Identity.validate())
+	}
+	check(p.Limits.validate())
+	check(p.Termination.validate())
+	_, err := p.selectBackend()
+	check(err)
+	check(p.Output.validate())
+	if p.Output.SpillDir != "" {
+		check(validateDir("output spill directory", p.Output.SpillDir))
+	}
+	if p.Limits.CgroupParent != "" && runtime.GOOS == "linux" {
+		if !filepath.IsAbs(p.Limits.CgroupParent) {
+			errs = append(errs, fmt.Errorf("sandbox: cgroup parent %q is not an absolute path", p.Limits.CgroupParent))
+		} else {
+			check(validateDir("cgroup parent", p.Limits.CgroupParent))
+		}
+	}
+	if p.Seccomp != nil {
+		check(p.Seccomp.validate())
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateMount checks that a mount's source exists and its target can be used.
+func validateMount(kind string, m Mount) error {
+	var errs []error
+	switch {
+	case m.Source == "":
+		errs = append(errs, fmt.Errorf("sandbox: %s mount has an empty source", kind))
+	case !filepath.IsAbs(m.Source):
+		errs = append(errs, fmt.Errorf("sandbox: %s mount source %q is not an absolute path", kind, m.Source))
+	default:
+		if _, err := os.Stat(m.Source); err != nil {
+			errs = append(errs, fmt.Errorf("sandbox: %s mount source: %w", kind, err))
+		}
+	}
+
+	if m.Target != m.Source {
+		switch {
+		case runtime.GOOS == "darwin":
+			errs = append(errs, fmt.Errorf("sandbox: %s mount target %s differs from source %s: path remapping is not supported on macOS", kind, m.Target, m.Source))
+		case !filepath.IsAbs(m.Target) || filepath.Clean(m.Target) != m.Target:
+			errs = append(errs, fmt.Errorf("sandbox: %s mount target %q must be an absolute, clean path", kind, m.Target))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateDir checks that path is an existing directory.
+func validateDir(what, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("sandbox: %s: %w", what, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("sandbox: %s %s is not a directory", what, path)
+	}
+	return nil
+}
+
+// validate checks that the profile only names known system calls and defined actions.
+func (sp *SeccompProfile) validate() error {
+	var errs []error
+	action := func(a SeccompAction) {
+		if a < SeccompAllow || a > SeccompKill {
+			errs = append(errs, fmt.Errorf("sandbox: seccomp: unknown action %d", a))
+		}
+	}
+	name := func(name string) {
+		if !knownSyscall(name) {
+			errs = append(errs, fmt.Errorf("sandbox: seccomp: unknown system call %q on %s", name, runtime.GOARCH))
+		}
+	}
+
+	action(sp.DefaultAction)
+	for _, n := range sp.Allow {
+		name(n)
+	}
+	for _, n := range sp.Deny {
+		name(n)
+	}
+	for _, r := range sp.Rules {
+		name(r.Syscall)
+		action(r.Action)
+		for _, a := range r.Args {
+			if a.Index < 0 || a.Index > 5 {
+				errs = append(errs, fmt.Errorf("sandbox: seccomp: rule for %s: argument index %d out of range", r.Syscall, a.Index))
+			}
+			if a.Op < SeccompArgEqual || a.Op > SeccompArgMaskedAny {
+				errs = append(errs, fmt.Errorf("sandbox: seccomp: rule for %s: unknown op %d", r.Syscall, a.Op))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	policy := DefaultPolicy()
+	policy.WorkDir = dir
+	require.NoError(t, policy.Validate())
+
+	file := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	policy.ReadOnlyMounts = append(policy.ReadOnlyMounts,
+		Mount{Source: "relative/dir", Target: "relative/dir"},
+		Mount{Source: filepath.Join(dir, "missing"), Target: filepath.Join(dir, "missing")},
+	)
+	policy.WorkDir = file
+	policy.MaskedPaths = []string{"", "[unclosed"}
+	policy.AllowNetwork = true
+	policy.AllowLocalhostOnly = true
+	policy.Files = map[string]File{"etc/relative": {}}
+	policy.Env.Set = map[string]string{"BAD=NAME": "x"}
+	policy.Limits.MaxProcesses = -1
+	policy.Identity = &Identity{User: "Not Valid"}
+
+	err := policy.Validate()
+	require.Error(t, err)
+	for _, want := range []string{
+		`"relative/dir" is not an absolute path`,
+		"missing",
+		"is not a directory",
+		"masked path must not be empty",
+		`"[unclosed"`,
+		"AllowNetwork and AllowLocalhostOnly",
+		"etc/relative",
+		"BAD=NAME",
+		"MaxProcesses",
+		"invalid user name",
+	} {
+		assert.Contains(t, err.Error(), want)
+	}
+
+	var nilPolicy *Policy
+	assert.Error(t, nilPolicy.Validate())
+}
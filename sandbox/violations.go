@@ -0,0 +1,100 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// ErrViolationMonitor is reported by CheckLimits, wrapping the error of the run, when a
+// command with ReportViolations was killed because its system calls could no longer be
+// observed. Without the host answering them, the watched system calls would fail.
+var ErrViolationMonitor = errors.New("sandbox: violation monitor failed")
+
+// Violation is an operation of a sandboxed process that the sandbox refused, recorded
+// when Policy.ReportViolations is set.
+type Violation struct {
+	// Time is when the operation was attempted.
+	Time time.Time
+
+	// PID is the host process ID of the process that attempted the operation.
+	PID int
+
+	// Op is the kind of operation:
+	//   - "write": opening an existing file for writing, or truncating it
+	//   - "create", "mkdir", "mknod", "symlink", "link": creating a directory entry
+	//   - "unlink", "rename": removing or moving a directory entry
+	//   - "connect": connecting to a network address
+	Op string
+
+	// Syscall is the name of the system call (e.g., "openat").
+	Syscall string
+
+	// Path is the path inside the sandbox, for filesystem operations.
+	Path string
+
+	// Addr is the "host:port" address, for "connect".
+	Addr string
+
+	// Err is the error the operation fails with, e.g. EROFS for a read-only mount, EACCES
+	// for a file the sandbox user may not write and ENETUNREACH without network access.
+	Err syscall.Errno
+}
+
+// String describes the violation, e.g. "write /etc/hosts: read-only file system".
+func (v Violation) String() string {
+	target := v.Path
+	if v.Addr != "" {
+		target = v.Addr
+	}
+	return fmt.Sprintf("%s %s: %v", v.Op, target, v.Err)
+}
+
+// Violations returns the violations recorded so far for a command created by
+// Policy.Command with ReportViolations set, in the order they happened. Once Wait has
+// returned, the list is complete. It returns nil for other commands.
+//
+// Example:
+//
+//	err = cmd.Run()
+//	for _, v := range sandbox.Violations(cmd) {
+//	    feedback = append(feedback, fmt.Sprintf("sandbox refused: %s", v))
+//	}
+func Violations(cmd *exec.Cmd) []Violation {
+	rec := outcomeOf(cmd)
+	if rec == nil {
+		return nil
+	}
+	return append([]Violation(nil), rec.violations...)
+}
+
+// NotifyViolations causes violations of cmd to be relayed to ch as they happen, in
+// addition to being recorded for Violations. As with signal.Notify, the sandbox does not
+// block sending to ch: the caller must ensure that ch has sufficient buffer space, and
+// events that do not fit are dropped from the channel (but still recorded). Call it before
+// starting cmd to observe every event. It has no effect on commands created without
+// ReportViolations.
+func NotifyViolations(cmd *exec.Cmd, ch chan<- Violation) {
+	st := stateOf(cmd)
+	if st == nil {
+		return
+	}
+	st.vmu.Lock()
+	defer st.vmu.Unlock()
+	st.watchers = append(st.watchers, ch)
+}
+
+// recordViolation stores v and relays it to the channels registered for the command.
+func (s *cmdState) recordViolation(v Violation) {
+	s.vmu.Lock()
+	defer s.vmu.Unlock()
+	s.violations = append(s.violations, v)
+	for _, ch := range s.watchers {
+		select {
+		case ch <- v:
+		default:
+		}
+	}
+}
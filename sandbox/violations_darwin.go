@@ -0,0 +1,18 @@
+//go:build darwin
+
+package sandbox
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// watchViolations does nothing on macOS, where ReportViolations is ignored.
+func (p *Policy) watchViolations(cmd *exec.Cmd, st *cmdState) error {
+	return nil
+}
+
+// installNotifyFilter is never requested on macOS.
+func installNotifyFilter(prog []byte, sock int) error {
+	return fmt.Errorf("seccomp is not supported on macOS")
+}
@@ -0,0 +1,711 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Violation reporting works by seccomp user notification. The helper installs a filter
+// that pauses the watched system calls and sends the filter's listener descriptor to the
+// parent over a Unix socket, then execs bubblewrap. For every paused call, the parent reads
+// the arguments from the process's memory, checks whether the operation will fail because
+// of the sandbox by resolving its paths through /proc/PID/root, records a Violation if so,
+// and lets the call continue unchanged. Calls made while bubblewrap sets up the sandbox
+// are ignored: reporting starts with the first execve by a process other than bubblewrap.
+
+// Constants from linux/seccomp.h, linux/openat2.h and asm-generic/fcntl.h, which are the
+// same on amd64 and arm64.
+const (
+	seccompSetModeFilter         = 1
+	seccompFilterFlagNewListener = 1 << 3
+	seccompUserNotifFlagContinue = 1
+
+	ioctlNotifRecv    = 0xc0502100 // SECCOMP_IOCTL_NOTIF_RECV
+	ioctlNotifSend    = 0xc0182101 // SECCOMP_IOCTL_NOTIF_SEND
+	ioctlNotifIDValid = 0x40082102 // SECCOMP_IOCTL_NOTIF_ID_VALID
+
+	prSetNoNewPrivs = 38
+
+	oPath               = 0x200000
+	resolveNoMagiclinks = 0x02
+	resolveInRoot       = 0x10
+
+	atFDCWD = -100
+
+	// O_WRONLY | O_RDWR | O_CREAT | O_TRUNC
+	openWriteFlags = 0x1 | 0x2 | 0x40 | 0x200
+	openCreat      = 0x40
+	openExcl       = 0x80
+)
+
+// seccompNotif is struct seccomp_notif.
+type seccompNotif struct {
+	id    uint64
+	pid   uint32
+	flags uint32
+	nr    int32
+	arch  uint32
+	ip    uint64
+	args  [6]uint64
+}
+
+// seccompNotifResp is struct seccomp_notif_resp.
+type seccompNotifResp struct {
+	id    uint64
+	val   int64
+	error int32
+	flags uint32
+}
+
+// openHow is struct open_how.
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+// sockFprog is struct sock_fprog.
+type sockFprog struct {
+	len    uint16
+	filter *byte
+}
+
+// pathArg locates a path among the arguments of a system call: the path at index path,
+// relative to the directory descriptor at index dirfd (-1 for the working directory).
+type pathArg struct {
+	dirfd, path int
+}
+
+// watchedSyscall describes how a watched system call is checked.
+type watchedSyscall struct {
+	op     string    // Violation.Op
+	paths  []pathArg // paths the call writes to
+	parent bool      // whether the call creates or removes the paths (else it opens them)
+	flags  int       // index of the open(2) flags, or -1
+}
+
+// watchedSyscalls lists the system calls that may be refused by the sandbox, by name.
+// Names that do not exist on an architecture (e.g., open and mkdir on arm64) are skipped.
+var watchedSyscalls = map[string]watchedSyscall{
+	"open":      {op: "write", paths: []pathArg{{-1, 0}}, flags: 1},
+	"openat":    {op: "write", paths: []pathArg{{0, 1}}, flags: 2},
+	"openat2":   {op: "write", paths: []pathArg{{0, 1}}, flags: -1},
+	"creat":     {op: "write", paths: []pathArg{{-1, 0}}, flags: -1},
+	"truncate":  {op: "write", paths: []pathArg{{-1, 0}}, flags: -1},
+	"mkdir":     {op: "mkdir", paths: []pathArg{{-1, 0}}, parent: true, flags: -1},
+	"mkdirat":   {op: "mkdir", paths: []pathArg{{0, 1}}, parent: true, flags: -1},
+	"mknod":     {op: "mknod", paths: []pathArg{{-1, 0}}, parent: true, flags: -1},
+	"mknodat":   {op: "mknod", paths: []pathArg{{0, 1}}, parent: true, flags: -1},
+	"unlink":    {op: "unlink", paths: []pathArg{{-1, 0}}, parent: true, flags: -1},
+	"unlinkat":  {op: "unlink", paths: []pathArg{{0, 1}}, parent: true, flags: -1},
+	"rmdir":     {op: "unlink", paths: []pathArg{{-1, 0}}, parent: true, flags: -1},
+	"rename":    {op: "rename", paths: []pathArg{{-1, 0}, {-1, 1}}, parent: true, flags: -1},
+	"renameat":  {op: "rename", paths: []pathArg{{0, 1}, {2, 3}}, parent: true, flags: -1},
+	"renameat2": {op: "rename", paths: []pathArg{{0, 1}, {2, 3}}, parent: true, flags: -1},
+	"symlink":   {op: "symlink", paths: []pathArg{{-1, 1}}, parent: true, flags: -1},
+	"symlinkat": {op: "symlink", paths: []pathArg{{1, 2}}, parent: true, flags: -1},
+	"link":      {op: "link", paths: []pathArg{{-1, 1}}, parent: true, flags: -1},
+	"linkat":    {op: "link", paths: []pathArg{{2, 3}}, parent: true, flags: -1},
+	"connect":   {op: "connect", flags: -1},
+	"execve":    {flags: -1},
+	"execveat":  {flags: -1},
+}
+
+// violationProfile returns the filter that notifies the watched system calls. Opens are
+// only notified when they request write access.
+func violationProfile() *SeccompProfile {
+	profile := &SeccompProfile{DefaultAction: SeccompAllow}
+	for name, w := range watchedSyscalls {
+		if !knownSyscall(name) {
+			continue
+		}
+		rule := SeccompRule{Syscall: name, Action: seccompNotify}
+		if w.flags >= 0 {
+			rule.Args = []SeccompArg{{Index: w.flags, Op: SeccompArgMaskedAny, Value: openWriteFlags}}
+		}
+		profile.Rules = append(profile.Rules, rule)
+	}
+	return profile
+}
+
+// watchViolations arranges for the helper to install the notification filter and starts
+// the monitor that receives its listener.
+func (p *Policy) watchViolations(cmd *exec.Cmd, st *cmdState) error {
+	prog, err := violationProfile().compile()
+	if err != nil {
+		return fmt.Errorf("sandbox: report violations: %w", err)
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("sandbox: report violations: %w", err)
+	}
+	if err := syscall.SetNonblock(fds[0], true); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return fmt.Errorf("sandbox: report violations: %w", err)
+	}
+	sock := os.NewFile(uintptr(fds[0]), "violation-socket")
+	child := os.NewFile(uintptr(fds[1]), "violation-socket")
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, child)
+	cfg := st.helperConfig()
+	cfg.NotifyFilter = prog
+	cfg.NotifySocket = 2 + len(cmd.ExtraFiles)
+
+	m := &violationMonitor{
+		st:          st,
+		netIsolated: !p.AllowSharedNamespaces || p.NetworkProxy != nil || !p.AllowNetwork,
+		numbers:     make(map[int32]string),
+	}
+	for name := range watchedSyscalls {
+		if nr, ok := syscallNumbers[name]; ok {
+			m.numbers[int32(nr)] = name
+		}
+	}
+	st.addCleanup(func() {
+		sock.Close()
+		child.Close()
+	})
+	go m.run(sock, child)
+	return nil
+}
+
+// installNotifyFilter installs the seccomp program prog on the calling thread and sends
+// the listener, along with the process ID, over the Unix socket sock. The filter is kept
+// across execve and inherited by every descendant.
+func installNotifyFilter(prog []byte, sock int) error {
+	if len(prog) == 0 || len(prog)%8 != 0 {
+		return fmt.Errorf("malformed seccomp program")
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %w", errno)
+	}
+	fprog := sockFprog{len: uint16(len(prog) / 8), filter: &prog[0]}
+	listener, _, errno := syscall.RawSyscall(uintptr(syscallNumbers["seccomp"]),
+		seccompSetModeFilter, seccompFilterFlagNewListener, uintptr(unsafe.Pointer(&fprog)))
+	runtime.KeepAlive(prog)
+	if errno != 0 {
+		return fmt.Errorf("seccomp: %w", errno)
+	}
+
+	pid := binary.NativeEndian.AppendUint64(nil, uint64(os.Getpid()))
+	err := syscall.Sendmsg(sock, pid, syscall.UnixRights(int(listener)), nil, 0)
+	syscall.Close(int(listener))
+	syscall.Close(sock)
+	if err != nil {
+		return fmt.Errorf("send seccomp listener: %w", err)
+	}
+	return nil
+}
+
+// violationMonitor handles the notifications of one command.
+type violationMonitor struct {
+	st          *cmdState
+	netIsolated bool             // whether connections beyond loopback fail
+	numbers     map[int32]string // watched system call numbers to names
+
+	helperPID int  // the helper, which becomes bubblewrap
+	armed     bool // whether the sandboxed command has started
+}
+
+// errNoListener is returned by receiveListener when the helper exited, or the command was
+// released, without installing the filter.
+var errNoListener = errors.New("helper exited before sending the seccomp listener")
+
+// run receives the listener from the helper and handles notifications until every
+// process in the sandbox has exited. If it cannot go on, it kills the run: closing the
+// listener would make the watched system calls fail with ENOSYS.
+func (m *violationMonitor) run(sock, child *os.File) {
+	listener, err := m.receiveListener(sock)
+	// The helper has started and holds its own copy of the socket
+	child.Close()
+	sock.Close()
+	if err != nil {
+		if !errors.Is(err, errNoListener) {
+			m.fail(err)
+		}
+		return
+	}
+	defer listener.Close()
+
+	conn, err := listener.SyscallConn()
+	if err != nil {
+		m.fail(err)
+		return
+	}
+	for {
+		var n seccompNotif
+		var recvErr error
+		done := false
+		err := conn.Read(func(fd uintptr) bool {
+			// Wait for a notification without blocking in the ioctl, which ignores O_NONBLOCK
+			pfd := [1]struct {
+				fd      int32
+				events  int16
+				revents int16
+			}{{fd: int32(fd), events: 0x1}} // POLLIN
+			ts := syscall.Timespec{}
+			_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd[0])), 1,
+				uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+			switch {
+			case errno == syscall.EINTR:
+				return false
+			case errno != 0:
+				recvErr = errno
+				return true
+			case pfd[0].revents&0x10 != 0: // POLLHUP: no process uses the filter anymore
+				done = true
+				return true
+			case pfd[0].revents&0x1 == 0:
+				return false
+			}
+			n = seccompNotif{}
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlNotifRecv, uintptr(unsafe.Pointer(&n))); errno != 0 {
+				recvErr = errno
+			}
+			return true
+		})
+		if done {
+			return
+		}
+		if err == nil && recvErr != nil {
+			if recvErr == syscall.ENOENT || recvErr == syscall.EINTR {
+				continue // the paused process was killed before the notification was read
+			}
+			err = recvErr
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+
+		m.handle(conn, &n)
+	}
+}
+
+// fail records why violations can no longer be observed and kills the run. The helper's
+// process ID is used rather than the Cmd's Process, which Start may not have set yet.
+func (m *violationMonitor) fail(err error) {
+	m.st.vmu.Lock()
+	m.st.monitorErr = err
+	m.st.vmu.Unlock()
+	if m.helperPID != 0 {
+		killProcessTree(m.helperPID, func() error {
+			return syscall.Kill(m.helperPID, syscall.SIGKILL)
+		}, m.st)
+	}
+}
+
+// receiveListener reads the helper's process ID and the listener descriptor from sock.
+func (m *violationMonitor) receiveListener(sock *os.File) (*os.File, error) {
+	conn, err := sock.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	var n, oobn int
+	var recvErr error
+	err = conn.Read(func(fd uintptr) bool {
+		n, oobn, _, _, recvErr = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_CMSG_CLOEXEC)
+		return recvErr != syscall.EAGAIN
+	})
+	if errors.Is(err, os.ErrClosed) {
+		return nil, errNoListener
+	}
+	if err == nil {
+		err = recvErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n != len(buf) {
+		return nil, errNoListener
+	}
+	m.helperPID = int(binary.NativeEndian.Uint64(buf))
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, fmt.Errorf("malformed seccomp listener message")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return nil, fmt.Errorf("malformed seccomp listener message")
+	}
+	if err := syscall.SetNonblock(fds[0], true); err != nil {
+		syscall.Close(fds[0])
+		return nil, err
+	}
+	return os.NewFile(uintptr(fds[0]), "seccomp-listener"), nil
+}
+
+// handle inspects one paused system call, records a violation if it will fail because of
+// the sandbox, and lets it continue.
+func (m *violationMonitor) handle(conn syscall.RawConn, n *seccompNotif) {
+	name := m.numbers[n.nr]
+	var v *Violation
+	switch {
+	case name == "execve" || name == "execveat":
+		if int(n.pid) != m.helperPID {
+			m.armed = true
+		}
+	case m.armed:
+		v = m.check(name, n)
+	}
+
+	conn.Control(func(fd uintptr) {
+		// Arguments read from the process's memory are only meaningful if the notification
+		// is still pending, i.e. the process was not killed and its PID reused meanwhile
+		if v != nil {
+			id := n.id
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlNotifIDValid, uintptr(unsafe.Pointer(&id))); errno != 0 {
+				v = nil
+			}
+		}
+		resp := seccompNotifResp{id: n.id, flags: seccompUserNotifFlagContinue}
+		syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlNotifSend, uintptr(unsafe.Pointer(&resp)))
+	})
+	if v != nil {
+		m.st.recordViolation(*v)
+	}
+}
+
+// check returns the violation the system call will cause, or nil.
+func (m *violationMonitor) check(name string, n *seccompNotif) *Violation {
+	w, ok := watchedSyscalls[name]
+	if !ok {
+		return nil
+	}
+	pid := int(n.pid)
+	v := &Violation{Time: time.Now(), PID: pid, Op: w.op, Syscall: name}
+
+	if name == "connect" {
+		addr, ok := m.refusedAddr(pid, n.args[1], n.args[2])
+		if !ok {
+			return nil
+		}
+		v.Addr, v.Err = addr, syscall.ENETUNREACH
+		return v
+	}
+
+	for _, pa := range w.paths {
+		path, err := readString(pid, n.args[pa.path])
+		if err != nil || path == "" {
+			return nil
+		}
+		dirfd := atFDCWD
+		if pa.dirfd >= 0 {
+			dirfd = int(int32(n.args[pa.dirfd]))
+		}
+		abs, ok := sandboxPath(pid, dirfd, path)
+		if !ok {
+			return nil
+		}
+
+		var errno syscall.Errno
+		switch {
+		case w.parent:
+			errno = checkWritable(pid, filepath.Dir(abs))
+		default:
+			var flags uint64
+			switch name {
+			case "open", "openat":
+				flags = n.args[w.flags]
+			case "creat":
+				flags = openWriteFlags
+			case "truncate":
+				flags = 0x1
+			case "openat2":
+				how := make([]byte, 8)
+				if readMem(pid, n.args[2], how) != nil {
+					return nil
+				}
+				flags = binary.NativeEndian.Uint64(how)
+				if flags&openWriteFlags == 0 {
+					return nil
+				}
+			}
+			errno = checkWritable(pid, abs)
+			if errno == syscall.ENOENT && flags&openCreat != 0 {
+				v.Op = "create"
+				errno = checkWritable(pid, filepath.Dir(abs))
+			} else if errno == 0 && flags&(openCreat|openExcl) == openCreat|openExcl {
+				errno = 0 // fails with EEXIST, which is not the sandbox's doing
+			}
+		}
+		if errno == syscall.EROFS || errno == syscall.EACCES || errno == syscall.EPERM {
+			v.Path, v.Err = abs, errno
+			return v
+		}
+	}
+	return nil
+}
+
+// refusedAddr returns the address a connect(2) call with the given sockaddr is made to,
+// if the sandbox's network namespace cannot reach it.
+func (m *violationMonitor) refusedAddr(pid int, addr, addrlen uint64) (string, bool) {
+	if !m.netIsolated || addrlen < 8 {
+		return "", false
+	}
+	buf := make([]byte, min(addrlen, 28))
+	if readMem(pid, addr, buf) != nil {
+		return "", false
+	}
+	var ip net.IP
+	switch binary.NativeEndian.Uint16(buf) {
+	case syscall.AF_INET:
+		ip = net.IP(buf[4:8])
+	case syscall.AF_INET6:
+		if len(buf) < 24 {
+			return "", false
+		}
+		ip = net.IP(buf[8:24])
+	default:
+		return "", false
+	}
+	if ip.IsLoopback() {
+		return "", false
+	}
+	port := binary.BigEndian.Uint16(buf[2:4])
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), true
+}
+
+// sandboxPath returns the absolute path inside the sandbox that path names, relative to
+// the directory descriptor dirfd of process pid.
+func sandboxPath(pid, dirfd int, path string) (string, bool) {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), true
+	}
+	link := fmt.Sprintf("/proc/%d/cwd", pid)
+	if dirfd != atFDCWD {
+		link = fmt.Sprintf("/proc/%d/fd/%d", pid, dirfd)
+	}
+	dir, err := os.Readlink(link)
+	if err != nil || !filepath.IsAbs(dir) {
+		return "", false
+	}
+	return filepath.Join(dir, path), true
+}
+
+// checkWritable reports why the sandbox path cannot be written by process pid, if it
+// cannot: the path is resolved inside the process's root directory, where its mounts
+// apply, and the permission bits are checked against the process's credentials rather
+// than the host's, which differ when the sandbox runs as another user (see Identity).
+func checkWritable(pid int, path string) syscall.Errno {
+	root, err := syscall.Open(fmt.Sprintf("/proc/%d/root", pid), oPath|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return 0
+	}
+	defer syscall.Close(root)
+
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return 0
+	}
+	how := openHow{flags: oPath | syscall.O_CLOEXEC, resolve: resolveInRoot | resolveNoMagiclinks}
+	fd, _, errno := syscall.Syscall6(uintptr(syscallNumbers["openat2"]), uintptr(root),
+		uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	defer syscall.Close(int(fd))
+
+	var st syscall.Stat_t
+	var fs syscall.Statfs_t
+	if syscall.Fstat(int(fd), &st) != nil || syscall.Fstatfs(int(fd), &fs) != nil {
+		return 0
+	}
+	creds, err := readProcCreds(pid)
+	if err != nil {
+		return 0
+	}
+	// The same order as access(2): permissions first, then the read-only mount, which
+	// does not apply to devices, FIFOs and sockets
+	if !creds.mayWrite(&st) {
+		return syscall.EACCES
+	}
+	const stRdonly = 0x1 // ST_RDONLY
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG, syscall.S_IFDIR, syscall.S_IFLNK:
+		if fs.Flags&stRdonly != 0 {
+			return syscall.EROFS
+		}
+	}
+	return 0
+}
+
+// procCreds are the credentials a process's file accesses are checked with, as seen from
+// the host's user namespace.
+type procCreds struct {
+	fsuid, fsgid uint32
+	groups       []uint32
+	dacOverride  bool        // CAP_DAC_OVERRIDE in the process's user namespace
+	uidMap       [][3]uint32 // inside, outside, count
+	gidMap       [][3]uint32
+}
+
+// readProcCreds reads the credentials of process pid from /proc.
+func readProcCreds(pid int) (*procCreds, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	c := &procCreds{}
+	var haveUID, haveGID bool
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		switch key {
+		case "Uid", "Gid":
+			// Real, effective, saved set and filesystem IDs
+			if len(fields) != 4 {
+				return nil, fmt.Errorf("malformed %s line", key)
+			}
+			id, err := strconv.ParseUint(fields[3], 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			if key == "Uid" {
+				c.fsuid, haveUID = uint32(id), true
+			} else {
+				c.fsgid, haveGID = uint32(id), true
+			}
+		case "Groups":
+			for _, f := range fields {
+				id, err := strconv.ParseUint(f, 10, 32)
+				if err != nil {
+					return nil, err
+				}
+				c.groups = append(c.groups, uint32(id))
+			}
+		case "CapEff":
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("malformed CapEff line")
+			}
+			caps, err := strconv.ParseUint(fields[0], 16, 64)
+			if err != nil {
+				return nil, err
+			}
+			const capDACOverride = 1
+			c.dacOverride = caps&(1<<capDACOverride) != 0
+		}
+	}
+	if !haveUID || !haveGID {
+		return nil, fmt.Errorf("no credentials in /proc/%d/status", pid)
+	}
+	if c.dacOverride {
+		if c.uidMap, err = readIDMap(fmt.Sprintf("/proc/%d/uid_map", pid)); err != nil {
+			return nil, err
+		}
+		if c.gidMap, err = readIDMap(fmt.Sprintf("/proc/%d/gid_map", pid)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readIDMap parses a /proc/PID/uid_map or gid_map file.
+func readIDMap(path string) ([][3]uint32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m [][3]uint32
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		var e [3]uint32
+		for i, f := range fields {
+			n, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			e[i] = uint32(n)
+		}
+		m = append(m, e)
+	}
+	return m, nil
+}
+
+// mapped reports whether the host ID id has an ID in the namespace described by m.
+func mapped(m [][3]uint32, id uint32) bool {
+	for _, e := range m {
+		if id >= e[1] && uint64(id) < uint64(e[1])+uint64(e[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayWrite reports whether the credentials allow writing to the file st describes,
+// following the kernel's permission check. ACLs are not taken into account.
+func (c *procCreds) mayWrite(st *syscall.Stat_t) bool {
+	// Capabilities only apply to files whose owner and group exist in the namespace
+	if c.dacOverride && mapped(c.uidMap, st.Uid) && mapped(c.gidMap, st.Gid) {
+		return true
+	}
+	switch {
+	case c.fsuid == st.Uid:
+		return st.Mode&0o200 != 0
+	case c.fsgid == st.Gid || slices.Contains(c.groups, st.Gid):
+		return st.Mode&0o020 != 0
+	}
+	return st.Mode&0o002 != 0
+}
+
+// readString reads a NUL-terminated string of at most PATH_MAX bytes from the memory of
+// process pid.
+func readString(pid int, addr uint64) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	const pathMax = 4096
+	var buf []byte
+	for len(buf) < pathMax {
+		// Read up to the end of the page, the next of which may be unmapped
+		chunk := make([]byte, 4096-(addr+uint64(len(buf)))%4096)
+		n, err := f.ReadAt(chunk, int64(addr)+int64(len(buf)))
+		for i := 0; i < n; i++ {
+			if chunk[i] == 0 {
+				return string(append(buf, chunk[:i]...)), nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, chunk[:n]...)
+	}
+	return "", fmt.Errorf("string at %#x exceeds %d bytes", addr, pathMax)
+}
+
+// readMem fills buf from the memory of process pid at addr.
+func readMem(pid int, addr uint64, buf []byte) error {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.ReadAt(buf, int64(addr))
+	return err
+}
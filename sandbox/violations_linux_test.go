@@ -0,0 +1,160 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestViolationProfile(t *testing.T) {
+	t.Parallel()
+
+	if _, err := seccompAuditArch(); err != nil {
+		t.Skip(err)
+	}
+	profile := violationProfile()
+	_, err := profile.compile()
+	require.NoError(t, err)
+
+	var openat *SeccompRule
+	for i, r := range profile.Rules {
+		if r.Syscall == "openat" {
+			openat = &profile.Rules[i]
+		}
+		assert.True(t, knownSyscall(r.Syscall), r.Syscall)
+	}
+	require.NotNil(t, openat)
+	assert.Equal(t, []SeccompArg{{Index: 2, Op: SeccompArgMaskedAny, Value: openWriteFlags}}, openat.Args,
+		"read-only opens are not paused")
+}
+
+func TestProcCredsMayWrite(t *testing.T) {
+	t.Parallel()
+
+	creds, err := readProcCreds(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, uint32(os.Getuid()), creds.fsuid)
+	assert.Equal(t, uint32(os.Getgid()), creds.fsgid)
+
+	// The sandbox user of an Identity: uid 1000 in a namespace mapping only that user
+	user := &procCreds{fsuid: 1000, fsgid: 1000, groups: []uint32{27}}
+	file := func(uid, gid, mode uint32) *syscall.Stat_t {
+		return &syscall.Stat_t{Uid: uid, Gid: gid, Mode: syscall.S_IFREG | mode}
+	}
+	assert.True(t, user.mayWrite(file(1000, 0, 0o644)))
+	assert.False(t, user.mayWrite(file(1000, 1000, 0o464)), "the owner bits apply to the owner")
+	assert.True(t, user.mayWrite(file(0, 27, 0o664)), "supplementary groups count")
+	assert.False(t, user.mayWrite(file(0, 0, 0o644)))
+	assert.True(t, user.mayWrite(file(0, 0, 0o666)))
+
+	// Root inside a user namespace may only override permissions on files it maps
+	nsRoot := &procCreds{fsuid: 1000, fsgid: 1000, dacOverride: true, uidMap: [][3]uint32{{0, 1000, 1}}, gidMap: [][3]uint32{{0, 1000, 1}}}
+	assert.True(t, nsRoot.mayWrite(file(1000, 1000, 0o444)))
+	assert.False(t, nsRoot.mayWrite(file(0, 0, 0o644)))
+}
+
+// TestViolationMonitor runs a program through the helper without bubblewrap, so that the
+// notification mechanism can be tested where bubblewrap is unavailable.
+func TestViolationMonitor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if _, err := seccompAuditArch(); err != nil {
+		t.Skip(err)
+	}
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked")
+	require.NoError(t, os.Mkdir(locked, 0o555))
+
+	// The command must exec something to start reporting, as bubblewrap does
+	cmd := exec.Command("/bin/sh", "-c", pythonPath+` -c '
+import os, socket
+s = socket.socket()
+s.settimeout(0.2)
+s.connect_ex(("192.0.2.1", 9))
+s.connect_ex(("127.0.0.1", 9))
+open(os.path.join("`+dir+`", "allowed.txt"), "w").close()
+try:
+    open(os.path.join("`+locked+`", "denied.txt"), "w")
+except OSError:
+    pass
+'`)
+	cmd.Dir = dir
+	st := newCmdState(&Policy{})
+	require.NoError(t, (&Policy{}).watchViolations(cmd, st))
+	require.NoError(t, wrapWithHelper(cmd, helperModeExec, st.helper))
+	st.attach(cmd)
+
+	ch := make(chan Violation, 10)
+	NotifyViolations(cmd, ch)
+	output, err := cmd.CombinedOutput()
+	if err != nil && len(output) > 0 {
+		t.Skipf("seccomp user notification unavailable: %s", output)
+	}
+	require.NoError(t, err)
+
+	violations := Violations(cmd)
+	require.NotEmpty(t, violations)
+	assert.Equal(t, "connect", violations[0].Op)
+	assert.Equal(t, "192.0.2.1:9", violations[0].Addr)
+	assert.Equal(t, syscall.ENETUNREACH, violations[0].Err)
+	assert.Equal(t, "connect 192.0.2.1:9: network is unreachable", violations[0].String())
+	assert.Equal(t, violations[0], <-ch)
+
+	if os.Geteuid() != 0 {
+		// Root may write anywhere
+		require.Len(t, violations, 2)
+		assert.Equal(t, "create", violations[1].Op)
+		assert.Equal(t, filepath.Join(locked, "denied.txt"), violations[1].Path)
+		assert.Equal(t, syscall.EACCES, violations[1].Err)
+	} else {
+		assert.Len(t, violations, 1)
+	}
+}
+
+func TestIntegrationViolations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+
+	pythonPath, err := findPython()
+	require.NoError(t, err, "python3 is required for integration tests (minimum 3.11)")
+
+	policy := pythonPolicy()
+	policy.WorkDir = t.TempDir()
+	policy.ReportViolations = true
+	cmd, err := policy.Command(context.Background(), pythonPath, "-c", `
+import os
+for path in ("/etc/hosts", "/usr/boxedpy-probe", "scratch.txt"):
+    try:
+        open(path, "w").close()
+    except OSError as e:
+        print(path, e.strerror)
+`)
+	require.NoError(t, err)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %s", output)
+
+	var got []string
+	for _, v := range Violations(cmd) {
+		got = append(got, v.String())
+	}
+	assert.Equal(t, []string{
+		"write /etc/hosts: read-only file system",
+		"create /usr/boxedpy-probe: read-only file system",
+	}, got)
+}